@@ -41,14 +41,12 @@ func NewBrokerConfigFromEnv(logger lager.Logger) (*BrokerConfig, error) {
 		return nil, fmt.Errorf("failed loading config: %v", err)
 	}
 
-	var cs credstore.CredStore
-
-	if config.CredStoreConfig.HasCredHubConfig() {
-		var err error
-		cs, err = credstore.NewCredhubStore(&config.CredStoreConfig, logger)
-		if err != nil {
-			return nil, fmt.Errorf("failed creating credstore: %v", err)
-		}
+	cs, err := credstore.New(&config.CredStoreConfig, logger)
+	if err != nil {
+		return nil, fmt.Errorf("failed creating credstore: %v", err)
+	}
+	if cs != nil {
+		cs = credstore.NewInstrumentedStore(cs)
 	}
 
 	return &BrokerConfig{
@@ -22,6 +22,7 @@ import (
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"code.cloudfoundry.org/lager"
 	. "github.com/cloudfoundry-incubator/cloud-service-broker/brokerapi/brokers"
@@ -125,8 +126,9 @@ func fakeService(t *testing.T, isAsync bool) *serviceStub {
 		Plans: []broker.ServicePlan{
 			{
 				ServicePlan: domain.ServicePlan{
-					ID:   "3dcc45e8-0020-11ec-8ae1-f7abb5d2e742",
-					Name: "fake-plan-name",
+					ID:              "3dcc45e8-0020-11ec-8ae1-f7abb5d2e742",
+					Name:            "fake-plan-name",
+					MaintenanceInfo: &domain.MaintenanceInfo{Version: "1.1.0"},
 				},
 			},
 		},
@@ -356,6 +358,25 @@ func TestServiceBroker_Provision(t *testing.T) {
 				assertEqual(t, "errors should match", ErrInvalidUserInput, err)
 			},
 		},
+		"maintenance-info-conflict": {
+			ServiceState: StateNone,
+			Check: func(t *testing.T, broker *ServiceBroker, stub *serviceStub) {
+				req := stub.ProvisionDetails()
+				req.MaintenanceInfo = &domain.MaintenanceInfo{Version: "1.0.0"}
+				_, err := broker.Provision(context.Background(), fakeInstanceId, req, true)
+				assertEqual(t, "errors should match", apiresponses.ErrMaintenanceInfoConflict, err)
+				assertEqual(t, "provision calls should match", 0, stub.Provider.ProvisionCallCount())
+			},
+		},
+		"maintenance-info-matches": {
+			ServiceState: StateNone,
+			Check: func(t *testing.T, broker *ServiceBroker, stub *serviceStub) {
+				req := stub.ProvisionDetails()
+				req.MaintenanceInfo = &domain.MaintenanceInfo{Version: "1.1.0"}
+				_, err := broker.Provision(context.Background(), fakeInstanceId, req, true)
+				failIfErr(t, "provision", err)
+			},
+		},
 		"error-setting-request-details": {
 			ServiceState: StateNone,
 			Check: func(t *testing.T, broker *ServiceBroker, stub *serviceStub) {
@@ -556,6 +577,56 @@ func TestServiceBroker_Bind(t *testing.T) {
 			},
 			Credstore: &credstorefakes.FakeCredStore{},
 		},
+		"async-bind": {
+			ServiceState: StateProvisioned,
+			Check: func(t *testing.T, broker *ServiceBroker, stub *serviceStub) {
+				stub.Provider.BindsAsyncReturns(true)
+				stub.Provider.BindAsyncReturns("my-operation-id", nil)
+
+				binding, err := broker.Bind(context.Background(), fakeInstanceId, fakeBindingId, stub.BindDetails(), true)
+				failIfErr(t, "binding", err)
+
+				assertEqual(t, "BindCallCount should match", 0, stub.Provider.BindCallCount())
+				assertEqual(t, "BindAsyncCallCount should match", 1, stub.Provider.BindAsyncCallCount())
+				assertEqual(t, "IsAsync should be set", true, binding.IsAsync)
+				assertEqual(t, "operation id should be set as the data", "my-operation-id", binding.OperationData)
+
+				record, err := db_service.GetServiceBindingCredentialsByServiceInstanceIdAndBindingId(context.Background(), fakeInstanceId, fakeBindingId)
+				failIfErr(t, "looking up binding", err)
+				assertEqual(t, "OperationType should be set as Bind", models.BindOperationType, record.OperationType)
+				assertEqual(t, "OperationId should be set", "my-operation-id", record.OperationId)
+			},
+		},
+		"async-bind-requested-sync": {
+			ServiceState: StateProvisioned,
+			Check: func(t *testing.T, broker *ServiceBroker, stub *serviceStub) {
+				stub.Provider.BindsAsyncReturns(true)
+
+				binding, err := broker.Bind(context.Background(), fakeInstanceId, fakeBindingId, stub.BindDetails(), false)
+				failIfErr(t, "binding", err)
+
+				assertEqual(t, "BindCallCount should match", 1, stub.Provider.BindCallCount())
+				assertEqual(t, "BindAsyncCallCount should match", 0, stub.Provider.BindAsyncCallCount())
+				assertEqual(t, "IsAsync should not be set", false, binding.IsAsync)
+			},
+		},
+		"async-bind-in-progress": {
+			ServiceState: StateProvisioned,
+			Check: func(t *testing.T, broker *ServiceBroker, stub *serviceStub) {
+				stub.Provider.BindsAsyncReturns(true)
+				stub.Provider.BindAsyncReturns("my-operation-id", nil)
+
+				_, err := broker.Bind(context.Background(), fakeInstanceId, fakeBindingId, stub.BindDetails(), true)
+				failIfErr(t, "binding", err)
+
+				binding, err := broker.Bind(context.Background(), fakeInstanceId, fakeBindingId, stub.BindDetails(), true)
+				failIfErr(t, "binding again", err)
+
+				assertEqual(t, "BindAsyncCallCount should match", 1, stub.Provider.BindAsyncCallCount())
+				assertEqual(t, "IsAsync should be set", true, binding.IsAsync)
+				assertEqual(t, "operation id should be set as the data", "my-operation-id", binding.OperationData)
+			},
+		},
 	}
 
 	cases.Run(t)
@@ -614,6 +685,120 @@ func TestServiceBroker_Unbind(t *testing.T) {
 				assertTrue(t, "errors should match", strings.Contains(err.Error(), "error while decrypting"))
 			},
 		},
+		"async-unbind": {
+			ServiceState: StateBound,
+			Check: func(t *testing.T, broker *ServiceBroker, stub *serviceStub) {
+				stub.Provider.BindsAsyncReturns(true)
+				stub.Provider.UnbindAsyncReturns("my-operation-id", nil)
+
+				resp, err := broker.Unbind(context.Background(), fakeInstanceId, fakeBindingId, stub.UnbindDetails(), true)
+				failIfErr(t, "unbinding", err)
+
+				assertEqual(t, "UnbindCallCount should match", 0, stub.Provider.UnbindCallCount())
+				assertEqual(t, "UnbindAsyncCallCount should match", 1, stub.Provider.UnbindAsyncCallCount())
+				assertEqual(t, "IsAsync should be set", true, resp.IsAsync)
+				assertEqual(t, "operation id should be set as the data", "my-operation-id", resp.OperationData)
+
+				record, err := db_service.GetServiceBindingCredentialsByServiceInstanceIdAndBindingId(context.Background(), fakeInstanceId, fakeBindingId)
+				failIfErr(t, "looking up binding", err)
+				assertEqual(t, "OperationType should be set as Unbind", models.UnbindOperationType, record.OperationType)
+			},
+		},
+	}
+
+	cases.Run(t)
+}
+
+func TestServiceBroker_RotateBindingCredentials(t *testing.T) {
+	rotated := map[string]interface{}{"password": "new-secret"}
+
+	cases := BrokerEndpointTestSuite{
+		"good-request": {
+			ServiceState: StateBound,
+			Check: func(t *testing.T, broker *ServiceBroker, stub *serviceStub) {
+				stub.Provider.RotateBindingCredentialsReturns(rotated, nil)
+
+				err := broker.RotateBindingCredentials(context.Background(), fakeInstanceId, fakeBindingId, time.Hour)
+				failIfErr(t, "rotating credentials", err)
+				assertEqual(t, "RotateBindingCredentialsCallCount should match", 1, stub.Provider.RotateBindingCredentialsCallCount())
+				_, _, bindRecord, gracePeriod := stub.Provider.RotateBindingCredentialsArgsForCall(0)
+				assertEqual(t, "binding should match", fakeBindingId, bindRecord.BindingId)
+				assertEqual(t, "grace period should match", time.Hour, gracePeriod)
+
+				spec, err := broker.GetBinding(context.Background(), fakeInstanceId, fakeBindingId, domain.FetchBindingDetails{})
+				failIfErr(t, "getting binding", err)
+				credMap, ok := spec.Credentials.(map[string]interface{})
+				assertTrue(t, "binding credentials should be a map", ok)
+				assertEqual(t, "credentials should be the rotated ones", "new-secret", credMap["password"])
+			},
+		},
+		"good-request-with-credstore": {
+			ServiceState: StateBound,
+			Check: func(t *testing.T, broker *ServiceBroker, stub *serviceStub) {
+				stub.Provider.RotateBindingCredentialsReturns(rotated, nil)
+				fcs := broker.Credstore.(*credstorefakes.FakeCredStore)
+				assertEqual(t, "Credstore Put call count should match", 1, fcs.PutCallCount())
+
+				err := broker.RotateBindingCredentials(context.Background(), fakeInstanceId, fakeBindingId, 0)
+				failIfErr(t, "rotating credentials", err)
+				assertEqual(t, "Credstore Put call count should match", 2, fcs.PutCallCount())
+				_, credentials := fcs.PutArgsForCall(1)
+				assertEqual(t, "Credstore should have the rotated credentials", "new-secret", credentials.(map[string]interface{})["password"])
+			},
+			Credstore: &credstorefakes.FakeCredStore{},
+		},
+		"request-cancelled": {
+			ServiceState: StateBound,
+			Check: func(t *testing.T, broker *ServiceBroker, stub *serviceStub) {
+				stub.Provider.RotateBindingCredentialsReturns(rotated, nil)
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+
+				err := broker.RotateBindingCredentials(ctx, fakeInstanceId, fakeBindingId, 0)
+				failIfErr(t, "rotating credentials", err)
+				providerCtx, _, _, _ := stub.Provider.RotateBindingCredentialsArgsForCall(0)
+				assertTrue(t, "the rotation should not be cancelled with the request", providerCtx.Err() == nil)
+
+				spec, err := broker.GetBinding(context.Background(), fakeInstanceId, fakeBindingId, domain.FetchBindingDetails{})
+				failIfErr(t, "getting binding", err)
+				credMap := spec.Credentials.(map[string]interface{})
+				assertEqual(t, "credentials should be the rotated ones", "new-secret", credMap["password"])
+			},
+		},
+		"provider-error": {
+			ServiceState: StateBound,
+			Check: func(t *testing.T, broker *ServiceBroker, stub *serviceStub) {
+				stub.Provider.RotateBindingCredentialsReturns(nil, errors.New("not supported"))
+
+				err := broker.RotateBindingCredentials(context.Background(), fakeInstanceId, fakeBindingId, 0)
+				assertEqual(t, "error should match", "error rotating credentials: not supported", err.Error())
+
+				spec, err := broker.GetBinding(context.Background(), fakeInstanceId, fakeBindingId, domain.FetchBindingDetails{})
+				failIfErr(t, "getting binding", err)
+				credMap := spec.Credentials.(map[string]interface{})
+				assertEqual(t, "credentials should not change", "bar", credMap["foo"])
+			},
+		},
+		"missing-binding": {
+			ServiceState: StateProvisioned,
+			Check: func(t *testing.T, broker *ServiceBroker, stub *serviceStub) {
+				err := broker.RotateBindingCredentials(context.Background(), fakeInstanceId, fakeBindingId, 0)
+				assertTrue(t, "rotating a missing binding should fail", err != nil)
+				assertEqual(t, "RotateBindingCredentialsCallCount should match", 0, stub.Provider.RotateBindingCredentialsCallCount())
+			},
+		},
+		"binding-operation-in-progress": {
+			ServiceState: StateProvisioned,
+			Check: func(t *testing.T, broker *ServiceBroker, stub *serviceStub) {
+				stub.Provider.BindsAsyncReturns(true)
+				_, err := broker.Bind(context.Background(), fakeInstanceId, fakeBindingId, stub.BindDetails(), true)
+				failIfErr(t, "binding", err)
+
+				err = broker.RotateBindingCredentials(context.Background(), fakeInstanceId, fakeBindingId, 0)
+				assertTrue(t, "rotating while binding should fail", err != nil)
+				assertEqual(t, "RotateBindingCredentialsCallCount should match", 0, stub.Provider.RotateBindingCredentialsCallCount())
+			},
+		},
 	}
 
 	cases.Run(t)
@@ -686,10 +871,35 @@ func TestServiceBroker_GetBinding(t *testing.T) {
 	cases := BrokerEndpointTestSuite{
 		"called-on-bound": {
 			ServiceState: StateBound,
+			Check: func(t *testing.T, broker *ServiceBroker, stub *serviceStub) {
+				spec, err := broker.GetBinding(context.Background(), fakeInstanceId, fakeBindingId, domain.FetchBindingDetails{})
+				failIfErr(t, "getting binding", err)
+
+				credMap, ok := spec.Credentials.(map[string]interface{})
+				assertTrue(t, "binding credentials should be a map", ok)
+				assertEqual(t, "credentials should come from the binding record", "bar", credMap["foo"])
+				assertEqual(t, "instance details should be merged into credentials", "instancename", credMap["mynameis"])
+			},
+		},
+		"called-on-bound-with-credstore": {
+			ServiceState: StateBound,
+			Check: func(t *testing.T, broker *ServiceBroker, stub *serviceStub) {
+				spec, err := broker.GetBinding(context.Background(), fakeInstanceId, fakeBindingId, domain.FetchBindingDetails{})
+				failIfErr(t, "getting binding", err)
+
+				credMap, ok := spec.Credentials.(map[string]interface{})
+				assertTrue(t, "binding credentials should be a map", ok)
+				assertTrue(t, "value foo should not be exposed", credMap["foo"] == nil)
+				assertEqual(t, "cred-hub ref has correct value", "/c/csb/fake-service-name/newbinding/secrets-and-services", credMap["credhub-ref"])
+			},
+			Credstore: &credstorefakes.FakeCredStore{},
+		},
+		"called-on-unbound": {
+			ServiceState: StateUnbound,
 			Check: func(t *testing.T, broker *ServiceBroker, stub *serviceStub) {
 				_, err := broker.GetBinding(context.Background(), fakeInstanceId, fakeBindingId, domain.FetchBindingDetails{})
 
-				assertEqual(t, "expect get binding not supported err", ErrGetBindingsUnsupported, err)
+				assertEqual(t, "expect binding not found err", apiresponses.ErrBindingNotFound, err)
 			},
 		},
 	}
@@ -700,11 +910,53 @@ func TestServiceBroker_GetBinding(t *testing.T) {
 func TestServiceBroker_GetInstance(t *testing.T) {
 	cases := BrokerEndpointTestSuite{
 		"called-while-provisioned": {
-			ServiceState: StateProvisioned,
+			ServiceState: StateNone,
+			Check: func(t *testing.T, broker *ServiceBroker, stub *serviceStub) {
+				req := stub.ProvisionDetails()
+				req.RawParameters = json.RawMessage(`{"foo":"bar"}`)
+				_, err := broker.Provision(context.Background(), fakeInstanceId, req, true)
+				failIfErr(t, "provisioning", err)
+
+				spec, err := broker.GetInstance(context.Background(), fakeInstanceId, domain.FetchInstanceDetails{})
+				failIfErr(t, "getting instance", err)
+
+				assertEqual(t, "service id should match", stub.ServiceId, spec.ServiceID)
+				assertEqual(t, "plan id should match", stub.PlanId, spec.PlanID)
+				assertEqual(t, "parameters should match", map[string]interface{}{"foo": "bar"}, spec.Parameters)
+			},
+		},
+		"called-while-provisioning": {
+			ServiceState: StateNone,
+			AsyncService: true,
+			Check: func(t *testing.T, broker *ServiceBroker, stub *serviceStub) {
+				stub.Provider.ProvisionReturns(models.ServiceInstanceDetails{OperationType: models.ProvisionOperationType}, nil)
+				_, err := broker.Provision(context.Background(), fakeInstanceId, stub.ProvisionDetails(), true)
+				failIfErr(t, "provisioning", err)
+
+				_, err = broker.GetInstance(context.Background(), fakeInstanceId, domain.FetchInstanceDetails{})
+				assertEqual(t, "expect concurrent access err", apiresponses.ErrConcurrentInstanceAccess, err)
+			},
+		},
+		"called-after-provisioning-failed": {
+			ServiceState: StateNone,
+			AsyncService: true,
+			Check: func(t *testing.T, broker *ServiceBroker, stub *serviceStub) {
+				stub.Provider.ProvisionReturns(models.ServiceInstanceDetails{OperationType: models.ProvisionOperationType}, nil)
+				_, err := broker.Provision(context.Background(), fakeInstanceId, stub.ProvisionDetails(), true)
+				failIfErr(t, "provisioning", err)
+				stub.Provider.PollInstanceReturns(true, "", errors.New("provision failed"))
+
+				spec, err := broker.GetInstance(context.Background(), fakeInstanceId, domain.FetchInstanceDetails{})
+				failIfErr(t, "getting instance", err)
+				assertEqual(t, "service id should match", stub.ServiceId, spec.ServiceID)
+			},
+		},
+		"called-while-deprovisioned": {
+			ServiceState: StateDeprovisioned,
 			Check: func(t *testing.T, broker *ServiceBroker, stub *serviceStub) {
 				_, err := broker.GetInstance(context.Background(), fakeInstanceId, domain.FetchInstanceDetails{})
 
-				assertEqual(t, "expect get instances not supported err", ErrGetInstancesUnsupported, err)
+				assertEqual(t, "expect instance not found err", ErrInstanceNotFound, err)
 			},
 		},
 	}
@@ -713,14 +965,106 @@ func TestServiceBroker_GetInstance(t *testing.T) {
 }
 
 func TestServiceBroker_LastBindingOperation(t *testing.T) {
+	bindAsync := func(t *testing.T, broker *ServiceBroker, stub *serviceStub) {
+		stub.Provider.BindsAsyncReturns(true)
+		stub.Provider.BindAsyncReturns("my-operation-id", nil)
+		_, err := broker.Bind(context.Background(), fakeInstanceId, fakeBindingId, stub.BindDetails(), true)
+		failIfErr(t, "binding", err)
+	}
+
 	cases := BrokerEndpointTestSuite{
-		"called-while-bound": {
+		"called-on-synchronous-service": {
+			ServiceState: StateBound,
+			Check: func(t *testing.T, broker *ServiceBroker, stub *serviceStub) {
+				_, err := broker.LastBindingOperation(context.Background(), fakeInstanceId, fakeBindingId, domain.PollDetails{})
+
+				assertEqual(t, "expect last binding to return async required", apiresponses.ErrAsyncRequired, err)
+			},
+		},
+		"missing-binding": {
 			ServiceState: StateProvisioned,
 			AsyncService: true,
 			Check: func(t *testing.T, broker *ServiceBroker, stub *serviceStub) {
 				_, err := broker.LastBindingOperation(context.Background(), fakeInstanceId, fakeBindingId, domain.PollDetails{})
 
-				assertEqual(t, "expect last binding to return async required", apiresponses.ErrAsyncRequired, err)
+				assertEqual(t, "expect binding does not exist", apiresponses.ErrBindingDoesNotExist, err)
+			},
+		},
+		"bind-in-progress": {
+			ServiceState: StateProvisioned,
+			Check: func(t *testing.T, broker *ServiceBroker, stub *serviceStub) {
+				bindAsync(t, broker, stub)
+				stub.Provider.PollBindingReturns(false, "applying", nil)
+
+				status, err := broker.LastBindingOperation(context.Background(), fakeInstanceId, fakeBindingId, domain.PollDetails{})
+				failIfErr(t, "checking last binding operation", err)
+				assertEqual(t, "state should be in progress", domain.InProgress, status.State)
+				assertEqual(t, "description should be the poll message", "applying", status.Description)
+
+				_, err = broker.GetBinding(context.Background(), fakeInstanceId, fakeBindingId, domain.FetchBindingDetails{})
+				assertEqual(t, "binding should not be fetchable yet", apiresponses.ErrBindingNotFound, err)
+			},
+		},
+		"bind-failed": {
+			ServiceState: StateProvisioned,
+			Check: func(t *testing.T, broker *ServiceBroker, stub *serviceStub) {
+				bindAsync(t, broker, stub)
+				stub.Provider.PollBindingReturns(true, "", errors.New("apply failed"))
+
+				status, err := broker.LastBindingOperation(context.Background(), fakeInstanceId, fakeBindingId, domain.PollDetails{})
+				failIfErr(t, "checking last binding operation", err)
+				assertEqual(t, "state should be failed", domain.Failed, status.State)
+				assertEqual(t, "description should be error string", "apply failed", status.Description)
+			},
+		},
+		"bind-succeeded": {
+			ServiceState: StateProvisioned,
+			Check: func(t *testing.T, broker *ServiceBroker, stub *serviceStub) {
+				bindAsync(t, broker, stub)
+				stub.Provider.PollBindingReturns(true, "done", nil)
+				stub.Provider.BindingOutputsReturns(map[string]interface{}{"password": "secret"}, nil)
+
+				status, err := broker.LastBindingOperation(context.Background(), fakeInstanceId, fakeBindingId, domain.PollDetails{})
+				failIfErr(t, "checking last binding operation", err)
+				assertEqual(t, "state should be succeeded", domain.Succeeded, status.State)
+
+				spec, err := broker.GetBinding(context.Background(), fakeInstanceId, fakeBindingId, domain.FetchBindingDetails{})
+				failIfErr(t, "getting binding", err)
+				credMap, ok := spec.Credentials.(map[string]interface{})
+				assertTrue(t, "binding credentials should be a map", ok)
+				assertEqual(t, "credentials should come from the binding outputs", "secret", credMap["password"])
+			},
+		},
+		"bind-succeeded-with-credstore": {
+			ServiceState: StateProvisioned,
+			Check: func(t *testing.T, broker *ServiceBroker, stub *serviceStub) {
+				bindAsync(t, broker, stub)
+				fcs := broker.Credstore.(*credstorefakes.FakeCredStore)
+				assertEqual(t, "Credstore AddPermission call count should match", 1, fcs.AddPermissionCallCount())
+				assertEqual(t, "Credstore Put should wait for the operation", 0, fcs.PutCallCount())
+
+				stub.Provider.PollBindingReturns(true, "", nil)
+				_, err := broker.LastBindingOperation(context.Background(), fakeInstanceId, fakeBindingId, domain.PollDetails{})
+				failIfErr(t, "checking last binding operation", err)
+				assertEqual(t, "Credstore Put call count should match", 1, fcs.PutCallCount())
+			},
+			Credstore: &credstorefakes.FakeCredStore{},
+		},
+		"unbind-succeeded": {
+			ServiceState: StateBound,
+			Check: func(t *testing.T, broker *ServiceBroker, stub *serviceStub) {
+				stub.Provider.BindsAsyncReturns(true)
+				_, err := broker.Unbind(context.Background(), fakeInstanceId, fakeBindingId, stub.UnbindDetails(), true)
+				failIfErr(t, "unbinding", err)
+
+				stub.Provider.PollBindingReturns(true, "", nil)
+				status, err := broker.LastBindingOperation(context.Background(), fakeInstanceId, fakeBindingId, domain.PollDetails{})
+				failIfErr(t, "checking last binding operation", err)
+				assertEqual(t, "state should be succeeded", domain.Succeeded, status.State)
+
+				exists, err := db_service.ExistsServiceBindingCredentialsByServiceInstanceIdAndBindingId(context.Background(), fakeInstanceId, fakeBindingId)
+				failIfErr(t, "checking binding", err)
+				assertEqual(t, "binding should have been deleted", false, exists)
 			},
 		},
 	}
@@ -810,6 +1154,125 @@ func TestServiceBroker_Update(t *testing.T) {
 				failIfErr(t, "update", err)
 			},
 		},
+		"dry-run": {
+			ServiceState: StateProvisioned,
+			AsyncService: true,
+			Check: func(t *testing.T, broker *ServiceBroker, stub *serviceStub) {
+				req := stub.UpdateDetails()
+				req.RawParameters = json.RawMessage(`{"force_delete":"false","dry_run":true}`)
+				stub.Provider.PreviewUpdateReturns(models.ServiceInstanceDetails{OperationId: "preview-id"}, nil)
+
+				resp, err := broker.Update(context.Background(), fakeInstanceId, req, true)
+
+				failIfErr(t, "update", err)
+				assertEqual(t, "update calls should match", 0, stub.Provider.UpdateCallCount())
+				assertEqual(t, "preview calls should match", 1, stub.Provider.PreviewUpdateCallCount())
+				assertEqual(t, "operation data should match", "preview-id", resp.OperationData)
+
+				_, actualVarContext := stub.Provider.PreviewUpdateArgsForCall(0)
+				assertTrue(t, "dry run parameter should be removed", !actualVarContext.HasKey("dry_run"))
+			},
+		},
+		"dry-run-false": {
+			ServiceState: StateProvisioned,
+			AsyncService: true,
+			Check: func(t *testing.T, broker *ServiceBroker, stub *serviceStub) {
+				req := stub.UpdateDetails()
+				req.RawParameters = json.RawMessage(`{"dry_run":false}`)
+
+				_, err := broker.Update(context.Background(), fakeInstanceId, req, true)
+
+				failIfErr(t, "update", err)
+				assertEqual(t, "update calls should match", 1, stub.Provider.UpdateCallCount())
+				assertEqual(t, "preview calls should match", 0, stub.Provider.PreviewUpdateCallCount())
+			},
+		},
+		"dry-run-not-boolean": {
+			ServiceState: StateProvisioned,
+			AsyncService: true,
+			Check: func(t *testing.T, broker *ServiceBroker, stub *serviceStub) {
+				req := stub.UpdateDetails()
+				req.RawParameters = json.RawMessage(`{"dry_run":"yes"}`)
+
+				_, err := broker.Update(context.Background(), fakeInstanceId, req, true)
+				assertEqual(t, "errors should match", ErrInvalidDryRun, err)
+			},
+		},
+		"dry-run-plan-change": {
+			ServiceState: StateProvisioned,
+			AsyncService: true,
+			Check: func(t *testing.T, broker *ServiceBroker, stub *serviceStub) {
+				req := stub.UpdateDetails()
+				req.RawParameters = json.RawMessage(`{"dry_run":true}`)
+				req.PreviousValues.PlanID = "previous-plan-id"
+
+				_, err := broker.Update(context.Background(), fakeInstanceId, req, true)
+				assertEqual(t, "errors should match", ErrDryRunPlanChange, err)
+				assertEqual(t, "preview calls should match", 0, stub.Provider.PreviewUpdateCallCount())
+			},
+		},
+		"dry-run-upgrade": {
+			ServiceState: StateProvisioned,
+			AsyncService: true,
+			Check: func(t *testing.T, broker *ServiceBroker, stub *serviceStub) {
+				req := stub.UpdateDetails()
+				req.RawParameters = json.RawMessage(`{"dry_run":true}`)
+				req.MaintenanceInfo = &domain.MaintenanceInfo{Version: "1.1.0"}
+				req.PreviousValues.MaintenanceInfo = &domain.MaintenanceInfo{Version: "1.0.0"}
+
+				_, err := broker.Update(context.Background(), fakeInstanceId, req, true)
+				assertEqual(t, "errors should match", ErrDryRunPlanChange, err)
+				assertEqual(t, "preview calls should match", 0, stub.Provider.PreviewUpdateCallCount())
+				assertEqual(t, "upgrade calls should match", 0, stub.Provider.UpgradeCallCount())
+			},
+		},
+		"upgrade": {
+			ServiceState: StateProvisioned,
+			AsyncService: true,
+			Check: func(t *testing.T, broker *ServiceBroker, stub *serviceStub) {
+				req := stub.UpdateDetails()
+				req.MaintenanceInfo = &domain.MaintenanceInfo{Version: "1.1.0"}
+				req.PreviousValues.MaintenanceInfo = &domain.MaintenanceInfo{Version: "1.0.0"}
+				stub.Provider.UpgradeReturns(models.ServiceInstanceDetails{OperationId: "upgrade-id"}, nil)
+
+				resp, err := broker.Update(context.Background(), fakeInstanceId, req, true)
+
+				failIfErr(t, "update", err)
+				assertEqual(t, "update calls should match", 0, stub.Provider.UpdateCallCount())
+				assertEqual(t, "upgrade calls should match", 1, stub.Provider.UpgradeCallCount())
+				assertEqual(t, "operation data should match", "upgrade-id", resp.OperationData)
+			},
+		},
+		"maintenance-info-unchanged": {
+			ServiceState: StateProvisioned,
+			AsyncService: true,
+			Check: func(t *testing.T, broker *ServiceBroker, stub *serviceStub) {
+				req := stub.UpdateDetails()
+				req.RawParameters = json.RawMessage(`{"force_delete":"false"}`)
+				req.MaintenanceInfo = &domain.MaintenanceInfo{Version: "1.1.0"}
+				req.PreviousValues.MaintenanceInfo = &domain.MaintenanceInfo{Version: "1.1.0"}
+
+				_, err := broker.Update(context.Background(), fakeInstanceId, req, true)
+
+				failIfErr(t, "update", err)
+				assertEqual(t, "update calls should match", 1, stub.Provider.UpdateCallCount())
+				assertEqual(t, "upgrade calls should match", 0, stub.Provider.UpgradeCallCount())
+			},
+		},
+		"maintenance-info-conflict": {
+			ServiceState: StateProvisioned,
+			AsyncService: true,
+			Check: func(t *testing.T, broker *ServiceBroker, stub *serviceStub) {
+				req := stub.UpdateDetails()
+				req.MaintenanceInfo = &domain.MaintenanceInfo{Version: "2.0.0"}
+				req.PreviousValues.MaintenanceInfo = &domain.MaintenanceInfo{Version: "1.0.0"}
+
+				_, err := broker.Update(context.Background(), fakeInstanceId, req, true)
+
+				assertEqual(t, "errors should match", apiresponses.ErrMaintenanceInfoConflict, err)
+				assertEqual(t, "upgrade calls should match", 0, stub.Provider.UpgradeCallCount())
+			},
+		},
 
 		"error-getting-request-details": {
 			ServiceState: StateProvisioned,
@@ -20,12 +20,14 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"time"
 
 	"code.cloudfoundry.org/lager"
 	"github.com/cloudfoundry-incubator/cloud-service-broker/db_service"
 	"github.com/cloudfoundry-incubator/cloud-service-broker/db_service/models"
 	"github.com/cloudfoundry-incubator/cloud-service-broker/pkg/broker"
 	"github.com/cloudfoundry-incubator/cloud-service-broker/pkg/credstore"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/pkg/varcontext"
 	"github.com/cloudfoundry-incubator/cloud-service-broker/utils/correlation"
 	"github.com/cloudfoundry-incubator/cloud-service-broker/utils/request"
 	"github.com/pivotal-cf/brokerapi/v8"
@@ -34,15 +36,21 @@ import (
 )
 
 var (
-	invalidUserInputMsg        = "User supplied parameters must be in the form of a valid JSON map."
-	ErrInvalidUserInput        = apiresponses.NewFailureResponse(errors.New(invalidUserInputMsg), http.StatusBadRequest, "parsing-user-request")
-	ErrGetInstancesUnsupported = apiresponses.NewFailureResponse(errors.New("the service_instances endpoint is unsupported"), http.StatusBadRequest, "unsupported")
-	ErrGetBindingsUnsupported  = apiresponses.NewFailureResponse(errors.New("the service_bindings endpoint is unsupported"), http.StatusBadRequest, "unsupported")
-	ErrNonUpdatableParameter   = apiresponses.NewFailureResponse(errors.New("attempt to update parameter that may result in service instance re-creation and data loss"), http.StatusBadRequest, "prohibited")
+	invalidUserInputMsg      = "User supplied parameters must be in the form of a valid JSON map."
+	ErrInvalidUserInput      = apiresponses.NewFailureResponse(errors.New(invalidUserInputMsg), http.StatusBadRequest, "parsing-user-request")
+	ErrInstanceNotFound      = apiresponses.NewFailureResponse(errors.New("instance cannot be fetched"), http.StatusNotFound, "instance-not-found")
+	ErrNonUpdatableParameter = apiresponses.NewFailureResponse(errors.New("attempt to update parameter that may result in service instance re-creation and data loss"), http.StatusBadRequest, "prohibited")
+	ErrInvalidDryRun         = apiresponses.NewFailureResponse(fmt.Errorf("the %q parameter must be a boolean", dryRunParameter), http.StatusBadRequest, "parsing-user-request")
+	ErrDryRunPlanChange      = apiresponses.NewFailureResponse(fmt.Errorf("the %q parameter cannot be used to change the plan or maintenance_info of an instance", dryRunParameter), http.StatusUnprocessableEntity, "dry-run-plan-change")
 )
 
 const credhubClientIdentifier = "csb"
 
+// dryRunParameter is a reserved update parameter. When it is true the update is
+// planned but not applied, and the changes it would make are reported as the
+// description of the last operation.
+const dryRunParameter = "dry_run"
+
 // ServiceBroker is a brokerapi.ServiceBroker that can be used to generate an OSB compatible service broker.
 type ServiceBroker struct {
 	registry  broker.BrokerRegistry
@@ -117,6 +125,10 @@ func (broker *ServiceBroker) Provision(ctx context.Context, instanceID string, d
 		return domain.ProvisionedServiceSpec{}, err
 	}
 
+	if err := checkMaintenanceInfo(*plan, details.MaintenanceInfo); err != nil {
+		return domain.ProvisionedServiceSpec{}, err
+	}
+
 	// verify async provisioning is allowed if it is required
 	shouldProvisionAsync := serviceHelper.ProvisionsAsync()
 	if shouldProvisionAsync && !clientSupportsAsync {
@@ -265,6 +277,16 @@ func (broker *ServiceBroker) Bind(ctx context.Context, instanceID, bindingID str
 		return domain.Binding{}, fmt.Errorf("error checking for existing binding: %w", err)
 	}
 	if exists {
+		existingBinding, err := db_service.GetServiceBindingCredentialsByServiceInstanceIdAndBindingId(ctx, instanceID, bindingID)
+		if err != nil {
+			return domain.Binding{}, fmt.Errorf("error retrieving existing binding: %w", err)
+		}
+
+		// the platform may retry the request while the binding is still being created
+		if existingBinding.OperationType == models.BindOperationType && clientSupportsAsync {
+			return domain.Binding{IsAsync: true, OperationData: existingBinding.OperationId}, nil
+		}
+
 		return domain.Binding{}, apiresponses.ErrBindingAlreadyExists
 	}
 
@@ -297,6 +319,10 @@ func (broker *ServiceBroker) Bind(ctx context.Context, instanceID, bindingID str
 		return domain.Binding{}, fmt.Errorf("error generating bind variables: %w", err)
 	}
 
+	if clientSupportsAsync && serviceProvider.BindsAsync() {
+		return broker.bindAsync(ctx, serviceDefinition, serviceProvider, instanceID, bindingID, details, vars)
+	}
+
 	// create binding
 	credsDetails, err := serviceProvider.Bind(ctx, vars)
 	if err != nil {
@@ -345,6 +371,41 @@ func (broker *ServiceBroker) Bind(ctx context.Context, instanceID, bindingID str
 	return *binding, nil
 }
 
+// bindAsync starts the creation of a binding in the background and records the
+// operation so that it can be polled through LastBindingOperation.
+func (broker *ServiceBroker) bindAsync(ctx context.Context, serviceDefinition *broker.ServiceDefinition, serviceProvider broker.ServiceProvider, instanceID, bindingID string, details domain.BindDetails, vars *varcontext.VarContext) (domain.Binding, error) {
+	operationId, err := serviceProvider.BindAsync(ctx, vars)
+	if err != nil {
+		return domain.Binding{}, fmt.Errorf("error performing bind: %w", err)
+	}
+
+	newCreds := models.ServiceBindingCredentials{
+		ServiceInstanceId: instanceID,
+		BindingId:         bindingID,
+		ServiceId:         details.ServiceID,
+		OperationType:     models.BindOperationType,
+		OperationId:       operationId,
+	}
+
+	if err := db_service.CreateServiceBindingCredentials(ctx, &newCreds); err != nil {
+		return domain.Binding{}, fmt.Errorf("error saving binding to database: %w. WARNING: this binding cannot be unbound through cf. Please contact your operator for cleanup",
+			err)
+	}
+
+	// The credentials are only written to the Credstore once the operation has
+	// completed, but the permission can be granted to the app up front.
+	if broker.Credstore != nil {
+		credentialName := getCredentialName(broker.getServiceName(serviceDefinition), bindingID)
+
+		_, err = broker.Credstore.AddPermission(credentialName, "mtls-app:"+details.AppGUID, []string{"read"})
+		if err != nil {
+			return domain.Binding{}, fmt.Errorf("bind failure: unable to add Credstore permissions to app: %w", err)
+		}
+	}
+
+	return domain.Binding{IsAsync: true, OperationData: operationId}, nil
+}
+
 func (broker *ServiceBroker) getServiceName(def *broker.ServiceDefinition) string {
 	return def.Name
 }
@@ -355,33 +416,112 @@ func getCredentialName(serviceName, bindingID string) string {
 
 // GetBinding fetches an existing service binding.
 // GET /v2/service_instances/{instance_id}/service_bindings/{binding_id}
-//
-// NOTE: This functionality is not implemented.
 func (broker *ServiceBroker) GetBinding(ctx context.Context, instanceID, bindingID string, details domain.FetchBindingDetails) (domain.GetBindingSpec, error) {
 	broker.Logger.Info("GetBinding", correlation.ID(ctx), lager.Data{
 		"instance_id": instanceID,
 		"binding_id":  bindingID,
+		"service_id":  details.ServiceID,
+		"plan_id":     details.PlanID,
 	})
 
-	return domain.GetBindingSpec{}, ErrGetBindingsUnsupported
+	bindRecord, err := db_service.GetServiceBindingCredentialsByServiceInstanceIdAndBindingId(ctx, instanceID, bindingID)
+	if err != nil {
+		return domain.GetBindingSpec{}, apiresponses.ErrBindingNotFound
+	}
+
+	// the OSB spec requires a 404 while the binding is still being created
+	if bindRecord.OperationType == models.BindOperationType {
+		return domain.GetBindingSpec{}, apiresponses.ErrBindingNotFound
+	}
+
+	instanceRecord, err := db_service.GetServiceInstanceDetailsById(ctx, instanceID)
+	if err != nil {
+		return domain.GetBindingSpec{}, apiresponses.ErrBindingNotFound
+	}
+
+	serviceDefinition, serviceProvider, err := broker.getDefinitionAndProvider(instanceRecord.ServiceId)
+	if err != nil {
+		return domain.GetBindingSpec{}, fmt.Errorf("error retrieving service definition: %w", err)
+	}
+
+	binding, err := serviceProvider.BuildInstanceCredentials(ctx, *bindRecord, *instanceRecord)
+	if err != nil {
+		return domain.GetBindingSpec{}, fmt.Errorf("error building credentials: %w", err)
+	}
+
+	// credentials were stored in the Credstore when the binding was created,
+	// so we hand out the same reference rather than the raw secrets
+	if broker.Credstore != nil {
+		binding.Credentials = map[string]interface{}{
+			"credhub-ref": getCredentialName(broker.getServiceName(serviceDefinition), bindingID),
+		}
+	}
+
+	return domain.GetBindingSpec{
+		Credentials:     binding.Credentials,
+		SyslogDrainURL:  binding.SyslogDrainURL,
+		RouteServiceURL: binding.RouteServiceURL,
+		VolumeMounts:    binding.VolumeMounts,
+	}, nil
 }
 
 // GetInstance fetches information about a service instance
 // GET /v2/service_instances/{instance_id}
-//
-// NOTE: This functionality is not implemented.
 func (broker *ServiceBroker) GetInstance(ctx context.Context, instanceID string, details domain.FetchInstanceDetails) (domain.GetInstanceDetailsSpec, error) {
 	broker.Logger.Info("GetInstance", correlation.ID(ctx), lager.Data{
 		"instance_id": instanceID,
+		"service_id":  details.ServiceID,
+		"plan_id":     details.PlanID,
 	})
 
-	return domain.GetInstanceDetailsSpec{}, ErrGetInstancesUnsupported
+	instance, err := db_service.GetServiceInstanceDetailsById(ctx, instanceID)
+	if err != nil {
+		return domain.GetInstanceDetailsSpec{}, ErrInstanceNotFound
+	}
+
+	// the OSB spec requires a 422 while the instance is still being provisioned
+	// or updated. The operation type is only cleared once the platform polls a
+	// successful operation, so whether it is still running is asked of the
+	// provider, and instances whose operation failed are returned as they are.
+	switch instance.OperationType {
+	case models.ProvisionOperationType, models.UpdateOperationType:
+		_, serviceProvider, err := broker.getDefinitionAndProvider(instance.ServiceId)
+		if err != nil {
+			return domain.GetInstanceDetailsSpec{}, err
+		}
+
+		if done, _, err := serviceProvider.PollInstance(ctx, *instance); err == nil && !done {
+			return domain.GetInstanceDetailsSpec{}, apiresponses.ErrConcurrentInstanceAccess
+		}
+	}
+
+	pr, err := db_service.GetProvisionRequestDetailsByInstanceId(ctx, instanceID)
+	if err != nil {
+		return domain.GetInstanceDetailsSpec{}, fmt.Errorf("error retrieving provision request details for %q: %w", instanceID, err)
+	}
+
+	rawParameters, err := pr.GetRequestDetails()
+	if err != nil {
+		return domain.GetInstanceDetailsSpec{}, fmt.Errorf("retrieving request details: %w", err)
+	}
+
+	var parameters map[string]interface{}
+	if len(rawParameters) > 0 {
+		if err := json.Unmarshal(rawParameters, &parameters); err != nil {
+			return domain.GetInstanceDetailsSpec{}, fmt.Errorf("error unmarshalling request details: %w", err)
+		}
+	}
+
+	return domain.GetInstanceDetailsSpec{
+		ServiceID:    instance.ServiceId,
+		PlanID:       instance.PlanId,
+		DashboardURL: "",
+		Parameters:   parameters,
+	}, nil
 }
 
 // LastBindingOperation fetches last operation state for a service binding.
 // GET /v2/service_instances/{instance_id}/service_bindings/{binding_id}/last_operation
-//
-// NOTE: This functionality is not implemented.
 func (broker *ServiceBroker) LastBindingOperation(ctx context.Context, instanceID, bindingID string, details domain.PollDetails) (domain.LastOperation, error) {
 	broker.Logger.Info("LastBindingOperation", correlation.ID(ctx), lager.Data{
 		"instance_id":    instanceID,
@@ -391,7 +531,109 @@ func (broker *ServiceBroker) LastBindingOperation(ctx context.Context, instanceI
 		"operation_data": details.OperationData,
 	})
 
-	return domain.LastOperation{}, apiresponses.ErrAsyncRequired
+	bindRecord, err := db_service.GetServiceBindingCredentialsByServiceInstanceIdAndBindingId(ctx, instanceID, bindingID)
+	if err != nil {
+		return domain.LastOperation{}, apiresponses.ErrBindingDoesNotExist
+	}
+
+	instanceRecord, err := db_service.GetServiceInstanceDetailsById(ctx, instanceID)
+	if err != nil {
+		return domain.LastOperation{}, apiresponses.ErrInstanceDoesNotExist
+	}
+
+	serviceDefinition, serviceProvider, err := broker.getDefinitionAndProvider(instanceRecord.ServiceId)
+	if err != nil {
+		return domain.LastOperation{}, err
+	}
+
+	if !serviceProvider.BindsAsync() {
+		return domain.LastOperation{}, apiresponses.ErrAsyncRequired
+	}
+
+	if bindRecord.OperationType == models.ClearOperationType {
+		return domain.LastOperation{State: domain.Succeeded}, nil
+	}
+
+	done, message, err := serviceProvider.PollBinding(ctx, instanceID, bindingID)
+	if err != nil {
+		return domain.LastOperation{State: domain.Failed, Description: err.Error()}, nil
+	}
+
+	if !done {
+		return domain.LastOperation{State: domain.InProgress, Description: message}, nil
+	}
+
+	switch bindRecord.OperationType {
+	case models.BindOperationType:
+		err = broker.completeBind(ctx, serviceDefinition, serviceProvider, bindRecord, instanceRecord)
+	case models.UnbindOperationType:
+		err = broker.completeUnbind(ctx, serviceDefinition, bindRecord)
+	}
+
+	return domain.LastOperation{State: domain.Succeeded, Description: message}, err
+}
+
+// completeBind stores the credentials of a binding once its asynchronous
+// operation has succeeded.
+func (broker *ServiceBroker) completeBind(ctx context.Context, serviceDefinition *broker.ServiceDefinition, serviceProvider broker.ServiceProvider, bindRecord *models.ServiceBindingCredentials, instanceRecord *models.ServiceInstanceDetails) error {
+	credsDetails, err := serviceProvider.BindingOutputs(ctx, bindRecord.ServiceInstanceId, bindRecord.BindingId)
+	if err != nil {
+		return fmt.Errorf("error retrieving binding outputs: %w", err)
+	}
+
+	if err := bindRecord.SetOtherDetails(credsDetails); err != nil {
+		return fmt.Errorf("error serializing credentials: %w. WARNING: these credentials cannot be unbound through cf. Please contact your operator for cleanup", err)
+	}
+
+	if broker.Credstore != nil {
+		binding, err := serviceProvider.BuildInstanceCredentials(ctx, *bindRecord, *instanceRecord)
+		if err != nil {
+			return fmt.Errorf("error building credentials: %w", err)
+		}
+
+		credentialName := getCredentialName(broker.getServiceName(serviceDefinition), bindRecord.BindingId)
+		if _, err := broker.Credstore.Put(credentialName, binding.Credentials); err != nil {
+			return fmt.Errorf("bind failure: unable to put credentials in Credstore: %w", err)
+		}
+	}
+
+	bindRecord.OperationType = models.ClearOperationType
+	bindRecord.OperationId = ""
+	if err := db_service.SaveServiceBindingCredentials(ctx, bindRecord); err != nil {
+		return fmt.Errorf("error saving credentials to database: %w. WARNING: these credentials cannot be unbound through cf. Please contact your operator for cleanup", err)
+	}
+
+	return nil
+}
+
+// completeUnbind removes all traces of a binding once its asynchronous
+// operation has succeeded.
+func (broker *ServiceBroker) completeUnbind(ctx context.Context, serviceDefinition *broker.ServiceDefinition, bindRecord *models.ServiceBindingCredentials) error {
+	if err := broker.deleteCredentials(serviceDefinition, bindRecord.BindingId); err != nil {
+		return err
+	}
+
+	if err := db_service.DeleteServiceBindingCredentials(ctx, bindRecord); err != nil {
+		return fmt.Errorf("error soft-deleting credentials from database: %s. WARNING: these credentials will remain visible in cf. Contact your operator for cleanup", err)
+	}
+
+	return nil
+}
+
+// deleteCredentials removes the credentials of a binding from the Credstore, if
+// one is configured.
+func (broker *ServiceBroker) deleteCredentials(serviceDefinition *broker.ServiceDefinition, bindingID string) error {
+	if broker.Credstore == nil {
+		return nil
+	}
+
+	credentialName := getCredentialName(broker.getServiceName(serviceDefinition), bindingID)
+
+	if err := broker.Credstore.DeletePermission(credentialName); err != nil {
+		broker.Logger.Error(fmt.Sprintf("fail to delete permissions on the key %s", credentialName), err)
+	}
+
+	return broker.Credstore.Delete(credentialName)
 }
 
 // Unbind destroys an account and credentials with access to an instance of a service.
@@ -414,6 +656,11 @@ func (broker *ServiceBroker) Unbind(ctx context.Context, instanceID, bindingID s
 		return domain.UnbindSpec{}, apiresponses.ErrBindingDoesNotExist
 	}
 
+	// the platform may retry the request while the binding is still being deleted
+	if existingBinding.OperationType == models.UnbindOperationType && asyncSupported {
+		return domain.UnbindSpec{IsAsync: true, OperationData: existingBinding.OperationId}, nil
+	}
+
 	// get existing service instance details
 	instance, err := db_service.GetServiceInstanceDetailsById(ctx, instanceID)
 	if err != nil {
@@ -449,23 +696,28 @@ func (broker *ServiceBroker) Unbind(ctx context.Context, instanceID, bindingID s
 		return domain.UnbindSpec{}, err
 	}
 
+	if asyncSupported && serviceProvider.BindsAsync() {
+		operationId, err := serviceProvider.UnbindAsync(ctx, *instance, *existingBinding, vars)
+		if err != nil {
+			return domain.UnbindSpec{}, err
+		}
+
+		existingBinding.OperationType = models.UnbindOperationType
+		existingBinding.OperationId = operationId
+		if err := db_service.SaveServiceBindingCredentials(ctx, existingBinding); err != nil {
+			return domain.UnbindSpec{}, fmt.Errorf("error saving binding to database: %s. WARNING: these credentials will remain visible in cf. Contact your operator for cleanup", err)
+		}
+
+		return domain.UnbindSpec{IsAsync: true, OperationData: operationId}, nil
+	}
+
 	// remove binding from service provider
 	if err := serviceProvider.Unbind(ctx, *instance, *existingBinding, vars); err != nil {
 		return domain.UnbindSpec{}, err
 	}
 
-	if broker.Credstore != nil {
-		credentialName := getCredentialName(broker.getServiceName(serviceDefinition), bindingID)
-
-		err = broker.Credstore.DeletePermission(credentialName)
-		if err != nil {
-			broker.Logger.Error(fmt.Sprintf("fail to delete permissions on the key %s", credentialName), err)
-		}
-
-		err := broker.Credstore.Delete(credentialName)
-		if err != nil {
-			return domain.UnbindSpec{}, err
-		}
+	if err := broker.deleteCredentials(serviceDefinition, bindingID); err != nil {
+		return domain.UnbindSpec{}, err
 	}
 
 	// remove binding from database
@@ -476,6 +728,70 @@ func (broker *ServiceBroker) Unbind(ctx context.Context, instanceID, bindingID s
 	return domain.UnbindSpec{}, nil
 }
 
+// RotateBindingCredentials replaces the credentials of a binding without the
+// app having to unbind and bind again. The new credentials are saved in place
+// of the old ones, and written to the Credstore if one is configured, so the
+// app gets them when it is next restaged. If the grace period is not zero, the
+// previous credentials remain valid until it ends.
+//
+// It is not part of the OSB API, and is offered to operators by the admin API
+// and the rotate-credentials command. It is not cancelled with the context, as
+// once the rotation is applied the new credentials must be saved.
+func (broker *ServiceBroker) RotateBindingCredentials(ctx context.Context, instanceID, bindingID string, gracePeriod time.Duration) error {
+	broker.Logger.Info("RotateBindingCredentials", correlation.ID(ctx), lager.Data{
+		"instance_id":  instanceID,
+		"binding_id":   bindingID,
+		"grace_period": gracePeriod.String(),
+	})
+	ctx = correlation.Background(ctx)
+
+	bindRecord, err := db_service.GetServiceBindingCredentialsByServiceInstanceIdAndBindingId(ctx, instanceID, bindingID)
+	if err != nil {
+		return fmt.Errorf("error retrieving binding: %w", err)
+	}
+
+	if bindRecord.OperationType != models.ClearOperationType {
+		return fmt.Errorf("cannot rotate the credentials of binding %q while a %s operation is in progress", bindingID, bindRecord.OperationType)
+	}
+
+	instanceRecord, err := db_service.GetServiceInstanceDetailsById(ctx, instanceID)
+	if err != nil {
+		return fmt.Errorf("error retrieving service instance details: %w", err)
+	}
+
+	serviceDefinition, serviceProvider, err := broker.getDefinitionAndProvider(instanceRecord.ServiceId)
+	if err != nil {
+		return fmt.Errorf("error retrieving service definition: %w", err)
+	}
+
+	credsDetails, err := serviceProvider.RotateBindingCredentials(ctx, *instanceRecord, *bindRecord, gracePeriod)
+	if err != nil {
+		return fmt.Errorf("error rotating credentials: %w", err)
+	}
+
+	if err := bindRecord.SetOtherDetails(credsDetails); err != nil {
+		return fmt.Errorf("error serializing credentials: %w", err)
+	}
+
+	if err := db_service.SaveServiceBindingCredentials(ctx, bindRecord); err != nil {
+		return fmt.Errorf("error saving credentials to database: %w", err)
+	}
+
+	if broker.Credstore != nil {
+		binding, err := serviceProvider.BuildInstanceCredentials(ctx, *bindRecord, *instanceRecord)
+		if err != nil {
+			return fmt.Errorf("error building credentials: %w", err)
+		}
+
+		credentialName := getCredentialName(broker.getServiceName(serviceDefinition), bindingID)
+		if _, err := broker.Credstore.Put(credentialName, binding.Credentials); err != nil {
+			return fmt.Errorf("unable to put credentials in Credstore: %w", err)
+		}
+	}
+
+	return nil
+}
+
 // LastOperation fetches last operation state for a service instance.
 // It is bound to the `GET /v2/service_instances/:instance_id/last_operation` endpoint.
 // It is called by `cf create-service` or `cf delete-service` if the operation was asynchronous.
@@ -577,6 +893,10 @@ func (broker *ServiceBroker) Update(ctx context.Context, instanceID string, deta
 		return response, err
 	}
 
+	if err := checkMaintenanceInfo(*plan, details.MaintenanceInfo); err != nil {
+		return response, err
+	}
+
 	// verify async provisioning is allowed if it is required
 	shouldProvisionAsync := serviceHelper.ProvisionsAsync()
 	if shouldProvisionAsync && !asyncAllowed {
@@ -588,6 +908,17 @@ func (broker *ServiceBroker) Update(ctx context.Context, instanceID string, deta
 		return response, ErrInvalidUserInput
 	}
 
+	dryRun, err := extractDryRun(&details)
+	if err != nil {
+		return response, err
+	}
+
+	// a dry run plans the update with the templates and plan the instance was
+	// last deployed with, so it cannot preview a change to either
+	if dryRun && (details.PlanID != instance.PlanId || isPlanChange(details) || isUpgrade(details)) {
+		return response, ErrDryRunPlanChange
+	}
+
 	allowUpdate, err := brokerService.AllowedUpdate(details)
 
 	if err != nil {
@@ -615,8 +946,33 @@ func (broker *ServiceBroker) Update(ctx context.Context, instanceID string, deta
 		return response, err
 	}
 
+	// a dry run leaves the instance as it is, so there are no details to save
+	if dryRun {
+		previewDetails, err := serviceHelper.PreviewUpdate(ctx, vars)
+		if err != nil {
+			return domain.UpdateServiceSpec{}, err
+		}
+
+		response.IsAsync = shouldProvisionAsync
+		response.OperationData = previewDetails.OperationId
+		return response, nil
+	}
+
+	// an instance is upgraded when the platform asks for the maintenance_info
+	// of its plan to change, which applies the current templates of the
+	// service to it with the parameters it already has
+	update := serviceHelper.Update
+	if isUpgrade(details) {
+		broker.Logger.Info("Upgrading", correlation.ID(ctx), lager.Data{
+			"instance_id": instanceID,
+			"from":        maintenanceInfoVersion(details.PreviousValues.MaintenanceInfo),
+			"to":          maintenanceInfoVersion(details.MaintenanceInfo),
+		})
+		update = serviceHelper.Upgrade
+	}
+
 	// get instance details
-	newInstanceDetails, err := serviceHelper.Update(ctx, vars)
+	newInstanceDetails, err := update(ctx, vars)
 	if err != nil {
 		return domain.UpdateServiceSpec{}, err
 	}
@@ -646,6 +1002,79 @@ func (broker *ServiceBroker) Update(ctx context.Context, instanceID string, deta
 	return response, nil
 }
 
+// extractDryRun removes the reserved dry run parameter from the parameters of
+// the update, and reports whether it was set.
+func extractDryRun(details *domain.UpdateDetails) (bool, error) {
+	if len(details.GetRawParameters()) == 0 {
+		return false, nil
+	}
+
+	params := map[string]json.RawMessage{}
+	if err := json.Unmarshal(details.GetRawParameters(), &params); err != nil {
+		return false, ErrInvalidUserInput
+	}
+
+	value, ok := params[dryRunParameter]
+	if !ok {
+		return false, nil
+	}
+
+	var dryRun bool
+	if err := json.Unmarshal(value, &dryRun); err != nil {
+		return false, ErrInvalidDryRun
+	}
+
+	delete(params, dryRunParameter)
+	raw, err := json.Marshal(params)
+	if err != nil {
+		return false, err
+	}
+	details.RawParameters = raw
+
+	return dryRun, nil
+}
+
+// checkMaintenanceInfo returns an error if the platform asks for a
+// maintenance_info other than the plan's.
+func checkMaintenanceInfo(plan broker.ServicePlan, requested *domain.MaintenanceInfo) error {
+	switch {
+	case requested == nil:
+		return nil
+	case plan.MaintenanceInfo == nil:
+		return apiresponses.ErrMaintenanceInfoNilConflict
+	case !plan.MaintenanceInfo.Equals(*requested):
+		return apiresponses.ErrMaintenanceInfoConflict
+	default:
+		return nil
+	}
+}
+
+// isPlanChange reports whether the platform asks for the plan of the instance
+// to change.
+func isPlanChange(details domain.UpdateDetails) bool {
+	return details.PreviousValues.PlanID != "" && details.PreviousValues.PlanID != details.PlanID
+}
+
+// isUpgrade reports whether the update changes the maintenance_info of the
+// instance.
+func isUpgrade(details domain.UpdateDetails) bool {
+	switch {
+	case details.MaintenanceInfo == nil:
+		return false
+	case details.PreviousValues.MaintenanceInfo == nil:
+		return true
+	default:
+		return !details.PreviousValues.MaintenanceInfo.Equals(*details.MaintenanceInfo)
+	}
+}
+
+func maintenanceInfoVersion(info *domain.MaintenanceInfo) string {
+	if info == nil {
+		return ""
+	}
+	return info.Version
+}
+
 func isValidOrEmptyJSON(msg json.RawMessage) bool {
 	return msg == nil || len(msg) == 0 || json.Valid(msg)
 }
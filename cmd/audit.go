@@ -0,0 +1,97 @@
+// Copyright 2021 the Service Broker Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/cloudfoundry-incubator/cloud-service-broker/db_service"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/pkg/audit"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/utils"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	auditCmd := &cobra.Command{
+		Use:   "audit",
+		Short: "Read the audit log of the broker",
+		Long:  `Read the audit log of the provision, update, deprovision, bind and unbind requests made to the broker`,
+		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+			db := db_service.New(utils.NewLogger("audit"))
+			if err := setEncryptorFromEnv(db); err != nil {
+				log.Fatal(err)
+			}
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			cmd.Help()
+		},
+	}
+	rootCmd.AddCommand(auditCmd)
+
+	var instanceID string
+	var limit int
+	var asJSON bool
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "show the most recent audit events, newest first",
+		Long: `Show the most recent audit events, newest first, and check that they have
+not been changed since they were recorded. Without --instance, also check that
+no events have been deleted or inserted between them.`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			events, err := db_service.GetAuditEvents(context.Background(), instanceID, limit)
+			if err != nil {
+				log.Fatal(err)
+			}
+			for i := range events {
+				if events[i].Parameters, err = events[i].GetParameters(); err != nil {
+					log.Fatalf("error decrypting the parameters of audit event %d: %v", events[i].ID, err)
+				}
+			}
+
+			if asJSON {
+				for _, event := range events {
+					line, err := audit.Marshal(event)
+					if err != nil {
+						log.Fatal(err)
+					}
+					fmt.Println(string(line))
+				}
+			} else {
+				w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.StripEscape)
+				fmt.Fprintln(w, "ID\tTime\tOperation\tInstance\tBinding\tUser\tOutcome\tRequest ID\tMessage")
+				for _, event := range events {
+					fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%q\n", event.ID, event.StartedAt.Format(time.RFC822), event.Operation, event.ServiceInstanceId, event.BindingId, audit.User(event), event.Outcome, event.RequestId, event.Message)
+				}
+				w.Flush()
+			}
+
+			// the events of an instance are not consecutive in the chain, so
+			// only their own hashes are checked
+			if err := audit.Verify(events, instanceID == ""); err != nil {
+				log.Fatalf("the audit log has been tampered with: %v", err)
+			}
+		},
+	}
+	listCmd.Flags().StringVarP(&instanceID, "instance", "i", "", "only show the events of this service instance and its bindings")
+	listCmd.Flags().IntVarP(&limit, "limit", "n", 50, "the number of events to show, or 0 for all of them")
+	listCmd.Flags().BoolVarP(&asJSON, "json", "", false, "show the events as JSON, one per line, including their parameters")
+	auditCmd.AddCommand(listCmd)
+}
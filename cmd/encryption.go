@@ -0,0 +1,164 @@
+// Copyright 2021 the Service Broker Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/cloudfoundry-incubator/cloud-service-broker/db_service"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/db_service/models"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/internal/encryption"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/internal/encryption/dbrotator"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/utils"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	var encrypt bool
+
+	encryptionCmd := &cobra.Command{
+		Use:   "encryption",
+		Short: "Inspect the encryption of the database",
+		Run: func(cmd *cobra.Command, args []string) {
+			cmd.Help()
+		},
+	}
+	rootCmd.AddCommand(encryptionCmd)
+
+	encryptionCmd.AddCommand(&cobra.Command{
+		Use:   "status",
+		Short: "Show the primary password and the progress of re-encrypting the database",
+		Long: `Show the primary password that the database is encrypted with, and the one
+that is configured. When they differ, a running broker re-encrypts the
+database in the background, and the progress of each table is shown, along
+with the error of the last attempt if it failed. Once they are the same, old
+passwords can be removed from the configuration.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			logger := utils.NewLogger("encryption-status")
+			db := db_service.New(logger)
+
+			config, err := encryption.ParseConfiguration(db, viper.GetBool(encryptionEnabled), viper.GetString(encryptionPasswords))
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			fmt.Printf("Stored primary:     %s\n", labelName(config.StoredPrimaryLabel))
+			fmt.Printf("Configured primary: %s\n", labelName(config.ConfiguredPrimaryLabel))
+			if !config.Changed {
+				fmt.Println("The database is encrypted with the configured primary")
+				return
+			}
+			fmt.Println("The database is being re-encrypted with the configured primary by the broker")
+			fmt.Println()
+
+			status, err := dbrotator.Status(db, config.ConfiguredPrimaryLabel)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.StripEscape)
+			fmt.Fprintln(w, "Table\tRows\tDone\tUpdated\tCompleted")
+			for _, s := range status {
+				fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%s\n", s.Table, s.Rows, s.RowsDone, formatTime(s.UpdatedAt), formatTime(s.CompletedAt))
+			}
+			w.Flush()
+
+			lastError, failedAt, err := dbrotator.LastError(db, config.ConfiguredPrimaryLabel)
+			switch {
+			case err != nil:
+				log.Fatal(err)
+			case lastError != "":
+				fmt.Println()
+				fmt.Printf("The last attempt failed at %s, and is retried by the broker: %s\n", formatTime(&failedAt), lastError)
+			}
+		},
+	})
+
+	verifyCmd := &cobra.Command{
+		Use:   "verify",
+		Short: "Check the database for values that should be encrypted but are not",
+		Long: `Check that every value of the encrypted columns of the database can be
+decrypted with the configured primary password. Values that cannot be are
+listed, with whether they are plaintext, or encrypted with a password that is
+not configured. Plaintext is found when a column is encrypted by a newer
+version of the broker than the one that wrote the values.
+
+With --encrypt, plaintext values are encrypted with the primary password.
+
+The command exits with a non-zero status if any values are found that have not
+been encrypted.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			logger := utils.NewLogger("encryption-verify")
+			db := db_service.New(logger)
+
+			config, err := encryption.ParseConfiguration(db, viper.GetBool(encryptionEnabled), viper.GetString(encryptionPasswords))
+			switch {
+			case err != nil:
+				log.Fatal(err)
+			case config.Changed:
+				log.Fatal("the database is being re-encrypted, verify it once `encryption status` shows it is encrypted with the configured primary")
+			case config.ConfiguredPrimaryLabel == "":
+				fmt.Println("Encryption is not enabled, so the database is not encrypted")
+				return
+			}
+			models.SetEncryptor(config.Encryptor)
+
+			findings, err := dbrotator.Verify(context.Background(), db, encrypt)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			if len(findings) == 0 {
+				fmt.Printf("Every value is encrypted with %s\n", labelName(config.ConfiguredPrimaryLabel))
+				return
+			}
+
+			failed := false
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.StripEscape)
+			fmt.Fprintln(w, "Table\tColumn\tKey\tProblem")
+			for _, f := range findings {
+				problem := "encrypted with an unknown password"
+				switch {
+				case f.Encrypted:
+					problem = "was plaintext, now encrypted"
+				case f.Plaintext:
+					problem = "plaintext"
+				}
+				fmt.Fprintf(w, "%s\t%s\t%q\t%s\n", f.Table, f.Column, f.Key, problem)
+				failed = failed || !f.Encrypted
+			}
+			w.Flush()
+
+			if failed {
+				os.Exit(1)
+			}
+		},
+	}
+	verifyCmd.Flags().BoolVarP(&encrypt, "encrypt", "", false, "encrypt the plaintext values that are found")
+	encryptionCmd.AddCommand(verifyCmd)
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+	return t.Format(time.RFC822)
+}
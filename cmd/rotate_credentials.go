@@ -0,0 +1,80 @@
+// Copyright 2021 the Service Broker Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/cloudfoundry-incubator/cloud-service-broker/brokerapi/brokers"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/db_service"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/utils"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	var gracePeriod time.Duration
+
+	rotateCmd := &cobra.Command{
+		Use:   "rotate-credentials <binding-id>",
+		Short: "Replace the credentials of a binding without unbinding it",
+		Long: `Replace the credentials of a binding without the app having to unbind and bind
+again. The bind template of the service is applied to the binding with a new
+seed for the secrets it generates, and the new credentials are saved, and
+written to the credential store if one is configured. The app gets them when
+it is next restaged.
+
+With --grace-period, the previous credentials remain valid until the period
+ends, when a running broker removes them. This needs the bind template to
+support it, see the brokerpak specification.`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			logger := utils.NewLogger("rotate-credentials")
+			db := db_service.New(logger)
+			if err := setEncryptorFromEnv(db); err != nil {
+				log.Fatal(err)
+			}
+
+			cfg, err := brokers.NewBrokerConfigFromEnv(logger)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			serviceBroker, err := brokers.New(cfg, logger)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			ctx := context.Background()
+			binding, err := db_service.GetServiceBindingCredentialsByBindingId(ctx, args[0])
+			if err != nil {
+				log.Fatalf("error retrieving binding %q: %s", args[0], err)
+			}
+
+			if err := serviceBroker.RotateBindingCredentials(ctx, binding.ServiceInstanceId, binding.BindingId, gracePeriod); err != nil {
+				log.Fatal(err)
+			}
+
+			fmt.Printf("Rotated the credentials of binding %q\n", binding.BindingId)
+			if gracePeriod > 0 {
+				fmt.Printf("The previous credentials remain valid until %s\n", time.Now().Add(gracePeriod).Format(time.RFC822))
+			}
+		},
+	}
+	rotateCmd.Flags().DurationVarP(&gracePeriod, "grace-period", "g", 0, "keep the previous credentials valid for this long, such as 24h")
+	rootCmd.AddCommand(rotateCmd)
+}
@@ -17,8 +17,10 @@ package cmd
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"net/http"
+	"time"
 
 	"gorm.io/gorm"
 
@@ -28,10 +30,14 @@ import (
 	"github.com/cloudfoundry-incubator/cloud-service-broker/db_service/models"
 	"github.com/cloudfoundry-incubator/cloud-service-broker/internal/encryption"
 	"github.com/cloudfoundry-incubator/cloud-service-broker/internal/encryption/dbrotator"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/pkg/audit"
 	"github.com/cloudfoundry-incubator/cloud-service-broker/pkg/broker"
 	"github.com/cloudfoundry-incubator/cloud-service-broker/pkg/brokerpak"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/pkg/metrics"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/pkg/providers/tf"
 	"github.com/cloudfoundry-incubator/cloud-service-broker/pkg/server"
 	"github.com/cloudfoundry-incubator/cloud-service-broker/pkg/toggles"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/pkg/tracing"
 	"github.com/cloudfoundry-incubator/cloud-service-broker/utils"
 	"github.com/gorilla/mux"
 	"github.com/pivotal-cf/brokerapi/v8"
@@ -45,13 +51,19 @@ const (
 	apiPasswordProp     = "api.password"
 	apiPortProp         = "api.port"
 	apiHostProp         = "api.host"
+	adminUserProp       = "api.admin_user"
+	adminPasswordProp   = "api.admin_password"
 	encryptionPasswords = "db.encryption.passwords"
 	encryptionEnabled   = "db.encryption.enabled"
+	auditLogFileProp    = "audit.file"
 )
 
 var cfCompatibilityToggle = toggles.Features.Toggle("enable-cf-sharing", false, `Set all services to have the Sharable flag so they can be shared
 	across spaces in PCF.`)
 
+var resumeInterruptedJobsToggle = toggles.Features.Toggle("resume-interrupted-terraform-jobs", false, `Resume Terraform operations that were
+	interrupted when the broker stopped, rather than marking them as failed.`)
+
 func init() {
 	rootCmd.AddCommand(&cobra.Command{
 		Use:   "serve",
@@ -75,12 +87,19 @@ func init() {
 	viper.BindEnv(apiPasswordProp, "SECURITY_USER_PASSWORD")
 	viper.BindEnv(apiPortProp, "PORT")
 	viper.BindEnv(apiHostProp, "CSB_LISTENER_HOST")
+	viper.BindEnv(adminUserProp, "ADMIN_USER_NAME")
+	viper.BindEnv(adminPasswordProp, "ADMIN_USER_PASSWORD")
 	viper.BindEnv(encryptionPasswords, "ENCRYPTION_PASSWORDS")
 	viper.BindEnv(encryptionEnabled, "ENCRYPTION_ENABLED")
+	viper.BindEnv(auditLogFileProp, "AUDIT_LOG_FILE")
 }
 
 func serve() {
 	logger := utils.NewLogger("cloud-service-broker")
+	if err := tracing.Setup(context.Background(), logger); err != nil {
+		logger.Fatal("Error setting up tracing", err)
+	}
+
 	db := db_service.New(logger)
 	setupDBEncryption(db, logger)
 
@@ -89,11 +108,23 @@ func serve() {
 	if err != nil {
 		logger.Fatal("Error initializing service broker config", err)
 	}
-	var serviceBroker domain.ServiceBroker
-	serviceBroker, err = brokers.New(cfg, logger)
+
+	if err := tf.RecoverInterruptedJobs(context.Background(), cfg.Registry, resumeInterruptedJobsToggle.IsActive(), logger); err != nil {
+		logger.Error("recovering interrupted terraform jobs", err)
+	}
+	tf.StartInterruptedJobSweeper(context.Background(), cfg.Registry, resumeInterruptedJobsToggle.IsActive(), logger)
+
+	if err := tf.StartDriftSweeper(context.Background(), cfg.Registry, logger); err != nil {
+		logger.Error("starting terraform drift sweeper", err)
+	}
+
+	tf.StartCredentialRetirementSweeper(context.Background(), cfg.Registry, logger)
+
+	csb, err := brokers.New(cfg, logger)
 	if err != nil {
 		logger.Fatal("Error initializing service broker", err)
 	}
+	var serviceBroker domain.ServiceBroker = csb
 
 	credentials := brokerapi.BrokerCredentials{
 		Username: viper.GetString(apiUserProp),
@@ -105,19 +136,23 @@ func serve() {
 		serviceBroker = server.NewCfSharingWrapper(serviceBroker)
 	}
 
+	serviceBroker = server.NewAuditWrapper(serviceBroker, cfg.Registry, logger, auditSinks(logger)...)
+	serviceBroker = server.NewMetricsWrapper(serviceBroker)
+	serviceBroker = server.NewTracingWrapper(serviceBroker)
+
 	services, err := serviceBroker.Services(context.Background())
 	if err != nil {
 		logger.Error("creating service catalog", err)
 	}
 	logger.Info("service catalog", lager.Data{"catalog": services})
 
-	brokerAPI := brokerapi.New(serviceBroker, logger, credentials)
+	brokerAPI := tracing.Propagate(brokerapi.New(serviceBroker, logger, credentials))
 
 	sqldb, err := db.DB()
 	if err != nil {
 		logger.Error("failed to get database connection", err)
 	}
-	startServer(cfg.Registry, sqldb, brokerAPI)
+	startServer(cfg.Registry, sqldb, brokerAPI, csb)
 }
 
 func serveDocs() {
@@ -128,7 +163,7 @@ func serveDocs() {
 		logger.Error("loading brokerpaks", err)
 	}
 
-	startServer(registry, nil, nil)
+	startServer(registry, nil, nil, nil)
 }
 
 func setupDBEncryption(db *gorm.DB, logger lager.Logger) {
@@ -138,14 +173,16 @@ func setupDBEncryption(db *gorm.DB, logger lager.Logger) {
 	}
 
 	if config.Changed {
-		logger.Info("rotating-database-encryption", lager.Data{"previous-primary": labelName(config.StoredPrimaryLabel), "new-primary": labelName(config.ConfiguredPrimaryLabel)})
+		// The database is re-encrypted in the background while the broker
+		// serves requests, with an encryptor that can read the data encrypted
+		// with both the previous and the new primary password. It is kept
+		// until the broker is restarted, as the encryptor is not safe to
+		// change while serving. An interrupted rotation resumes when the
+		// broker is next started.
 		models.SetEncryptor(config.RotationEncryptor)
-		if err := dbrotator.ReencryptDB(db); err != nil {
-			logger.Fatal("Error rotating database encryption", err)
-		}
-		if err := encryption.UpdatePasswordMetadata(db, config.ConfiguredPrimaryLabel); err != nil {
-			logger.Fatal("Error updating password metadata", err)
-		}
+		go rotateDBEncryption(db, config, logger)
+	} else {
+		models.SetEncryptor(config.Encryptor)
 	}
 
 	if err := encryption.DeletePasswordMetadata(db, config.ToDeleteLabels); err != nil {
@@ -153,10 +190,87 @@ func setupDBEncryption(db *gorm.DB, logger lager.Logger) {
 	}
 
 	logger.Info("database-encryption", lager.Data{"primary": labelName(config.ConfiguredPrimaryLabel)})
-	models.SetEncryptor(config.Encryptor)
+	metrics.EncryptionPrimary.WithLabelValues(labelName(config.ConfiguredPrimaryLabel)).Set(1)
+}
+
+// rotationRetryDelay is how long a failed rotation of the database encryption
+// waits before it is tried again, doubling after each failure up to
+// maxRotationRetryDelay.
+var rotationRetryDelay, maxRotationRetryDelay = time.Minute, 30 * time.Minute
+
+// rotateDBEncryption re-encrypts the database with the configured primary
+// password, and records it as the primary password once done. Only one broker
+// sharing the database rotates it at a time. A rotation that fails, or that
+// another broker is running, is tried again until it has completed, resuming
+// from its checkpoints.
+func rotateDBEncryption(db *gorm.DB, config encryption.Configuration, logger lager.Logger) {
+	data := lager.Data{"previous-primary": labelName(config.StoredPrimaryLabel), "new-primary": labelName(config.ConfiguredPrimaryLabel)}
+	logger.Info("rotating-database-encryption", data)
+	metrics.EncryptionRotationInProgress.Set(1)
+	defer metrics.EncryptionRotationInProgress.Set(0)
+
+	delay := rotationRetryDelay
+	for {
+		err := dbrotator.WithLease(context.Background(), db, config.ConfiguredPrimaryLabel, logger, func(ctx context.Context) error {
+			if err := dbrotator.Reencrypt(ctx, db, config.ConfiguredPrimaryLabel, dbrotator.DefaultBatchSize, logger.Session("rotate-database-encryption")); err != nil {
+				return err
+			}
+			if err := reencryptTerraformStates(ctx, logger); err != nil {
+				return fmt.Errorf("error reencrypting terraform states: %w", err)
+			}
+			if err := encryption.UpdatePasswordMetadata(db, config.ConfiguredPrimaryLabel); err != nil {
+				return fmt.Errorf("error updating password metadata: %w", err)
+			}
+			return nil
+		})
+
+		switch {
+		case err == nil:
+			logger.Info("rotated-database-encryption", data)
+			metrics.EncryptionLastRotation.SetToCurrentTime()
+			return
+		case errors.Is(err, dbrotator.ErrRotationInProgress):
+			// the rotation is checked again once the lease of the other
+			// broker would have expired, in case it stopped
+			logger.Info("database-encryption-rotated-by-another-broker", data)
+			time.Sleep(dbrotator.LeaseDuration)
+		default:
+			logger.Error("rotating-database-encryption", err, lager.Data{"retry-in": delay.String()})
+			time.Sleep(delay)
+			if delay *= 2; delay > maxRotationRetryDelay {
+				delay = maxRotationRetryDelay
+			}
+		}
+	}
+}
+
+// reencryptTerraformStates re-encrypts the Terraform state that is kept outside
+// the database, which is not re-encrypted with it.
+func reencryptTerraformStates(ctx context.Context, logger lager.Logger) error {
+	store, err := tf.NewStateStoreFromEnv()
+	if err != nil {
+		return err
+	}
+
+	return tf.ReencryptStates(ctx, store, logger.Session("reencrypt-terraform-states"))
 }
 
-func startServer(registry broker.BrokerRegistry, db *sql.DB, brokerapi http.Handler) {
+func auditSinks(logger lager.Logger) []audit.Sink {
+	sinks := []audit.Sink{audit.DatabaseSink{}}
+
+	if path := viper.GetString(auditLogFileProp); path != "" {
+		fileSink, err := audit.NewFileSink(path)
+		if err != nil {
+			logger.Fatal("Error opening audit log file", err)
+		}
+		logger.Info("audit-log-file", lager.Data{"path": path})
+		sinks = append(sinks, fileSink)
+	}
+
+	return sinks
+}
+
+func startServer(registry broker.BrokerRegistry, db *sql.DB, brokerapi http.Handler, rotator server.CredentialRotator) {
 	logger := utils.NewLogger("cloud-service-broker")
 
 	router := mux.NewRouter()
@@ -164,11 +278,21 @@ func startServer(registry broker.BrokerRegistry, db *sql.DB, brokerapi http.Hand
 	// match paths going to the brokerapi first
 	if brokerapi != nil {
 		router.PathPrefix("/v2").Handler(brokerapi)
+
+		// the admin API can repair instances, so it is only served when
+		// credentials separate from the platform's have been configured
+		adminUser, adminPassword := viper.GetString(adminUserProp), viper.GetString(adminPasswordProp)
+		if adminUser != "" && adminPassword != "" {
+			server.AddAdminHandler(router, registry, rotator, adminUser, adminPassword, logger)
+		} else {
+			logger.Info("admin API disabled, set ADMIN_USER_NAME and ADMIN_USER_PASSWORD to enable it")
+		}
 	}
 
 	server.AddDocsHandler(router, registry)
 	router.HandleFunc("/examples", server.NewExampleHandler(registry))
 	server.AddHealthHandler(router, db)
+	server.AddMetricsHandler(router)
 
 	port := viper.GetString(apiPortProp)
 	host := viper.GetString(apiHostProp)
@@ -17,31 +17,41 @@ package cmd
 import (
 	"context"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"strconv"
 	"text/tabwriter"
 	"time"
 
+	"code.cloudfoundry.org/lager"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/brokerapi/brokers"
 	"github.com/cloudfoundry-incubator/cloud-service-broker/db_service"
 	"github.com/cloudfoundry-incubator/cloud-service-broker/db_service/models"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/internal/encryption"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/pkg/broker"
 	"github.com/cloudfoundry-incubator/cloud-service-broker/pkg/providers/tf"
-	"github.com/cloudfoundry-incubator/cloud-service-broker/pkg/providers/tf/wrapper"
 	"github.com/cloudfoundry-incubator/cloud-service-broker/utils"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 	"gorm.io/gorm"
 )
 
 func init() {
 	var jobRunner *tf.TfJobRunner
 	var db *gorm.DB
+	var logger lager.Logger
 
 	tfCmd := &cobra.Command{
 		Use:   "tf",
 		Short: "Interact with the Terraform backend",
 		Long:  `Interact with the Terraform backend`,
 		PersistentPreRunE: func(cmd *cobra.Command, args []string) (err error) {
-			logger := utils.NewLogger("tf")
+			logger = utils.NewLogger("tf")
 			db = db_service.New(logger)
+			if err := setEncryptorFromEnv(db); err != nil {
+				return err
+			}
 
 			jobRunner, err = tf.NewTfJobRunerFromEnv()
 			return err
@@ -57,17 +67,8 @@ func init() {
 		Use:   "dump",
 		Short: "dump a Terraform workspace",
 		Run: func(cmd *cobra.Command, args []string) {
-			deployment, err := db_service.GetTerraformDeploymentById(context.Background(), args[0])
-			if err != nil {
-				log.Fatal(err)
-			}
-			w, err := deployment.GetWorkspace()
-			if err != nil {
-				log.Fatal(err)
-			}
-			ws, err := wrapper.DeserializeWorkspace(w)
+			ws, err := jobRunner.Workspace(context.Background(), args[0])
 			if err != nil {
-				fmt.Printf("Error: %s\n", err.Error())
 				log.Fatal(err)
 			}
 
@@ -86,6 +87,18 @@ func init() {
 		},
 	})
 
+	tfCmd.AddCommand(&cobra.Command{
+		Use:   "cancel",
+		Short: "cancel the Terraform job in progress on a workspace",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := jobRunner.Cancel(context.Background(), args[0]); err != nil {
+				log.Fatal(err)
+			}
+			fmt.Printf("Cancellation requested for %q\n", args[0])
+		},
+	})
+
 	tfCmd.AddCommand(&cobra.Command{
 		Use:   "list",
 		Short: "show the list of Terraform workspaces",
@@ -95,8 +108,17 @@ func init() {
 				log.Fatal(err)
 			}
 
+			// the brokerpaks are only needed to tell which workspaces are
+			// outdated, so the list is shown without that if they can't be loaded
+			registry := broker.BrokerRegistry{}
+			if cfg, err := brokers.NewBrokerConfigFromEnv(logger); err != nil {
+				logger.Error("loading-brokerpaks", err)
+			} else {
+				registry = cfg.Registry
+			}
+
 			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.StripEscape)
-			fmt.Fprintln(w, "ID\tLast Operation\tState\tLast Updated\tElapsed\tMessage")
+			fmt.Fprintln(w, "ID\tLast Operation\tState\tLast Updated\tElapsed\tDrift\tBrokerpak\tOutdated\tRetirement Failures\tMessage")
 
 			for _, result := range results {
 				lastUpdate := result.UpdatedAt.Format(time.RFC822)
@@ -106,9 +128,198 @@ func init() {
 					elapsed = time.Since(result.UpdatedAt).Truncate(time.Second).String()
 				}
 
-				fmt.Fprintf(w, "%q\t%s\t%s\t%s\t%s\t%q\n", result.ID, result.LastOperationType, result.LastOperationState, lastUpdate, elapsed, result.LastOperationMessage)
+				brokerpak, outdated := "", ""
+				if status, err := tf.CheckTemplates(context.Background(), registry, &result, logger); err == nil {
+					outdated = strconv.FormatBool(status.Outdated())
+					if p := status.Provenance; p != nil {
+						brokerpak = fmt.Sprintf("%s %s", p.BrokerpakName, p.BrokerpakVersion)
+					}
+				}
+
+				// how many times in a row removing the previous credentials
+				// of a binding has failed
+				retirementFailures := ""
+				if result.CredentialRetirementFailures > 0 {
+					retirementFailures = strconv.Itoa(result.CredentialRetirementFailures)
+				}
+
+				fmt.Fprintf(w, "%q\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%q\n", result.ID, result.LastOperationType, result.LastOperationState, lastUpdate, elapsed, result.DriftStatus, brokerpak, outdated, retirementFailures, result.LastOperationMessage)
+			}
+			w.Flush()
+		},
+	})
+
+	tfCmd.AddCommand(&cobra.Command{
+		Use:   "retry <id>",
+		Short: "run the last operation on a Terraform workspace again if it failed",
+		Long: `Run the last operation on a Terraform workspace again if it failed, using the
+workspace and variables stored when the operation started. Terraform runs in this
+process, so the command waits for the operation to finish, and fails if it does.
+The platform sees the result the next time it polls the last operation of the
+instance or binding. Failed imports are not retried, as applying the import
+templates again could create duplicates of the imported resources.`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			cfg, err := brokers.NewBrokerConfigFromEnv(logger)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			ctx := context.Background()
+			if err := tf.RetryFailedOperation(ctx, cfg.Registry, args[0], logger); err != nil {
+				log.Fatal(err)
+			}
+
+			fmt.Printf("Retrying the last operation on %q\n", args[0])
+			if err := jobRunner.Wait(ctx, args[0]); err != nil {
+				log.Fatalf("the last operation on %q failed again: %s", args[0], err)
+			}
+			fmt.Printf("The last operation on %q succeeded\n", args[0])
+		},
+	})
+
+	stateCmd := &cobra.Command{
+		Use:   "state",
+		Short: "read and repair the Terraform state of a workspace",
+		Run: func(cmd *cobra.Command, args []string) {
+			cmd.Help()
+		},
+	}
+	tfCmd.AddCommand(stateCmd)
+
+	stateCmd.AddCommand(&cobra.Command{
+		Use:   "export <id>",
+		Short: "write the Terraform state of a workspace to stdout",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			state, err := jobRunner.ExportState(context.Background(), args[0])
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			os.Stdout.Write(state)
+		},
+	})
+
+	stateCmd.AddCommand(&cobra.Command{
+		Use:   "import <id> <file>",
+		Short: "replace the Terraform state of a workspace with the contents of a file, or stdin if the file is -",
+		Args:  cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			var state []byte
+			var err error
+			if args[1] == "-" {
+				state, err = io.ReadAll(os.Stdin)
+			} else {
+				state, err = os.ReadFile(args[1])
+			}
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			if err := jobRunner.ImportState(context.Background(), args[0], state); err != nil {
+				log.Fatal(err)
+			}
+			fmt.Printf("Imported state into %q\n", args[0])
+		},
+	})
+
+	stateCmd.AddCommand(&cobra.Command{
+		Use:   "rm <id> <address>...",
+		Short: "run terraform state rm on a workspace, forgetting resources without destroying them",
+		Args:  cobra.MinimumNArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			output, err := deploymentJobRunner(args[0], logger).RemoveState(context.Background(), args[0], args[1:]...)
+			if err != nil {
+				log.Fatal(err)
+			}
+			fmt.Print(output)
+		},
+	})
+
+	stateCmd.AddCommand(&cobra.Command{
+		Use:   "mv <id> <source> <destination>",
+		Short: "run terraform state mv on a workspace, moving a resource to a new address",
+		Args:  cobra.ExactArgs(3),
+		Run: func(cmd *cobra.Command, args []string) {
+			output, err := deploymentJobRunner(args[0], logger).MoveState(context.Background(), args[0], args[1], args[2])
+			if err != nil {
+				log.Fatal(err)
+			}
+			fmt.Print(output)
+		},
+	})
+
+	tfCmd.AddCommand(&cobra.Command{
+		Use:   "drift [id]",
+		Short: "check Terraform workspaces for changes made outside of the broker",
+		Long: `Check a Terraform workspace, or all workspaces with resources, for changes
+made to their resources outside of the broker. The result is recorded on each
+workspace and shown by "tf list". No resources are changed.
+
+Drift detection requires Terraform 0.15.4 or later. Checks of workspaces that
+use older versions fail with an error saying so.`,
+		Args: cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			ctx := context.Background()
+			var results []models.TerraformDeployment
+			if len(args) == 1 {
+				result, err := deploymentJobRunner(args[0], logger).CheckDrift(ctx, args[0])
+				if result == nil {
+					log.Fatal(err)
+				}
+				results = append(results, *result)
+			} else {
+				cfg, err := brokers.NewBrokerConfigFromEnv(logger)
+				if err != nil {
+					log.Fatal(err)
+				}
+				results, err = tf.CheckAllDrift(ctx, cfg.Registry, time.Now(), logger)
+				if err != nil {
+					log.Fatal(err)
+				}
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.StripEscape)
+			fmt.Fprintln(w, "ID\tDrift\tChecked\tMessage")
+			for _, result := range results {
+				fmt.Fprintf(w, "%q\t%s\t%s\t%q\n", result.ID, result.DriftStatus, result.DriftCheckedAt.Format(time.RFC822), result.DriftMessage)
 			}
 			w.Flush()
 		},
 	})
 }
+
+// setEncryptorFromEnv sets the encryptor of the database models from the
+// encryption configuration. Rotating the encryption is left to the broker, so
+// that it is never done by more than one process. While it is rotating, the
+// data is read with both the previous and the new primary password.
+func setEncryptorFromEnv(db *gorm.DB) error {
+	config, err := encryption.ParseConfiguration(db, viper.GetBool(encryptionEnabled), viper.GetString(encryptionPasswords))
+	switch {
+	case err != nil:
+		return fmt.Errorf("error parsing encryption configuration: %w", err)
+	case config.Changed:
+		models.SetEncryptor(config.RotationEncryptor)
+	default:
+		models.SetEncryptor(config.Encryptor)
+	}
+
+	return nil
+}
+
+// deploymentJobRunner gets the TfJobRunner of the service that the deployment
+// belongs to, which is needed to run Terraform with the service's brokerpak.
+func deploymentJobRunner(id string, logger lager.Logger) *tf.TfJobRunner {
+	cfg, err := brokers.NewBrokerConfigFromEnv(logger)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	runner, err := tf.NewTfJobRunnerForDeployment(context.Background(), cfg.Registry, id, logger)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return runner
+}
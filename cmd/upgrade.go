@@ -0,0 +1,193 @@
+// Copyright 2021 the Service Broker Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+
+	"github.com/cloudfoundry-incubator/cloud-service-broker/brokerapi/brokers"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/db_service"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/pkg/broker"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/pkg/providers/tf"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/utils"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	var service, plan, reportFile string
+	var opts tf.UpgradeOptions
+
+	upgradeCmd := &cobra.Command{
+		Use:   "upgrade",
+		Short: "Upgrade the instances of a service to the current templates of its brokerpak",
+		Long: `Upgrade the instances of a service to the current templates of its brokerpak,
+as "cf upgrade-service" does for a single instance. The provision template of
+the service is applied to each instance with the parameters it already has.
+Instances that already use the current template are left as they are.
+
+The result of each instance is printed as it completes. With --report, results
+are also appended to a file, one JSON object per line, and instances that the
+file records as upgraded are skipped, so an interrupted run can be resumed by
+running the command again with the same file.
+
+Terraform runs in this process. When interrupted, no more upgrades are started
+and the command waits for those in progress to finish.`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			logger := utils.NewLogger("upgrade")
+			db := db_service.New(logger)
+			if err := setEncryptorFromEnv(db); err != nil {
+				log.Fatal(err)
+			}
+
+			cfg, err := brokers.NewBrokerConfigFromEnv(logger)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			defn, err := findService(cfg.Registry, service)
+			if err != nil {
+				log.Fatal(err)
+			}
+			opts.ServiceId = defn.Id
+
+			if plan != "" {
+				opts.PlanId, err = findPlanId(defn, plan)
+				if err != nil {
+					log.Fatal(err)
+				}
+			}
+
+			report := func(result tf.UpgradeResult) {}
+			if reportFile != "" {
+				opts.Skip, err = readUpgradeReport(reportFile)
+				if err != nil {
+					log.Fatal(err)
+				}
+
+				f, err := os.OpenFile(reportFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+				if err != nil {
+					log.Fatal(err)
+				}
+				defer f.Close()
+
+				encoder := json.NewEncoder(f)
+				report = func(result tf.UpgradeResult) {
+					if err := encoder.Encode(result); err != nil {
+						log.Fatal(err)
+					}
+				}
+			}
+
+			// interrupting stops new upgrades from starting, and waits for the
+			// ones in progress to finish, as Terraform runs in this process and
+			// exiting would interrupt them. Interrupting again exits at once.
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			interrupts := make(chan os.Signal, 1)
+			signal.Notify(interrupts, os.Interrupt)
+			go func() {
+				<-interrupts
+				signal.Stop(interrupts)
+				fmt.Fprintln(os.Stderr, "waiting for the upgrades in progress to finish, interrupt again to exit now")
+				cancel()
+			}()
+
+			var mutex sync.Mutex
+			counts := make(map[string]int)
+			err = tf.UpgradeInstances(ctx, cfg.Registry, opts, func(result tf.UpgradeResult) {
+				mutex.Lock()
+				defer mutex.Unlock()
+
+				counts[result.Outcome]++
+				report(result)
+				fmt.Printf("%s\t%s\t%q\n", result.InstanceId, result.Outcome, result.Message)
+			}, logger)
+
+			fmt.Printf("\n%d upgraded, %d up to date, %d pending, %d skipped, %d failed\n",
+				counts[tf.Upgraded], counts[tf.UpToDate], counts[tf.UpgradePending], counts[tf.UpgradeSkipped], counts[tf.UpgradeFailed])
+			switch {
+			case err != nil:
+				log.Fatal(err)
+			case counts[tf.UpgradeFailed] > 0:
+				os.Exit(1)
+			}
+		},
+	}
+	upgradeCmd.Flags().StringVarP(&service, "service", "s", "", "the name or ID of the service whose instances are upgraded")
+	upgradeCmd.Flags().StringVarP(&plan, "plan", "p", "", "only upgrade the instances of the plan with this name or ID")
+	upgradeCmd.Flags().IntVarP(&opts.Parallelism, "parallelism", "n", 1, "the number of instances to upgrade at once")
+	upgradeCmd.Flags().BoolVarP(&opts.DryRun, "dry-run", "", false, "show the instances that would be upgraded without changing them")
+	upgradeCmd.Flags().StringVarP(&reportFile, "report", "r", "", "append the results to this file, and skip the instances it records as upgraded")
+	upgradeCmd.MarkFlagRequired("service")
+	rootCmd.AddCommand(upgradeCmd)
+}
+
+func findService(registry broker.BrokerRegistry, nameOrId string) (*broker.ServiceDefinition, error) {
+	for _, svc := range registry.GetAllServices() {
+		if svc.Name == nameOrId || svc.Id == nameOrId {
+			return svc, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unknown service: %q", nameOrId)
+}
+
+func findPlanId(defn *broker.ServiceDefinition, nameOrId string) (string, error) {
+	for _, plan := range defn.Plans {
+		if plan.Name == nameOrId || plan.ID == nameOrId {
+			return plan.ID, nil
+		}
+	}
+
+	return "", fmt.Errorf("unknown plan %q of service %q", nameOrId, defn.Name)
+}
+
+// readUpgradeReport gets the ids of the instances that a report written by an
+// earlier run records as upgraded or up to date.
+func readUpgradeReport(path string) (map[string]bool, error) {
+	done := make(map[string]bool)
+
+	f, err := os.Open(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return done, nil
+	case err != nil:
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var result tf.UpgradeResult
+		if err := json.Unmarshal(scanner.Bytes(), &result); err != nil {
+			return nil, fmt.Errorf("error reading upgrade report %q: %w", path, err)
+		}
+
+		if result.Outcome == tf.Upgraded || result.Outcome == tf.UpToDate {
+			done[result.InstanceId] = true
+		}
+	}
+
+	return done, scanner.Err()
+}
@@ -27,7 +27,7 @@ func CreateServiceInstanceDetails(ctx context.Context, object *models.ServiceIns
 	return defaultDatastore().CreateServiceInstanceDetails(ctx, object)
 }
 func (ds *SqlDatastore) CreateServiceInstanceDetails(ctx context.Context, object *models.ServiceInstanceDetails) error {
-	return ds.db.Create(object).Error
+	return ds.db.WithContext(ctx).Create(object).Error
 }
 
 // SaveServiceInstanceDetails updates an existing record in the database.
@@ -35,7 +35,7 @@ func SaveServiceInstanceDetails(ctx context.Context, object *models.ServiceInsta
 	return defaultDatastore().SaveServiceInstanceDetails(ctx, object)
 }
 func (ds *SqlDatastore) SaveServiceInstanceDetails(ctx context.Context, object *models.ServiceInstanceDetails) error {
-	return ds.db.Save(object).Error
+	return ds.db.WithContext(ctx).Save(object).Error
 }
 
 // DeleteServiceInstanceDetailsById soft-deletes the record by its key (id).
@@ -43,7 +43,7 @@ func DeleteServiceInstanceDetailsById(ctx context.Context, id string) error {
 	return defaultDatastore().DeleteServiceInstanceDetailsById(ctx, id)
 }
 func (ds *SqlDatastore) DeleteServiceInstanceDetailsById(ctx context.Context, id string) error {
-	return ds.db.Where("id = ?", id).Delete(&models.ServiceInstanceDetails{}).Error
+	return ds.db.WithContext(ctx).Where("id = ?", id).Delete(&models.ServiceInstanceDetails{}).Error
 }
 
 // DeleteServiceInstanceDetails soft-deletes the record.
@@ -51,7 +51,7 @@ func DeleteServiceInstanceDetails(ctx context.Context, record *models.ServiceIns
 	return defaultDatastore().DeleteServiceInstanceDetails(ctx, record)
 }
 func (ds *SqlDatastore) DeleteServiceInstanceDetails(ctx context.Context, record *models.ServiceInstanceDetails) error {
-	return ds.db.Delete(record).Error
+	return ds.db.WithContext(ctx).Delete(record).Error
 }
 
 // GetServiceInstanceDetailsById gets an instance of ServiceInstanceDetails by its key (id).
@@ -60,7 +60,7 @@ func GetServiceInstanceDetailsById(ctx context.Context, id string) (*models.Serv
 }
 func (ds *SqlDatastore) GetServiceInstanceDetailsById(ctx context.Context, id string) (*models.ServiceInstanceDetails, error) {
 	record := models.ServiceInstanceDetails{}
-	if err := ds.db.Where("id = ?", id).First(&record).Error; err != nil {
+	if err := ds.db.WithContext(ctx).Where("id = ?", id).First(&record).Error; err != nil {
 		return nil, err
 	}
 
@@ -73,7 +73,7 @@ func ExistsServiceInstanceDetailsById(ctx context.Context, id string) (bool, err
 }
 func (ds *SqlDatastore) ExistsServiceInstanceDetailsById(ctx context.Context, id string) (bool, error) {
 	var count int64
-	if err := ds.db.Model(&models.ServiceInstanceDetails{}).Where("id = ?", id).Count(&count).Error; err != nil {
+	if err := ds.db.WithContext(ctx).Model(&models.ServiceInstanceDetails{}).Where("id = ?", id).Count(&count).Error; err != nil {
 		return false, err
 	}
 
@@ -85,7 +85,7 @@ func CreateServiceBindingCredentials(ctx context.Context, object *models.Service
 	return defaultDatastore().CreateServiceBindingCredentials(ctx, object)
 }
 func (ds *SqlDatastore) CreateServiceBindingCredentials(ctx context.Context, object *models.ServiceBindingCredentials) error {
-	return ds.db.Create(object).Error
+	return ds.db.WithContext(ctx).Create(object).Error
 }
 
 // SaveServiceBindingCredentials updates an existing record in the database.
@@ -93,7 +93,7 @@ func SaveServiceBindingCredentials(ctx context.Context, object *models.ServiceBi
 	return defaultDatastore().SaveServiceBindingCredentials(ctx, object)
 }
 func (ds *SqlDatastore) SaveServiceBindingCredentials(ctx context.Context, object *models.ServiceBindingCredentials) error {
-	return ds.db.Save(object).Error
+	return ds.db.WithContext(ctx).Save(object).Error
 }
 
 // DeleteServiceBindingCredentialsByServiceInstanceIdAndBindingId soft-deletes the record by its key (serviceInstanceId, bindingId).
@@ -101,7 +101,7 @@ func DeleteServiceBindingCredentialsByServiceInstanceIdAndBindingId(ctx context.
 	return defaultDatastore().DeleteServiceBindingCredentialsByServiceInstanceIdAndBindingId(ctx, serviceInstanceId, bindingId)
 }
 func (ds *SqlDatastore) DeleteServiceBindingCredentialsByServiceInstanceIdAndBindingId(ctx context.Context, serviceInstanceId string, bindingId string) error {
-	return ds.db.Where("service_instance_id = ? AND binding_id = ?", serviceInstanceId, bindingId).Delete(&models.ServiceBindingCredentials{}).Error
+	return ds.db.WithContext(ctx).Where("service_instance_id = ? AND binding_id = ?", serviceInstanceId, bindingId).Delete(&models.ServiceBindingCredentials{}).Error
 }
 
 // DeleteServiceBindingCredentialsByBindingId soft-deletes the record by its key (bindingId).
@@ -109,7 +109,7 @@ func DeleteServiceBindingCredentialsByBindingId(ctx context.Context, bindingId s
 	return defaultDatastore().DeleteServiceBindingCredentialsByBindingId(ctx, bindingId)
 }
 func (ds *SqlDatastore) DeleteServiceBindingCredentialsByBindingId(ctx context.Context, bindingId string) error {
-	return ds.db.Where("binding_id = ?", bindingId).Delete(&models.ServiceBindingCredentials{}).Error
+	return ds.db.WithContext(ctx).Where("binding_id = ?", bindingId).Delete(&models.ServiceBindingCredentials{}).Error
 }
 
 // DeleteServiceBindingCredentialsById soft-deletes the record by its key (id).
@@ -117,7 +117,7 @@ func DeleteServiceBindingCredentialsById(ctx context.Context, id uint) error {
 	return defaultDatastore().DeleteServiceBindingCredentialsById(ctx, id)
 }
 func (ds *SqlDatastore) DeleteServiceBindingCredentialsById(ctx context.Context, id uint) error {
-	return ds.db.Where("id = ?", id).Delete(&models.ServiceBindingCredentials{}).Error
+	return ds.db.WithContext(ctx).Where("id = ?", id).Delete(&models.ServiceBindingCredentials{}).Error
 }
 
 // DeleteServiceBindingCredentials soft-deletes the record.
@@ -125,7 +125,7 @@ func DeleteServiceBindingCredentials(ctx context.Context, record *models.Service
 	return defaultDatastore().DeleteServiceBindingCredentials(ctx, record)
 }
 func (ds *SqlDatastore) DeleteServiceBindingCredentials(ctx context.Context, record *models.ServiceBindingCredentials) error {
-	return ds.db.Delete(record).Error
+	return ds.db.WithContext(ctx).Delete(record).Error
 }
 
 // GetServiceBindingCredentialsByServiceInstanceIdAndBindingId gets an instance of ServiceBindingCredentials by its key (serviceInstanceId, bindingId).
@@ -134,7 +134,7 @@ func GetServiceBindingCredentialsByServiceInstanceIdAndBindingId(ctx context.Con
 }
 func (ds *SqlDatastore) GetServiceBindingCredentialsByServiceInstanceIdAndBindingId(ctx context.Context, serviceInstanceId string, bindingId string) (*models.ServiceBindingCredentials, error) {
 	record := models.ServiceBindingCredentials{}
-	if err := ds.db.Where("service_instance_id = ? AND binding_id = ?", serviceInstanceId, bindingId).First(&record).Error; err != nil {
+	if err := ds.db.WithContext(ctx).Where("service_instance_id = ? AND binding_id = ?", serviceInstanceId, bindingId).First(&record).Error; err != nil {
 		return nil, err
 	}
 
@@ -147,7 +147,7 @@ func ExistsServiceBindingCredentialsByServiceInstanceIdAndBindingId(ctx context.
 }
 func (ds *SqlDatastore) ExistsServiceBindingCredentialsByServiceInstanceIdAndBindingId(ctx context.Context, serviceInstanceId string, bindingId string) (bool, error) {
 	var count int64
-	if err := ds.db.Model(&models.ServiceBindingCredentials{}).Where("service_instance_id = ? AND binding_id = ?", serviceInstanceId, bindingId).Count(&count).Error; err != nil {
+	if err := ds.db.WithContext(ctx).Model(&models.ServiceBindingCredentials{}).Where("service_instance_id = ? AND binding_id = ?", serviceInstanceId, bindingId).Count(&count).Error; err != nil {
 		return false, err
 	}
 
@@ -160,7 +160,7 @@ func GetServiceBindingCredentialsByBindingId(ctx context.Context, bindingId stri
 }
 func (ds *SqlDatastore) GetServiceBindingCredentialsByBindingId(ctx context.Context, bindingId string) (*models.ServiceBindingCredentials, error) {
 	record := models.ServiceBindingCredentials{}
-	if err := ds.db.Where("binding_id = ?", bindingId).First(&record).Error; err != nil {
+	if err := ds.db.WithContext(ctx).Where("binding_id = ?", bindingId).First(&record).Error; err != nil {
 		return nil, err
 	}
 
@@ -173,7 +173,7 @@ func ExistsServiceBindingCredentialsByBindingId(ctx context.Context, bindingId s
 }
 func (ds *SqlDatastore) ExistsServiceBindingCredentialsByBindingId(ctx context.Context, bindingId string) (bool, error) {
 	var count int64
-	if err := ds.db.Model(&models.ServiceBindingCredentials{}).Where("binding_id = ?", bindingId).Count(&count).Error; err != nil {
+	if err := ds.db.WithContext(ctx).Model(&models.ServiceBindingCredentials{}).Where("binding_id = ?", bindingId).Count(&count).Error; err != nil {
 		return false, err
 	}
 
@@ -186,7 +186,7 @@ func GetServiceBindingCredentialsById(ctx context.Context, id uint) (*models.Ser
 }
 func (ds *SqlDatastore) GetServiceBindingCredentialsById(ctx context.Context, id uint) (*models.ServiceBindingCredentials, error) {
 	record := models.ServiceBindingCredentials{}
-	if err := ds.db.Where("id = ?", id).First(&record).Error; err != nil {
+	if err := ds.db.WithContext(ctx).Where("id = ?", id).First(&record).Error; err != nil {
 		return nil, err
 	}
 
@@ -199,7 +199,7 @@ func ExistsServiceBindingCredentialsById(ctx context.Context, id uint) (bool, er
 }
 func (ds *SqlDatastore) ExistsServiceBindingCredentialsById(ctx context.Context, id uint) (bool, error) {
 	var count int64
-	if err := ds.db.Model(&models.ServiceBindingCredentials{}).Where("id = ?", id).Count(&count).Error; err != nil {
+	if err := ds.db.WithContext(ctx).Model(&models.ServiceBindingCredentials{}).Where("id = ?", id).Count(&count).Error; err != nil {
 		return false, err
 	}
 
@@ -211,7 +211,7 @@ func CreateProvisionRequestDetails(ctx context.Context, object *models.Provision
 	return defaultDatastore().CreateProvisionRequestDetails(ctx, object)
 }
 func (ds *SqlDatastore) CreateProvisionRequestDetails(ctx context.Context, object *models.ProvisionRequestDetails) error {
-	return ds.db.Create(object).Error
+	return ds.db.WithContext(ctx).Create(object).Error
 }
 
 // SaveProvisionRequestDetails updates an existing record in the database.
@@ -219,7 +219,7 @@ func SaveProvisionRequestDetails(ctx context.Context, object *models.ProvisionRe
 	return defaultDatastore().SaveProvisionRequestDetails(ctx, object)
 }
 func (ds *SqlDatastore) SaveProvisionRequestDetails(ctx context.Context, object *models.ProvisionRequestDetails) error {
-	return ds.db.Save(object).Error
+	return ds.db.WithContext(ctx).Save(object).Error
 }
 
 // DeleteProvisionRequestDetailsById soft-deletes the record by its key (id).
@@ -227,7 +227,7 @@ func DeleteProvisionRequestDetailsById(ctx context.Context, id uint) error {
 	return defaultDatastore().DeleteProvisionRequestDetailsById(ctx, id)
 }
 func (ds *SqlDatastore) DeleteProvisionRequestDetailsById(ctx context.Context, id uint) error {
-	return ds.db.Where("id = ?", id).Delete(&models.ProvisionRequestDetails{}).Error
+	return ds.db.WithContext(ctx).Where("id = ?", id).Delete(&models.ProvisionRequestDetails{}).Error
 }
 
 // DeleteProvisionRequestDetails soft-deletes the record.
@@ -235,7 +235,7 @@ func DeleteProvisionRequestDetails(ctx context.Context, record *models.Provision
 	return defaultDatastore().DeleteProvisionRequestDetails(ctx, record)
 }
 func (ds *SqlDatastore) DeleteProvisionRequestDetails(ctx context.Context, record *models.ProvisionRequestDetails) error {
-	return ds.db.Delete(record).Error
+	return ds.db.WithContext(ctx).Delete(record).Error
 }
 
 // GetProvisionRequestDetailsById gets an instance of ProvisionRequestDetails by its key (id).
@@ -244,7 +244,7 @@ func GetProvisionRequestDetailsById(ctx context.Context, id uint) (*models.Provi
 }
 func (ds *SqlDatastore) GetProvisionRequestDetailsById(ctx context.Context, id uint) (*models.ProvisionRequestDetails, error) {
 	record := models.ProvisionRequestDetails{}
-	if err := ds.db.Where("id = ?", id).First(&record).Error; err != nil {
+	if err := ds.db.WithContext(ctx).Where("id = ?", id).First(&record).Error; err != nil {
 		return nil, err
 	}
 
@@ -257,7 +257,7 @@ func ExistsProvisionRequestDetailsById(ctx context.Context, id uint) (bool, erro
 }
 func (ds *SqlDatastore) ExistsProvisionRequestDetailsById(ctx context.Context, id uint) (bool, error) {
 	var count int64
-	if err := ds.db.Model(&models.ProvisionRequestDetails{}).Where("id = ?", id).Count(&count).Error; err != nil {
+	if err := ds.db.WithContext(ctx).Model(&models.ProvisionRequestDetails{}).Where("id = ?", id).Count(&count).Error; err != nil {
 		return false, err
 	}
 
@@ -269,15 +269,16 @@ func CreateTerraformDeployment(ctx context.Context, object *models.TerraformDepl
 	return defaultDatastore().CreateTerraformDeployment(ctx, object)
 }
 func (ds *SqlDatastore) CreateTerraformDeployment(ctx context.Context, object *models.TerraformDeployment) error {
-	return ds.db.Create(object).Error
+	return ds.db.WithContext(ctx).Create(object).Error
 }
 
 // SaveTerraformDeployment updates an existing record in the database.
+// It fails with ErrVersionConflict if the record was saved since it was read.
 func SaveTerraformDeployment(ctx context.Context, object *models.TerraformDeployment) error {
 	return defaultDatastore().SaveTerraformDeployment(ctx, object)
 }
 func (ds *SqlDatastore) SaveTerraformDeployment(ctx context.Context, object *models.TerraformDeployment) error {
-	return ds.db.Save(object).Error
+	return ds.saveVersioned(ctx, object, &object.Version)
 }
 
 // DeleteTerraformDeploymentById soft-deletes the record by its key (id).
@@ -285,7 +286,7 @@ func DeleteTerraformDeploymentById(ctx context.Context, id string) error {
 	return defaultDatastore().DeleteTerraformDeploymentById(ctx, id)
 }
 func (ds *SqlDatastore) DeleteTerraformDeploymentById(ctx context.Context, id string) error {
-	return ds.db.Where("id = ?", id).Delete(&models.TerraformDeployment{}).Error
+	return ds.db.WithContext(ctx).Where("id = ?", id).Delete(&models.TerraformDeployment{}).Error
 }
 
 // DeleteTerraformDeployment soft-deletes the record.
@@ -293,7 +294,7 @@ func DeleteTerraformDeployment(ctx context.Context, record *models.TerraformDepl
 	return defaultDatastore().DeleteTerraformDeployment(ctx, record)
 }
 func (ds *SqlDatastore) DeleteTerraformDeployment(ctx context.Context, record *models.TerraformDeployment) error {
-	return ds.db.Delete(record).Error
+	return ds.db.WithContext(ctx).Delete(record).Error
 }
 
 // GetTerraformDeploymentById gets an instance of TerraformDeployment by its key (id).
@@ -302,7 +303,7 @@ func GetTerraformDeploymentById(ctx context.Context, id string) (*models.Terrafo
 }
 func (ds *SqlDatastore) GetTerraformDeploymentById(ctx context.Context, id string) (*models.TerraformDeployment, error) {
 	record := models.TerraformDeployment{}
-	if err := ds.db.Where("id = ?", id).First(&record).Error; err != nil {
+	if err := ds.db.WithContext(ctx).Where("id = ?", id).First(&record).Error; err != nil {
 		return nil, err
 	}
 
@@ -315,7 +316,7 @@ func ExistsTerraformDeploymentById(ctx context.Context, id string) (bool, error)
 }
 func (ds *SqlDatastore) ExistsTerraformDeploymentById(ctx context.Context, id string) (bool, error) {
 	var count int64
-	if err := ds.db.Model(&models.TerraformDeployment{}).Where("id = ?", id).Count(&count).Error; err != nil {
+	if err := ds.db.WithContext(ctx).Model(&models.TerraformDeployment{}).Where("id = ?", id).Count(&count).Error; err != nil {
 		return false, err
 	}
 
@@ -2,6 +2,7 @@ package db_service
 
 import (
 	"context"
+	"time"
 
 	"github.com/cloudfoundry-incubator/cloud-service-broker/db_service/models"
 )
@@ -11,9 +12,249 @@ func GetProvisionRequestDetailsByInstanceId(ctx context.Context, instanceId stri
 }
 func (ds *SqlDatastore) GetProvisionRequestDetailsByInstanceId(ctx context.Context, instanceId string) (*models.ProvisionRequestDetails, error) {
 	record := models.ProvisionRequestDetails{}
-	if err := ds.db.Where("service_instance_id = ?", instanceId).First(&record).Error; err != nil {
+	if err := ds.db.WithContext(ctx).Where("service_instance_id = ?", instanceId).First(&record).Error; err != nil {
 		return nil, err
 	}
 
 	return &record, nil
 }
+
+// GetTerraformDeploymentsByLastOperationState gets all the TerraformDeployments whose last operation is in the given state.
+func GetTerraformDeploymentsByLastOperationState(ctx context.Context, state string) ([]models.TerraformDeployment, error) {
+	return defaultDatastore().GetTerraformDeploymentsByLastOperationState(ctx, state)
+}
+func (ds *SqlDatastore) GetTerraformDeploymentsByLastOperationState(ctx context.Context, state string) ([]models.TerraformDeployment, error) {
+	var records []models.TerraformDeployment
+	if err := ds.db.WithContext(ctx).Where("last_operation_state = ?", state).Find(&records).Error; err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
+
+// GetTerraformDeployments gets all the TerraformDeployments.
+func GetTerraformDeployments(ctx context.Context) ([]models.TerraformDeployment, error) {
+	return defaultDatastore().GetTerraformDeployments(ctx)
+}
+func (ds *SqlDatastore) GetTerraformDeployments(ctx context.Context) ([]models.TerraformDeployment, error) {
+	var records []models.TerraformDeployment
+	if err := ds.db.WithContext(ctx).Order("id").Find(&records).Error; err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
+
+// GetTerraformDeploymentsWithPreviousCredentialsExpiredBy gets all the
+// TerraformDeployments whose previous credentials expire at or before the time.
+func GetTerraformDeploymentsWithPreviousCredentialsExpiredBy(ctx context.Context, t time.Time) ([]models.TerraformDeployment, error) {
+	return defaultDatastore().GetTerraformDeploymentsWithPreviousCredentialsExpiredBy(ctx, t)
+}
+func (ds *SqlDatastore) GetTerraformDeploymentsWithPreviousCredentialsExpiredBy(ctx context.Context, t time.Time) ([]models.TerraformDeployment, error) {
+	var records []models.TerraformDeployment
+	if err := ds.db.WithContext(ctx).Where("previous_credentials_expire_at <= ?", t).Order("id").Find(&records).Error; err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
+
+// GetServiceInstanceDetails gets all the ServiceInstanceDetails.
+func GetServiceInstanceDetails(ctx context.Context) ([]models.ServiceInstanceDetails, error) {
+	return defaultDatastore().GetServiceInstanceDetails(ctx)
+}
+func (ds *SqlDatastore) GetServiceInstanceDetails(ctx context.Context) ([]models.ServiceInstanceDetails, error) {
+	var records []models.ServiceInstanceDetails
+	if err := ds.db.WithContext(ctx).Order("id").Find(&records).Error; err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
+
+// GetServiceBindingCredentials gets all the ServiceBindingCredentials.
+func GetServiceBindingCredentials(ctx context.Context) ([]models.ServiceBindingCredentials, error) {
+	return defaultDatastore().GetServiceBindingCredentials(ctx)
+}
+func (ds *SqlDatastore) GetServiceBindingCredentials(ctx context.Context) ([]models.ServiceBindingCredentials, error) {
+	var records []models.ServiceBindingCredentials
+	if err := ds.db.WithContext(ctx).Order("id").Find(&records).Error; err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
+
+// GetServiceBindingCredentialsByServiceInstanceId gets the ServiceBindingCredentials of a service instance.
+func GetServiceBindingCredentialsByServiceInstanceId(ctx context.Context, serviceInstanceId string) ([]models.ServiceBindingCredentials, error) {
+	return defaultDatastore().GetServiceBindingCredentialsByServiceInstanceId(ctx, serviceInstanceId)
+}
+func (ds *SqlDatastore) GetServiceBindingCredentialsByServiceInstanceId(ctx context.Context, serviceInstanceId string) ([]models.ServiceBindingCredentials, error) {
+	var records []models.ServiceBindingCredentials
+	if err := ds.db.WithContext(ctx).Where("service_instance_id = ?", serviceInstanceId).Order("id").Find(&records).Error; err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
+
+// CreateTerraformJob creates a new record in the database and assigns it a primary key.
+func CreateTerraformJob(ctx context.Context, object *models.TerraformJob) error {
+	return defaultDatastore().CreateTerraformJob(ctx, object)
+}
+func (ds *SqlDatastore) CreateTerraformJob(ctx context.Context, object *models.TerraformJob) error {
+	return ds.db.WithContext(ctx).Create(object).Error
+}
+
+// SaveTerraformJob updates an existing record in the database.
+func SaveTerraformJob(ctx context.Context, object *models.TerraformJob) error {
+	return defaultDatastore().SaveTerraformJob(ctx, object)
+}
+func (ds *SqlDatastore) SaveTerraformJob(ctx context.Context, object *models.TerraformJob) error {
+	return ds.db.WithContext(ctx).Save(object).Error
+}
+
+// GetTerraformJobsByDeploymentIdAndState gets the jobs run against a deployment that are in the given state, oldest first.
+func GetTerraformJobsByDeploymentIdAndState(ctx context.Context, deploymentId, state string) ([]models.TerraformJob, error) {
+	return defaultDatastore().GetTerraformJobsByDeploymentIdAndState(ctx, deploymentId, state)
+}
+func (ds *SqlDatastore) GetTerraformJobsByDeploymentIdAndState(ctx context.Context, deploymentId, state string) ([]models.TerraformJob, error) {
+	var records []models.TerraformJob
+	if err := ds.db.WithContext(ctx).Where("deployment_id = ? AND state = ?", deploymentId, state).Order("id asc").Find(&records).Error; err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
+
+// GetLastTerraformJobByDeploymentId gets the most recent job run against a deployment, or nil if none have been recorded.
+func GetLastTerraformJobByDeploymentId(ctx context.Context, deploymentId string) (*models.TerraformJob, error) {
+	return defaultDatastore().GetLastTerraformJobByDeploymentId(ctx, deploymentId)
+}
+func (ds *SqlDatastore) GetLastTerraformJobByDeploymentId(ctx context.Context, deploymentId string) (*models.TerraformJob, error) {
+	var records []models.TerraformJob
+	if err := ds.db.WithContext(ctx).Where("deployment_id = ?", deploymentId).Order("id desc").Limit(1).Find(&records).Error; err != nil {
+		return nil, err
+	}
+
+	if len(records) == 0 {
+		return nil, nil
+	}
+	return &records[0], nil
+}
+
+// GetLastTerraformJobByDeploymentIdExcludingOperationType gets the most recent job run against a deployment that is
+// not of the given operation type, or nil if none have been recorded.
+func GetLastTerraformJobByDeploymentIdExcludingOperationType(ctx context.Context, deploymentId, operationType string) (*models.TerraformJob, error) {
+	return defaultDatastore().GetLastTerraformJobByDeploymentIdExcludingOperationType(ctx, deploymentId, operationType)
+}
+func (ds *SqlDatastore) GetLastTerraformJobByDeploymentIdExcludingOperationType(ctx context.Context, deploymentId, operationType string) (*models.TerraformJob, error) {
+	var records []models.TerraformJob
+	if err := ds.db.WithContext(ctx).Where("deployment_id = ? AND operation_type <> ?", deploymentId, operationType).Order("id desc").Limit(1).Find(&records).Error; err != nil {
+		return nil, err
+	}
+
+	if len(records) == 0 {
+		return nil, nil
+	}
+	return &records[0], nil
+}
+
+// AcquireTerraformDeploymentLock takes the lock on the deployment for the owner until expiresAt. The owner can
+// renew a lock it already holds by acquiring it again. It returns false if the lock is held by another owner and has
+// not expired.
+func AcquireTerraformDeploymentLock(ctx context.Context, deploymentId, owner string, expiresAt time.Time) (bool, error) {
+	return defaultDatastore().AcquireTerraformDeploymentLock(ctx, deploymentId, owner, expiresAt)
+}
+func (ds *SqlDatastore) AcquireTerraformDeploymentLock(ctx context.Context, deploymentId, owner string, expiresAt time.Time) (bool, error) {
+	result := ds.db.WithContext(ctx).Model(&models.TerraformDeploymentLock{}).
+		Where("deployment_id = ? AND (owner = ? OR expires_at < ?)", deploymentId, owner, time.Now().UTC()).
+		Updates(map[string]interface{}{"owner": owner, "expires_at": expiresAt.UTC()})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected > 0 {
+		return true, nil
+	}
+
+	createErr := ds.db.WithContext(ctx).Create(&models.TerraformDeploymentLock{DeploymentId: deploymentId, Owner: owner, ExpiresAt: expiresAt.UTC()}).Error
+	if createErr == nil {
+		return true, nil
+	}
+
+	// The lock exists, either held by another owner or renewed by this owner
+	// without any change that the database counts as an affected row.
+	var lock models.TerraformDeploymentLock
+	if err := ds.db.WithContext(ctx).Where("deployment_id = ?", deploymentId).First(&lock).Error; err != nil {
+		return false, createErr
+	}
+
+	return lock.Owner == owner, nil
+}
+
+// ReleaseTerraformDeploymentLock releases the lock on the deployment if it is held by the owner.
+func ReleaseTerraformDeploymentLock(ctx context.Context, deploymentId, owner string) error {
+	return defaultDatastore().ReleaseTerraformDeploymentLock(ctx, deploymentId, owner)
+}
+func (ds *SqlDatastore) ReleaseTerraformDeploymentLock(ctx context.Context, deploymentId, owner string) error {
+	return ds.db.WithContext(ctx).Where("deployment_id = ? AND owner = ?", deploymentId, owner).Delete(&models.TerraformDeploymentLock{}).Error
+}
+
+// IsTerraformDeploymentLocked checks whether any owner holds a lock on the deployment that has not expired.
+func IsTerraformDeploymentLocked(ctx context.Context, deploymentId string) (bool, error) {
+	return defaultDatastore().IsTerraformDeploymentLocked(ctx, deploymentId)
+}
+func (ds *SqlDatastore) IsTerraformDeploymentLocked(ctx context.Context, deploymentId string) (bool, error) {
+	var count int64
+	if err := ds.db.WithContext(ctx).Model(&models.TerraformDeploymentLock{}).Where("deployment_id = ? AND expires_at >= ?", deploymentId, time.Now().UTC()).Count(&count).Error; err != nil {
+		return false, err
+	}
+
+	return count != 0, nil
+}
+
+// CreateAuditEvent appends a new record to the audit log and assigns it a primary key.
+func CreateAuditEvent(ctx context.Context, object *models.AuditEvent) error {
+	return defaultDatastore().CreateAuditEvent(ctx, object)
+}
+func (ds *SqlDatastore) CreateAuditEvent(ctx context.Context, object *models.AuditEvent) error {
+	return ds.db.WithContext(ctx).Create(object).Error
+}
+
+// GetLastAuditEvent gets the most recent AuditEvent, or nil if there are none.
+func GetLastAuditEvent(ctx context.Context) (*models.AuditEvent, error) {
+	return defaultDatastore().GetLastAuditEvent(ctx)
+}
+func (ds *SqlDatastore) GetLastAuditEvent(ctx context.Context) (*models.AuditEvent, error) {
+	var records []models.AuditEvent
+	if err := ds.db.WithContext(ctx).Order("id desc").Limit(1).Find(&records).Error; err != nil {
+		return nil, err
+	}
+
+	if len(records) == 0 {
+		return nil, nil
+	}
+	return &records[0], nil
+}
+
+// GetAuditEvents gets the most recent AuditEvents, newest first, up to limit if it is positive. If serviceInstanceId is
+// not empty, only the events of that service instance and its bindings are returned.
+func GetAuditEvents(ctx context.Context, serviceInstanceId string, limit int) ([]models.AuditEvent, error) {
+	return defaultDatastore().GetAuditEvents(ctx, serviceInstanceId, limit)
+}
+func (ds *SqlDatastore) GetAuditEvents(ctx context.Context, serviceInstanceId string, limit int) ([]models.AuditEvent, error) {
+	query := ds.db.WithContext(ctx).Order("id desc")
+	if serviceInstanceId != "" {
+		query = query.Where("service_instance_id = ?", serviceInstanceId)
+	}
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+
+	var records []models.AuditEvent
+	if err := query.Find(&records).Error; err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
@@ -2,9 +2,13 @@ package db_service
 
 import (
 	"context"
+	"errors"
+	"reflect"
+	"sort"
 	"testing"
 	"time"
 
+	"github.com/cloudfoundry-incubator/cloud-service-broker/db_service/models"
 	"gorm.io/gorm"
 )
 
@@ -40,3 +44,352 @@ func TestSqlDatastore_GetsProvisionRequestDetailsByInstanceId(t *testing.T) {
 	// Ensure non-gorm fields were deserialized correctly
 	ensureProvisionRequestDetailsFieldsMatch(t, &instance, ret)
 }
+
+func TestSqlDatastore_GetTerraformDeploymentsByLastOperationState(t *testing.T) {
+	ds := newInMemoryDatastore(t)
+	testCtx := context.Background()
+
+	for id, state := range map[string]string{"tf:a:": "in progress", "tf:b:": "succeeded", "tf:c:": "in progress"} {
+		deployment := models.TerraformDeployment{ID: id, LastOperationState: state}
+		if err := ds.CreateTerraformDeployment(testCtx, &deployment); err != nil {
+			t.Fatalf("Expected to be able to create the item %#v, got error: %s", deployment, err)
+		}
+	}
+
+	ret, err := ds.GetTerraformDeploymentsByLastOperationState(testCtx, "in progress")
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	var ids []string
+	for _, deployment := range ret {
+		ids = append(ids, deployment.ID)
+	}
+	sort.Strings(ids)
+	if !reflect.DeepEqual(ids, []string{"tf:a:", "tf:c:"}) {
+		t.Errorf("Expected in progress deployments, got %v", ids)
+	}
+}
+
+func TestSqlDatastore_GetTerraformDeployments(t *testing.T) {
+	ds := newInMemoryDatastore(t)
+	testCtx := context.Background()
+
+	for _, id := range []string{"tf:b:", "tf:a:", "tf:a:binding"} {
+		deployment := models.TerraformDeployment{ID: id}
+		if err := ds.CreateTerraformDeployment(testCtx, &deployment); err != nil {
+			t.Fatalf("Expected to be able to create the item %#v, got error: %s", deployment, err)
+		}
+	}
+
+	ret, err := ds.GetTerraformDeployments(testCtx)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	var ids []string
+	for _, deployment := range ret {
+		ids = append(ids, deployment.ID)
+	}
+	if !reflect.DeepEqual(ids, []string{"tf:a:", "tf:a:binding", "tf:b:"}) {
+		t.Errorf("Expected all deployments ordered by id, got %v", ids)
+	}
+}
+
+func TestSqlDatastore_GetTerraformDeploymentsWithPreviousCredentialsExpiredBy(t *testing.T) {
+	ds := newInMemoryDatastore(t)
+	testCtx := context.Background()
+
+	now := time.Now()
+	earlier, later := now.Add(-time.Hour), now.Add(time.Hour)
+	for id, expireAt := range map[string]*time.Time{"tf:a:binding": &earlier, "tf:b:binding": &later, "tf:c:binding": nil, "tf:d:binding": &now} {
+		deployment := models.TerraformDeployment{ID: id, PreviousCredentialsExpireAt: expireAt}
+		if err := ds.CreateTerraformDeployment(testCtx, &deployment); err != nil {
+			t.Fatalf("Expected to be able to create the item %#v, got error: %s", deployment, err)
+		}
+	}
+
+	ret, err := ds.GetTerraformDeploymentsWithPreviousCredentialsExpiredBy(testCtx, now)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	var ids []string
+	for _, deployment := range ret {
+		ids = append(ids, deployment.ID)
+	}
+	if !reflect.DeepEqual(ids, []string{"tf:a:binding", "tf:d:binding"}) {
+		t.Errorf("Expected deployments with expired credentials, got %v", ids)
+	}
+}
+
+func TestSqlDatastore_GetServiceInstanceDetails(t *testing.T) {
+	ds := newInMemoryDatastore(t)
+	testCtx := context.Background()
+
+	for _, id := range []string{"b", "a"} {
+		instance := models.ServiceInstanceDetails{ID: id}
+		if err := ds.CreateServiceInstanceDetails(testCtx, &instance); err != nil {
+			t.Fatalf("Expected to be able to create the item %#v, got error: %s", instance, err)
+		}
+	}
+
+	ret, err := ds.GetServiceInstanceDetails(testCtx)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	var ids []string
+	for _, instance := range ret {
+		ids = append(ids, instance.ID)
+	}
+	if !reflect.DeepEqual(ids, []string{"a", "b"}) {
+		t.Errorf("Expected all instances ordered by id, got %v", ids)
+	}
+}
+
+func TestSqlDatastore_GetServiceBindingCredentials(t *testing.T) {
+	ds := newInMemoryDatastore(t)
+	testCtx := context.Background()
+
+	for _, ids := range [][2]string{{"a", "binding-1"}, {"b", "binding-2"}, {"a", "binding-3"}} {
+		binding := models.ServiceBindingCredentials{ServiceInstanceId: ids[0], BindingId: ids[1]}
+		if err := ds.CreateServiceBindingCredentials(testCtx, &binding); err != nil {
+			t.Fatalf("Expected to be able to create the item %#v, got error: %s", binding, err)
+		}
+	}
+
+	bindingIds := func(bindings []models.ServiceBindingCredentials) (ids []string) {
+		for _, binding := range bindings {
+			ids = append(ids, binding.BindingId)
+		}
+		return ids
+	}
+
+	all, err := ds.GetServiceBindingCredentials(testCtx)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if ids := bindingIds(all); !reflect.DeepEqual(ids, []string{"binding-1", "binding-2", "binding-3"}) {
+		t.Errorf("Expected all bindings, got %v", ids)
+	}
+
+	forInstance, err := ds.GetServiceBindingCredentialsByServiceInstanceId(testCtx, "a")
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if ids := bindingIds(forInstance); !reflect.DeepEqual(ids, []string{"binding-1", "binding-3"}) {
+		t.Errorf("Expected the bindings of the instance, got %v", ids)
+	}
+}
+
+func TestSqlDatastore_TerraformJobs(t *testing.T) {
+	ds := newInMemoryDatastore(t)
+	testCtx := context.Background()
+
+	jobs := []models.TerraformJob{
+		{DeploymentId: "tf:a:", OperationType: "provision", State: "in progress"},
+		{DeploymentId: "tf:b:", OperationType: "provision", State: "in progress"},
+		{DeploymentId: "tf:a:", OperationType: "update", State: "in progress"},
+	}
+	for i := range jobs {
+		if err := ds.CreateTerraformJob(testCtx, &jobs[i]); err != nil {
+			t.Fatalf("Expected to be able to create the item %#v, got error: %s", jobs[i], err)
+		}
+	}
+
+	ret, err := ds.GetTerraformJobsByDeploymentIdAndState(testCtx, "tf:a:", "in progress")
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if len(ret) != 2 || ret[0].OperationType != "provision" || ret[1].OperationType != "update" {
+		t.Errorf("Expected the jobs for the deployment oldest first, got %#v", ret)
+	}
+
+	ret[0].State = "succeeded"
+	if err := ds.SaveTerraformJob(testCtx, &ret[0]); err != nil {
+		t.Fatalf("Expected no error saving job, got: %v", err)
+	}
+
+	ret, err = ds.GetTerraformJobsByDeploymentIdAndState(testCtx, "tf:a:", "in progress")
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if len(ret) != 1 || ret[0].OperationType != "update" {
+		t.Errorf("Expected only the update job to be in progress, got %#v", ret)
+	}
+
+	last, err := ds.GetLastTerraformJobByDeploymentId(testCtx, "tf:a:")
+	if err != nil || last == nil || last.OperationType != "update" {
+		t.Errorf("Expected the newest job of the deployment, got %#v, %v", last, err)
+	}
+
+	last, err = ds.GetLastTerraformJobByDeploymentId(testCtx, "tf:c:")
+	if err != nil || last != nil {
+		t.Errorf("Expected no job, got %#v, %v", last, err)
+	}
+
+	last, err = ds.GetLastTerraformJobByDeploymentIdExcludingOperationType(testCtx, "tf:a:", "update")
+	if err != nil || last == nil || last.OperationType != "provision" {
+		t.Errorf("Expected the newest job of the deployment that is not an update, got %#v, %v", last, err)
+	}
+
+	last, err = ds.GetLastTerraformJobByDeploymentIdExcludingOperationType(testCtx, "tf:c:", "update")
+	if err != nil || last != nil {
+		t.Errorf("Expected no job, got %#v, %v", last, err)
+	}
+}
+
+func TestSqlDatastore_SaveTerraformDeploymentVersioning(t *testing.T) {
+	ds := newInMemoryDatastore(t)
+	testCtx := context.Background()
+
+	deployment := models.TerraformDeployment{ID: "tf:a:"}
+	if err := ds.CreateTerraformDeployment(testCtx, &deployment); err != nil {
+		t.Fatalf("Expected to be able to create the item %#v, got error: %s", deployment, err)
+	}
+
+	first, err := ds.GetTerraformDeploymentById(testCtx, deployment.ID)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	second, err := ds.GetTerraformDeploymentById(testCtx, deployment.ID)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	first.LastOperationState = "succeeded"
+	if err := ds.SaveTerraformDeployment(testCtx, first); err != nil {
+		t.Fatalf("Expected no error saving the first copy, got: %v", err)
+	}
+	if first.Version != 1 {
+		t.Errorf("Expected the version to be incremented to 1, got %d", first.Version)
+	}
+
+	second.LastOperationState = "failed"
+	if err := ds.SaveTerraformDeployment(testCtx, second); err != ErrVersionConflict {
+		t.Errorf("Expected ErrVersionConflict saving a stale copy, got: %v", err)
+	}
+	if second.Version != 0 {
+		t.Errorf("Expected the version of the stale copy to be unchanged, got %d", second.Version)
+	}
+
+	ret, err := ds.GetTerraformDeploymentById(testCtx, deployment.ID)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if ret.LastOperationState != "succeeded" || ret.Version != 1 {
+		t.Errorf("Expected the first save to be kept, got state %q version %d", ret.LastOperationState, ret.Version)
+	}
+}
+
+func TestSqlDatastore_TerraformDeploymentLock(t *testing.T) {
+	ds := newInMemoryDatastore(t)
+	testCtx := context.Background()
+	expiry := time.Now().Add(time.Minute)
+
+	acquired, err := ds.AcquireTerraformDeploymentLock(testCtx, "tf:a:", "owner-1", expiry)
+	if err != nil || !acquired {
+		t.Fatalf("Expected to acquire the free lock, got %v, %v", acquired, err)
+	}
+
+	acquired, err = ds.AcquireTerraformDeploymentLock(testCtx, "tf:a:", "owner-1", expiry)
+	if err != nil || !acquired {
+		t.Errorf("Expected the owner to be able to renew the lock, got %v, %v", acquired, err)
+	}
+
+	acquired, err = ds.AcquireTerraformDeploymentLock(testCtx, "tf:a:", "owner-2", expiry)
+	if err != nil || acquired {
+		t.Errorf("Expected another owner not to acquire the held lock, got %v, %v", acquired, err)
+	}
+
+	locked, err := ds.IsTerraformDeploymentLocked(testCtx, "tf:a:")
+	if err != nil || !locked {
+		t.Errorf("Expected the deployment to be locked, got %v, %v", locked, err)
+	}
+
+	if err := ds.ReleaseTerraformDeploymentLock(testCtx, "tf:a:", "owner-2"); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	locked, err = ds.IsTerraformDeploymentLocked(testCtx, "tf:a:")
+	if err != nil || !locked {
+		t.Errorf("Expected only the owner to be able to release the lock, got %v, %v", locked, err)
+	}
+
+	if err := ds.ReleaseTerraformDeploymentLock(testCtx, "tf:a:", "owner-1"); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	acquired, err = ds.AcquireTerraformDeploymentLock(testCtx, "tf:a:", "owner-2", expiry)
+	if err != nil || !acquired {
+		t.Errorf("Expected to acquire the released lock, got %v, %v", acquired, err)
+	}
+}
+
+func TestSqlDatastore_TerraformDeploymentLockExpiry(t *testing.T) {
+	ds := newInMemoryDatastore(t)
+	testCtx := context.Background()
+
+	acquired, err := ds.AcquireTerraformDeploymentLock(testCtx, "tf:a:", "owner-1", time.Now().Add(-time.Second))
+	if err != nil || !acquired {
+		t.Fatalf("Expected to acquire the free lock, got %v, %v", acquired, err)
+	}
+
+	locked, err := ds.IsTerraformDeploymentLocked(testCtx, "tf:a:")
+	if err != nil || locked {
+		t.Errorf("Expected an expired lock not to count as locked, got %v, %v", locked, err)
+	}
+
+	acquired, err = ds.AcquireTerraformDeploymentLock(testCtx, "tf:a:", "owner-2", time.Now().Add(time.Minute))
+	if err != nil || !acquired {
+		t.Errorf("Expected another owner to take over the expired lock, got %v, %v", acquired, err)
+	}
+}
+
+func TestSqlDatastore_AuditEvents(t *testing.T) {
+	ds := newInMemoryDatastore(t)
+	testCtx := context.Background()
+
+	if last, err := ds.GetLastAuditEvent(testCtx); err != nil || last != nil {
+		t.Errorf("Expected no last event, got %v, %v", last, err)
+	}
+
+	events := []models.AuditEvent{
+		{Operation: "provision", ServiceInstanceId: "instance-1", Outcome: "succeeded"},
+		{Operation: "provision", ServiceInstanceId: "instance-2", Outcome: "failed"},
+		{Operation: "bind", ServiceInstanceId: "instance-1", BindingId: "binding-1", Outcome: "succeeded"},
+	}
+	for i := range events {
+		if err := ds.CreateAuditEvent(testCtx, &events[i]); err != nil {
+			t.Fatalf("Expected to be able to create the item %#v, got error: %s", events[i], err)
+		}
+	}
+
+	all, err := ds.GetAuditEvents(testCtx, "", 0)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if len(all) != 3 || all[0].Operation != "bind" || all[2].ServiceInstanceId != "instance-1" {
+		t.Errorf("Expected all the events newest first, got %#v", all)
+	}
+
+	last, err := ds.GetLastAuditEvent(testCtx)
+	if err != nil || last == nil || last.ID != events[2].ID {
+		t.Errorf("Expected the newest event, got %#v, %v", last, err)
+	}
+
+	forInstance, err := ds.GetAuditEvents(testCtx, "instance-1", 1)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if len(forInstance) != 1 || forInstance[0].BindingId != "binding-1" {
+		t.Errorf("Expected the newest event of the instance, got %#v", forInstance)
+	}
+
+	events[0].Outcome = "failed"
+	if err := ds.db.Save(&events[0]).Error; !errors.Is(err, models.ErrAuditEventImmutable) {
+		t.Errorf("Expected updating an event to fail, got %v", err)
+	}
+	if err := ds.db.Delete(&events[0]).Error; !errors.Is(err, models.ErrAuditEventImmutable) {
+		t.Errorf("Expected deleting an event to fail, got %v", err)
+	}
+}
@@ -83,6 +83,7 @@ func main() {
 			PrimaryKeyExample: `"42"`,
 			PrimaryKeyField:   "id",
 			Keys:              []fieldList{},
+			Versioned:         true,
 			ExampleFields: map[string]interface{}{
 				"Workspace":            "{}",
 				"LastOperationType":    "create",
@@ -146,6 +147,8 @@ type crudModel struct {
 	PrimaryKeyExample string
 	ExampleFields     map[string]interface{}
 	Keys              []fieldList
+	// Versioned models have a Version field that is checked and incremented on save.
+	Versioned bool
 }
 
 type fieldList []crudField
@@ -294,13 +297,20 @@ import (
 // {{funcName "Create" .Type}} creates a new record in the database and assigns it a primary key.
 func {{funcName "Create" .Type}}(ctx context.Context, object *models.{{.Type}}) error { return defaultDatastore().{{funcName "Create" .Type}}(ctx, object) }
 func (ds *SqlDatastore) Create{{.Type}}(ctx context.Context, object *models.{{.Type}}) error {
-	return ds.db.Create(object).Error
+	return ds.db.WithContext(ctx).Create(object).Error
 }
 
 // {{funcName "Save" .Type}} updates an existing record in the database.
+{{- if .Versioned}}
+// It fails with ErrVersionConflict if the record was saved since it was read.
+{{- end}}
 func {{funcName "Save" .Type}}(ctx context.Context, object *models.{{.Type}}) error { return defaultDatastore().{{funcName "Save" .Type}}(ctx, object) }
 func (ds *SqlDatastore) {{funcName "Save" .Type}}(ctx context.Context, object *models.{{.Type}}) error {
-	return ds.db.Save(object).Error
+{{- if .Versioned}}
+	return ds.saveVersioned(ctx, object, &object.Version)
+{{- else}}
+	return ds.db.WithContext(ctx).Save(object).Error
+{{- end}}
 }
 
 {{- $type := .Type}}
@@ -309,13 +319,13 @@ func (ds *SqlDatastore) {{funcName "Save" .Type}}(ctx context.Context, object *m
 // {{$fn}} soft-deletes the record by its key ({{$key.CallParams}}).
 func {{$fn}}(ctx context.Context, {{ $key.Args }}) error { return defaultDatastore().{{$fn}}(ctx, {{$key.CallParams}}) }
 func (ds *SqlDatastore) {{$fn}}(ctx context.Context, {{ $key.Args }}) error {
-	return ds.db.{{ $key.WhereClause }}.Delete(&models.{{$type}}{}).Error
+	return ds.db.WithContext(ctx).{{ $key.WhereClause }}.Delete(&models.{{$type}}{}).Error
 }
 {{ end }}
 // Delete{{.Type}} soft-deletes the record.
 func {{funcName "Delete" .Type}}(ctx context.Context, record *models.{{.Type}}) error { return defaultDatastore().{{funcName "Delete" .Type}}(ctx, record) }
 func (ds *SqlDatastore) {{funcName "Delete" .Type}}(ctx context.Context, record *models.{{.Type}}) error {
-	return ds.db.Delete(record).Error
+	return ds.db.WithContext(ctx).Delete(record).Error
 }
 
 {{- $type := .Type}}
@@ -326,7 +336,7 @@ func (ds *SqlDatastore) {{funcName "Delete" .Type}}(ctx context.Context, record
 func {{$getFn}}(ctx context.Context, {{ $key.Args }}) (*models.{{$type}}, error) { return defaultDatastore().{{$getFn}}(ctx, {{$key.CallParams}}) }
 func (ds *SqlDatastore) {{$getFn}}(ctx context.Context, {{ $key.Args }}) (*models.{{$type}}, error) {
 	record := models.{{$type}}{}
-	if err := ds.db.{{ $key.WhereClause }}.First(&record).Error; err != nil {
+	if err := ds.db.WithContext(ctx).{{ $key.WhereClause }}.First(&record).Error; err != nil {
 		return nil, err
 	}
 
@@ -338,7 +348,7 @@ func (ds *SqlDatastore) {{$getFn}}(ctx context.Context, {{ $key.Args }}) (*model
 func {{$existsFn}}(ctx context.Context, {{ $key.Args }}) (bool, error) { return defaultDatastore().{{$existsFn}}(ctx, {{$key.CallParams}}) }
 func (ds *SqlDatastore) {{$existsFn}}(ctx context.Context, {{ $key.Args }}) (bool, error) {
 	var count int64
-	if err := ds.db.Model(&models.{{$type}}{}).{{ $key.WhereClause }}.Count(&count).Error; err != nil {
+	if err := ds.db.WithContext(ctx).Model(&models.{{$type}}{}).{{ $key.WhereClause }}.Count(&count).Error; err != nil {
 		return false, err
 	}
 
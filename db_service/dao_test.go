@@ -36,6 +36,9 @@ func newInMemoryDatastore(t *testing.T) *SqlDatastore {
 	testDb.Migrator().CreateTable(models.ServiceBindingCredentials{})
 	testDb.Migrator().CreateTable(models.ProvisionRequestDetails{})
 	testDb.Migrator().CreateTable(models.TerraformDeployment{})
+	testDb.Migrator().CreateTable(models.TerraformJob{})
+	testDb.Migrator().CreateTable(models.TerraformDeploymentLock{})
+	testDb.Migrator().CreateTable(models.AuditEvent{})
 
 	return &SqlDatastore{db: testDb}
 }
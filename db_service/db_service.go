@@ -17,6 +17,8 @@
 package db_service
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"sync"
 
@@ -50,3 +52,26 @@ func defaultDatastore() *SqlDatastore {
 type SqlDatastore struct {
 	db *gorm.DB
 }
+
+// ErrVersionConflict is returned when saving a versioned record that has been
+// saved by someone else since it was read.
+var ErrVersionConflict = errors.New("the record was modified concurrently, reload it and try again")
+
+// saveVersioned updates the record only if its version in the database still
+// matches the version that was read, and increments the version if it does.
+func (ds *SqlDatastore) saveVersioned(ctx context.Context, object interface{}, version *int) error {
+	current := *version
+	*version = current + 1
+
+	result := ds.db.WithContext(ctx).Where("version = ?", current).Select("*").Updates(object)
+	switch {
+	case result.Error != nil:
+		*version = current
+		return result.Error
+	case result.RowsAffected == 0:
+		*version = current
+		return ErrVersionConflict
+	default:
+		return nil
+	}
+}
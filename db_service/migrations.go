@@ -22,7 +22,7 @@ import (
 	"gorm.io/gorm"
 )
 
-const numMigrations = 10
+const numMigrations = 22
 
 // RunMigrations runs schema migrations on the provided service broker database to get it up to date
 func RunMigrations(db *gorm.DB) error {
@@ -99,6 +99,54 @@ func RunMigrations(db *gorm.DB) error {
 		return autoMigrateTables(db, &models.PasswordMetadataV1{})
 	}
 
+	migrations[10] = func() error {
+		return autoMigrateTables(db, &models.ServiceBindingCredentialsV2{})
+	}
+
+	migrations[11] = func() error {
+		return autoMigrateTables(db, &models.TerraformJobV1{})
+	}
+
+	migrations[12] = func() error {
+		return autoMigrateTables(db, &models.TerraformDeploymentV3{}, &models.TerraformDeploymentLockV1{})
+	}
+
+	migrations[13] = func() error {
+		return autoMigrateTables(db, &models.TerraformJobV2{})
+	}
+
+	migrations[14] = func() error {
+		return autoMigrateTables(db, &models.TerraformDeploymentV4{})
+	}
+
+	migrations[15] = func() error {
+		return autoMigrateTables(db, &models.AuditEventV1{})
+	}
+
+	migrations[16] = func() error {
+		return autoMigrateTables(db, &models.TerraformDeploymentV5{})
+	}
+
+	migrations[17] = func() error {
+		return autoMigrateTables(db, &models.PasswordMetadataV2{})
+	}
+
+	migrations[18] = func() error {
+		return autoMigrateTables(db, &models.EncryptionRotationV1{})
+	}
+
+	migrations[19] = func() error {
+		return autoMigrateTables(db, &models.EncryptionRotationLeaseV1{})
+	}
+
+	migrations[20] = func() error {
+		return autoMigrateTables(db, &models.AuditEventV2{})
+	}
+
+	migrations[21] = func() error {
+		return autoMigrateTables(db, &models.TerraformDeploymentV6{})
+	}
+
 	var lastMigrationNumber = -1
 
 	// if we've run any migrations before, we should have a migrations table, so find the last one we ran
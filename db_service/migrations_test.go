@@ -147,6 +147,42 @@ func TestRunMigrations(t *testing.T) {
 			}
 		},
 
+		"encryption-rotations-unique-per-label-and-table": func(t *testing.T, db *gorm.DB) {
+			if err := RunMigrations(db); err != nil {
+				t.Fatal(err)
+			}
+
+			if !db.Migrator().HasIndex(&models.EncryptionRotation{}, "idx_encryption_rotations_label_table") {
+				t.Error("Expected encryption_rotations to have a unique index on primary_label and table_name")
+			}
+
+			if err := db.Create(&models.EncryptionRotation{PrimaryLabel: "label", Table: "table"}).Error; err != nil {
+				t.Fatal(err)
+			}
+			if err := db.Create(&models.EncryptionRotation{PrimaryLabel: "label", Table: "table"}).Error; err == nil {
+				t.Error("Expected a second checkpoint for the same label and table to be rejected")
+			}
+		},
+
+		"audit-events-chain-cannot-fork": func(t *testing.T, db *gorm.DB) {
+			if err := RunMigrations(db); err != nil {
+				t.Fatal(err)
+			}
+
+			prevHash := "hash"
+			if err := db.Create(&models.AuditEvent{Operation: "provision", Outcome: "succeeded", PrevHash: &prevHash}).Error; err != nil {
+				t.Fatal(err)
+			}
+			if err := db.Create(&models.AuditEvent{Operation: "bind", Outcome: "succeeded", PrevHash: &prevHash}).Error; err == nil {
+				t.Error("Expected a second event following the same event to be rejected")
+			}
+			for i := 0; i < 2; i++ {
+				if err := db.Create(&models.AuditEvent{Operation: "unbind", Outcome: "succeeded"}).Error; err != nil {
+					t.Errorf("Expected events recorded before the chain to be allowed, got %v", err)
+				}
+			}
+		},
+
 		"can-run-migrations-multiple-times": func(t *testing.T, db *gorm.DB) {
 			for i := 0; i < 10; i++ {
 				if err := RunMigrations(db); err != nil {
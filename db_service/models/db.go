@@ -16,6 +16,9 @@ package models
 
 import (
 	"encoding/json"
+	"errors"
+
+	"gorm.io/gorm"
 )
 
 const (
@@ -27,14 +30,39 @@ const (
 	DeprovisionOperationType = "deprovision"
 	UpdateOperationType      = "update"
 	ClearOperationType       = ""
+
+	// The following operation types correspond to asynchronous bind/unbind calls
+	// and will exist on a ServiceBindingCredentials with an operation ID that can
+	// be used to look up the state of an operation.
+	BindOperationType   = "bind"
+	UnbindOperationType = "unbind"
 )
 
+// EncryptedTag is the struct tag that marks the fields of models whose columns
+// are encrypted with the Encryptor, as `encrypted:"true"`. The database is
+// re-encrypted, and checked for values that are not encrypted, by the columns
+// that are marked.
+const EncryptedTag = "encrypted"
+
+// LegacyPlaintextTag is the struct tag that marks encrypted fields whose
+// columns were written in plaintext before they were encrypted, as
+// `legacyplaintext:"true"`. When the database is re-encrypted, values of those
+// columns that cannot be decrypted, and are not in the form of encrypted
+// values, are treated as plaintext and encrypted.
+const LegacyPlaintextTag = "legacyplaintext"
+
 var encryptorInstance Encryptor = nil
 
 func SetEncryptor(encryptor Encryptor) {
 	encryptorInstance = encryptor
 }
 
+// GetEncryptor gets the encryptor that values of encrypted columns are
+// encrypted and decrypted with.
+func GetEncryptor() Encryptor {
+	return encryptorInstance
+}
+
 //go:generate go run github.com/maxbrunsfeld/counterfeiter/v6 -o fakes/fake_encryption.go . Encryptor
 type Encryptor interface {
 	Encrypt(plaintext []byte) (string, error)
@@ -43,7 +71,7 @@ type Encryptor interface {
 
 // ServiceBindingCredentials holds credentials returned to the users after
 // binding to a service.
-type ServiceBindingCredentials ServiceBindingCredentialsV1
+type ServiceBindingCredentials ServiceBindingCredentialsV2
 
 // SetOtherDetails marshals the value passed in into a JSON string and sets
 // OtherDetails to it if marshalling was successful.
@@ -143,9 +171,34 @@ type Migration MigrationV1
 // long-running operations.
 type CloudOperation CloudOperationV1
 
+// SetErrorMessage encrypts the value and stores it in the ErrorMessage field.
+func (co *CloudOperation) SetErrorMessage(value string) error {
+	encrypted, err := encryptorInstance.Encrypt([]byte(value))
+	if err != nil {
+		return err
+	}
+
+	co.ErrorMessage = encrypted
+	return nil
+}
+
+// GetErrorMessage decrypts the ErrorMessage field. An empty ErrorMessage field
+// is not decrypted.
+func (co CloudOperation) GetErrorMessage() (string, error) {
+	if co.ErrorMessage == "" {
+		return "", nil
+	}
+
+	decrypted, err := encryptorInstance.Decrypt(co.ErrorMessage)
+	if err != nil {
+		return "", err
+	}
+	return string(decrypted), nil
+}
+
 // TerraformDeployment holds Terraform state and plan information for resources
 // that use that execution system.
-type TerraformDeployment TerraformDeploymentV2
+type TerraformDeployment TerraformDeploymentV6
 
 func (t *TerraformDeployment) SetWorkspace(value string) error {
 	encrypted, err := encryptorInstance.Encrypt([]byte(value))
@@ -165,6 +218,72 @@ func (t *TerraformDeployment) GetWorkspace() (string, error) {
 	return string(decrypted), nil
 }
 
+// TerraformJob records an operation run against a TerraformDeployment.
+type TerraformJob TerraformJobV2
+
+// TerraformDeploymentLock is a lease on a TerraformDeployment held while an
+// operation runs against it.
+type TerraformDeploymentLock TerraformDeploymentLockV1
+
 // PasswordMetadata contains information about the passwords, but never the
 // passwords themselves
-type PasswordMetadata PasswordMetadataV1
+type PasswordMetadata PasswordMetadataV2
+
+// EncryptionRotation is a checkpoint of re-encrypting a table of the database.
+type EncryptionRotation EncryptionRotationV1
+
+// EncryptionRotationLease is held by the broker that is re-encrypting the
+// database.
+type EncryptionRotationLease EncryptionRotationLeaseV1
+
+// AuditEvent records a state-changing request made to the broker.
+type AuditEvent AuditEventV2
+
+// SetParameters encrypts the value and stores it in the Parameters field. An
+// empty value is stored as it is.
+func (e *AuditEvent) SetParameters(value string) error {
+	if value == "" {
+		e.Parameters = ""
+		return nil
+	}
+
+	encrypted, err := encryptorInstance.Encrypt([]byte(value))
+	if err != nil {
+		return err
+	}
+
+	e.Parameters = encrypted
+	return nil
+}
+
+// GetParameters decrypts the Parameters field. An empty Parameters field is not
+// decrypted, and parameters recorded in plaintext before the field was
+// encrypted are returned as they are.
+func (e AuditEvent) GetParameters() (string, error) {
+	if e.Parameters == "" {
+		return "", nil
+	}
+
+	decrypted, err := encryptorInstance.Decrypt(e.Parameters)
+	switch {
+	case err == nil:
+		return string(decrypted), nil
+	case json.Valid([]byte(e.Parameters)):
+		return e.Parameters, nil
+	default:
+		return "", err
+	}
+}
+
+// ErrAuditEventImmutable is returned on attempts to change or delete an AuditEvent.
+var ErrAuditEventImmutable = errors.New("audit events cannot be changed or deleted")
+
+// BeforeUpdate is a gorm hook that prevents AuditEvents from being changed.
+func (AuditEvent) BeforeUpdate(*gorm.DB) error {
+	return ErrAuditEventImmutable
+}
+
+// BeforeDelete is a gorm hook that prevents AuditEvents from being deleted.
+func (AuditEvent) BeforeDelete(*gorm.DB) error {
+	return ErrAuditEventImmutable
+}
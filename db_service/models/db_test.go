@@ -596,6 +596,91 @@ var _ = Describe("Db", func() {
 		})
 	})
 
+	Describe("CloudOperation", func() {
+		const plaintext = "error message"
+
+		BeforeEach(func() {
+			encryptor = gcmencryptor.New(newKey())
+			models.SetEncryptor(encryptor)
+		})
+
+		Describe("SetErrorMessage", func() {
+			It("encrypts the error message", func() {
+				var c models.CloudOperation
+				Expect(c.SetErrorMessage(plaintext)).To(Succeed())
+				Expect(c.ErrorMessage).NotTo(Equal(plaintext))
+
+				p, err := encryptor.Decrypt(c.ErrorMessage)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(p)).To(Equal(plaintext))
+			})
+		})
+
+		Describe("GetErrorMessage", func() {
+			It("decrypts the error message", func() {
+				var c models.CloudOperation
+				Expect(c.SetErrorMessage(plaintext)).To(Succeed())
+				Expect(c.GetErrorMessage()).To(Equal(plaintext))
+			})
+
+			It("returns empty if it is empty", func() {
+				Expect(models.CloudOperation{}.GetErrorMessage()).To(BeEmpty())
+			})
+
+			It("returns the error if decryption fails", func() {
+				c := models.CloudOperation{ErrorMessage: "not-encrypted"}
+				_, err := c.GetErrorMessage()
+				Expect(err).To(HaveOccurred())
+			})
+		})
+	})
+
+	Describe("AuditEvent", func() {
+		const plaintext = `{"name":"db"}`
+
+		BeforeEach(func() {
+			encryptor = gcmencryptor.New(newKey())
+			models.SetEncryptor(encryptor)
+		})
+
+		Describe("SetParameters", func() {
+			It("encrypts the parameters", func() {
+				var e models.AuditEvent
+				Expect(e.SetParameters(plaintext)).To(Succeed())
+				Expect(e.Parameters).NotTo(Equal(plaintext))
+
+				p, err := encryptor.Decrypt(e.Parameters)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(p)).To(Equal(plaintext))
+			})
+
+			It("leaves empty parameters empty", func() {
+				var e models.AuditEvent
+				Expect(e.SetParameters("")).To(Succeed())
+				Expect(e.Parameters).To(BeEmpty())
+			})
+		})
+
+		Describe("GetParameters", func() {
+			It("decrypts the parameters", func() {
+				var e models.AuditEvent
+				Expect(e.SetParameters(plaintext)).To(Succeed())
+				Expect(e.GetParameters()).To(Equal(plaintext))
+			})
+
+			It("returns parameters recorded in plaintext as they are", func() {
+				e := models.AuditEvent{Parameters: plaintext}
+				Expect(e.GetParameters()).To(Equal(plaintext))
+			})
+
+			It("returns the error if decryption fails", func() {
+				e := models.AuditEvent{Parameters: "not-encrypted"}
+				_, err := e.GetParameters()
+				Expect(err).To(HaveOccurred())
+			})
+		})
+	})
+
 	Describe("TerraformDeployment", func() {
 		const plaintext = "plaintext"
 
@@ -46,6 +46,35 @@ func (ServiceBindingCredentialsV1) TableName() string {
 	return "service_binding_credentials"
 }
 
+// ServiceBindingCredentialsV2 adds operation tracking so that bindings can be
+// created and deleted asynchronously.
+type ServiceBindingCredentialsV2 struct {
+	gorm.Model
+
+	OtherDetails string `gorm:"type:text" encrypted:"true"`
+
+	ServiceId         string
+	ServiceInstanceId string
+	BindingId         string
+
+	// OperationType holds a string corresponding to what kind of operation
+	// OperationId is referencing. The binding is "locked" for editing if
+	// an operation is pending.
+	OperationType string
+
+	// OperationId holds a string referencing an operation specific to a broker.
+	// The OperationId will be cleared after a successful operation.
+	// This string MAY be sent to users and MUST NOT leak confidential information.
+	OperationId string `gorm:"type:varchar(1024)"`
+}
+
+// TableName returns a consistent table name (`service_binding_credentials`) for
+// gorm so multiple structs from different versions of the database all operate
+// on the same table.
+func (ServiceBindingCredentialsV2) TableName() string {
+	return "service_binding_credentials"
+}
+
 // ServiceInstanceDetailsV1 holds information about provisioned services.
 type ServiceInstanceDetailsV1 struct {
 	ID        string `gorm:"primary_key;not null"`
@@ -81,7 +110,7 @@ type ServiceInstanceDetailsV2 struct {
 	Name         string
 	Location     string
 	Url          string
-	OtherDetails string `gorm:"type:text"`
+	OtherDetails string `gorm:"type:text" encrypted:"true"`
 
 	ServiceId        string
 	PlanId           string
@@ -114,7 +143,7 @@ type ProvisionRequestDetailsV1 struct {
 
 	ServiceInstanceId string `gorm:"uniqueIndex"`
 	// is a json.Marshal of models.ProvisionDetails
-	RequestDetails string
+	RequestDetails string `encrypted:"true"`
 }
 
 // TableName returns a consistent table name (`provision_request_details`) for
@@ -132,7 +161,7 @@ type ProvisionRequestDetailsV2 struct {
 	ServiceInstanceId string
 
 	// is a json.Marshal of models.ProvisionDetails
-	RequestDetails string `gorm:"type:text"`
+	RequestDetails string `gorm:"type:text" encrypted:"true"`
 }
 
 // TableName returns a consistent table name (`provision_request_details`) for
@@ -166,7 +195,7 @@ type CloudOperationV1 struct {
 	Name          string
 	Status        string
 	OperationType string
-	ErrorMessage  string `gorm:"type:text"`
+	ErrorMessage  string `gorm:"type:text" encrypted:"true" legacyplaintext:"true"`
 	InsertTime    string
 	StartTime     string
 	TargetId      string
@@ -262,6 +291,266 @@ func (TerraformDeploymentV2) TableName() string {
 	return "terraform_deployments"
 }
 
+// TerraformDeploymentV3 adds a Version that is incremented each time the
+// deployment is saved, so that concurrent updates to the same deployment can be
+// detected rather than silently overwriting each other.
+type TerraformDeploymentV3 struct {
+	ID        string `gorm:"primary_key;type:varchar(1024)"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	DeletedAt *time.Time
+
+	// Workspace contains a JSON serialized version of the Terraform workspace.
+	Workspace string `gorm:"type:mediumtext"`
+
+	// LastOperationType describes the last operation being performed on the resource.
+	LastOperationType string
+
+	// LastOperationState holds one of the following strings "in progress", "succeeded", "failed".
+	// These mirror the OSB API.
+	LastOperationState string
+
+	// LastOperationMessage is a description that can be passed back to the user.
+	LastOperationMessage string `gorm:"type:text"`
+
+	// Version is the number of times the deployment has been saved.
+	Version int `gorm:"not null;default:0"`
+}
+
+// TableName returns a consistent table name (`terraform_deployments`) for gorm
+// so multiple structs from different versions of the database all operate on
+// the same table.
+func (TerraformDeploymentV3) TableName() string {
+	return "terraform_deployments"
+}
+
+// TerraformDeploymentV4 records the result of the last check for drift between
+// the deployment's Terraform state and the real resources.
+type TerraformDeploymentV4 struct {
+	ID        string `gorm:"primary_key;type:varchar(1024)"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	DeletedAt *time.Time
+
+	// Workspace contains a JSON serialized version of the Terraform workspace.
+	Workspace string `gorm:"type:mediumtext"`
+
+	// LastOperationType describes the last operation being performed on the resource.
+	LastOperationType string
+
+	// LastOperationState holds one of the following strings "in progress", "succeeded", "failed".
+	// These mirror the OSB API.
+	LastOperationState string
+
+	// LastOperationMessage is a description that can be passed back to the user.
+	LastOperationMessage string `gorm:"type:text"`
+
+	// Version is the number of times the deployment has been saved.
+	Version int `gorm:"not null;default:0"`
+
+	// DriftStatus holds one of the following strings "none", "drifted", "failed",
+	// or is empty if the deployment has never been checked for drift.
+	DriftStatus string
+
+	// DriftMessage is a summary of the drift, or the reason the check failed.
+	DriftMessage string `gorm:"type:text"`
+
+	// DriftCheckedAt is when the deployment was last checked for drift.
+	DriftCheckedAt *time.Time
+}
+
+// TableName returns a consistent table name (`terraform_deployments`) for gorm
+// so multiple structs from different versions of the database all operate on
+// the same table.
+func (TerraformDeploymentV4) TableName() string {
+	return "terraform_deployments"
+}
+
+// TerraformDeploymentV5 records when the credentials of a binding deployment
+// were last rotated, and when the credentials they replaced stop being valid.
+type TerraformDeploymentV5 struct {
+	ID        string `gorm:"primary_key;type:varchar(1024)"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	DeletedAt *time.Time
+
+	// Workspace contains a JSON serialized version of the Terraform workspace.
+	Workspace string `gorm:"type:mediumtext" encrypted:"true"`
+
+	// LastOperationType describes the last operation being performed on the resource.
+	LastOperationType string
+
+	// LastOperationState holds one of the following strings "in progress", "succeeded", "failed".
+	// These mirror the OSB API.
+	LastOperationState string
+
+	// LastOperationMessage is a description that can be passed back to the user.
+	LastOperationMessage string `gorm:"type:text"`
+
+	// Version is the number of times the deployment has been saved.
+	Version int `gorm:"not null;default:0"`
+
+	// DriftStatus holds one of the following strings "none", "drifted", "failed",
+	// or is empty if the deployment has never been checked for drift.
+	DriftStatus string
+
+	// DriftMessage is a summary of the drift, or the reason the check failed.
+	DriftMessage string `gorm:"type:text"`
+
+	// DriftCheckedAt is when the deployment was last checked for drift.
+	DriftCheckedAt *time.Time
+
+	// CredentialsRotatedAt is when the credentials were last rotated.
+	CredentialsRotatedAt *time.Time
+
+	// PreviousCredentialsExpireAt is when the credentials replaced by the last
+	// rotation are to be removed, or nil if they have been already.
+	PreviousCredentialsExpireAt *time.Time
+}
+
+// TableName returns a consistent table name (`terraform_deployments`) for gorm
+// so multiple structs from different versions of the database all operate on
+// the same table.
+func (TerraformDeploymentV5) TableName() string {
+	return "terraform_deployments"
+}
+
+// TerraformDeploymentV6 records how many times in a row removing the previous
+// credentials of a binding deployment has failed, so that it is backed off.
+type TerraformDeploymentV6 struct {
+	ID        string `gorm:"primary_key;type:varchar(1024)"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	DeletedAt *time.Time
+
+	// Workspace contains a JSON serialized version of the Terraform workspace.
+	Workspace string `gorm:"type:mediumtext" encrypted:"true"`
+
+	// LastOperationType describes the last operation being performed on the resource.
+	LastOperationType string
+
+	// LastOperationState holds one of the following strings "in progress", "succeeded", "failed".
+	// These mirror the OSB API.
+	LastOperationState string
+
+	// LastOperationMessage is a description that can be passed back to the user.
+	LastOperationMessage string `gorm:"type:text"`
+
+	// Version is the number of times the deployment has been saved.
+	Version int `gorm:"not null;default:0"`
+
+	// DriftStatus holds one of the following strings "none", "drifted", "failed",
+	// or is empty if the deployment has never been checked for drift.
+	DriftStatus string
+
+	// DriftMessage is a summary of the drift, or the reason the check failed.
+	DriftMessage string `gorm:"type:text"`
+
+	// DriftCheckedAt is when the deployment was last checked for drift.
+	DriftCheckedAt *time.Time
+
+	// CredentialsRotatedAt is when the credentials were last rotated.
+	CredentialsRotatedAt *time.Time
+
+	// PreviousCredentialsExpireAt is when the credentials replaced by the last
+	// rotation are to be removed, or nil if they have been already. If removing
+	// them fails, it is moved on to when it is next tried.
+	PreviousCredentialsExpireAt *time.Time
+
+	// CredentialRetirementFailures is how many times in a row removing the
+	// previous credentials has failed.
+	CredentialRetirementFailures int `gorm:"not null;default:0"`
+}
+
+// TableName returns a consistent table name (`terraform_deployments`) for gorm
+// so multiple structs from different versions of the database all operate on
+// the same table.
+func (TerraformDeploymentV6) TableName() string {
+	return "terraform_deployments"
+}
+
+// TerraformDeploymentLockV1 is a lease on a TerraformDeployment held while an
+// operation runs against it, so that brokers sharing a database never run
+// Terraform against the same deployment at the same time. A lease that has
+// expired may be taken over by another owner.
+type TerraformDeploymentLockV1 struct {
+	// DeploymentId is the ID of the TerraformDeployment that is locked.
+	DeploymentId string `gorm:"primary_key;type:varchar(1024)"`
+
+	// Owner is a unique token identifying the holder of the lock.
+	Owner string
+
+	// ExpiresAt is the time at which the lock lapses unless it is renewed.
+	ExpiresAt time.Time
+}
+
+// TableName returns a consistent table name (`terraform_deployment_locks`) for
+// gorm so multiple structs from different versions of the database all operate
+// on the same table.
+func (TerraformDeploymentLockV1) TableName() string {
+	return "terraform_deployment_locks"
+}
+
+// TerraformJobV1 is a durable record of an operation run by the TfJobRunner
+// against a TerraformDeployment. It allows operations that were interrupted by
+// the broker stopping to be detected and recovered.
+type TerraformJobV1 struct {
+	gorm.Model
+
+	// DeploymentId is the ID of the TerraformDeployment the job operates on.
+	DeploymentId string `gorm:"type:varchar(1024)"`
+
+	// OperationType describes the operation being performed on the deployment.
+	OperationType string
+
+	// State holds one of the following strings "in progress", "succeeded", "failed".
+	State string `gorm:"index"`
+
+	// Message is a description of the outcome of the job.
+	Message string `gorm:"type:text"`
+
+	// FinishedAt is the time at which the job completed, if it has.
+	FinishedAt *time.Time
+}
+
+// TableName returns a consistent table name (`terraform_jobs`) for gorm so
+// multiple structs from different versions of the database all operate on the
+// same table.
+func (TerraformJobV1) TableName() string {
+	return "terraform_jobs"
+}
+
+// TerraformJobV2 adds CancelRequested, which operators set to ask the broker
+// running the job to stop it.
+type TerraformJobV2 struct {
+	gorm.Model
+
+	// DeploymentId is the ID of the TerraformDeployment the job operates on.
+	DeploymentId string `gorm:"type:varchar(1024)"`
+
+	// OperationType describes the operation being performed on the deployment.
+	OperationType string
+
+	// State holds one of the following strings "in progress", "succeeded", "failed".
+	State string `gorm:"index"`
+
+	// Message is a description of the outcome of the job.
+	Message string `gorm:"type:text"`
+
+	// FinishedAt is the time at which the job completed, if it has.
+	FinishedAt *time.Time
+
+	// CancelRequested is set when an operator has asked for the job to be cancelled.
+	CancelRequested bool `gorm:"not null;default:false"`
+}
+
+// TableName returns a consistent table name (`terraform_jobs`) for gorm so
+// multiple structs from different versions of the database all operate on the
+// same table.
+func (TerraformJobV2) TableName() string {
+	return "terraform_jobs"
+}
+
 // PasswordMetadataV1 contains information about the passwords, but never the
 // passwords themselves
 type PasswordMetadataV1 struct {
@@ -278,3 +567,172 @@ type PasswordMetadataV1 struct {
 func (PasswordMetadataV1) TableName() string {
 	return "password_metadata"
 }
+
+// PasswordMetadataV2 adds the data key of keys held in a key management
+// service, wrapped by the service, so that it can be unwrapped on startup.
+// It is empty for passwords.
+type PasswordMetadataV2 struct {
+	ID        uint `gorm:"primarykey"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+
+	Label      string `gorm:"index;unique;not null"`
+	Salt       []byte `gorm:"type:blob;not null"`
+	Canary     string `gorm:"not null"`
+	Primary    bool
+	WrappedKey []byte `gorm:"type:blob"`
+}
+
+func (PasswordMetadataV2) TableName() string {
+	return "password_metadata"
+}
+
+// EncryptionRotationV1 is a checkpoint of re-encrypting a table of the database
+// with a new primary password, so that an interrupted rotation can be resumed.
+type EncryptionRotationV1 struct {
+	ID        uint `gorm:"primarykey"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+
+	// PrimaryLabel is the label of the password the table is being
+	// re-encrypted with, and is empty when encryption is being disabled.
+	PrimaryLabel string `gorm:"uniqueIndex:idx_encryption_rotations_label_table;type:varchar(255);not null"`
+	Table        string `gorm:"column:table_name;uniqueIndex:idx_encryption_rotations_label_table;type:varchar(255);not null"`
+
+	// LastKey is the primary key of the last row re-encrypted, in the order
+	// of the primary keys, and is empty before the first batch.
+	LastKey     string
+	RowsDone    int64
+	CompletedAt *time.Time
+}
+
+func (EncryptionRotationV1) TableName() string {
+	return "encryption_rotations"
+}
+
+// EncryptionRotationLeaseV1 is a lease held by the broker that is re-encrypting
+// the database, so that brokers sharing the database never re-encrypt it at the
+// same time. A lease that has expired may be taken over by another owner. The
+// row is kept once the lease is released, to record the result of the last
+// rotation.
+type EncryptionRotationLeaseV1 struct {
+	// Name identifies the lease, as there is only one.
+	Name string `gorm:"primary_key;type:varchar(255)"`
+
+	// Owner is a unique token identifying the holder of the lease, and is
+	// empty when it is released.
+	Owner     string
+	ExpiresAt time.Time
+
+	// PrimaryLabel is the label of the password that the last rotation
+	// re-encrypted the database with, and LastError is the error it failed
+	// with, or empty if it succeeded.
+	PrimaryLabel string
+	LastError    string `gorm:"type:text"`
+	UpdatedAt    time.Time
+}
+
+func (EncryptionRotationLeaseV1) TableName() string {
+	return "encryption_rotation_leases"
+}
+
+// AuditEventV1 records a state-changing request made to the broker. Records
+// are only ever appended, never updated or deleted.
+type AuditEventV1 struct {
+	ID        uint `gorm:"primarykey"`
+	CreatedAt time.Time
+
+	// Operation is one of "provision", "update", "deprovision", "bind" or "unbind".
+	Operation string `gorm:"not null"`
+
+	ServiceInstanceId string `gorm:"index"`
+	BindingId         string
+	ServiceId         string
+	PlanId            string
+
+	// RequestId and CorrelationId are taken from the X-Broker-API-Request-Identity
+	// and X-Correlation-ID headers of the request.
+	RequestId     string
+	CorrelationId string
+
+	// OriginatingIdentity is the JSON-encoded X-Broker-API-Originating-Identity
+	// header of the request, identifying the user who made it.
+	OriginatingIdentity string `gorm:"type:text"`
+
+	// Parameters is the JSON-encoded parameters of the request, with fields
+	// marked as sensitive redacted.
+	Parameters string `gorm:"type:text"`
+
+	// Outcome is "succeeded" or "failed", or "accepted" for asynchronous
+	// operations, which complete later.
+	Outcome string `gorm:"not null"`
+
+	// Message is the error returned when the request failed.
+	Message string `gorm:"type:text"`
+
+	StartedAt  time.Time
+	FinishedAt time.Time
+}
+
+// TableName returns a consistent table name (`audit_events`) for gorm so
+// multiple structs from different versions of the database all operate on the
+// same table.
+func (AuditEventV1) TableName() string {
+	return "audit_events"
+}
+
+// AuditEventV2 adds a hash chain to AuditEventV1, so that changes to the audit
+// log can be detected.
+type AuditEventV2 struct {
+	ID        uint `gorm:"primarykey"`
+	CreatedAt time.Time
+
+	// Operation is one of "provision", "update", "deprovision", "bind" or "unbind".
+	Operation string `gorm:"not null"`
+
+	ServiceInstanceId string `gorm:"index"`
+	BindingId         string
+	ServiceId         string
+	PlanId            string
+
+	// RequestId and CorrelationId are taken from the X-Broker-API-Request-Identity
+	// and X-Correlation-ID headers of the request.
+	RequestId     string
+	CorrelationId string
+
+	// OriginatingIdentity is the JSON-encoded X-Broker-API-Originating-Identity
+	// header of the request, identifying the user who made it.
+	OriginatingIdentity string `gorm:"type:text"`
+
+	// Parameters is the JSON-encoded parameters of the request that the
+	// service declares, with fields marked as sensitive redacted. Parameters
+	// recorded before it was encrypted are in plaintext.
+	Parameters string `gorm:"type:text" encrypted:"true" legacyplaintext:"true"`
+
+	// Outcome is "succeeded" or "failed", or "accepted" for asynchronous
+	// operations, which complete later.
+	Outcome string `gorm:"not null"`
+
+	// Message is the error returned when the request failed.
+	Message string `gorm:"type:text"`
+
+	StartedAt  time.Time
+	FinishedAt time.Time
+
+	// PrevHash is the Hash of the event before this one, or empty for the
+	// first event of the chain. It is unique, so that the chain cannot fork
+	// when brokers append events at the same time. It is null for events that
+	// were recorded before the chain was introduced.
+	PrevHash *string `gorm:"size:64;uniqueIndex"`
+
+	// Hash is the SHA-256 of PrevHash and the fields of the event, or empty
+	// for events that were recorded before the chain was introduced.
+	Hash string `gorm:"size:64;not null;default:''"`
+}
+
+// TableName returns a consistent table name (`audit_events`) for gorm so
+// multiple structs from different versions of the database all operate on the
+// same table.
+func (AuditEventV2) TableName() string {
+	return "audit_events"
+}
@@ -87,6 +87,10 @@ func SetupDb(logger lager.Logger) *gorm.DB {
 		db, err = setupSqlite3Db(logger)
 	}
 
+	if err == nil {
+		err = db.Use(tracingPlugin{})
+	}
+
 	if err != nil {
 		logger.Error("Database Setup", err)
 		os.Exit(1)
@@ -0,0 +1,85 @@
+// Copyright 2021 the Service Broker Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package db_service
+
+import (
+	"errors"
+
+	"github.com/cloudfoundry-incubator/cloud-service-broker/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+const spanKey = "csb:span"
+
+// tracingPlugin records a span for every database call that is made with a
+// context, as the DAO functions do.
+type tracingPlugin struct{}
+
+var _ gorm.Plugin = tracingPlugin{}
+
+func (tracingPlugin) Name() string {
+	return "csb:tracing"
+}
+
+func (tracingPlugin) Initialize(db *gorm.DB) error {
+	callbacks := db.Callback()
+	for _, err := range []error{
+		callbacks.Create().Before("gorm:create").Register("csb:start_span", startSpan("create")),
+		callbacks.Create().After("gorm:create").Register("csb:end_span", endSpan),
+		callbacks.Query().Before("gorm:query").Register("csb:start_span", startSpan("query")),
+		callbacks.Query().After("gorm:query").Register("csb:end_span", endSpan),
+		callbacks.Update().Before("gorm:update").Register("csb:start_span", startSpan("update")),
+		callbacks.Update().After("gorm:update").Register("csb:end_span", endSpan),
+		callbacks.Delete().Before("gorm:delete").Register("csb:start_span", startSpan("delete")),
+		callbacks.Delete().After("gorm:delete").Register("csb:end_span", endSpan),
+		callbacks.Row().Before("gorm:row").Register("csb:start_span", startSpan("row")),
+		callbacks.Row().After("gorm:row").Register("csb:end_span", endSpan),
+		callbacks.Raw().Before("gorm:raw").Register("csb:start_span", startSpan("raw")),
+		callbacks.Raw().After("gorm:raw").Register("csb:end_span", endSpan),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func startSpan(operation string) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		_, span := tracing.Start(db.Statement.Context, "db."+operation, attribute.String("db.sql.table", db.Statement.Table))
+		db.InstanceSet(spanKey, span)
+	}
+}
+
+func endSpan(db *gorm.DB) {
+	value, ok := db.InstanceGet(spanKey)
+	if !ok {
+		return
+	}
+
+	span := value.(trace.Span)
+	span.SetAttributes(attribute.String("db.statement", db.Statement.SQL.String()))
+
+	// records that are not found are not errors in the broker, they are how
+	// it finds out if records exist
+	err := db.Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		err = nil
+	}
+	tracing.End(span, err)
+}
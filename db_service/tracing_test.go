@@ -0,0 +1,76 @@
+// Copyright 2021 the Service Broker Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package db_service
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/cloudfoundry-incubator/cloud-service-broker/pkg/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracingPlugin(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(tracing.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	ds := newInMemoryDatastore(t)
+	if err := ds.db.Use(tracingPlugin{}); err != nil {
+		t.Fatalf("Expected to be able to use the plugin, got error: %s", err)
+	}
+
+	ctx, parent := tracing.Start(context.Background(), "parent")
+	_, instance := createProvisionRequestDetailsInstance()
+	if err := ds.CreateProvisionRequestDetails(ctx, &instance); err != nil {
+		t.Fatalf("Expected to be able to create the item, got error: %s", err)
+	}
+	if _, err := ds.GetProvisionRequestDetailsById(ctx, instance.ID+1); err == nil {
+		t.Fatal("Expected an error getting a non-existing record")
+	}
+	tracing.End(parent, nil)
+
+	ended := recorder.Ended()
+	if len(ended) != 3 {
+		t.Fatalf("Expected 3 spans, got %d", len(ended))
+	}
+
+	create, query := ended[0], ended[1]
+	if create.Name() != "db.create" || query.Name() != "db.query" {
+		t.Errorf("Expected spans db.create and db.query, got %s and %s", create.Name(), query.Name())
+	}
+	for _, span := range []sdktrace.ReadOnlySpan{create, query} {
+		if span.Parent().SpanID() != parent.SpanContext().SpanID() {
+			t.Errorf("Expected span %s to be a child of the parent span", span.Name())
+		}
+		if span.Status().Code == codes.Error {
+			t.Errorf("Expected span %s not to be an error, got %v", span.Name(), span.Status())
+		}
+
+		attributes := map[string]string{}
+		for _, kv := range span.Attributes() {
+			attributes[string(kv.Key)] = kv.Value.AsString()
+		}
+		if attributes["db.sql.table"] != "provision_request_details" {
+			t.Errorf("Expected span %s to have table provision_request_details, got %q", span.Name(), attributes["db.sql.table"])
+		}
+		if !strings.Contains(attributes["db.statement"], "provision_request_details") {
+			t.Errorf("Expected span %s to record the statement, got %q", span.Name(), attributes["db.statement"])
+		}
+	}
+}
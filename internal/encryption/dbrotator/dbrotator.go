@@ -1,118 +1,149 @@
 package dbrotator
 
 import (
+	"context"
 	"fmt"
+	"time"
 
+	"code.cloudfoundry.org/lager"
 	"github.com/cloudfoundry-incubator/cloud-service-broker/db_service/models"
 	"gorm.io/gorm"
 )
 
+// DefaultBatchSize is the number of rows re-encrypted in each transaction.
+const DefaultBatchSize = 100
+
 // ReencryptDB re-encrypts the database with the primary encryptor (which can be the No-op encryptor)
 func ReencryptDB(db *gorm.DB) error {
-	dbEncryptors := []func(*gorm.DB) error{
-		encryptProvisionRequestDetails,
-		encryptServiceInstanceDetails,
-		encryptServiceBindingCredentials,
-		encryptTerraformWorkspaces,
-	}
-	for _, e := range dbEncryptors {
-		if err := e(db); err != nil {
-			return err
-		}
+	tables, err := encryptedTables(db)
+	if err != nil {
+		return fmt.Errorf("error reencrypting: %v", err)
 	}
 
-	return nil
-}
-
-func encryptProvisionRequestDetails(db *gorm.DB) error {
-	var provisionRequestDetailsBatch []models.ProvisionRequestDetails
-	result := db.FindInBatches(&provisionRequestDetailsBatch, 100, func(tx *gorm.DB, batchNumber int) error {
-		for i := range provisionRequestDetailsBatch {
-			details, err := provisionRequestDetailsBatch[i].GetRequestDetails()
-
+	for _, t := range tables {
+		var last *string
+		for {
+			rows, err := reencryptBatch(db, t, last, DefaultBatchSize)
 			if err != nil {
-				return err
+				return fmt.Errorf("error reencrypting: %v", err)
 			}
-
-			if err := provisionRequestDetailsBatch[i].SetRequestDetails(details); err != nil {
-				return err
+			if len(rows) == 0 {
+				break
 			}
+			last = &rows[len(rows)-1].key
 		}
-
-		return tx.Save(&provisionRequestDetailsBatch).Error
-	})
-	if result.Error != nil {
-		return fmt.Errorf("error reencrypting: %v", result.Error)
 	}
 
 	return nil
 }
 
-func encryptServiceInstanceDetails(db *gorm.DB) error {
-	var serviceInstanceDetailsBatch []models.ServiceInstanceDetails
-	result := db.FindInBatches(&serviceInstanceDetailsBatch, 100, func(tx *gorm.DB, batchNumber int) error {
-		for i := range serviceInstanceDetailsBatch {
-			var details interface{}
-			if err := serviceInstanceDetailsBatch[i].GetOtherDetails(&details); err != nil {
-				return err
-			}
-
-			if err := serviceInstanceDetailsBatch[i].SetOtherDetails(details); err != nil {
-				return err
-			}
-		}
+// Reencrypt re-encrypts the database with the primary encryptor in batches,
+// while the broker is running. The encryptor must be able to decrypt with both
+// the previous and the new primary password.
+//
+// After each batch, a checkpoint is stored for the label of the new primary
+// password, so that if it is interrupted, it resumes from the last batch when
+// run again with the same label. Checkpoints of any other label are removed,
+// as they belong to a rotation that will not be completed.
+//
+// A row is only updated if it has not changed since it was read, so that
+// changes made by the broker while rotating are never overwritten. Such rows
+// have already been encrypted with the new primary password.
+func Reencrypt(ctx context.Context, db *gorm.DB, primaryLabel string, batchSize int, logger lager.Logger) error {
+	if err := db.Where("primary_label <> ?", primaryLabel).Delete(&models.EncryptionRotation{}).Error; err != nil {
+		return fmt.Errorf("error removing checkpoints of previous rotations: %w", err)
+	}
 
-		return tx.Save(&serviceInstanceDetailsBatch).Error
-	})
-	if result.Error != nil {
-		return fmt.Errorf("error reencrypting: %v", result.Error)
+	tables, err := encryptedTables(db)
+	if err != nil {
+		return err
 	}
 
-	return nil
-}
+	for _, t := range tables {
+		checkpoint := models.EncryptionRotation{PrimaryLabel: primaryLabel, Table: t.name}
+		if err := checkpointQuery(db, primaryLabel, t).FirstOrCreate(&checkpoint).Error; err != nil {
+			return fmt.Errorf("error reading checkpoint of %s: %w", t.name, err)
+		}
 
-func encryptServiceBindingCredentials(db *gorm.DB) error {
-	var serviceBindingCredentialsBatch []models.ServiceBindingCredentials
-	result := db.FindInBatches(&serviceBindingCredentialsBatch, 100, func(tx *gorm.DB, batchNumber int) error {
-		for i := range serviceBindingCredentialsBatch {
-			var details interface{}
-			if err := serviceBindingCredentialsBatch[i].GetOtherDetails(&details); err != nil {
+		for checkpoint.CompletedAt == nil {
+			if err := ctx.Err(); err != nil {
 				return err
 			}
 
-			if err := serviceBindingCredentialsBatch[i].SetOtherDetails(details); err != nil {
-				return err
+			err := db.Transaction(func(tx *gorm.DB) error {
+				var last *string
+				if checkpoint.RowsDone > 0 {
+					last = &checkpoint.LastKey
+				}
+
+				rows, err := reencryptBatch(tx, t, last, batchSize)
+				if err != nil {
+					return err
+				}
+
+				if len(rows) == 0 {
+					now := time.Now()
+					checkpoint.CompletedAt = &now
+				} else {
+					checkpoint.LastKey = rows[len(rows)-1].key
+					checkpoint.RowsDone += int64(len(rows))
+				}
+				return tx.Save(&checkpoint).Error
+			})
+			if err != nil {
+				return fmt.Errorf("error reencrypting %s after key %q: %w", t.name, checkpoint.LastKey, err)
 			}
 		}
 
-		return tx.Save(&serviceBindingCredentialsBatch).Error
-	})
-	if result.Error != nil {
-		return fmt.Errorf("error reencrypting: %v", result.Error)
+		logger.Info("reencrypted-table", lager.Data{"table": t.name, "rows": checkpoint.RowsDone})
 	}
 
 	return nil
 }
 
-func encryptTerraformWorkspaces(db *gorm.DB) error {
-	var terraformWorkspacesBatch []models.TerraformDeployment
-	result := db.FindInBatches(&terraformWorkspacesBatch, 100, func(tx *gorm.DB, batchNumber int) error {
-		for i := range terraformWorkspacesBatch {
-			workspace, err := terraformWorkspacesBatch[i].GetWorkspace()
-			if err != nil {
-				return err
-			}
+// TableStatus is the progress of re-encrypting a table of the database.
+type TableStatus struct {
+	Table       string
+	Rows        int64
+	RowsDone    int64
+	CompletedAt *time.Time
+	UpdatedAt   *time.Time
+}
 
-			if err := terraformWorkspacesBatch[i].SetWorkspace(workspace); err != nil {
-				return err
-			}
+// Status gets the progress of re-encrypting each table with the primary
+// password with the label. Tables that have not been started have no
+// progress.
+func Status(db *gorm.DB, primaryLabel string) ([]TableStatus, error) {
+	tables, err := encryptedTables(db)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []TableStatus
+	for _, t := range tables {
+		status := TableStatus{Table: t.name}
+		if err := db.Table(t.name).Count(&status.Rows).Error; err != nil {
+			return nil, err
 		}
 
-		return tx.Save(&terraformWorkspacesBatch).Error
-	})
-	if result.Error != nil {
-		return fmt.Errorf("error reencrypting: %v", result.Error)
+		var checkpoints []models.EncryptionRotation
+		if err := checkpointQuery(db, primaryLabel, t).Find(&checkpoints).Error; err != nil {
+			return nil, err
+		}
+		if len(checkpoints) > 0 {
+			status.RowsDone = checkpoints[0].RowsDone
+			status.CompletedAt = checkpoints[0].CompletedAt
+			status.UpdatedAt = &checkpoints[0].UpdatedAt
+		}
+
+		result = append(result, status)
 	}
 
-	return nil
+	return result, nil
+}
+
+// checkpointQuery selects the checkpoint of the table. The label is empty when
+// encryption is being disabled, so it cannot be a struct condition.
+func checkpointQuery(db *gorm.DB, primaryLabel string, t table) *gorm.DB {
+	return db.Where("primary_label = ? AND table_name = ?", primaryLabel, t.name)
 }
@@ -2,12 +2,15 @@ package dbrotator_test
 
 import (
 	"context"
+	b64 "encoding/base64"
 
 	"github.com/cloudfoundry-incubator/cloud-service-broker/db_service"
 	"github.com/cloudfoundry-incubator/cloud-service-broker/db_service/models"
 	"github.com/cloudfoundry-incubator/cloud-service-broker/internal/encryption/compoundencryptor"
 	"github.com/cloudfoundry-incubator/cloud-service-broker/internal/encryption/dbrotator"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/internal/encryption/envelopeencryptor"
 	"github.com/cloudfoundry-incubator/cloud-service-broker/internal/encryption/gcmencryptor"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/internal/encryption/kms"
 	"github.com/cloudfoundry-incubator/cloud-service-broker/internal/encryption/noopencryptor"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -62,6 +65,8 @@ var _ = Describe("ReencryptDB", func() {
 			db.Migrator().CreateTable(models.ServiceBindingCredentials{})
 			db.Migrator().CreateTable(models.ProvisionRequestDetails{})
 			db.Migrator().CreateTable(models.TerraformDeployment{})
+			db.Migrator().CreateTable(models.CloudOperation{})
+			db.Migrator().CreateTable(models.AuditEvent{})
 
 			db_service.DbConnection = db
 			models.SetEncryptor(noopencryptor.New())
@@ -117,6 +122,8 @@ var _ = Describe("ReencryptDB", func() {
 			db.Migrator().CreateTable(models.ServiceBindingCredentials{})
 			db.Migrator().CreateTable(models.ProvisionRequestDetails{})
 			db.Migrator().CreateTable(models.TerraformDeployment{})
+			db.Migrator().CreateTable(models.CloudOperation{})
+			db.Migrator().CreateTable(models.AuditEvent{})
 
 			db_service.DbConnection = db
 			copy(key[:], "one-key-here-with-32-bytes-in-it")
@@ -173,6 +180,8 @@ var _ = Describe("ReencryptDB", func() {
 			db.Migrator().CreateTable(models.ServiceBindingCredentials{})
 			db.Migrator().CreateTable(models.ProvisionRequestDetails{})
 			db.Migrator().CreateTable(models.TerraformDeployment{})
+			db.Migrator().CreateTable(models.CloudOperation{})
+			db.Migrator().CreateTable(models.AuditEvent{})
 
 			db_service.DbConnection = db
 			copy(key[:], "one-key-here-with-32-bytes-in-it")
@@ -230,6 +239,35 @@ var _ = Describe("ReencryptDB", func() {
 			Expect(persistedTerraformWorkspace()).NotTo(Equal(firstEncryptionPersistedTerraformWorkspace))
 		})
 
+		It("re-encrypts the database with a key management service key", func() {
+			wrapper, err := kms.New(kms.Config{Provider: kms.ProviderLocal, KeyID: "local", Key: b64.StdEncoding.EncodeToString(newKey[:])})
+			Expect(err).NotTo(HaveOccurred())
+			envelopeEncryptor, err := envelopeencryptor.New(context.TODO(), wrapper)
+			Expect(err).NotTo(HaveOccurred())
+
+			models.SetEncryptor(compoundencryptor.New(
+				envelopeEncryptor,
+				gcmencryptor.New(key),
+				envelopeEncryptor,
+			))
+
+			By("running the encryption")
+			Expect(dbrotator.ReencryptDB(db)).NotTo(HaveOccurred())
+
+			By("being able to decrypt with only the key management service key")
+			models.SetEncryptor(envelopeEncryptor)
+
+			instance, err := db_service.GetServiceInstanceDetailsById(context.TODO(), "1")
+			Expect(err).NotTo(HaveOccurred())
+			var details map[string]interface{}
+			Expect(instance.GetOtherDetails(&details)).To(Succeed())
+			Expect(details).To(Equal(mapSecret))
+
+			deployment, err := db_service.GetTerraformDeploymentById(context.TODO(), "1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(deployment.GetWorkspace()).To(Equal(jsonSecret))
+		})
+
 		Context("ServiceInstanceDetails", func() {
 			It("returns error when decryption fails", func() {
 				newEncryptor := gcmencryptor.New(newKey)
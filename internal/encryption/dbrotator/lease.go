@@ -0,0 +1,127 @@
+package dbrotator
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/db_service/models"
+	"github.com/pborman/uuid"
+	"gorm.io/gorm"
+)
+
+// leaseName is the name of the lease on re-encrypting the database.
+const leaseName = "rotation"
+
+// LeaseDuration is how long the lease on re-encrypting the database lasts
+// unless it is renewed. It is renewed while the rotation runs, so it only
+// lapses if the broker holding it stops.
+var LeaseDuration = 2 * time.Minute
+
+// ErrRotationInProgress is returned when another broker holds the lease on
+// re-encrypting the database.
+var ErrRotationInProgress = errors.New("the database is being re-encrypted by another broker")
+
+// WithLease runs rotate while holding the lease on re-encrypting the database,
+// and records the error it returns, if any, as the result of rotating with the
+// primary password with the label. It fails with ErrRotationInProgress if the
+// lease is held by another broker.
+func WithLease(ctx context.Context, db *gorm.DB, primaryLabel string, logger lager.Logger, rotate func(context.Context) error) error {
+	owner := uuid.New()
+	acquired, err := acquireLease(db, owner)
+	switch {
+	case err != nil:
+		return err
+	case !acquired:
+		return ErrRotationInProgress
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	renewed := make(chan struct{})
+	go func() {
+		defer close(renewed)
+		renewLease(ctx, db, owner, cancel, logger)
+	}()
+
+	rotateErr := rotate(ctx)
+	cancel()
+	<-renewed
+
+	result := map[string]interface{}{"owner": "", "primary_label": primaryLabel, "last_error": ""}
+	if rotateErr != nil {
+		result["last_error"] = rotateErr.Error()
+	}
+	if err := db.Model(&models.EncryptionRotationLease{}).Where("name = ? AND owner = ?", leaseName, owner).Updates(result).Error; err != nil {
+		logger.Error("release-lease", err)
+	}
+
+	return rotateErr
+}
+
+// renewLease renews the lease until the context is done. If the lease is lost,
+// the rotation is cancelled.
+func renewLease(ctx context.Context, db *gorm.DB, owner string, cancel context.CancelFunc, logger lager.Logger) {
+	ticker := time.NewTicker(LeaseDuration / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			acquired, err := acquireLease(db, owner)
+			switch {
+			case err != nil:
+				logger.Error("renew-lease", err)
+			case !acquired:
+				logger.Error("renew-lease", ErrRotationInProgress)
+				cancel()
+				return
+			}
+		}
+	}
+}
+
+// acquireLease takes the lease for the owner, or renews it if the owner
+// already holds it. It returns false if another owner holds the lease and it
+// has not expired.
+func acquireLease(db *gorm.DB, owner string) (bool, error) {
+	expiresAt := time.Now().Add(LeaseDuration).UTC()
+	result := db.Model(&models.EncryptionRotationLease{}).
+		Where("name = ? AND (owner = ? OR owner = ? OR expires_at < ?)", leaseName, owner, "", time.Now().UTC()).
+		Updates(map[string]interface{}{"owner": owner, "expires_at": expiresAt})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected > 0 {
+		return true, nil
+	}
+
+	createErr := db.Create(&models.EncryptionRotationLease{Name: leaseName, Owner: owner, ExpiresAt: expiresAt}).Error
+	if createErr == nil {
+		return true, nil
+	}
+
+	var lease models.EncryptionRotationLease
+	if err := db.Where("name = ?", leaseName).First(&lease).Error; err != nil {
+		return false, createErr
+	}
+
+	return lease.Owner == owner, nil
+}
+
+// LastError gets the error that the last rotation with the primary password
+// with the label failed with, and when, or an empty string if it has not
+// failed.
+func LastError(db *gorm.DB, primaryLabel string) (string, time.Time, error) {
+	var leases []models.EncryptionRotationLease
+	if err := db.Where("name = ? AND primary_label = ?", leaseName, primaryLabel).Find(&leases).Error; err != nil {
+		return "", time.Time{}, err
+	}
+
+	if len(leases) == 0 {
+		return "", time.Time{}, nil
+	}
+	return leases[0].LastError, leases[0].UpdatedAt, nil
+}
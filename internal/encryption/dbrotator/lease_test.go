@@ -0,0 +1,79 @@
+package dbrotator_test
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/db_service/models"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/internal/encryption/dbrotator"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+var _ = Describe("WithLease", func() {
+	const label = "new-label"
+
+	var (
+		db     *gorm.DB
+		logger lager.Logger
+	)
+
+	BeforeEach(func() {
+		var err error
+		db, err = gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(db.Migrator().CreateTable(models.EncryptionRotationLease{})).To(Succeed())
+		logger = lager.NewLogger("test")
+	})
+
+	It("runs one rotation at a time", func() {
+		var nested error
+		Expect(dbrotator.WithLease(context.TODO(), db, label, logger, func(context.Context) error {
+			nested = dbrotator.WithLease(context.TODO(), db, label, logger, func(context.Context) error {
+				Fail("a second rotation should not run")
+				return nil
+			})
+			return nil
+		})).To(Succeed())
+		Expect(nested).To(MatchError(dbrotator.ErrRotationInProgress))
+
+		By("releasing the lease once done")
+		Expect(dbrotator.WithLease(context.TODO(), db, label, logger, func(context.Context) error { return nil })).To(Succeed())
+	})
+
+	It("takes over a lease that has expired", func() {
+		Expect(db.Create(&models.EncryptionRotationLease{Name: "rotation", Owner: "stopped-broker", ExpiresAt: time.Now().Add(-time.Second)}).Error).To(Succeed())
+
+		var ran bool
+		Expect(dbrotator.WithLease(context.TODO(), db, label, logger, func(context.Context) error {
+			ran = true
+			return nil
+		})).To(Succeed())
+		Expect(ran).To(BeTrue())
+	})
+
+	It("records the error of the last rotation", func() {
+		Expect(dbrotator.WithLease(context.TODO(), db, label, logger, func(context.Context) error {
+			return errors.New("rotation failed")
+		})).To(MatchError("rotation failed"))
+
+		lastError, failedAt, err := dbrotator.LastError(db, label)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(lastError).To(Equal("rotation failed"))
+		Expect(failedAt).To(BeTemporally("~", time.Now(), time.Minute))
+
+		lastError, _, err = dbrotator.LastError(db, "other-label")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(lastError).To(BeEmpty())
+
+		By("clearing it once a rotation succeeds")
+		Expect(dbrotator.WithLease(context.TODO(), db, label, logger, func(context.Context) error { return nil })).To(Succeed())
+		lastError, _, err = dbrotator.LastError(db, label)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(lastError).To(BeEmpty())
+	})
+})
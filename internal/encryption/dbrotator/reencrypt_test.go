@@ -0,0 +1,237 @@
+package dbrotator_test
+
+import (
+	"context"
+	"fmt"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/db_service/models"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/internal/encryption/compoundencryptor"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/internal/encryption/dbrotator"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/internal/encryption/gcmencryptor"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+var _ = Describe("Reencrypt", func() {
+	const (
+		instances = 5
+		label     = "new-label"
+	)
+
+	var (
+		db         *gorm.DB
+		oldKey     [32]byte
+		newKey     [32]byte
+		ciphertext map[string]string
+		logger     lager.Logger
+	)
+
+	persistedInstances := func() map[string]string {
+		var records []models.ServiceInstanceDetails
+		Expect(db.Find(&records).Error).NotTo(HaveOccurred())
+
+		result := make(map[string]string)
+		for _, r := range records {
+			result[r.ID] = r.OtherDetails
+		}
+		return result
+	}
+
+	checkpoint := func(table string) models.EncryptionRotation {
+		var record models.EncryptionRotation
+		Expect(db.Where("table_name = ?", table).First(&record).Error).NotTo(HaveOccurred())
+		return record
+	}
+
+	BeforeEach(func() {
+		var err error
+		db, err = gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(db.Migrator().CreateTable(
+			models.ServiceInstanceDetails{},
+			models.ServiceBindingCredentials{},
+			models.ProvisionRequestDetails{},
+			models.TerraformDeployment{},
+			models.CloudOperation{},
+			models.AuditEvent{},
+			models.EncryptionRotation{},
+		)).To(Succeed())
+
+		logger = lager.NewLogger("test")
+		copy(oldKey[:], "one-key-here-with-32-bytes-in-it")
+		copy(newKey[:], "another-key-here-with-32-bytes-in-it")
+
+		models.SetEncryptor(gcmencryptor.New(oldKey))
+		for i := 0; i < instances; i++ {
+			record := models.ServiceInstanceDetails{ID: fmt.Sprintf("instance-%d", i)}
+			Expect(record.SetOtherDetails(map[string]interface{}{"index": i})).To(Succeed())
+			Expect(db.Create(&record).Error).To(Succeed())
+		}
+		ciphertext = persistedInstances()
+
+		newEncryptor := gcmencryptor.New(newKey)
+		models.SetEncryptor(compoundencryptor.New(newEncryptor, gcmencryptor.New(oldKey), newEncryptor))
+	})
+
+	It("re-encrypts every row and records that each table is complete", func() {
+		Expect(dbrotator.Reencrypt(context.TODO(), db, label, 2, logger)).To(Succeed())
+
+		models.SetEncryptor(gcmencryptor.New(newKey))
+		var records []models.ServiceInstanceDetails
+		Expect(db.Find(&records).Error).NotTo(HaveOccurred())
+		Expect(records).To(HaveLen(instances))
+		for _, r := range records {
+			var details map[string]interface{}
+			Expect(r.GetOtherDetails(&details)).To(Succeed())
+		}
+
+		status, err := dbrotator.Status(db, label)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(status).To(HaveLen(6))
+		for _, s := range status {
+			Expect(s.CompletedAt).NotTo(BeNil(), s.Table)
+			Expect(s.RowsDone).To(Equal(s.Rows), s.Table)
+		}
+	})
+
+	It("re-encrypts every column marked as encrypted, including in soft-deleted rows", func() {
+		operation := models.CloudOperation{}
+		Expect(operation.SetErrorMessage("error message")).To(Succeed())
+		Expect(db.Create(&operation).Error).To(Succeed())
+
+		details := models.ProvisionRequestDetails{ServiceInstanceId: "instance-0"}
+		Expect(details.SetRequestDetails([]byte(`{"a":"secret"}`))).To(Succeed())
+		Expect(db.Create(&details).Error).To(Succeed())
+		Expect(db.Delete(&details).Error).To(Succeed())
+
+		Expect(dbrotator.Reencrypt(context.TODO(), db, label, 2, logger)).To(Succeed())
+
+		models.SetEncryptor(gcmencryptor.New(newKey))
+		Expect(db.First(&operation).Error).To(Succeed())
+		Expect(operation.GetErrorMessage()).To(Equal("error message"))
+
+		Expect(db.Unscoped().First(&details).Error).To(Succeed())
+		Expect(details.GetRequestDetails()).To(MatchJSON(`{"a":"secret"}`))
+	})
+
+	It("encrypts values written in plaintext before their column was encrypted", func() {
+		Expect(db.Create(&models.CloudOperation{Name: "legacy", ErrorMessage: "error message"}).Error).To(Succeed())
+
+		Expect(dbrotator.Reencrypt(context.TODO(), db, label, 2, logger)).To(Succeed())
+
+		models.SetEncryptor(gcmencryptor.New(newKey))
+		var operation models.CloudOperation
+		Expect(db.First(&operation).Error).To(Succeed())
+		Expect(operation.ErrorMessage).NotTo(Equal("error message"))
+		Expect(operation.GetErrorMessage()).To(Equal("error message"))
+	})
+
+	It("encrypts audit event parameters recorded in plaintext", func() {
+		Expect(db.Create(&models.AuditEvent{Operation: "provision", Outcome: "succeeded", Parameters: `{"name":"db"}`}).Error).To(Succeed())
+
+		Expect(dbrotator.Reencrypt(context.TODO(), db, label, 2, logger)).To(Succeed())
+
+		models.SetEncryptor(gcmencryptor.New(newKey))
+		var event models.AuditEvent
+		Expect(db.First(&event).Error).To(Succeed())
+		Expect(event.Parameters).NotTo(Equal(`{"name":"db"}`))
+		Expect(event.GetParameters()).To(Equal(`{"name":"db"}`))
+	})
+
+	It("fails on values of other columns that cannot be decrypted", func() {
+		Expect(db.Create(&models.ServiceInstanceDetails{ID: "plaintext", OtherDetails: `{"a":"b"}`}).Error).To(Succeed())
+
+		Expect(dbrotator.Reencrypt(context.TODO(), db, label, 2, logger)).To(MatchError(ContainSubstring("error reencrypting service_instance_details")))
+	})
+
+	It("leaves empty values empty", func() {
+		Expect(db.Create(&models.CloudOperation{Name: "no-error"}).Error).To(Succeed())
+
+		Expect(dbrotator.Reencrypt(context.TODO(), db, label, 2, logger)).To(Succeed())
+
+		var operation models.CloudOperation
+		Expect(db.First(&operation).Error).To(Succeed())
+		Expect(operation.ErrorMessage).To(BeEmpty())
+	})
+
+	It("resumes from the last checkpoint", func() {
+		By("stopping after the first batch")
+		Expect(db.Create(&models.EncryptionRotation{PrimaryLabel: label, Table: "service_instance_details", LastKey: "instance-1", RowsDone: 2}).Error).To(Succeed())
+
+		Expect(dbrotator.Reencrypt(context.TODO(), db, label, 2, logger)).To(Succeed())
+
+		persisted := persistedInstances()
+		Expect(persisted["instance-0"]).To(Equal(ciphertext["instance-0"]))
+		Expect(persisted["instance-1"]).To(Equal(ciphertext["instance-1"]))
+		for _, id := range []string{"instance-2", "instance-3", "instance-4"} {
+			Expect(persisted[id]).NotTo(Equal(ciphertext[id]))
+		}
+		Expect(checkpoint("service_instance_details").RowsDone).To(Equal(int64(instances)))
+	})
+
+	It("keeps the checkpoints of a rotation that disables encryption apart", func() {
+		Expect(db.Create(&models.EncryptionRotation{PrimaryLabel: label, Table: "service_instance_details", LastKey: "instance-4", RowsDone: 5}).Error).To(Succeed())
+
+		Expect(dbrotator.Reencrypt(context.TODO(), db, "", 2, logger)).To(Succeed())
+
+		persisted := persistedInstances()
+		for id := range ciphertext {
+			Expect(persisted[id]).NotTo(Equal(ciphertext[id]))
+		}
+		Expect(checkpoint("service_instance_details").PrimaryLabel).To(BeEmpty())
+	})
+
+	It("removes the checkpoints of a rotation to another password", func() {
+		Expect(db.Create(&models.EncryptionRotation{PrimaryLabel: "other-label", Table: "service_instance_details", LastKey: "instance-4", RowsDone: 5}).Error).To(Succeed())
+
+		Expect(dbrotator.Reencrypt(context.TODO(), db, label, 2, logger)).To(Succeed())
+
+		persisted := persistedInstances()
+		for id := range ciphertext {
+			Expect(persisted[id]).NotTo(Equal(ciphertext[id]))
+		}
+		Expect(checkpoint("service_instance_details").PrimaryLabel).To(Equal(label))
+
+		var count int64
+		Expect(db.Model(&models.EncryptionRotation{}).Where("primary_label = ?", "other-label").Count(&count).Error).To(Succeed())
+		Expect(count).To(BeZero())
+	})
+
+	It("does not overwrite rows that changed while re-encrypting", func() {
+		changed := models.ServiceInstanceDetails{ID: "instance-3"}
+		Expect(changed.SetOtherDetails(map[string]interface{}{"changed": true})).To(Succeed())
+
+		By("changing a row after the batch is read")
+		done := false
+		Expect(db.Callback().Query().After("gorm:query").Register("change-row", func(tx *gorm.DB) {
+			if tx.Statement.Table == "service_instance_details" && !done {
+				done = true
+				Expect(tx.Session(&gorm.Session{NewDB: true}).Exec("UPDATE service_instance_details SET other_details = ? WHERE id = ?", changed.OtherDetails, changed.ID).Error).To(Succeed())
+			}
+		})).To(Succeed())
+
+		Expect(dbrotator.Reencrypt(context.TODO(), db, label, 10, logger)).To(Succeed())
+		Expect(db.Callback().Query().Remove("change-row")).To(Succeed())
+
+		Expect(persistedInstances()["instance-3"]).To(Equal(changed.OtherDetails))
+	})
+
+	It("stops when the context is done", func() {
+		ctx, cancel := context.WithCancel(context.TODO())
+		cancel()
+
+		Expect(dbrotator.Reencrypt(ctx, db, label, 2, logger)).To(MatchError(context.Canceled))
+		Expect(persistedInstances()).To(Equal(ciphertext))
+	})
+
+	Describe("Status", func() {
+		It("reports tables that have not been started", func() {
+			status, err := dbrotator.Status(db, label)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(status).To(ContainElement(dbrotator.TableStatus{Table: "service_instance_details", Rows: instances}))
+		})
+	})
+})
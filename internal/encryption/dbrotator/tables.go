@@ -0,0 +1,178 @@
+package dbrotator
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/cloudfoundry-incubator/cloud-service-broker/db_service/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// allModels is every model of the database. Their fields marked with the
+// models.EncryptedTag struct tag are found by reflection, so that columns are
+// covered as soon as they are marked.
+var allModels = []interface{}{
+	&models.ProvisionRequestDetails{},
+	&models.ServiceInstanceDetails{},
+	&models.ServiceBindingCredentials{},
+	&models.TerraformDeployment{},
+	&models.CloudOperation{},
+	&models.TerraformJob{},
+	&models.TerraformDeploymentLock{},
+	&models.PasswordMetadata{},
+	&models.EncryptionRotation{},
+	&models.EncryptionRotationLease{},
+	&models.AuditEvent{},
+	&models.Migration{},
+}
+
+// table is a table of the database with encrypted columns.
+type table struct {
+	name    string
+	key     *schema.Field
+	columns []*schema.Field
+	model   reflect.Type
+}
+
+// value is a value of an encrypted column of a row.
+type value struct {
+	column      string
+	ciphertext  string
+	reencrypted string
+	// legacyPlaintext is set if the column can hold values written in
+	// plaintext before it was encrypted.
+	legacyPlaintext bool
+}
+
+// row is a row of a table, with the values of its encrypted columns before and
+// after being re-encrypted.
+type row struct {
+	key    string
+	values []value
+}
+
+// encryptedTables finds the tables with encrypted columns.
+func encryptedTables(db *gorm.DB) ([]table, error) {
+	var tables []table
+	for _, model := range allModels {
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(model); err != nil {
+			return nil, err
+		}
+
+		t := table{
+			name:  stmt.Schema.Table,
+			key:   stmt.Schema.PrioritizedPrimaryField,
+			model: stmt.Schema.ModelType,
+		}
+		for _, field := range stmt.Schema.Fields {
+			if field.Tag.Get(models.EncryptedTag) != "true" {
+				continue
+			}
+			if field.FieldType.Kind() != reflect.String {
+				return nil, fmt.Errorf("encrypted field %s of %s must be a string", field.Name, t.model)
+			}
+			t.columns = append(t.columns, field)
+		}
+
+		if len(t.columns) > 0 {
+			tables = append(tables, t)
+		}
+	}
+
+	return tables, nil
+}
+
+// load reads the rows that follow the key in the order of their primary keys,
+// or the first rows if the key is nil. Soft-deleted rows are included.
+func (t table) load(db *gorm.DB, after *string, size int) ([]row, error) {
+	selected := []string{t.key.DBName}
+	for _, c := range t.columns {
+		selected = append(selected, c.DBName)
+	}
+
+	query := db.Unscoped().Select(selected).Order(t.key.DBName).Limit(size)
+	if after != nil {
+		query = query.Where(t.key.DBName+" > ?", *after)
+	}
+
+	records := reflect.New(reflect.SliceOf(t.model))
+	if err := query.Find(records.Interface()).Error; err != nil {
+		return nil, err
+	}
+
+	rows := make([]row, records.Elem().Len())
+	for i := range rows {
+		record := records.Elem().Index(i)
+		key, _ := t.key.ValueOf(record)
+		rows[i].key = fmt.Sprint(key)
+
+		for _, c := range t.columns {
+			v, _ := c.ValueOf(record)
+			rows[i].values = append(rows[i].values, value{
+				column:          c.DBName,
+				ciphertext:      v.(string),
+				legacyPlaintext: c.Tag.Get(models.LegacyPlaintextTag) == "true",
+			})
+		}
+	}
+
+	return rows, nil
+}
+
+// update sets the encrypted columns of the row to their re-encrypted values.
+// The row is only updated if none of its values have changed since it was read.
+func (t table) update(db *gorm.DB, r row) error {
+	query := db.Table(t.name).Where(t.key.DBName+" = ?", r.key)
+	changes := make(map[string]interface{})
+	for _, v := range r.values {
+		query = query.Where(v.column+" = ?", v.ciphertext)
+		if v.reencrypted != v.ciphertext {
+			changes[v.column] = v.reencrypted
+		}
+	}
+
+	if len(changes) == 0 {
+		return nil
+	}
+	return query.UpdateColumns(changes).Error
+}
+
+// reencryptBatch re-encrypts the rows of the table that follow the key in the
+// order of their primary keys, or the first rows if the key is nil, returning
+// the rows it read. Empty values are left empty, and plaintext values of
+// columns marked with models.LegacyPlaintextTag are encrypted.
+func reencryptBatch(db *gorm.DB, t table, after *string, size int) ([]row, error) {
+	rows, err := t.load(db, after, size)
+	if err != nil {
+		return nil, err
+	}
+
+	encryptor := models.GetEncryptor()
+	for _, r := range rows {
+		for i, v := range r.values {
+			if v.ciphertext == "" {
+				continue
+			}
+
+			plaintext, err := encryptor.Decrypt(v.ciphertext)
+			switch {
+			case err == nil:
+			case v.legacyPlaintext && !looksEncrypted(v.ciphertext):
+				plaintext = []byte(v.ciphertext)
+			default:
+				return nil, err
+			}
+			if r.values[i].reencrypted, err = encryptor.Encrypt(plaintext); err != nil {
+				return nil, err
+			}
+		}
+
+		if err := t.update(db, r); err != nil {
+			return nil, err
+		}
+	}
+
+	return rows, nil
+}
@@ -0,0 +1,92 @@
+package dbrotator
+
+import (
+	"context"
+	b64 "encoding/base64"
+
+	"github.com/cloudfoundry-incubator/cloud-service-broker/db_service/models"
+	"gorm.io/gorm"
+)
+
+// minCiphertextLength is the length of the nonce and tag that every value
+// encrypted with AES-GCM has.
+const minCiphertextLength = 12 + 16
+
+// Finding is a value of an encrypted column that cannot be decrypted.
+type Finding struct {
+	Table  string
+	Column string
+	Key    string
+	// Plaintext is set if the value is not encrypted, rather than encrypted
+	// with a password that is not configured.
+	Plaintext bool
+	// Encrypted is set if the value was plaintext and has been encrypted.
+	Encrypted bool
+}
+
+// Verify checks that every value of the encrypted columns of the database can
+// be decrypted with the encryptor, returning those that cannot. If encrypt is
+// set, the values that are plaintext are encrypted. Empty values are not
+// encrypted, and are not reported.
+func Verify(ctx context.Context, db *gorm.DB, encrypt bool) ([]Finding, error) {
+	tables, err := encryptedTables(db)
+	if err != nil {
+		return nil, err
+	}
+
+	encryptor := models.GetEncryptor()
+	var findings []Finding
+	for _, t := range tables {
+		var last *string
+		for {
+			if err := ctx.Err(); err != nil {
+				return findings, err
+			}
+
+			rows, err := t.load(db, last, DefaultBatchSize)
+			if err != nil {
+				return findings, err
+			}
+			if len(rows) == 0 {
+				break
+			}
+			last = &rows[len(rows)-1].key
+
+			for _, r := range rows {
+				var found []Finding
+				for i, v := range r.values {
+					r.values[i].reencrypted = v.ciphertext
+					if v.ciphertext == "" {
+						continue
+					}
+					if _, err := encryptor.Decrypt(v.ciphertext); err == nil {
+						continue
+					}
+
+					finding := Finding{Table: t.name, Column: v.column, Key: r.key, Plaintext: !looksEncrypted(v.ciphertext)}
+					if encrypt && finding.Plaintext {
+						if r.values[i].reencrypted, err = encryptor.Encrypt([]byte(v.ciphertext)); err != nil {
+							return findings, err
+						}
+						finding.Encrypted = true
+					}
+					found = append(found, finding)
+				}
+
+				if err := t.update(db, r); err != nil {
+					return findings, err
+				}
+				findings = append(findings, found...)
+			}
+		}
+	}
+
+	return findings, nil
+}
+
+// looksEncrypted reports whether the value has the form of a value encrypted
+// with AES-GCM, rather than being plaintext.
+func looksEncrypted(value string) bool {
+	decoded, err := b64.StdEncoding.DecodeString(value)
+	return err == nil && len(decoded) >= minCiphertextLength
+}
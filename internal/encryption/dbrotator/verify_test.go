@@ -0,0 +1,97 @@
+package dbrotator_test
+
+import (
+	"context"
+
+	"github.com/cloudfoundry-incubator/cloud-service-broker/db_service/models"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/internal/encryption/dbrotator"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/internal/encryption/gcmencryptor"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/internal/encryption/noopencryptor"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+var _ = Describe("Verify", func() {
+	var (
+		db        *gorm.DB
+		key       [32]byte
+		otherKey  [32]byte
+		encrypted models.CloudOperation
+		plaintext models.CloudOperation
+		unknown   models.CloudOperation
+	)
+
+	BeforeEach(func() {
+		var err error
+		db, err = gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(db.Migrator().CreateTable(
+			models.ServiceInstanceDetails{},
+			models.ServiceBindingCredentials{},
+			models.ProvisionRequestDetails{},
+			models.TerraformDeployment{},
+			models.CloudOperation{},
+			models.AuditEvent{},
+		)).To(Succeed())
+
+		copy(key[:], "one-key-here-with-32-bytes-in-it")
+		copy(otherKey[:], "another-key-here-with-32-bytes-in-it")
+
+		By("writing a value before the column was encrypted")
+		models.SetEncryptor(noopencryptor.New())
+		plaintext = models.CloudOperation{Name: "plaintext"}
+		Expect(plaintext.SetErrorMessage("error message")).To(Succeed())
+		Expect(db.Create(&plaintext).Error).To(Succeed())
+
+		By("writing a value with a password that is no longer configured")
+		models.SetEncryptor(gcmencryptor.New(otherKey))
+		unknown = models.CloudOperation{Name: "unknown"}
+		Expect(unknown.SetErrorMessage("error message")).To(Succeed())
+		Expect(db.Create(&unknown).Error).To(Succeed())
+
+		models.SetEncryptor(gcmencryptor.New(key))
+		encrypted = models.CloudOperation{Name: "encrypted"}
+		Expect(encrypted.SetErrorMessage("error message")).To(Succeed())
+		Expect(db.Create(&encrypted).Error).To(Succeed())
+		Expect(db.Create(&models.CloudOperation{Name: "empty"}).Error).To(Succeed())
+	})
+
+	It("finds the values that cannot be decrypted", func() {
+		findings, err := dbrotator.Verify(context.TODO(), db, false)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(findings).To(ConsistOf(
+			dbrotator.Finding{Table: "cloud_operations", Column: "error_message", Key: "1", Plaintext: true},
+			dbrotator.Finding{Table: "cloud_operations", Column: "error_message", Key: "2"},
+		))
+
+		var operation models.CloudOperation
+		Expect(db.First(&operation, plaintext.ID).Error).To(Succeed())
+		Expect(operation.ErrorMessage).To(Equal("error message"))
+	})
+
+	It("encrypts plaintext values", func() {
+		findings, err := dbrotator.Verify(context.TODO(), db, true)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(findings).To(ConsistOf(
+			dbrotator.Finding{Table: "cloud_operations", Column: "error_message", Key: "1", Plaintext: true, Encrypted: true},
+			dbrotator.Finding{Table: "cloud_operations", Column: "error_message", Key: "2"},
+		))
+
+		var operation models.CloudOperation
+		Expect(db.First(&operation, plaintext.ID).Error).To(Succeed())
+		Expect(operation.ErrorMessage).NotTo(Equal("error message"))
+		Expect(operation.GetErrorMessage()).To(Equal("error message"))
+
+		By("leaving the values it cannot decrypt unchanged")
+		var unchanged models.CloudOperation
+		Expect(db.First(&unchanged, unknown.ID).Error).To(Succeed())
+		Expect(unchanged.ErrorMessage).To(Equal(unknown.ErrorMessage))
+
+		By("finding nothing more to encrypt")
+		findings, err = dbrotator.Verify(context.TODO(), db, true)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(findings).To(HaveLen(1))
+	})
+})
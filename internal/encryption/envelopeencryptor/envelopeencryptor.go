@@ -0,0 +1,61 @@
+package envelopeencryptor
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+
+	"github.com/cloudfoundry-incubator/cloud-service-broker/internal/encryption/gcmencryptor"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/internal/encryption/kms"
+)
+
+// New creates an encryptor with a new random data key. The data key is wrapped
+// by the key management service so that it can be stored, and opened again
+// with Open. The key management service is not called to encrypt or decrypt.
+func New(ctx context.Context, wrapper kms.KeyWrapper) (EnvelopeEncryptor, error) {
+	var key [32]byte
+	if _, err := io.ReadFull(rand.Reader, key[:]); err != nil {
+		return EnvelopeEncryptor{}, err
+	}
+
+	wrapped, err := wrapper.Wrap(ctx, key[:])
+	if err != nil {
+		return EnvelopeEncryptor{}, fmt.Errorf("error wrapping data key: %w", err)
+	}
+
+	return EnvelopeEncryptor{dataKey: gcmencryptor.New(key), wrappedKey: wrapped}, nil
+}
+
+// Open creates an encryptor with a data key that was wrapped by New.
+func Open(ctx context.Context, wrapper kms.KeyWrapper, wrappedKey []byte) (EnvelopeEncryptor, error) {
+	unwrapped, err := wrapper.Unwrap(ctx, wrappedKey)
+	switch {
+	case err != nil:
+		return EnvelopeEncryptor{}, fmt.Errorf("error unwrapping data key: %w", err)
+	case len(unwrapped) != 32:
+		return EnvelopeEncryptor{}, fmt.Errorf("error unwrapping data key: expected 32 bytes, got %d", len(unwrapped))
+	}
+
+	var key [32]byte
+	copy(key[:], unwrapped)
+	return EnvelopeEncryptor{dataKey: gcmencryptor.New(key), wrappedKey: wrappedKey}, nil
+}
+
+type EnvelopeEncryptor struct {
+	dataKey    gcmencryptor.GCMEncryptor
+	wrappedKey []byte
+}
+
+func (e EnvelopeEncryptor) Encrypt(plaintext []byte) (string, error) {
+	return e.dataKey.Encrypt(plaintext)
+}
+
+func (e EnvelopeEncryptor) Decrypt(ciphertext string) ([]byte, error) {
+	return e.dataKey.Decrypt(ciphertext)
+}
+
+// WrappedKey is the data key as wrapped by the key management service.
+func (e EnvelopeEncryptor) WrappedKey() []byte {
+	return e.wrappedKey
+}
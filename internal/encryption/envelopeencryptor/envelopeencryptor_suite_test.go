@@ -0,0 +1,13 @@
+package envelopeencryptor
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestEnvelopeEncryptor(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Envelope Encryptor Suite")
+}
@@ -0,0 +1,101 @@
+package envelopeencryptor_test
+
+import (
+	"context"
+	b64 "encoding/base64"
+	"errors"
+
+	"github.com/cloudfoundry-incubator/cloud-service-broker/internal/encryption/envelopeencryptor"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/internal/encryption/kms"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("EnvelopeEncryptor", func() {
+	var wrapper kms.KeyWrapper
+
+	BeforeEach(func() {
+		var err error
+		wrapper, err = kms.New(kms.Config{
+			Provider: kms.ProviderLocal,
+			KeyID:    "test",
+			Key:      b64.StdEncoding.EncodeToString([]byte("a-local-key-of-exactly-32-bytes!")),
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("can decrypt what it encrypted", func() {
+		encryptor, err := envelopeencryptor.New(context.Background(), wrapper)
+		Expect(err).NotTo(HaveOccurred())
+
+		encrypted, err := encryptor.Encrypt([]byte("Text to Encrypt"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(encrypted).NotTo(ContainSubstring("Encrypt"))
+
+		decrypted, err := encryptor.Decrypt(encrypted)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(decrypted)).To(Equal("Text to Encrypt"))
+	})
+
+	It("can be opened again with the wrapped key", func() {
+		encryptor, err := envelopeencryptor.New(context.Background(), wrapper)
+		Expect(err).NotTo(HaveOccurred())
+		encrypted, err := encryptor.Encrypt([]byte("Text to Encrypt"))
+		Expect(err).NotTo(HaveOccurred())
+
+		opened, err := envelopeencryptor.Open(context.Background(), wrapper, encryptor.WrappedKey())
+		Expect(err).NotTo(HaveOccurred())
+		decrypted, err := opened.Decrypt(encrypted)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(decrypted)).To(Equal("Text to Encrypt"))
+	})
+
+	It("uses a new data key each time", func() {
+		first, err := envelopeencryptor.New(context.Background(), wrapper)
+		Expect(err).NotTo(HaveOccurred())
+		second, err := envelopeencryptor.New(context.Background(), wrapper)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(first.WrappedKey()).NotTo(Equal(second.WrappedKey()))
+
+		encrypted, err := first.Encrypt([]byte("Text to Encrypt"))
+		Expect(err).NotTo(HaveOccurred())
+		_, err = second.Decrypt(encrypted)
+		Expect(err).To(MatchError("cipher: message authentication failed"))
+	})
+
+	When("the key management service fails", func() {
+		It("returns the error", func() {
+			_, err := envelopeencryptor.New(context.Background(), failingWrapper{})
+			Expect(err).To(MatchError("error wrapping data key: unavailable"))
+
+			_, err = envelopeencryptor.Open(context.Background(), failingWrapper{}, []byte("wrapped"))
+			Expect(err).To(MatchError("error unwrapping data key: unavailable"))
+		})
+	})
+
+	When("the wrapped key was made by a different key", func() {
+		It("returns an error", func() {
+			other, err := kms.New(kms.Config{
+				Provider: kms.ProviderLocal,
+				KeyID:    "other",
+				Key:      b64.StdEncoding.EncodeToString([]byte("another-local-key-with-32-bytes!")),
+			})
+			Expect(err).NotTo(HaveOccurred())
+			encryptor, err := envelopeencryptor.New(context.Background(), other)
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = envelopeencryptor.Open(context.Background(), wrapper, encryptor.WrappedKey())
+			Expect(err).To(MatchError("error unwrapping data key: cipher: message authentication failed"))
+		})
+	})
+})
+
+type failingWrapper struct{}
+
+func (failingWrapper) Wrap(context.Context, []byte) ([]byte, error) {
+	return nil, errors.New("unavailable")
+}
+
+func (failingWrapper) Unwrap(context.Context, []byte) ([]byte, error) {
+	return nil, errors.New("unavailable")
+}
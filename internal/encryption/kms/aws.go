@@ -0,0 +1,53 @@
+package kms
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	awskms "github.com/aws/aws-sdk-go/service/kms"
+)
+
+type awsKMS struct {
+	keyID  string
+	client *awskms.KMS
+}
+
+func newAWS(cfg Config) (KeyWrapper, error) {
+	awsConfig := aws.NewConfig()
+	if cfg.Region != "" {
+		awsConfig = awsConfig.WithRegion(cfg.Region)
+	}
+	if cfg.Endpoint != "" {
+		awsConfig = awsConfig.WithEndpoint(cfg.Endpoint)
+	}
+
+	sess, err := session.NewSession(awsConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return awsKMS{keyID: cfg.KeyID, client: awskms.New(sess)}, nil
+}
+
+func (a awsKMS) Wrap(ctx context.Context, plaintext []byte) ([]byte, error) {
+	output, err := a.client.EncryptWithContext(ctx, &awskms.EncryptInput{
+		KeyId:     aws.String(a.keyID),
+		Plaintext: plaintext,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return output.CiphertextBlob, nil
+}
+
+// Unwrap does not need the key, as AWS records it in the ciphertext.
+func (a awsKMS) Unwrap(ctx context.Context, ciphertext []byte) ([]byte, error) {
+	output, err := a.client.DecryptWithContext(ctx, &awskms.DecryptInput{
+		CiphertextBlob: ciphertext,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return output.Plaintext, nil
+}
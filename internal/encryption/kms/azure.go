@@ -0,0 +1,93 @@
+package kms
+
+import (
+	"context"
+	b64 "encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+const (
+	azureDefaultLoginEndpoint = "https://login.microsoftonline.com"
+	azureKeyVaultScope        = "https://vault.azure.net/.default"
+	azureAPIVersion           = "7.3"
+	azureWrapAlgorithm        = "RSA-OAEP-256"
+)
+
+type azureKeyVault struct {
+	keyID  string
+	client *http.Client
+}
+
+// azureWrappedKey is the wrapped form of a data key. It records the version
+// of the key that wrapped it, which is needed to unwrap it after the key is
+// rotated in Key Vault.
+type azureWrappedKey struct {
+	KeyID string `json:"kid"`
+	Value string `json:"value"`
+}
+
+func newAzure(cfg Config) (KeyWrapper, error) {
+	if cfg.TenantID == "" || cfg.ClientID == "" || cfg.ClientSecret == "" {
+		return nil, errors.New("a tenant_id, client_id and client_secret are required for the azure key management service")
+	}
+
+	endpoint := cfg.Endpoint
+	if endpoint == "" {
+		endpoint = azureDefaultLoginEndpoint
+	}
+
+	credentials := clientcredentials.Config{
+		ClientID:     cfg.ClientID,
+		ClientSecret: cfg.ClientSecret,
+		TokenURL:     fmt.Sprintf("%s/%s/oauth2/v2.0/token", strings.TrimSuffix(endpoint, "/"), cfg.TenantID),
+		Scopes:       []string{azureKeyVaultScope},
+		AuthStyle:    oauth2.AuthStyleInParams,
+	}
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, httpClient)
+
+	return azureKeyVault{
+		keyID:  strings.TrimSuffix(cfg.KeyID, "/"),
+		client: credentials.Client(ctx),
+	}, nil
+}
+
+func (a azureKeyVault) Wrap(ctx context.Context, plaintext []byte) ([]byte, error) {
+	var response azureWrappedKey
+	if err := a.do(ctx, a.keyID, "wrapkey", plaintext, &response); err != nil {
+		return nil, err
+	}
+	return json.Marshal(response)
+}
+
+func (a azureKeyVault) Unwrap(ctx context.Context, ciphertext []byte) ([]byte, error) {
+	var wrapped azureWrappedKey
+	if err := json.Unmarshal(ciphertext, &wrapped); err != nil {
+		return nil, fmt.Errorf("error reading wrapped key: %w", err)
+	}
+	value, err := b64.RawURLEncoding.DecodeString(wrapped.Value)
+	if err != nil {
+		return nil, fmt.Errorf("error reading wrapped key: %w", err)
+	}
+
+	var response azureWrappedKey
+	if err := a.do(ctx, wrapped.KeyID, "unwrapkey", value, &response); err != nil {
+		return nil, err
+	}
+	return b64.RawURLEncoding.DecodeString(response.Value)
+}
+
+func (a azureKeyVault) do(ctx context.Context, keyID, operation string, value []byte, result *azureWrappedKey) error {
+	body := map[string]string{
+		"alg":   azureWrapAlgorithm,
+		"value": b64.RawURLEncoding.EncodeToString(value),
+	}
+	url := fmt.Sprintf("%s/%s?api-version=%s", keyID, operation, azureAPIVersion)
+	return postJSON(ctx, a.client, url, nil, body, result)
+}
@@ -0,0 +1,71 @@
+package kms
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+const (
+	gcpDefaultEndpoint = "https://cloudkms.googleapis.com"
+	gcpKMSScope        = "https://www.googleapis.com/auth/cloudkms"
+)
+
+type gcpKMS struct {
+	url    string
+	client *http.Client
+}
+
+func newGCP(cfg Config) (KeyWrapper, error) {
+	endpoint := cfg.Endpoint
+	if endpoint == "" {
+		endpoint = gcpDefaultEndpoint
+	}
+
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, httpClient)
+	var client *http.Client
+	if cfg.Credentials != "" {
+		credentials, err := google.CredentialsFromJSON(ctx, []byte(cfg.Credentials), gcpKMSScope)
+		if err != nil {
+			return nil, fmt.Errorf("error reading GCP credentials: %w", err)
+		}
+		client = oauth2.NewClient(ctx, credentials.TokenSource)
+	} else {
+		var err error
+		client, err = google.DefaultClient(ctx, gcpKMSScope)
+		if err != nil {
+			return nil, fmt.Errorf("error finding GCP credentials: %w", err)
+		}
+	}
+
+	return gcpKMS{
+		url:    fmt.Sprintf("%s/v1/%s", strings.TrimSuffix(endpoint, "/"), cfg.KeyID),
+		client: client,
+	}, nil
+}
+
+// Wrap and Unwrap rely on the JSON encoding of []byte being base64, as the
+// GCP API expects.
+func (g gcpKMS) Wrap(ctx context.Context, plaintext []byte) ([]byte, error) {
+	var response struct {
+		Ciphertext []byte `json:"ciphertext"`
+	}
+	if err := postJSON(ctx, g.client, g.url+":encrypt", nil, map[string][]byte{"plaintext": plaintext}, &response); err != nil {
+		return nil, err
+	}
+	return response.Ciphertext, nil
+}
+
+func (g gcpKMS) Unwrap(ctx context.Context, ciphertext []byte) ([]byte, error) {
+	var response struct {
+		Plaintext []byte `json:"plaintext"`
+	}
+	if err := postJSON(ctx, g.client, g.url+":decrypt", nil, map[string][]byte{"ciphertext": ciphertext}, &response); err != nil {
+		return nil, err
+	}
+	return response.Plaintext, nil
+}
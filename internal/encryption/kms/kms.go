@@ -0,0 +1,117 @@
+// Package kms wraps and unwraps data keys with a key held in an external key
+// management service, so that the keys that encrypt the database are never
+// stored in the clear.
+package kms
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+const (
+	ProviderAWS          = "aws"
+	ProviderGCP          = "gcp"
+	ProviderAzure        = "azure"
+	ProviderVaultTransit = "vault-transit"
+	ProviderLocal        = "local"
+)
+
+var Providers = []string{ProviderAWS, ProviderGCP, ProviderAzure, ProviderVaultTransit, ProviderLocal}
+
+// KeyWrapper encrypts and decrypts data keys with a key that never leaves the
+// key management service.
+type KeyWrapper interface {
+	Wrap(ctx context.Context, plaintext []byte) ([]byte, error)
+	Unwrap(ctx context.Context, ciphertext []byte) ([]byte, error)
+}
+
+// Config identifies a key in a key management service, and how to reach it.
+// Fields that do not apply to the provider are ignored.
+type Config struct {
+	Provider string `json:"provider"`
+	// KeyID is the ARN or alias of an AWS key, the resource name of a GCP
+	// key, the URL of an Azure Key Vault key, or the name of a Vault
+	// Transit key.
+	KeyID string `json:"key_id"`
+	// Endpoint overrides the API endpoint of AWS and GCP, and the login
+	// endpoint of Azure. It is the address of the Vault server.
+	Endpoint string `json:"endpoint,omitempty"`
+
+	// AWS credentials are found by the default credential chain
+	Region string `json:"region,omitempty"`
+
+	// Credentials is the JSON of a GCP service account. Application default
+	// credentials are used if it is not set.
+	Credentials string `json:"credentials,omitempty"`
+
+	TenantID     string `json:"tenant_id,omitempty"`
+	ClientID     string `json:"client_id,omitempty"`
+	ClientSecret string `json:"client_secret,omitempty"`
+
+	Token string `json:"token,omitempty"`
+	Mount string `json:"mount,omitempty"`
+
+	// Key is a base64 encoded 32 byte key that the local provider wraps data
+	// keys with. It offers no more protection than a password, and stands in
+	// for a key management service in development and tests.
+	Key string `json:"key,omitempty"`
+}
+
+func New(cfg Config) (KeyWrapper, error) {
+	if cfg.KeyID == "" {
+		return nil, fmt.Errorf("a key_id is required for the %s key management service", cfg.Provider)
+	}
+
+	switch cfg.Provider {
+	case ProviderAWS:
+		return newAWS(cfg)
+	case ProviderGCP:
+		return newGCP(cfg)
+	case ProviderAzure:
+		return newAzure(cfg)
+	case ProviderVaultTransit:
+		return newVaultTransit(cfg)
+	case ProviderLocal:
+		return newLocal(cfg)
+	default:
+		return nil, fmt.Errorf("unknown key management service provider %q, must be one of %v", cfg.Provider, Providers)
+	}
+}
+
+var httpClient = &http.Client{Timeout: 30 * time.Second}
+
+// postJSON posts the body to the URL with the client, decoding the response
+// into result.
+func postJSON(ctx context.Context, client *http.Client, url string, header http.Header, body, result interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s returned %s: %s", req.URL.Host, resp.Status, bytes.TrimSpace(message))
+	}
+
+	return json.NewDecoder(resp.Body).Decode(result)
+}
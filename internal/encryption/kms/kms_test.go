@@ -0,0 +1,242 @@
+package kms_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	b64 "encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+
+	"github.com/cloudfoundry-incubator/cloud-service-broker/internal/encryption/kms"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("KMS", func() {
+	var (
+		server   *httptest.Server
+		requests []*http.Request
+		handler  func(w http.ResponseWriter, r *http.Request, body map[string]interface{})
+	)
+
+	BeforeEach(func() {
+		requests = nil
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer GinkgoRecover()
+			requests = append(requests, r)
+			var body map[string]interface{}
+			if r.Header.Get("Content-Type") != "application/x-www-form-urlencoded" {
+				json.NewDecoder(r.Body).Decode(&body)
+			}
+			handler(w, r, body)
+		}))
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	// roundTrip wraps and unwraps a key, checking that the wrapped form is
+	// different
+	roundTrip := func(wrapper kms.KeyWrapper) {
+		wrapped, err := wrapper.Wrap(context.Background(), []byte("data-key"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(wrapped)).NotTo(ContainSubstring("data-key"))
+
+		unwrapped, err := wrapper.Unwrap(context.Background(), wrapped)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(unwrapped).To(Equal([]byte("data-key")))
+	}
+
+	Describe("New()", func() {
+		It("requires a key", func() {
+			_, err := kms.New(kms.Config{Provider: kms.ProviderAWS})
+			Expect(err).To(MatchError("a key_id is required for the aws key management service"))
+		})
+
+		It("rejects unknown providers", func() {
+			_, err := kms.New(kms.Config{Provider: "hsm", KeyID: "key"})
+			Expect(err).To(MatchError(ContainSubstring(`unknown key management service provider "hsm"`)))
+		})
+	})
+
+	Describe("local", func() {
+		It("wraps and unwraps keys", func() {
+			wrapper, err := kms.New(kms.Config{Provider: kms.ProviderLocal, KeyID: "local", Key: b64.StdEncoding.EncodeToString([]byte("a-local-key-of-exactly-32-bytes!"))})
+			Expect(err).NotTo(HaveOccurred())
+			roundTrip(wrapper)
+		})
+
+		It("requires a 32 byte key", func() {
+			_, err := kms.New(kms.Config{Provider: kms.ProviderLocal, KeyID: "local", Key: b64.StdEncoding.EncodeToString([]byte("short"))})
+			Expect(err).To(MatchError("the key of the local key management service must be 32 bytes, base64 encoded"))
+		})
+	})
+
+	Describe("vault-transit", func() {
+		BeforeEach(func() {
+			handler = func(w http.ResponseWriter, r *http.Request, body map[string]interface{}) {
+				switch r.URL.Path {
+				case "/v1/transit/encrypt/my-key":
+					json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]string{"ciphertext": "vault:v1:" + body["plaintext"].(string)}})
+				case "/v1/transit/decrypt/my-key":
+					json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]string{"plaintext": strings.TrimPrefix(body["ciphertext"].(string), "vault:v1:")}})
+				default:
+					w.WriteHeader(http.StatusNotFound)
+				}
+			}
+		})
+
+		It("wraps and unwraps keys", func() {
+			wrapper, err := kms.New(kms.Config{Provider: kms.ProviderVaultTransit, KeyID: "my-key", Endpoint: server.URL, Token: "vault-token"})
+			Expect(err).NotTo(HaveOccurred())
+			roundTrip(wrapper)
+
+			Expect(requests).To(HaveLen(2))
+			Expect(requests[0].Header.Get("X-Vault-Token")).To(Equal("vault-token"))
+		})
+
+		It("returns errors from vault", func() {
+			wrapper, err := kms.New(kms.Config{Provider: kms.ProviderVaultTransit, KeyID: "other-key", Endpoint: server.URL})
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = wrapper.Wrap(context.Background(), []byte("data-key"))
+			Expect(err).To(MatchError(ContainSubstring("404 Not Found")))
+		})
+
+		It("requires an endpoint", func() {
+			_, err := kms.New(kms.Config{Provider: kms.ProviderVaultTransit, KeyID: "my-key"})
+			Expect(err).To(MatchError("an endpoint is required for the vault-transit key management service"))
+		})
+	})
+
+	Describe("aws", func() {
+		BeforeEach(func() {
+			os.Setenv("AWS_ACCESS_KEY_ID", "access-key")
+			os.Setenv("AWS_SECRET_ACCESS_KEY", "secret-key")
+			handler = func(w http.ResponseWriter, r *http.Request, body map[string]interface{}) {
+				switch r.Header.Get("X-Amz-Target") {
+				case "TrentService.Encrypt":
+					Expect(body["KeyId"]).To(Equal("alias/broker"))
+					json.NewEncoder(w).Encode(map[string]interface{}{"CiphertextBlob": reverse(b64.StdEncoding, body["Plaintext"].(string))})
+				case "TrentService.Decrypt":
+					json.NewEncoder(w).Encode(map[string]interface{}{"Plaintext": reverse(b64.StdEncoding, body["CiphertextBlob"].(string))})
+				default:
+					w.WriteHeader(http.StatusBadRequest)
+				}
+			}
+		})
+
+		AfterEach(func() {
+			os.Unsetenv("AWS_ACCESS_KEY_ID")
+			os.Unsetenv("AWS_SECRET_ACCESS_KEY")
+		})
+
+		It("wraps and unwraps keys", func() {
+			wrapper, err := kms.New(kms.Config{Provider: kms.ProviderAWS, KeyID: "alias/broker", Region: "us-west-2", Endpoint: server.URL})
+			Expect(err).NotTo(HaveOccurred())
+			roundTrip(wrapper)
+		})
+	})
+
+	Describe("gcp", func() {
+		const keyName = "projects/p/locations/global/keyRings/r/cryptoKeys/k"
+
+		BeforeEach(func() {
+			handler = func(w http.ResponseWriter, r *http.Request, body map[string]interface{}) {
+				switch r.URL.Path {
+				case "/token":
+					json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "gcp-token", "token_type": "Bearer", "expires_in": 3600})
+				case "/v1/" + keyName + ":encrypt":
+					json.NewEncoder(w).Encode(map[string]interface{}{"ciphertext": reverse(b64.StdEncoding, body["plaintext"].(string))})
+				case "/v1/" + keyName + ":decrypt":
+					json.NewEncoder(w).Encode(map[string]interface{}{"plaintext": reverse(b64.StdEncoding, body["ciphertext"].(string))})
+				default:
+					w.WriteHeader(http.StatusNotFound)
+				}
+			}
+		})
+
+		It("wraps and unwraps keys with the service account", func() {
+			wrapper, err := kms.New(kms.Config{Provider: kms.ProviderGCP, KeyID: keyName, Endpoint: server.URL, Credentials: serviceAccount(server.URL + "/token")})
+			Expect(err).NotTo(HaveOccurred())
+			roundTrip(wrapper)
+
+			Expect(requests).To(HaveLen(3))
+			Expect(requests[1].Header.Get("Authorization")).To(Equal("Bearer gcp-token"))
+		})
+	})
+
+	Describe("azure", func() {
+		BeforeEach(func() {
+			handler = func(w http.ResponseWriter, r *http.Request, body map[string]interface{}) {
+				switch r.URL.Path {
+				case "/tenant/oauth2/v2.0/token":
+					w.Header().Set("Content-Type", "application/json")
+					json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "azure-token", "token_type": "Bearer", "expires_in": 3600})
+				case "/keys/broker/wrapkey":
+					Expect(body["alg"]).To(Equal("RSA-OAEP-256"))
+					json.NewEncoder(w).Encode(map[string]interface{}{"kid": server.URL + "/keys/broker/v1", "value": reverse(b64.RawURLEncoding, body["value"].(string))})
+				case "/keys/broker/v1/unwrapkey":
+					json.NewEncoder(w).Encode(map[string]interface{}{"kid": server.URL + "/keys/broker/v1", "value": reverse(b64.RawURLEncoding, body["value"].(string))})
+				default:
+					w.WriteHeader(http.StatusNotFound)
+				}
+			}
+		})
+
+		It("wraps and unwraps keys with the version of the key that wrapped them", func() {
+			wrapper, err := kms.New(kms.Config{
+				Provider:     kms.ProviderAzure,
+				KeyID:        server.URL + "/keys/broker",
+				Endpoint:     server.URL,
+				TenantID:     "tenant",
+				ClientID:     "client",
+				ClientSecret: "secret",
+			})
+			Expect(err).NotTo(HaveOccurred())
+			roundTrip(wrapper)
+
+			Expect(requests).To(HaveLen(3))
+			Expect(requests[1].Header.Get("Authorization")).To(Equal("Bearer azure-token"))
+			Expect(requests[2].URL.Query().Get("api-version")).To(Equal("7.3"))
+		})
+
+		It("requires client credentials", func() {
+			_, err := kms.New(kms.Config{Provider: kms.ProviderAzure, KeyID: "https://vault.vault.azure.net/keys/broker"})
+			Expect(err).To(MatchError("a tenant_id, client_id and client_secret are required for the azure key management service"))
+		})
+	})
+})
+
+// reverse stands in for encryption by the fake services, reversing the bytes
+// of an encoded value
+func reverse(encoding *b64.Encoding, s string) string {
+	data, err := encoding.DecodeString(s)
+	Expect(err).NotTo(HaveOccurred())
+	for i, j := 0, len(data)-1; i < j; i, j = i+1, j-1 {
+		data[i], data[j] = data[j], data[i]
+	}
+	return encoding.EncodeToString(data)
+}
+
+func serviceAccount(tokenURI string) string {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	Expect(err).NotTo(HaveOccurred())
+	privateKey := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+
+	data, err := json.Marshal(map[string]string{
+		"type":         "service_account",
+		"client_email": "broker@project.iam.gserviceaccount.com",
+		"private_key":  string(privateKey),
+		"token_uri":    tokenURI,
+	})
+	Expect(err).NotTo(HaveOccurred())
+	return string(data)
+}
@@ -0,0 +1,33 @@
+package kms
+
+import (
+	"context"
+	b64 "encoding/base64"
+	"errors"
+
+	"github.com/cloudfoundry-incubator/cloud-service-broker/internal/encryption/gcmencryptor"
+)
+
+type local struct {
+	encryptor gcmencryptor.GCMEncryptor
+}
+
+func newLocal(cfg Config) (KeyWrapper, error) {
+	decoded, err := b64.StdEncoding.DecodeString(cfg.Key)
+	if err != nil || len(decoded) != 32 {
+		return nil, errors.New("the key of the local key management service must be 32 bytes, base64 encoded")
+	}
+
+	var key [32]byte
+	copy(key[:], decoded)
+	return local{encryptor: gcmencryptor.New(key)}, nil
+}
+
+func (l local) Wrap(_ context.Context, plaintext []byte) ([]byte, error) {
+	wrapped, err := l.encryptor.Encrypt(plaintext)
+	return []byte(wrapped), err
+}
+
+func (l local) Unwrap(_ context.Context, ciphertext []byte) ([]byte, error) {
+	return l.encryptor.Decrypt(string(ciphertext))
+}
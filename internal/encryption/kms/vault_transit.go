@@ -0,0 +1,63 @@
+package kms
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+type vaultTransit struct {
+	url    string
+	key    string
+	header http.Header
+}
+
+func newVaultTransit(cfg Config) (KeyWrapper, error) {
+	if cfg.Endpoint == "" {
+		return nil, errors.New("an endpoint is required for the vault-transit key management service")
+	}
+
+	mount := cfg.Mount
+	if mount == "" {
+		mount = "transit"
+	}
+
+	return vaultTransit{
+		url:    fmt.Sprintf("%s/v1/%s", strings.TrimSuffix(cfg.Endpoint, "/"), strings.Trim(mount, "/")),
+		key:    url.PathEscape(cfg.KeyID),
+		header: http.Header{"X-Vault-Token": {cfg.Token}},
+	}, nil
+}
+
+// Wrap returns the Vault ciphertext, which records the version of the key, so
+// keys can be rotated in Vault.
+func (v vaultTransit) Wrap(ctx context.Context, plaintext []byte) ([]byte, error) {
+	var response struct {
+		Data struct {
+			Ciphertext string `json:"ciphertext"`
+		} `json:"data"`
+	}
+	if err := postJSON(ctx, httpClient, v.path("encrypt"), v.header, map[string][]byte{"plaintext": plaintext}, &response); err != nil {
+		return nil, err
+	}
+	return []byte(response.Data.Ciphertext), nil
+}
+
+func (v vaultTransit) Unwrap(ctx context.Context, ciphertext []byte) ([]byte, error) {
+	var response struct {
+		Data struct {
+			Plaintext []byte `json:"plaintext"`
+		} `json:"data"`
+	}
+	if err := postJSON(ctx, httpClient, v.path("decrypt"), v.header, map[string]string{"ciphertext": string(ciphertext)}, &response); err != nil {
+		return nil, err
+	}
+	return response.Data.Plaintext, nil
+}
+
+func (v vaultTransit) path(operation string) string {
+	return fmt.Sprintf("%s/%s/%s", v.url, operation, v.key)
+}
@@ -6,6 +6,7 @@ import (
 	"github.com/cloudfoundry-incubator/cloud-service-broker/db_service/models"
 	"github.com/cloudfoundry-incubator/cloud-service-broker/internal/encryption"
 	"github.com/cloudfoundry-incubator/cloud-service-broker/internal/encryption/compoundencryptor"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/internal/encryption/envelopeencryptor"
 	"github.com/cloudfoundry-incubator/cloud-service-broker/internal/encryption/gcmencryptor"
 	"github.com/cloudfoundry-incubator/cloud-service-broker/internal/encryption/noopencryptor"
 	. "github.com/onsi/ginkgo"
@@ -191,6 +192,36 @@ var _ = Describe("ParseConfiguration()", func() {
 				})
 			})
 
+			Context("the new primary is a key management service key", func() {
+				It("returns an envelope encryptor and a rotation encryptor", func() {
+					const password = `[{"label":"barfoo","password":{"secret":"averyverygoodpassword"}},{"label":"kmskey","kms":{"provider":"local","key_id":"local","key":"YS1sb2NhbC1rZXktb2YtZXhhY3RseS0zMi1ieXRlcyE="},"primary":true}]`
+
+					config, err := encryption.ParseConfiguration(db, true, password)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(config.Encryptor).To(BeAssignableToTypeOf(envelopeencryptor.EnvelopeEncryptor{}))
+					Expect(config.Changed).To(BeTrue())
+					Expect(config.ConfiguredPrimaryLabel).To(Equal("kmskey"))
+					Expect(config.StoredPrimaryLabel).To(Equal("barfoo"))
+
+					By("being able to decrypt encrypted values with the rotation encryptor")
+					encrypted, err := config.Encryptor.Encrypt([]byte("foo"))
+					Expect(err).NotTo(HaveOccurred())
+					decrypted, err := config.RotationEncryptor.Decrypt(encrypted)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(decrypted).To(Equal([]byte("foo")))
+
+					By("being able use rotation encryptor to decrypt a value encrypted with the stored primary")
+					decrypted, err = config.RotationEncryptor.Decrypt("E2wsRffeAvbMceRmEE5UItxnXrakgztiTtWOJXrzk54Bpm1IwVQgxg==")
+					Expect(err).NotTo(HaveOccurred())
+					Expect(decrypted).To(Equal([]byte("canary value")))
+
+					By("storing the wrapped data key")
+					var stored models.PasswordMetadata
+					Expect(db.Where("label = ?", "kmskey").First(&stored).Error).NotTo(HaveOccurred())
+					Expect(stored.WrappedKey).NotTo(BeEmpty())
+				})
+			})
+
 			Context("previous primary value not supplied", func() {
 				It("returns an error", func() {
 					const password = `[{"label":"supernew","password":{"secret":"supercoolnewpassword"},"primary":true}]`
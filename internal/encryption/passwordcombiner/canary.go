@@ -3,7 +3,7 @@ package passwordcombiner
 import (
 	"fmt"
 
-	"github.com/cloudfoundry-incubator/cloud-service-broker/internal/encryption/gcmencryptor"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/db_service/models"
 )
 
 // CanaryInput is the value that is encrypted with the key and stored in the database
@@ -11,11 +11,11 @@ import (
 // possible to create a rainbow table for this.
 const CanaryInput = "canary value"
 
-func encryptCanary(encryptor gcmencryptor.GCMEncryptor) (string, error) {
+func encryptCanary(encryptor models.Encryptor) (string, error) {
 	return encryptor.Encrypt([]byte(CanaryInput))
 }
 
-func decryptCanary(encryptor gcmencryptor.GCMEncryptor, canary, label string) error {
+func decryptCanary(encryptor models.Encryptor, canary, label string) error {
 	_, err := encryptor.Decrypt(canary)
 	switch {
 	case err == nil:
@@ -1,12 +1,12 @@
 package passwordcombiner
 
-import "github.com/cloudfoundry-incubator/cloud-service-broker/internal/encryption/gcmencryptor"
+import "github.com/cloudfoundry-incubator/cloud-service-broker/db_service/models"
 
 type CombinedPassword struct {
 	Label             string
 	Secret            string
 	Salt              []byte
-	Encryptor         gcmencryptor.GCMEncryptor
+	Encryptor         models.Encryptor
 	configuredPrimary bool
 	storedPrimary     bool
 }
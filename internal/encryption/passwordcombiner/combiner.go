@@ -1,12 +1,15 @@
 package passwordcombiner
 
 import (
+	"context"
 	"crypto/sha256"
 	"errors"
 	"fmt"
 
 	"github.com/cloudfoundry-incubator/cloud-service-broker/db_service/models"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/internal/encryption/envelopeencryptor"
 	"github.com/cloudfoundry-incubator/cloud-service-broker/internal/encryption/gcmencryptor"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/internal/encryption/kms"
 	"github.com/cloudfoundry-incubator/cloud-service-broker/internal/encryption/passwordparser"
 	"golang.org/x/crypto/pbkdf2"
 	"gorm.io/gorm"
@@ -47,23 +50,40 @@ func Combine(db *gorm.DB, parsed []passwordparser.PasswordEntry, storedPassMetad
 }
 
 func saveNewPasswordMetadata(db *gorm.DB, p passwordparser.PasswordEntry) (CombinedPassword, error) {
-	salt, err := randomSalt()
-	if err != nil {
-		return CombinedPassword{}, err
+	var (
+		e          models.Encryptor
+		salt       []byte
+		wrappedKey []byte
+	)
+	switch p.KMS {
+	case nil:
+		var err error
+		salt, err = randomSalt()
+		if err != nil {
+			return CombinedPassword{}, err
+		}
+		e = encryptor(p.Secret, salt)
+	default:
+		envelope, err := newEnvelopeEncryptor(*p.KMS, p.Label)
+		if err != nil {
+			return CombinedPassword{}, err
+		}
+		e = envelope
+		salt = []byte{} // keys are not derived from a password, so are not salted
+		wrappedKey = envelope.WrappedKey()
 	}
 
-	e := encryptor(p.Secret, salt)
-
 	canary, err := encryptCanary(e)
 	if err != nil {
 		return CombinedPassword{}, err
 	}
 
 	err = db.Create(&models.PasswordMetadata{
-		Label:   p.Label,
-		Salt:    salt,
-		Canary:  canary,
-		Primary: false, // Primary updated after successful rotation
+		Label:      p.Label,
+		Salt:       salt,
+		Canary:     canary,
+		WrappedKey: wrappedKey,
+		Primary:    false, // Primary updated after successful rotation
 	}).Error
 	if err != nil {
 		return CombinedPassword{}, err
@@ -79,7 +99,21 @@ func saveNewPasswordMetadata(db *gorm.DB, p passwordparser.PasswordEntry) (Combi
 }
 
 func mergeWithStoredMetadata(s models.PasswordMetadata, p passwordparser.PasswordEntry) (CombinedPassword, error) {
-	e := encryptor(p.Secret, s.Salt)
+	var e models.Encryptor
+	switch {
+	case p.KMS == nil && len(s.WrappedKey) == 0:
+		e = encryptor(p.Secret, s.Salt)
+	case p.KMS != nil && len(s.WrappedKey) != 0:
+		envelope, err := openEnvelopeEncryptor(*p.KMS, p.Label, s.WrappedKey)
+		if err != nil {
+			return CombinedPassword{}, err
+		}
+		e = envelope
+	case p.KMS == nil:
+		return CombinedPassword{}, fmt.Errorf("the password labelled %q was stored as a key management service key, a new label must be used to change it to a password", p.Label)
+	default:
+		return CombinedPassword{}, fmt.Errorf("the password labelled %q was stored as a password, a new label must be used to change it to a key management service key", p.Label)
+	}
 
 	if err := decryptCanary(e, s.Canary, p.Label); err != nil {
 		return CombinedPassword{}, err
@@ -113,6 +147,32 @@ func storedWithPrimary(stored []models.PasswordMetadata) (map[string]models.Pass
 	return result, primary, nil
 }
 
+func newEnvelopeEncryptor(cfg kms.Config, label string) (envelopeencryptor.EnvelopeEncryptor, error) {
+	wrapper, err := kms.New(cfg)
+	if err != nil {
+		return envelopeencryptor.EnvelopeEncryptor{}, fmt.Errorf("key management service for password labelled %q: %w", label, err)
+	}
+
+	e, err := envelopeencryptor.New(context.Background(), wrapper)
+	if err != nil {
+		return envelopeencryptor.EnvelopeEncryptor{}, fmt.Errorf("key management service for password labelled %q: %w", label, err)
+	}
+	return e, nil
+}
+
+func openEnvelopeEncryptor(cfg kms.Config, label string, wrappedKey []byte) (envelopeencryptor.EnvelopeEncryptor, error) {
+	wrapper, err := kms.New(cfg)
+	if err != nil {
+		return envelopeencryptor.EnvelopeEncryptor{}, fmt.Errorf("key management service for password labelled %q: %w", label, err)
+	}
+
+	e, err := envelopeencryptor.Open(context.Background(), wrapper, wrappedKey)
+	if err != nil {
+		return envelopeencryptor.EnvelopeEncryptor{}, fmt.Errorf("key management service for password labelled %q: %w - check that the key has not changed", label, err)
+	}
+	return e, nil
+}
+
 func encryptor(secret string, salt []byte) gcmencryptor.GCMEncryptor {
 	switch {
 	case len(secret) < 20:
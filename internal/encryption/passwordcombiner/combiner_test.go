@@ -1,8 +1,12 @@
 package passwordcombiner_test
 
 import (
+	b64 "encoding/base64"
+
 	"github.com/cloudfoundry-incubator/cloud-service-broker/db_service/models"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/internal/encryption/envelopeencryptor"
 	"github.com/cloudfoundry-incubator/cloud-service-broker/internal/encryption/gcmencryptor"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/internal/encryption/kms"
 	"github.com/cloudfoundry-incubator/cloud-service-broker/internal/encryption/passwordcombiner"
 	"github.com/cloudfoundry-incubator/cloud-service-broker/internal/encryption/passwordparser"
 	. "github.com/onsi/ginkgo"
@@ -300,4 +304,99 @@ var _ = Describe("Combine()", func() {
 			})
 		})
 	})
+
+	Context("key management service keys", func() {
+		var localKMS *kms.Config
+
+		BeforeEach(func() {
+			localKMS = &kms.Config{Provider: kms.ProviderLocal, KeyID: "local", Key: b64.StdEncoding.EncodeToString([]byte("a-local-key-of-exactly-32-bytes!"))}
+		})
+
+		It("stores a new data key wrapped by the key management service", func() {
+			keys := []passwordparser.PasswordEntry{{Label: "kmskey", KMS: localKMS, Primary: true}}
+
+			combined, err := passwordcombiner.Combine(db, keys, []models.PasswordMetadata{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(combined).To(HaveLen(1))
+			Expect(combined[0].Label).To(Equal("kmskey"))
+			Expect(combined[0].Secret).To(BeEmpty())
+			Expect(combined[0].Encryptor).To(BeAssignableToTypeOf(envelopeencryptor.EnvelopeEncryptor{}))
+
+			var stored models.PasswordMetadata
+			Expect(db.First(&stored).Error).NotTo(HaveOccurred())
+			Expect(stored.Label).To(Equal("kmskey"))
+			Expect(stored.Salt).To(BeEmpty())
+			Expect(stored.WrappedKey).NotTo(BeEmpty())
+			Expect(stored.Canary).NotTo(BeEmpty())
+
+			By("opening the stored data key again")
+			reopened, err := passwordcombiner.Combine(db, keys, []models.PasswordMetadata{stored})
+			Expect(err).NotTo(HaveOccurred())
+			encrypted, err := combined[0].Encryptor.Encrypt([]byte("flopsy"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(reopened[0].Encryptor.Decrypt(encrypted)).To(Equal([]byte("flopsy")))
+		})
+
+		It("can be combined with passwords", func() {
+			keys := []passwordparser.PasswordEntry{
+				{Label: "barfoo", Secret: "averyverygoodpassword"},
+				{Label: "kmskey", KMS: localKMS, Primary: true},
+			}
+
+			combined, err := passwordcombiner.Combine(db, keys, []models.PasswordMetadata{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(combined).To(HaveLen(2))
+			Expect(combined[0].Encryptor).To(BeAssignableToTypeOf(gcmencryptor.GCMEncryptor{}))
+
+			primary, ok := combined.ConfiguredPrimary()
+			Expect(ok).To(BeTrue())
+			Expect(primary.Label).To(Equal("kmskey"))
+		})
+
+		When("the key has changed", func() {
+			It("returns an error", func() {
+				keys := []passwordparser.PasswordEntry{{Label: "kmskey", KMS: localKMS}}
+				_, err := passwordcombiner.Combine(db, keys, []models.PasswordMetadata{})
+				Expect(err).NotTo(HaveOccurred())
+				var stored models.PasswordMetadata
+				Expect(db.First(&stored).Error).NotTo(HaveOccurred())
+
+				localKMS.Key = b64.StdEncoding.EncodeToString([]byte("another-local-key-with-32-bytes!"))
+				combined, err := passwordcombiner.Combine(db, keys, []models.PasswordMetadata{stored})
+				Expect(err).To(MatchError(`key management service for password labelled "kmskey": error unwrapping data key: cipher: message authentication failed - check that the key has not changed`))
+				Expect(combined).To(BeEmpty())
+			})
+		})
+
+		When("a password is changed to a key management service key", func() {
+			It("returns an error", func() {
+				storedMetadata := []models.PasswordMetadata{{
+					Label:  "barfoo",
+					Salt:   []byte("random-salt-containing-32-bytes!"),
+					Canary: "E2wsRffeAvbMceRmEE5UItxnXrakgztiTtWOJXrzk54Bpm1IwVQgxg==",
+				}}
+				keys := []passwordparser.PasswordEntry{{Label: "barfoo", KMS: localKMS}}
+
+				combined, err := passwordcombiner.Combine(db, keys, storedMetadata)
+				Expect(err).To(MatchError(`the password labelled "barfoo" was stored as a password, a new label must be used to change it to a key management service key`))
+				Expect(combined).To(BeEmpty())
+			})
+		})
+
+		When("a key management service key is changed to a password", func() {
+			It("returns an error", func() {
+				storedMetadata := []models.PasswordMetadata{{
+					Label:      "kmskey",
+					Salt:       []byte{},
+					Canary:     "canary",
+					WrappedKey: []byte("wrapped"),
+				}}
+				keys := []passwordparser.PasswordEntry{{Label: "kmskey", Secret: "averyverygoodpassword"}}
+
+				combined, err := passwordcombiner.Combine(db, keys, storedMetadata)
+				Expect(err).To(MatchError(`the password labelled "kmskey" was stored as a key management service key, a new label must be used to change it to a password`))
+				Expect(combined).To(BeEmpty())
+			})
+		})
+	})
 })
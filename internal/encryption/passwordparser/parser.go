@@ -4,12 +4,16 @@ import (
 	"encoding/json"
 	"fmt"
 
+	"github.com/cloudfoundry-incubator/cloud-service-broker/internal/encryption/kms"
 	"github.com/cloudfoundry-incubator/cloud-service-broker/pkg/validation"
 )
 
+// PasswordEntry is a password, or a key in a key management service if KMS is
+// set, that the database can be encrypted with.
 type PasswordEntry struct {
 	Label   string
 	Secret  string
+	KMS     *kms.Config
 	Primary bool
 }
 
@@ -42,6 +46,7 @@ type receiver []struct {
 	Password struct {
 		Secret string `json:"secret"`
 	} `json:"password"`
+	KMS *kms.Config `json:"kms"`
 }
 
 func convert(r receiver) []PasswordEntry {
@@ -50,6 +55,7 @@ func convert(r receiver) []PasswordEntry {
 		result = append(result, PasswordEntry{
 			Label:   p.Label,
 			Secret:  p.Password.Secret,
+			KMS:     p.KMS,
 			Primary: p.Primary,
 		})
 	}
@@ -65,10 +71,18 @@ func validate(passwordEntries []PasswordEntry) (errs *validation.FieldError) {
 			primaries++
 		}
 		errs = errs.Also(
-			validation.ErrIfOutsideLength(p.Secret, "secret.password", 20, 1024).ViaIndex(i),
 			validation.ErrIfOutsideLength(p.Label, "label", 5, 20).ViaIndex(i),
 			validation.ErrIfDuplicate(p.Label, "label", labels).ViaIndex(i),
 		)
+
+		switch {
+		case p.KMS == nil:
+			errs = errs.Also(validation.ErrIfOutsideLength(p.Secret, "secret.password", 20, 1024).ViaIndex(i))
+		case p.Secret != "":
+			errs = errs.Also(validation.ErrMultipleOneOf("password", "kms").ViaIndex(i))
+		default:
+			errs = errs.Also(validateKMS(*p.KMS).ViaField("kms").ViaIndex(i))
+		}
 	}
 
 	switch primaries {
@@ -81,3 +95,17 @@ func validate(passwordEntries []PasswordEntry) (errs *validation.FieldError) {
 		})
 	}
 }
+
+func validateKMS(cfg kms.Config) (errs *validation.FieldError) {
+	errs = errs.Also(validation.ErrIfBlank(cfg.KeyID, "key_id"))
+	for _, p := range kms.Providers {
+		if cfg.Provider == p {
+			return errs
+		}
+	}
+
+	return errs.Also(&validation.FieldError{
+		Message: fmt.Sprintf("expected one of %v, got %q", kms.Providers, cfg.Provider),
+		Paths:   []string{"provider"},
+	})
+}
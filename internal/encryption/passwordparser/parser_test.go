@@ -3,6 +3,7 @@ package passwordparser_test
 import (
 	"strings"
 
+	"github.com/cloudfoundry-incubator/cloud-service-broker/internal/encryption/kms"
 	"github.com/cloudfoundry-incubator/cloud-service-broker/internal/encryption/passwordparser"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/ginkgo/extensions/table"
@@ -81,6 +82,25 @@ var _ = Describe("Parser", func() {
 				},
 			},
 		),
+		Entry(
+			"key management service key",
+			`[{"label":"barfoo","password":{"secret":"veryverysecretpassword"}},{"label":"bazquz","kms":{"provider":"vault-transit","key_id":"broker","endpoint":"https://vault.example.com"},"primary":true}]`,
+			[]passwordparser.PasswordEntry{
+				{
+					Label:  "barfoo",
+					Secret: "veryverysecretpassword",
+				},
+				{
+					Label: "bazquz",
+					KMS: &kms.Config{
+						Provider: "vault-transit",
+						KeyID:    "broker",
+						Endpoint: "https://vault.example.com",
+					},
+					Primary: true,
+				},
+			},
+		),
 		Entry(
 			"no primary",
 			`[{"label":"barfoo","password":{"secret":"veryverysecretpassword"},"primary":false},{"label":"barbaz","password":{"secret":"anotherveryverysecretpassword"}},{"label":"bazquz","password":{"secret":"yetanotherveryverysecretpassword"},"primary":false}]`,
@@ -141,6 +161,21 @@ var _ = Describe("Parser", func() {
 			`[{"label":"barfoo","password":{"secret":"veryverysecretpassword"},"primary":false},{"label":"barbaz","password":{"secret":"anotherveryverysecretpassword"}},{"label":"barfoo","password":{"secret":"yetanotherveryverysecretpassword"},"primary":true}]`,
 			`password configuration error: duplicated value, must be unique: barfoo: [2].label`,
 		),
+		Entry(
+			"password and key management service key",
+			`[{"label":"barfoo","password":{"secret":"veryverysecretpassword"},"kms":{"provider":"aws","key_id":"alias/broker"},"primary":true}]`,
+			`password configuration error: expected exactly one, got both: [0].kms, [0].password`,
+		),
+		Entry(
+			"unknown key management service",
+			`[{"label":"barfoo","kms":{"provider":"hsm","key_id":"broker"},"primary":true}]`,
+			`password configuration error: expected one of [aws gcp azure vault-transit local], got "hsm": [0].kms.provider`,
+		),
+		Entry(
+			"key management service key without key_id",
+			`[{"label":"barfoo","kms":{"provider":"aws"},"primary":true}]`,
+			`password configuration error: missing field(s): [0].kms.key_id`,
+		),
 		Entry(
 			"multiple primaries",
 			`[{"label":"barfoo","password":{"secret":"veryverysecretpassword"},"primary":true},{"label":"barbaz","password":{"secret":"anotherveryverysecretpassword"}},{"label":"bazquz","password":{"secret":"yetanotherveryverysecretpassword"},"primary":true}]`,
@@ -0,0 +1,265 @@
+// Copyright 2021 the Service Broker Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package audit records the state-changing requests made to the broker, and
+// who made them, for compliance.
+package audit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/cloudfoundry-incubator/cloud-service-broker/db_service"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/db_service/models"
+)
+
+// Outcomes of audited requests.
+const (
+	Succeeded = "succeeded"
+	Failed    = "failed"
+	// Accepted is the outcome of asynchronous requests that were started
+	// successfully. Their final outcome is found by polling the last operation.
+	Accepted = "accepted"
+)
+
+// Sink records audit events.
+type Sink interface {
+	Record(ctx context.Context, event models.AuditEvent) error
+}
+
+// maxAppendAttempts is how many times DatabaseSink tries to append an event to
+// the chain when other brokers append events at the same time.
+const maxAppendAttempts = 5
+
+// DatabaseSink appends audit events to the audit_events table. Each event is
+// chained to the one before it by its hash, so that changing or deleting events
+// can be detected by Verify. The parameters are encrypted, and hashed before
+// they are.
+type DatabaseSink struct{}
+
+var _ Sink = DatabaseSink{}
+
+// Record implements Sink.
+func (DatabaseSink) Record(ctx context.Context, event models.AuditEvent) error {
+	// the times are stored to the millisecond, so they are hashed as stored
+	event.StartedAt = event.StartedAt.Truncate(time.Millisecond)
+	event.FinishedAt = event.FinishedAt.Truncate(time.Millisecond)
+
+	parameters := event.Parameters
+	var err error
+	for attempt := 0; attempt < maxAppendAttempts; attempt++ {
+		var last *models.AuditEvent
+		last, err = db_service.GetLastAuditEvent(ctx)
+		if err != nil {
+			return err
+		}
+
+		prevHash := ""
+		if last != nil {
+			prevHash = last.Hash
+		}
+		event.ID = 0
+		event.PrevHash = &prevHash
+		event.Parameters = parameters
+		event.Hash = Hash(event)
+		if err := event.SetParameters(parameters); err != nil {
+			return err
+		}
+
+		err = db_service.CreateAuditEvent(ctx, &event)
+		if err == nil {
+			return nil
+		}
+
+		// the hash of the last event is unique as a PrevHash, so appending
+		// fails if another event was appended after it, and is tried again
+		latest, latestErr := db_service.GetLastAuditEvent(ctx)
+		if latestErr != nil || latest == nil || (last != nil && latest.ID == last.ID) {
+			return err
+		}
+	}
+
+	return err
+}
+
+// Hash gets the hash that chains the event to the one before it, the SHA-256
+// of its PrevHash and its fields other than the ID and Hash.
+func Hash(event models.AuditEvent) string {
+	prevHash := ""
+	if event.PrevHash != nil {
+		prevHash = *event.PrevHash
+	}
+
+	// an array of strings always encodes
+	fields, _ := json.Marshal([]string{
+		prevHash,
+		event.Operation,
+		event.ServiceInstanceId,
+		event.BindingId,
+		event.ServiceId,
+		event.PlanId,
+		event.RequestId,
+		event.CorrelationId,
+		event.OriginatingIdentity,
+		event.Parameters,
+		event.Outcome,
+		event.Message,
+		event.StartedAt.UTC().Format(time.RFC3339Nano),
+		event.FinishedAt.UTC().Format(time.RFC3339Nano),
+	})
+	sum := sha256.Sum256(fields)
+	return hex.EncodeToString(sum[:])
+}
+
+// Verify checks the hash chain of events, given newest first as
+// db_service.GetAuditEvents gets them, with their parameters decrypted. Each
+// event is checked against its Hash and, if the events are consecutive,
+// against the Hash of the event before it, so that changed, inserted and
+// deleted events are found. Events recorded before the chain was introduced
+// cannot be checked, but must come before all the others.
+func Verify(events []models.AuditEvent, consecutive bool) error {
+	for i, event := range events {
+		var older *models.AuditEvent
+		if i+1 < len(events) {
+			older = &events[i+1]
+		}
+
+		if event.PrevHash == nil && event.Hash == "" {
+			if older != nil && older.PrevHash != nil {
+				return fmt.Errorf("audit event %d is not chained, but was recorded after chained events", event.ID)
+			}
+			continue
+		}
+
+		if event.PrevHash == nil || event.Hash != Hash(event) {
+			return fmt.Errorf("audit event %d has been changed since it was recorded", event.ID)
+		}
+
+		if consecutive && older != nil && *event.PrevHash != older.Hash {
+			return fmt.Errorf("audit event %d does not follow audit event %d, events between them have been deleted or inserted", event.ID, older.ID)
+		}
+	}
+
+	return nil
+}
+
+// FileSink appends audit events to a file, one JSON object per line.
+type FileSink struct {
+	mutex sync.Mutex
+	file  *os.File
+}
+
+var _ Sink = (*FileSink)(nil)
+
+// NewFileSink opens the file at path for appending, creating it if it does
+// not exist.
+func NewFileSink(path string) (*FileSink, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("error opening audit log file: %w", err)
+	}
+
+	return &FileSink{file: file}, nil
+}
+
+// Record implements Sink.
+func (s *FileSink) Record(_ context.Context, event models.AuditEvent) error {
+	line, err := Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	_, err = s.file.Write(append(line, '\n'))
+	return err
+}
+
+// Close closes the file.
+func (s *FileSink) Close() error {
+	return s.file.Close()
+}
+
+type entry struct {
+	ID                  uint            `json:"id,omitempty"`
+	Operation           string          `json:"operation"`
+	ServiceInstanceID   string          `json:"service_instance_id"`
+	BindingID           string          `json:"binding_id,omitempty"`
+	ServiceID           string          `json:"service_id"`
+	PlanID              string          `json:"plan_id"`
+	RequestID           string          `json:"request_id,omitempty"`
+	CorrelationID       string          `json:"correlation_id,omitempty"`
+	OriginatingIdentity json.RawMessage `json:"originating_identity,omitempty"`
+	Parameters          json.RawMessage `json:"parameters,omitempty"`
+	Outcome             string          `json:"outcome"`
+	Message             string          `json:"message,omitempty"`
+	StartedAt           time.Time       `json:"started_at"`
+	FinishedAt          time.Time       `json:"finished_at"`
+	PrevHash            *string         `json:"prev_hash,omitempty"`
+	Hash                string          `json:"hash,omitempty"`
+}
+
+// Marshal encodes the event as a single line of JSON.
+func Marshal(event models.AuditEvent) ([]byte, error) {
+	return json.Marshal(entry{
+		ID:                  event.ID,
+		Operation:           event.Operation,
+		ServiceInstanceID:   event.ServiceInstanceId,
+		BindingID:           event.BindingId,
+		ServiceID:           event.ServiceId,
+		PlanID:              event.PlanId,
+		RequestID:           event.RequestId,
+		CorrelationID:       event.CorrelationId,
+		OriginatingIdentity: rawJSON(event.OriginatingIdentity),
+		Parameters:          rawJSON(event.Parameters),
+		Outcome:             event.Outcome,
+		Message:             event.Message,
+		StartedAt:           event.StartedAt.UTC(),
+		FinishedAt:          event.FinishedAt.UTC(),
+		PrevHash:            event.PrevHash,
+		Hash:                event.Hash,
+	})
+}
+
+// User returns the user who made the request, as given in the originating
+// identity, or an empty string if it is not known.
+func User(event models.AuditEvent) string {
+	var identity struct {
+		Platform string `json:"platform"`
+		Value    struct {
+			UserID string `json:"user_id"`
+		} `json:"value"`
+	}
+	if err := json.Unmarshal([]byte(event.OriginatingIdentity), &identity); err != nil {
+		return ""
+	}
+
+	if identity.Value.UserID == "" {
+		return identity.Platform
+	}
+	return identity.Value.UserID
+}
+
+func rawJSON(value string) json.RawMessage {
+	if value == "" || !json.Valid([]byte(value)) {
+		return nil
+	}
+	return json.RawMessage(value)
+}
@@ -0,0 +1,223 @@
+// Copyright 2021 the Service Broker Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package audit
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloudfoundry-incubator/cloud-service-broker/db_service"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/db_service/models"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/internal/encryption/gcmencryptor"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestFileSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	if err := os.WriteFile(path, []byte("{\"existing\":true}\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	sink, err := NewFileSink(path)
+	if err != nil {
+		t.Fatalf("Expected no error opening the sink, got %v", err)
+	}
+
+	started := time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC)
+	events := []models.AuditEvent{
+		{
+			Operation:           "provision",
+			ServiceInstanceId:   "instance",
+			ServiceId:           "service",
+			PlanId:              "plan",
+			RequestId:           "request-id",
+			OriginatingIdentity: `{"platform":"cloudfoundry","value":{"user_id":"user-guid"}}`,
+			Parameters:          `{"password":"[REDACTED]"}`,
+			Outcome:             Accepted,
+			StartedAt:           started,
+			FinishedAt:          started.Add(time.Second),
+		},
+		{
+			Operation:         "unbind",
+			ServiceInstanceId: "instance",
+			BindingId:         "binding",
+			ServiceId:         "service",
+			PlanId:            "plan",
+			Outcome:           Failed,
+			Message:           "unbind failed",
+			StartedAt:         started,
+			FinishedAt:        started,
+		},
+	}
+	for _, event := range events {
+		if err := sink.Record(context.Background(), event); err != nil {
+			t.Fatalf("Expected no error recording an event, got %v", err)
+		}
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		`{"existing":true}`,
+		`{"operation":"provision","service_instance_id":"instance","service_id":"service","plan_id":"plan","request_id":"request-id","originating_identity":{"platform":"cloudfoundry","value":{"user_id":"user-guid"}},"parameters":{"password":"[REDACTED]"},"outcome":"accepted","started_at":"2021-10-01T12:00:00Z","finished_at":"2021-10-01T12:00:01Z"}`,
+		`{"operation":"unbind","service_instance_id":"instance","binding_id":"binding","service_id":"service","plan_id":"plan","outcome":"failed","message":"unbind failed","started_at":"2021-10-01T12:00:00Z","finished_at":"2021-10-01T12:00:00Z"}`,
+	}
+	if actual := strings.Split(strings.TrimSuffix(string(contents), "\n"), "\n"); strings.Join(actual, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected the events to be appended one per line, got:\n%s", contents)
+	}
+}
+
+func TestUser(t *testing.T) {
+	cases := map[string]struct {
+		Identity string
+		Expected string
+	}{
+		"cloud foundry user": {Identity: `{"platform":"cloudfoundry","value":{"user_id":"user-guid"}}`, Expected: "user-guid"},
+		"other platform":     {Identity: `{"platform":"kubernetes","value":{"username":"admin"}}`, Expected: "kubernetes"},
+		"no identity":        {Identity: "", Expected: ""},
+	}
+
+	for tn, tc := range cases {
+		t.Run(tn, func(t *testing.T) {
+			if actual := User(models.AuditEvent{OriginatingIdentity: tc.Identity}); actual != tc.Expected {
+				t.Errorf("Expected %q, got %q", tc.Expected, actual)
+			}
+		})
+	}
+}
+
+func TestDatabaseSink(t *testing.T) {
+	cases := map[string]struct {
+		Tamper   func(db *gorm.DB) error
+		Expected string
+	}{
+		"untouched": {
+			Tamper: func(db *gorm.DB) error { return nil },
+		},
+		"changed": {
+			Tamper: func(db *gorm.DB) error {
+				return db.Exec("UPDATE audit_events SET outcome = ? WHERE id = ?", Succeeded, 3).Error
+			},
+			Expected: "audit event 3 has been changed since it was recorded",
+		},
+		"deleted": {
+			Tamper: func(db *gorm.DB) error {
+				return db.Exec("DELETE FROM audit_events WHERE id = ?", 3).Error
+			},
+			Expected: "audit event 4 does not follow audit event 2, events between them have been deleted or inserted",
+		},
+		"unchained": {
+			Tamper: func(db *gorm.DB) error {
+				return db.Exec("UPDATE audit_events SET prev_hash = NULL, hash = '' WHERE id = ?", 4).Error
+			},
+			Expected: "audit event 4 is not chained, but was recorded after chained events",
+		},
+	}
+
+	for tn, tc := range cases {
+		t.Run(tn, func(t *testing.T) {
+			db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			sqlDB, err := db.DB()
+			if err != nil {
+				t.Fatal(err)
+			}
+			sqlDB.SetMaxOpenConns(1)
+			if err := db.Migrator().CreateTable(models.AuditEvent{}); err != nil {
+				t.Fatal(err)
+			}
+			db_service.DbConnection = db
+			models.SetEncryptor(gcmencryptor.New([32]byte{1}))
+
+			// an event recorded before the chain was introduced
+			if err := db.Create(&models.AuditEvent{Operation: "provision", ServiceInstanceId: "instance", Parameters: `{"name":"db"}`, Outcome: Succeeded}).Error; err != nil {
+				t.Fatal(err)
+			}
+
+			started := time.Now()
+			for _, outcome := range []string{Accepted, Failed, Succeeded} {
+				event := models.AuditEvent{
+					Operation:         "update",
+					ServiceInstanceId: "instance",
+					Parameters:        `{"name":"db"}`,
+					Outcome:           outcome,
+					StartedAt:         started,
+					FinishedAt:        started.Add(time.Second),
+				}
+				if err := (DatabaseSink{}).Record(context.Background(), event); err != nil {
+					t.Fatalf("Expected no error recording an event, got %v", err)
+				}
+			}
+
+			if err := tc.Tamper(db); err != nil {
+				t.Fatal(err)
+			}
+
+			events, err := db_service.GetAuditEvents(context.Background(), "", 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := range events {
+				if events[i].ID > 1 && strings.Contains(events[i].Parameters, `"db"`) {
+					t.Errorf("Expected the parameters of event %d to be encrypted, got %q", events[i].ID, events[i].Parameters)
+				}
+				if events[i].Parameters, err = events[i].GetParameters(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			switch err := Verify(events, true); {
+			case tc.Expected == "" && err != nil:
+				t.Errorf("Expected the chain to be intact, got %v", err)
+			case tc.Expected != "" && (err == nil || err.Error() != tc.Expected):
+				t.Errorf("Expected error %q, got %v", tc.Expected, err)
+			}
+		})
+	}
+}
+
+func TestVerify_NotConsecutive(t *testing.T) {
+	first, second, third := "", "", ""
+	events := []models.AuditEvent{
+		{ID: 3, Operation: "bind", PrevHash: &second},
+		{ID: 1, Operation: "provision", PrevHash: &first},
+	}
+	events[1].Hash = Hash(events[1])
+	second = "hash of event 2"
+	events[0].Hash = Hash(events[0])
+
+	if err := Verify(events, false); err != nil {
+		t.Errorf("Expected events that are not consecutive to be checked by their own hashes, got %v", err)
+	}
+	if err := Verify(events, true); err == nil {
+		t.Error("Expected consecutive events to be checked against each other")
+	}
+
+	events[0].PrevHash = &third
+	if err := Verify(events, false); err == nil {
+		t.Error("Expected a changed event to fail")
+	}
+}
@@ -4,6 +4,7 @@ package brokerfakes
 import (
 	"context"
 	"sync"
+	"time"
 
 	"github.com/cloudfoundry-incubator/cloud-service-broker/db_service/models"
 	"github.com/cloudfoundry-incubator/cloud-service-broker/pkg/broker"
@@ -26,6 +27,45 @@ type FakeServiceProvider struct {
 		result1 map[string]interface{}
 		result2 error
 	}
+	BindAsyncStub        func(context.Context, *varcontext.VarContext) (string, error)
+	bindAsyncMutex       sync.RWMutex
+	bindAsyncArgsForCall []struct {
+		arg1 context.Context
+		arg2 *varcontext.VarContext
+	}
+	bindAsyncReturns struct {
+		result1 string
+		result2 error
+	}
+	bindAsyncReturnsOnCall map[int]struct {
+		result1 string
+		result2 error
+	}
+	BindingOutputsStub        func(context.Context, string, string) (map[string]interface{}, error)
+	bindingOutputsMutex       sync.RWMutex
+	bindingOutputsArgsForCall []struct {
+		arg1 context.Context
+		arg2 string
+		arg3 string
+	}
+	bindingOutputsReturns struct {
+		result1 map[string]interface{}
+		result2 error
+	}
+	bindingOutputsReturnsOnCall map[int]struct {
+		result1 map[string]interface{}
+		result2 error
+	}
+	BindsAsyncStub        func() bool
+	bindsAsyncMutex       sync.RWMutex
+	bindsAsyncArgsForCall []struct {
+	}
+	bindsAsyncReturns struct {
+		result1 bool
+	}
+	bindsAsyncReturnsOnCall map[int]struct {
+		result1 bool
+	}
 	BuildInstanceCredentialsStub        func(context.Context, models.ServiceBindingCredentials, models.ServiceInstanceDetails) (*domain.Binding, error)
 	buildInstanceCredentialsMutex       sync.RWMutex
 	buildInstanceCredentialsArgsForCall []struct {
@@ -67,6 +107,23 @@ type FakeServiceProvider struct {
 	deprovisionsAsyncReturnsOnCall map[int]struct {
 		result1 bool
 	}
+	PollBindingStub        func(context.Context, string, string) (bool, string, error)
+	pollBindingMutex       sync.RWMutex
+	pollBindingArgsForCall []struct {
+		arg1 context.Context
+		arg2 string
+		arg3 string
+	}
+	pollBindingReturns struct {
+		result1 bool
+		result2 string
+		result3 error
+	}
+	pollBindingReturnsOnCall map[int]struct {
+		result1 bool
+		result2 string
+		result3 error
+	}
 	PollInstanceStub        func(context.Context, models.ServiceInstanceDetails) (bool, string, error)
 	pollInstanceMutex       sync.RWMutex
 	pollInstanceArgsForCall []struct {
@@ -83,6 +140,20 @@ type FakeServiceProvider struct {
 		result2 string
 		result3 error
 	}
+	PreviewUpdateStub        func(context.Context, *varcontext.VarContext) (models.ServiceInstanceDetails, error)
+	previewUpdateMutex       sync.RWMutex
+	previewUpdateArgsForCall []struct {
+		arg1 context.Context
+		arg2 *varcontext.VarContext
+	}
+	previewUpdateReturns struct {
+		result1 models.ServiceInstanceDetails
+		result2 error
+	}
+	previewUpdateReturnsOnCall map[int]struct {
+		result1 models.ServiceInstanceDetails
+		result2 error
+	}
 	ProvisionStub        func(context.Context, *varcontext.VarContext) (models.ServiceInstanceDetails, error)
 	provisionMutex       sync.RWMutex
 	provisionArgsForCall []struct {
@@ -107,6 +178,22 @@ type FakeServiceProvider struct {
 	provisionsAsyncReturnsOnCall map[int]struct {
 		result1 bool
 	}
+	RotateBindingCredentialsStub        func(context.Context, models.ServiceInstanceDetails, models.ServiceBindingCredentials, time.Duration) (map[string]interface{}, error)
+	rotateBindingCredentialsMutex       sync.RWMutex
+	rotateBindingCredentialsArgsForCall []struct {
+		arg1 context.Context
+		arg2 models.ServiceInstanceDetails
+		arg3 models.ServiceBindingCredentials
+		arg4 time.Duration
+	}
+	rotateBindingCredentialsReturns struct {
+		result1 map[string]interface{}
+		result2 error
+	}
+	rotateBindingCredentialsReturnsOnCall map[int]struct {
+		result1 map[string]interface{}
+		result2 error
+	}
 	UnbindStub        func(context.Context, models.ServiceInstanceDetails, models.ServiceBindingCredentials, *varcontext.VarContext) error
 	unbindMutex       sync.RWMutex
 	unbindArgsForCall []struct {
@@ -121,6 +208,22 @@ type FakeServiceProvider struct {
 	unbindReturnsOnCall map[int]struct {
 		result1 error
 	}
+	UnbindAsyncStub        func(context.Context, models.ServiceInstanceDetails, models.ServiceBindingCredentials, *varcontext.VarContext) (string, error)
+	unbindAsyncMutex       sync.RWMutex
+	unbindAsyncArgsForCall []struct {
+		arg1 context.Context
+		arg2 models.ServiceInstanceDetails
+		arg3 models.ServiceBindingCredentials
+		arg4 *varcontext.VarContext
+	}
+	unbindAsyncReturns struct {
+		result1 string
+		result2 error
+	}
+	unbindAsyncReturnsOnCall map[int]struct {
+		result1 string
+		result2 error
+	}
 	UpdateStub        func(context.Context, *varcontext.VarContext) (models.ServiceInstanceDetails, error)
 	updateMutex       sync.RWMutex
 	updateArgsForCall []struct {
@@ -147,6 +250,20 @@ type FakeServiceProvider struct {
 	updateInstanceDetailsReturnsOnCall map[int]struct {
 		result1 error
 	}
+	UpgradeStub        func(context.Context, *varcontext.VarContext) (models.ServiceInstanceDetails, error)
+	upgradeMutex       sync.RWMutex
+	upgradeArgsForCall []struct {
+		arg1 context.Context
+		arg2 *varcontext.VarContext
+	}
+	upgradeReturns struct {
+		result1 models.ServiceInstanceDetails
+		result2 error
+	}
+	upgradeReturnsOnCall map[int]struct {
+		result1 models.ServiceInstanceDetails
+		result2 error
+	}
 	invocations      map[string][][]interface{}
 	invocationsMutex sync.RWMutex
 }
@@ -216,6 +333,190 @@ func (fake *FakeServiceProvider) BindReturnsOnCall(i int, result1 map[string]int
 	}{result1, result2}
 }
 
+func (fake *FakeServiceProvider) BindAsync(arg1 context.Context, arg2 *varcontext.VarContext) (string, error) {
+	fake.bindAsyncMutex.Lock()
+	ret, specificReturn := fake.bindAsyncReturnsOnCall[len(fake.bindAsyncArgsForCall)]
+	fake.bindAsyncArgsForCall = append(fake.bindAsyncArgsForCall, struct {
+		arg1 context.Context
+		arg2 *varcontext.VarContext
+	}{arg1, arg2})
+	stub := fake.BindAsyncStub
+	fakeReturns := fake.bindAsyncReturns
+	fake.recordInvocation("BindAsync", []interface{}{arg1, arg2})
+	fake.bindAsyncMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fakeReturns.result1, fakeReturns.result2
+}
+
+func (fake *FakeServiceProvider) BindAsyncCallCount() int {
+	fake.bindAsyncMutex.RLock()
+	defer fake.bindAsyncMutex.RUnlock()
+	return len(fake.bindAsyncArgsForCall)
+}
+
+func (fake *FakeServiceProvider) BindAsyncCalls(stub func(context.Context, *varcontext.VarContext) (string, error)) {
+	fake.bindAsyncMutex.Lock()
+	defer fake.bindAsyncMutex.Unlock()
+	fake.BindAsyncStub = stub
+}
+
+func (fake *FakeServiceProvider) BindAsyncArgsForCall(i int) (context.Context, *varcontext.VarContext) {
+	fake.bindAsyncMutex.RLock()
+	defer fake.bindAsyncMutex.RUnlock()
+	argsForCall := fake.bindAsyncArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2
+}
+
+func (fake *FakeServiceProvider) BindAsyncReturns(result1 string, result2 error) {
+	fake.bindAsyncMutex.Lock()
+	defer fake.bindAsyncMutex.Unlock()
+	fake.BindAsyncStub = nil
+	fake.bindAsyncReturns = struct {
+		result1 string
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeServiceProvider) BindAsyncReturnsOnCall(i int, result1 string, result2 error) {
+	fake.bindAsyncMutex.Lock()
+	defer fake.bindAsyncMutex.Unlock()
+	fake.BindAsyncStub = nil
+	if fake.bindAsyncReturnsOnCall == nil {
+		fake.bindAsyncReturnsOnCall = make(map[int]struct {
+			result1 string
+			result2 error
+		})
+	}
+	fake.bindAsyncReturnsOnCall[i] = struct {
+		result1 string
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeServiceProvider) BindingOutputs(arg1 context.Context, arg2 string, arg3 string) (map[string]interface{}, error) {
+	fake.bindingOutputsMutex.Lock()
+	ret, specificReturn := fake.bindingOutputsReturnsOnCall[len(fake.bindingOutputsArgsForCall)]
+	fake.bindingOutputsArgsForCall = append(fake.bindingOutputsArgsForCall, struct {
+		arg1 context.Context
+		arg2 string
+		arg3 string
+	}{arg1, arg2, arg3})
+	stub := fake.BindingOutputsStub
+	fakeReturns := fake.bindingOutputsReturns
+	fake.recordInvocation("BindingOutputs", []interface{}{arg1, arg2, arg3})
+	fake.bindingOutputsMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2, arg3)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fakeReturns.result1, fakeReturns.result2
+}
+
+func (fake *FakeServiceProvider) BindingOutputsCallCount() int {
+	fake.bindingOutputsMutex.RLock()
+	defer fake.bindingOutputsMutex.RUnlock()
+	return len(fake.bindingOutputsArgsForCall)
+}
+
+func (fake *FakeServiceProvider) BindingOutputsCalls(stub func(context.Context, string, string) (map[string]interface{}, error)) {
+	fake.bindingOutputsMutex.Lock()
+	defer fake.bindingOutputsMutex.Unlock()
+	fake.BindingOutputsStub = stub
+}
+
+func (fake *FakeServiceProvider) BindingOutputsArgsForCall(i int) (context.Context, string, string) {
+	fake.bindingOutputsMutex.RLock()
+	defer fake.bindingOutputsMutex.RUnlock()
+	argsForCall := fake.bindingOutputsArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2, argsForCall.arg3
+}
+
+func (fake *FakeServiceProvider) BindingOutputsReturns(result1 map[string]interface{}, result2 error) {
+	fake.bindingOutputsMutex.Lock()
+	defer fake.bindingOutputsMutex.Unlock()
+	fake.BindingOutputsStub = nil
+	fake.bindingOutputsReturns = struct {
+		result1 map[string]interface{}
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeServiceProvider) BindingOutputsReturnsOnCall(i int, result1 map[string]interface{}, result2 error) {
+	fake.bindingOutputsMutex.Lock()
+	defer fake.bindingOutputsMutex.Unlock()
+	fake.BindingOutputsStub = nil
+	if fake.bindingOutputsReturnsOnCall == nil {
+		fake.bindingOutputsReturnsOnCall = make(map[int]struct {
+			result1 map[string]interface{}
+			result2 error
+		})
+	}
+	fake.bindingOutputsReturnsOnCall[i] = struct {
+		result1 map[string]interface{}
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeServiceProvider) BindsAsync() bool {
+	fake.bindsAsyncMutex.Lock()
+	ret, specificReturn := fake.bindsAsyncReturnsOnCall[len(fake.bindsAsyncArgsForCall)]
+	fake.bindsAsyncArgsForCall = append(fake.bindsAsyncArgsForCall, struct {
+	}{})
+	stub := fake.BindsAsyncStub
+	fakeReturns := fake.bindsAsyncReturns
+	fake.recordInvocation("BindsAsync", []interface{}{})
+	fake.bindsAsyncMutex.Unlock()
+	if stub != nil {
+		return stub()
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	return fakeReturns.result1
+}
+
+func (fake *FakeServiceProvider) BindsAsyncCallCount() int {
+	fake.bindsAsyncMutex.RLock()
+	defer fake.bindsAsyncMutex.RUnlock()
+	return len(fake.bindsAsyncArgsForCall)
+}
+
+func (fake *FakeServiceProvider) BindsAsyncCalls(stub func() bool) {
+	fake.bindsAsyncMutex.Lock()
+	defer fake.bindsAsyncMutex.Unlock()
+	fake.BindsAsyncStub = stub
+}
+
+func (fake *FakeServiceProvider) BindsAsyncReturns(result1 bool) {
+	fake.bindsAsyncMutex.Lock()
+	defer fake.bindsAsyncMutex.Unlock()
+	fake.BindsAsyncStub = nil
+	fake.bindsAsyncReturns = struct {
+		result1 bool
+	}{result1}
+}
+
+func (fake *FakeServiceProvider) BindsAsyncReturnsOnCall(i int, result1 bool) {
+	fake.bindsAsyncMutex.Lock()
+	defer fake.bindsAsyncMutex.Unlock()
+	fake.BindsAsyncStub = nil
+	if fake.bindsAsyncReturnsOnCall == nil {
+		fake.bindsAsyncReturnsOnCall = make(map[int]struct {
+			result1 bool
+		})
+	}
+	fake.bindsAsyncReturnsOnCall[i] = struct {
+		result1 bool
+	}{result1}
+}
+
 func (fake *FakeServiceProvider) BuildInstanceCredentials(arg1 context.Context, arg2 models.ServiceBindingCredentials, arg3 models.ServiceInstanceDetails) (*domain.Binding, error) {
 	fake.buildInstanceCredentialsMutex.Lock()
 	ret, specificReturn := fake.buildInstanceCredentialsReturnsOnCall[len(fake.buildInstanceCredentialsArgsForCall)]
@@ -402,6 +703,75 @@ func (fake *FakeServiceProvider) DeprovisionsAsyncReturnsOnCall(i int, result1 b
 	}{result1}
 }
 
+func (fake *FakeServiceProvider) PollBinding(arg1 context.Context, arg2 string, arg3 string) (bool, string, error) {
+	fake.pollBindingMutex.Lock()
+	ret, specificReturn := fake.pollBindingReturnsOnCall[len(fake.pollBindingArgsForCall)]
+	fake.pollBindingArgsForCall = append(fake.pollBindingArgsForCall, struct {
+		arg1 context.Context
+		arg2 string
+		arg3 string
+	}{arg1, arg2, arg3})
+	stub := fake.PollBindingStub
+	fakeReturns := fake.pollBindingReturns
+	fake.recordInvocation("PollBinding", []interface{}{arg1, arg2, arg3})
+	fake.pollBindingMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2, arg3)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2, ret.result3
+	}
+	return fakeReturns.result1, fakeReturns.result2, fakeReturns.result3
+}
+
+func (fake *FakeServiceProvider) PollBindingCallCount() int {
+	fake.pollBindingMutex.RLock()
+	defer fake.pollBindingMutex.RUnlock()
+	return len(fake.pollBindingArgsForCall)
+}
+
+func (fake *FakeServiceProvider) PollBindingCalls(stub func(context.Context, string, string) (bool, string, error)) {
+	fake.pollBindingMutex.Lock()
+	defer fake.pollBindingMutex.Unlock()
+	fake.PollBindingStub = stub
+}
+
+func (fake *FakeServiceProvider) PollBindingArgsForCall(i int) (context.Context, string, string) {
+	fake.pollBindingMutex.RLock()
+	defer fake.pollBindingMutex.RUnlock()
+	argsForCall := fake.pollBindingArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2, argsForCall.arg3
+}
+
+func (fake *FakeServiceProvider) PollBindingReturns(result1 bool, result2 string, result3 error) {
+	fake.pollBindingMutex.Lock()
+	defer fake.pollBindingMutex.Unlock()
+	fake.PollBindingStub = nil
+	fake.pollBindingReturns = struct {
+		result1 bool
+		result2 string
+		result3 error
+	}{result1, result2, result3}
+}
+
+func (fake *FakeServiceProvider) PollBindingReturnsOnCall(i int, result1 bool, result2 string, result3 error) {
+	fake.pollBindingMutex.Lock()
+	defer fake.pollBindingMutex.Unlock()
+	fake.PollBindingStub = nil
+	if fake.pollBindingReturnsOnCall == nil {
+		fake.pollBindingReturnsOnCall = make(map[int]struct {
+			result1 bool
+			result2 string
+			result3 error
+		})
+	}
+	fake.pollBindingReturnsOnCall[i] = struct {
+		result1 bool
+		result2 string
+		result3 error
+	}{result1, result2, result3}
+}
+
 func (fake *FakeServiceProvider) PollInstance(arg1 context.Context, arg2 models.ServiceInstanceDetails) (bool, string, error) {
 	fake.pollInstanceMutex.Lock()
 	ret, specificReturn := fake.pollInstanceReturnsOnCall[len(fake.pollInstanceArgsForCall)]
@@ -470,6 +840,71 @@ func (fake *FakeServiceProvider) PollInstanceReturnsOnCall(i int, result1 bool,
 	}{result1, result2, result3}
 }
 
+func (fake *FakeServiceProvider) PreviewUpdate(arg1 context.Context, arg2 *varcontext.VarContext) (models.ServiceInstanceDetails, error) {
+	fake.previewUpdateMutex.Lock()
+	ret, specificReturn := fake.previewUpdateReturnsOnCall[len(fake.previewUpdateArgsForCall)]
+	fake.previewUpdateArgsForCall = append(fake.previewUpdateArgsForCall, struct {
+		arg1 context.Context
+		arg2 *varcontext.VarContext
+	}{arg1, arg2})
+	stub := fake.PreviewUpdateStub
+	fakeReturns := fake.previewUpdateReturns
+	fake.recordInvocation("PreviewUpdate", []interface{}{arg1, arg2})
+	fake.previewUpdateMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fakeReturns.result1, fakeReturns.result2
+}
+
+func (fake *FakeServiceProvider) PreviewUpdateCallCount() int {
+	fake.previewUpdateMutex.RLock()
+	defer fake.previewUpdateMutex.RUnlock()
+	return len(fake.previewUpdateArgsForCall)
+}
+
+func (fake *FakeServiceProvider) PreviewUpdateCalls(stub func(context.Context, *varcontext.VarContext) (models.ServiceInstanceDetails, error)) {
+	fake.previewUpdateMutex.Lock()
+	defer fake.previewUpdateMutex.Unlock()
+	fake.PreviewUpdateStub = stub
+}
+
+func (fake *FakeServiceProvider) PreviewUpdateArgsForCall(i int) (context.Context, *varcontext.VarContext) {
+	fake.previewUpdateMutex.RLock()
+	defer fake.previewUpdateMutex.RUnlock()
+	argsForCall := fake.previewUpdateArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2
+}
+
+func (fake *FakeServiceProvider) PreviewUpdateReturns(result1 models.ServiceInstanceDetails, result2 error) {
+	fake.previewUpdateMutex.Lock()
+	defer fake.previewUpdateMutex.Unlock()
+	fake.PreviewUpdateStub = nil
+	fake.previewUpdateReturns = struct {
+		result1 models.ServiceInstanceDetails
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeServiceProvider) PreviewUpdateReturnsOnCall(i int, result1 models.ServiceInstanceDetails, result2 error) {
+	fake.previewUpdateMutex.Lock()
+	defer fake.previewUpdateMutex.Unlock()
+	fake.PreviewUpdateStub = nil
+	if fake.previewUpdateReturnsOnCall == nil {
+		fake.previewUpdateReturnsOnCall = make(map[int]struct {
+			result1 models.ServiceInstanceDetails
+			result2 error
+		})
+	}
+	fake.previewUpdateReturnsOnCall[i] = struct {
+		result1 models.ServiceInstanceDetails
+		result2 error
+	}{result1, result2}
+}
+
 func (fake *FakeServiceProvider) Provision(arg1 context.Context, arg2 *varcontext.VarContext) (models.ServiceInstanceDetails, error) {
 	fake.provisionMutex.Lock()
 	ret, specificReturn := fake.provisionReturnsOnCall[len(fake.provisionArgsForCall)]
@@ -556,6 +991,8 @@ func (fake *FakeServiceProvider) ProvisionsAsync() bool {
 func (fake *FakeServiceProvider) ProvisionsAsyncCallCount() int {
 	fake.provisionsAsyncMutex.RLock()
 	defer fake.provisionsAsyncMutex.RUnlock()
+	fake.rotateBindingCredentialsMutex.RLock()
+	defer fake.rotateBindingCredentialsMutex.RUnlock()
 	return len(fake.provisionsAsyncArgsForCall)
 }
 
@@ -588,6 +1025,73 @@ func (fake *FakeServiceProvider) ProvisionsAsyncReturnsOnCall(i int, result1 boo
 	}{result1}
 }
 
+func (fake *FakeServiceProvider) RotateBindingCredentials(arg1 context.Context, arg2 models.ServiceInstanceDetails, arg3 models.ServiceBindingCredentials, arg4 time.Duration) (map[string]interface{}, error) {
+	fake.rotateBindingCredentialsMutex.Lock()
+	ret, specificReturn := fake.rotateBindingCredentialsReturnsOnCall[len(fake.rotateBindingCredentialsArgsForCall)]
+	fake.rotateBindingCredentialsArgsForCall = append(fake.rotateBindingCredentialsArgsForCall, struct {
+		arg1 context.Context
+		arg2 models.ServiceInstanceDetails
+		arg3 models.ServiceBindingCredentials
+		arg4 time.Duration
+	}{arg1, arg2, arg3, arg4})
+	stub := fake.RotateBindingCredentialsStub
+	fakeReturns := fake.rotateBindingCredentialsReturns
+	fake.recordInvocation("RotateBindingCredentials", []interface{}{arg1, arg2, arg3, arg4})
+	fake.rotateBindingCredentialsMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2, arg3, arg4)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fakeReturns.result1, fakeReturns.result2
+}
+
+func (fake *FakeServiceProvider) RotateBindingCredentialsCallCount() int {
+	fake.rotateBindingCredentialsMutex.RLock()
+	defer fake.rotateBindingCredentialsMutex.RUnlock()
+	return len(fake.rotateBindingCredentialsArgsForCall)
+}
+
+func (fake *FakeServiceProvider) RotateBindingCredentialsCalls(stub func(context.Context, models.ServiceInstanceDetails, models.ServiceBindingCredentials, time.Duration) (map[string]interface{}, error)) {
+	fake.rotateBindingCredentialsMutex.Lock()
+	defer fake.rotateBindingCredentialsMutex.Unlock()
+	fake.RotateBindingCredentialsStub = stub
+}
+
+func (fake *FakeServiceProvider) RotateBindingCredentialsArgsForCall(i int) (context.Context, models.ServiceInstanceDetails, models.ServiceBindingCredentials, time.Duration) {
+	fake.rotateBindingCredentialsMutex.RLock()
+	defer fake.rotateBindingCredentialsMutex.RUnlock()
+	argsForCall := fake.rotateBindingCredentialsArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2, argsForCall.arg3, argsForCall.arg4
+}
+
+func (fake *FakeServiceProvider) RotateBindingCredentialsReturns(result1 map[string]interface{}, result2 error) {
+	fake.rotateBindingCredentialsMutex.Lock()
+	defer fake.rotateBindingCredentialsMutex.Unlock()
+	fake.RotateBindingCredentialsStub = nil
+	fake.rotateBindingCredentialsReturns = struct {
+		result1 map[string]interface{}
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeServiceProvider) RotateBindingCredentialsReturnsOnCall(i int, result1 map[string]interface{}, result2 error) {
+	fake.rotateBindingCredentialsMutex.Lock()
+	defer fake.rotateBindingCredentialsMutex.Unlock()
+	fake.RotateBindingCredentialsStub = nil
+	if fake.rotateBindingCredentialsReturnsOnCall == nil {
+		fake.rotateBindingCredentialsReturnsOnCall = make(map[int]struct {
+			result1 map[string]interface{}
+			result2 error
+		})
+	}
+	fake.rotateBindingCredentialsReturnsOnCall[i] = struct {
+		result1 map[string]interface{}
+		result2 error
+	}{result1, result2}
+}
+
 func (fake *FakeServiceProvider) Unbind(arg1 context.Context, arg2 models.ServiceInstanceDetails, arg3 models.ServiceBindingCredentials, arg4 *varcontext.VarContext) error {
 	fake.unbindMutex.Lock()
 	ret, specificReturn := fake.unbindReturnsOnCall[len(fake.unbindArgsForCall)]
@@ -652,6 +1156,73 @@ func (fake *FakeServiceProvider) UnbindReturnsOnCall(i int, result1 error) {
 	}{result1}
 }
 
+func (fake *FakeServiceProvider) UnbindAsync(arg1 context.Context, arg2 models.ServiceInstanceDetails, arg3 models.ServiceBindingCredentials, arg4 *varcontext.VarContext) (string, error) {
+	fake.unbindAsyncMutex.Lock()
+	ret, specificReturn := fake.unbindAsyncReturnsOnCall[len(fake.unbindAsyncArgsForCall)]
+	fake.unbindAsyncArgsForCall = append(fake.unbindAsyncArgsForCall, struct {
+		arg1 context.Context
+		arg2 models.ServiceInstanceDetails
+		arg3 models.ServiceBindingCredentials
+		arg4 *varcontext.VarContext
+	}{arg1, arg2, arg3, arg4})
+	stub := fake.UnbindAsyncStub
+	fakeReturns := fake.unbindAsyncReturns
+	fake.recordInvocation("UnbindAsync", []interface{}{arg1, arg2, arg3, arg4})
+	fake.unbindAsyncMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2, arg3, arg4)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fakeReturns.result1, fakeReturns.result2
+}
+
+func (fake *FakeServiceProvider) UnbindAsyncCallCount() int {
+	fake.unbindAsyncMutex.RLock()
+	defer fake.unbindAsyncMutex.RUnlock()
+	return len(fake.unbindAsyncArgsForCall)
+}
+
+func (fake *FakeServiceProvider) UnbindAsyncCalls(stub func(context.Context, models.ServiceInstanceDetails, models.ServiceBindingCredentials, *varcontext.VarContext) (string, error)) {
+	fake.unbindAsyncMutex.Lock()
+	defer fake.unbindAsyncMutex.Unlock()
+	fake.UnbindAsyncStub = stub
+}
+
+func (fake *FakeServiceProvider) UnbindAsyncArgsForCall(i int) (context.Context, models.ServiceInstanceDetails, models.ServiceBindingCredentials, *varcontext.VarContext) {
+	fake.unbindAsyncMutex.RLock()
+	defer fake.unbindAsyncMutex.RUnlock()
+	argsForCall := fake.unbindAsyncArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2, argsForCall.arg3, argsForCall.arg4
+}
+
+func (fake *FakeServiceProvider) UnbindAsyncReturns(result1 string, result2 error) {
+	fake.unbindAsyncMutex.Lock()
+	defer fake.unbindAsyncMutex.Unlock()
+	fake.UnbindAsyncStub = nil
+	fake.unbindAsyncReturns = struct {
+		result1 string
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeServiceProvider) UnbindAsyncReturnsOnCall(i int, result1 string, result2 error) {
+	fake.unbindAsyncMutex.Lock()
+	defer fake.unbindAsyncMutex.Unlock()
+	fake.UnbindAsyncStub = nil
+	if fake.unbindAsyncReturnsOnCall == nil {
+		fake.unbindAsyncReturnsOnCall = make(map[int]struct {
+			result1 string
+			result2 error
+		})
+	}
+	fake.unbindAsyncReturnsOnCall[i] = struct {
+		result1 string
+		result2 error
+	}{result1, result2}
+}
+
 func (fake *FakeServiceProvider) Update(arg1 context.Context, arg2 *varcontext.VarContext) (models.ServiceInstanceDetails, error) {
 	fake.updateMutex.Lock()
 	ret, specificReturn := fake.updateReturnsOnCall[len(fake.updateArgsForCall)]
@@ -779,29 +1350,108 @@ func (fake *FakeServiceProvider) UpdateInstanceDetailsReturnsOnCall(i int, resul
 	}{result1}
 }
 
+func (fake *FakeServiceProvider) Upgrade(arg1 context.Context, arg2 *varcontext.VarContext) (models.ServiceInstanceDetails, error) {
+	fake.upgradeMutex.Lock()
+	ret, specificReturn := fake.upgradeReturnsOnCall[len(fake.upgradeArgsForCall)]
+	fake.upgradeArgsForCall = append(fake.upgradeArgsForCall, struct {
+		arg1 context.Context
+		arg2 *varcontext.VarContext
+	}{arg1, arg2})
+	stub := fake.UpgradeStub
+	fakeReturns := fake.upgradeReturns
+	fake.recordInvocation("Upgrade", []interface{}{arg1, arg2})
+	fake.upgradeMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fakeReturns.result1, fakeReturns.result2
+}
+
+func (fake *FakeServiceProvider) UpgradeCallCount() int {
+	fake.upgradeMutex.RLock()
+	defer fake.upgradeMutex.RUnlock()
+	return len(fake.upgradeArgsForCall)
+}
+
+func (fake *FakeServiceProvider) UpgradeCalls(stub func(context.Context, *varcontext.VarContext) (models.ServiceInstanceDetails, error)) {
+	fake.upgradeMutex.Lock()
+	defer fake.upgradeMutex.Unlock()
+	fake.UpgradeStub = stub
+}
+
+func (fake *FakeServiceProvider) UpgradeArgsForCall(i int) (context.Context, *varcontext.VarContext) {
+	fake.upgradeMutex.RLock()
+	defer fake.upgradeMutex.RUnlock()
+	argsForCall := fake.upgradeArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2
+}
+
+func (fake *FakeServiceProvider) UpgradeReturns(result1 models.ServiceInstanceDetails, result2 error) {
+	fake.upgradeMutex.Lock()
+	defer fake.upgradeMutex.Unlock()
+	fake.UpgradeStub = nil
+	fake.upgradeReturns = struct {
+		result1 models.ServiceInstanceDetails
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeServiceProvider) UpgradeReturnsOnCall(i int, result1 models.ServiceInstanceDetails, result2 error) {
+	fake.upgradeMutex.Lock()
+	defer fake.upgradeMutex.Unlock()
+	fake.UpgradeStub = nil
+	if fake.upgradeReturnsOnCall == nil {
+		fake.upgradeReturnsOnCall = make(map[int]struct {
+			result1 models.ServiceInstanceDetails
+			result2 error
+		})
+	}
+	fake.upgradeReturnsOnCall[i] = struct {
+		result1 models.ServiceInstanceDetails
+		result2 error
+	}{result1, result2}
+}
+
 func (fake *FakeServiceProvider) Invocations() map[string][][]interface{} {
 	fake.invocationsMutex.RLock()
 	defer fake.invocationsMutex.RUnlock()
 	fake.bindMutex.RLock()
 	defer fake.bindMutex.RUnlock()
+	fake.bindAsyncMutex.RLock()
+	defer fake.bindAsyncMutex.RUnlock()
+	fake.bindingOutputsMutex.RLock()
+	defer fake.bindingOutputsMutex.RUnlock()
+	fake.bindsAsyncMutex.RLock()
+	defer fake.bindsAsyncMutex.RUnlock()
 	fake.buildInstanceCredentialsMutex.RLock()
 	defer fake.buildInstanceCredentialsMutex.RUnlock()
 	fake.deprovisionMutex.RLock()
 	defer fake.deprovisionMutex.RUnlock()
 	fake.deprovisionsAsyncMutex.RLock()
 	defer fake.deprovisionsAsyncMutex.RUnlock()
+	fake.pollBindingMutex.RLock()
+	defer fake.pollBindingMutex.RUnlock()
 	fake.pollInstanceMutex.RLock()
 	defer fake.pollInstanceMutex.RUnlock()
+	fake.previewUpdateMutex.RLock()
+	defer fake.previewUpdateMutex.RUnlock()
 	fake.provisionMutex.RLock()
 	defer fake.provisionMutex.RUnlock()
 	fake.provisionsAsyncMutex.RLock()
 	defer fake.provisionsAsyncMutex.RUnlock()
 	fake.unbindMutex.RLock()
 	defer fake.unbindMutex.RUnlock()
+	fake.unbindAsyncMutex.RLock()
+	defer fake.unbindAsyncMutex.RUnlock()
 	fake.updateMutex.RLock()
 	defer fake.updateMutex.RUnlock()
 	fake.updateInstanceDetailsMutex.RLock()
 	defer fake.updateInstanceDetailsMutex.RUnlock()
+	fake.upgradeMutex.RLock()
+	defer fake.upgradeMutex.RUnlock()
 	copiedInvocations := map[string][][]interface{}{}
 	for key, value := range fake.invocations {
 		copiedInvocations[key] = value
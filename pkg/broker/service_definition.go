@@ -49,6 +49,8 @@ type ServiceDefinition struct {
 	Bindable         bool
 	PlanUpdateable   bool
 	Plans            []ServicePlan
+	// MaintenanceInfo is the maintenance_info of user defined plans.
+	MaintenanceInfo *domain.MaintenanceInfo
 
 	ProvisionInputVariables    []BrokerVariable
 	ProvisionComputedVariables []varcontext.DefaultVariable
@@ -201,9 +203,11 @@ func (svc *ServiceDefinition) CatalogEntry() *Service {
 				ImageUrl:         svc.ImageUrl,
 				SupportUrl:       svc.SupportUrl,
 			},
-			Tags:          svc.Tags,
-			Bindable:      svc.Bindable,
-			PlanUpdatable: svc.PlanUpdateable,
+			Tags:                 svc.Tags,
+			Bindable:             svc.Bindable,
+			PlanUpdatable:        svc.PlanUpdateable,
+			InstancesRetrievable: true,
+			BindingsRetrievable:  svc.Bindable,
 		},
 		Plans: svc.Plans,
 	}
@@ -297,6 +301,11 @@ func (svc *ServiceDefinition) UserDefinedPlans() ([]ServicePlan, error) {
 			plan.ID, _ = plan.ServiceProperties["guid"].(string)
 		}
 
+		if plan.MaintenanceInfo == nil && svc.MaintenanceInfo != nil {
+			info := *svc.MaintenanceInfo
+			plan.MaintenanceInfo = &info
+		}
+
 		if err := svc.validatePlan(plan); err != nil {
 			return []ServicePlan{}, err
 		}
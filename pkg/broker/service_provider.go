@@ -16,6 +16,7 @@ package broker
 
 import (
 	"context"
+	"time"
 
 	"github.com/cloudfoundry-incubator/cloud-service-broker/db_service/models"
 	"github.com/cloudfoundry-incubator/cloud-service-broker/pkg/varcontext"
@@ -37,6 +38,16 @@ type ServiceProvider interface {
 	// Update makes necessary updates to resources so they match new desired configuration
 	Update(ctx context.Context, provisionContext *varcontext.VarContext) (models.ServiceInstanceDetails, error)
 
+	// PreviewUpdate works out the changes that Update would make to resources
+	// without making them. Like Update it runs asynchronously, and the changes
+	// are reported as the message of the operation.
+	PreviewUpdate(ctx context.Context, provisionContext *varcontext.VarContext) (models.ServiceInstanceDetails, error)
+
+	// Upgrade is like Update, but also changes the resources to match the
+	// current definition of the service, when the instance was created or last
+	// upgraded from an older one.
+	Upgrade(ctx context.Context, provisionContext *varcontext.VarContext) (models.ServiceInstanceDetails, error)
+
 	// Bind provisions the necessary resources for a user to be able to connect to the provisioned service.
 	// This may include creating service accounts, granting permissions, and adding users to services e.g. a SQL database user.
 	// It stores information necessary to access the service _and_ delete the binding in the returned map.
@@ -46,6 +57,21 @@ type ServiceProvider interface {
 	BuildInstanceCredentials(ctx context.Context, bindRecord models.ServiceBindingCredentials, instance models.ServiceInstanceDetails) (*domain.Binding, error)
 	// Unbind deprovisions the resources created with Bind.
 	Unbind(ctx context.Context, instance models.ServiceInstanceDetails, details models.ServiceBindingCredentials, vc *varcontext.VarContext) error
+	// BindAsync starts provisioning the resources for a binding in the background
+	// and returns an operation ID. The state of the operation can be found using PollBinding
+	// and, once it has succeeded, the credentials are available through BindingOutputs.
+	BindAsync(ctx context.Context, vc *varcontext.VarContext) (operationId string, err error)
+	// UnbindAsync starts deprovisioning the resources created for a binding in the background
+	// and returns an operation ID. The state of the operation can be found using PollBinding.
+	UnbindAsync(ctx context.Context, instance models.ServiceInstanceDetails, details models.ServiceBindingCredentials, vc *varcontext.VarContext) (operationId string, err error)
+	// PollBinding returns the status of the last asynchronous operation on a binding.
+	PollBinding(ctx context.Context, instanceID, bindingID string) (bool, string, error)
+	// BindingOutputs returns the credentials produced by a successful BindAsync.
+	BindingOutputs(ctx context.Context, instanceID, bindingID string) (map[string]interface{}, error)
+	// RotateBindingCredentials replaces the credentials of a binding without
+	// unbinding it, and returns the new credentials like Bind. If the grace
+	// period is not zero, the previous credentials remain valid until it ends.
+	RotateBindingCredentials(ctx context.Context, instance models.ServiceInstanceDetails, bindRecord models.ServiceBindingCredentials, gracePeriod time.Duration) (map[string]interface{}, error)
 	// Deprovision deprovisions the service.
 	// If the deprovision is asynchronous (results in a long-running job), then operationId is returned.
 	// If no error and no operationId are returned, then the deprovision is expected to have been completed successfully.
@@ -53,6 +79,7 @@ type ServiceProvider interface {
 	PollInstance(ctx context.Context, instance models.ServiceInstanceDetails) (bool, string, error)
 	ProvisionsAsync() bool
 	DeprovisionsAsync() bool
+	BindsAsync() bool
 
 	// UpdateInstanceDetails updates the ServiceInstanceDetails with the most recent state from GCP.
 	// This function is optional, but will be called after async provisions, updates, and possibly
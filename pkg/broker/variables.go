@@ -58,6 +58,9 @@ type BrokerVariable struct {
 	// http://json-schema.org/latest/json-schema-validation.html
 	Constraints    map[string]interface{} `yaml:"constraints,omitempty"`
 	ProhibitUpdate bool                   `yaml:"prohibit_update,omitempty"`
+	// Sensitive fields hold secrets, such as passwords, that are redacted
+	// wherever the broker records the parameters of a request.
+	Sensitive bool `yaml:"sensitive,omitempty"`
 }
 
 var _ validation.Validatable = (*ServiceDefinition)(nil)
@@ -65,6 +65,8 @@ func (pak *BrokerPakReader) Services() ([]tf.TfServiceDefinitionV1, error) {
 		}
 
 		tmp.RequiredEnvVars = manifest.RequiredEnvVars
+		tmp.BrokerpakName = manifest.Name
+		tmp.BrokerpakVersion = manifest.Version
 		services = append(services, tmp)
 	}
 
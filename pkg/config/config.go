@@ -26,9 +26,36 @@ const (
 	credhubSkipSSLValidation    = "credhub.skip_ssl_validation"
 	credhubCaCertFile           = "credhub.ca_cert_file"
 	credhubStoreBindCredentials = "credhub.store_bind_credentials"
+
+	credStoreBackend            = "credhub.backend"
+	vaultAddress                = "credhub.vault.address"
+	vaultToken                  = "credhub.vault.token"
+	vaultMount                  = "credhub.vault.mount"
+	vaultCaCertFile             = "credhub.vault.ca_cert_file"
+	vaultSkipSSLValidation      = "credhub.vault.skip_ssl_validation"
+	kubernetesAPIServer         = "credhub.kubernetes.api_server"
+	kubernetesNamespace         = "credhub.kubernetes.namespace"
+	kubernetesTokenFile         = "credhub.kubernetes.token_file"
+	kubernetesCaCertFile        = "credhub.kubernetes.ca_cert_file"
+	kubernetesSkipSSLValidation = "credhub.kubernetes.skip_ssl_validation"
+	fileDirectory               = "credhub.file.directory"
+)
+
+// The credential store backends that can be configured.
+const (
+	CredHubBackend    = "credhub"
+	VaultBackend      = "vault"
+	KubernetesBackend = "kubernetes"
+	FileBackend       = "file"
 )
 
+// CredStoreConfig configures the store of binding credentials. It holds the
+// configuration of every backend, for backward compatibility under the
+// credhub key of the configuration file.
 type CredStoreConfig struct {
+	// Backend is the backend to use. It defaults to CredHub if its URL is set.
+	Backend string `mapstructure:"backend"`
+
 	CredHubURL           string `mapstructure:"url"`
 	UaaURL               string `mapstructure:"uaa_url"`
 	UaaClientName        string `mapstructure:"uaa_client_name"`
@@ -36,6 +63,35 @@ type CredStoreConfig struct {
 	SkipSSLValidation    bool   `mapstructure:"skip_ssl_validation"`
 	CaCertFile           string `mapstructure:"ca_cert_file"`
 	StoreBindCredentials bool   `mapstructure:"store_bind_credentials"`
+
+	Vault      VaultConfig      `mapstructure:"vault"`
+	Kubernetes KubernetesConfig `mapstructure:"kubernetes"`
+	File       FileConfig       `mapstructure:"file"`
+}
+
+// VaultConfig configures a HashiCorp Vault KV version 2 secrets engine.
+type VaultConfig struct {
+	Address           string `mapstructure:"address"`
+	Token             string `mapstructure:"token"`
+	Mount             string `mapstructure:"mount"`
+	CaCertFile        string `mapstructure:"ca_cert_file"`
+	SkipSSLValidation bool   `mapstructure:"skip_ssl_validation"`
+}
+
+// KubernetesConfig configures the Kubernetes API server that secrets are stored
+// in. Unset values default to those of the pod the broker runs in.
+type KubernetesConfig struct {
+	APIServer         string `mapstructure:"api_server"`
+	Namespace         string `mapstructure:"namespace"`
+	TokenFile         string `mapstructure:"token_file"`
+	CaCertFile        string `mapstructure:"ca_cert_file"`
+	SkipSSLValidation bool   `mapstructure:"skip_ssl_validation"`
+}
+
+// FileConfig configures a directory that credentials are stored in, which is
+// only suitable for development and tests.
+type FileConfig struct {
+	Directory string `mapstructure:"directory"`
 }
 
 type Config struct {
@@ -51,6 +107,19 @@ func Parse() (*Config, error) {
 	viper.BindEnv(credhubSkipSSLValidation, "CH_SKIP_SSL_VALIDATION")
 	viper.BindEnv(credhubCaCertFile, "CH_CA_CERT_FILE")
 	viper.BindEnv(credhubStoreBindCredentials, "CH_STORE_BIND_CREDENTIALS")
+	viper.BindEnv(credStoreBackend, "CREDSTORE_BACKEND")
+	viper.BindEnv(vaultAddress, "VAULT_ADDR")
+	viper.BindEnv(vaultToken, "VAULT_TOKEN")
+	viper.BindEnv(vaultMount, "CREDSTORE_VAULT_MOUNT")
+	viper.BindEnv(vaultCaCertFile, "VAULT_CACERT")
+	viper.BindEnv(vaultSkipSSLValidation, "VAULT_SKIP_VERIFY")
+	viper.BindEnv(kubernetesAPIServer, "CREDSTORE_KUBERNETES_API_SERVER")
+	viper.BindEnv(kubernetesNamespace, "CREDSTORE_KUBERNETES_NAMESPACE")
+	viper.BindEnv(kubernetesTokenFile, "CREDSTORE_KUBERNETES_TOKEN_FILE")
+	viper.BindEnv(kubernetesCaCertFile, "CREDSTORE_KUBERNETES_CA_CERT_FILE")
+	viper.BindEnv(kubernetesSkipSSLValidation, "CREDSTORE_KUBERNETES_SKIP_SSL_VALIDATION")
+	viper.BindEnv(fileDirectory, "CREDSTORE_FILE_DIRECTORY")
+	viper.SetDefault(vaultMount, "secret")
 
 	err := viper.Unmarshal(&c)
 	if err != nil {
@@ -63,3 +132,12 @@ func Parse() (*Config, error) {
 func (c *CredStoreConfig) HasCredHubConfig() bool {
 	return c.CredHubURL != ""
 }
+
+// BackendName gets the backend to use, which is CredHub if no backend is set
+// and its URL is, or empty if no credential store is configured.
+func (c *CredStoreConfig) BackendName() string {
+	if c.Backend == "" && c.HasCredHubConfig() {
+		return CredHubBackend
+	}
+	return c.Backend
+}
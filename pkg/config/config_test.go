@@ -53,6 +53,47 @@ var _ = Describe("Config", func() {
 
 				Expect(c.CredStoreConfig.CredHubURL).To(Equal("https://credhub.example.com"))
 				Expect(c.CredStoreConfig.UaaURL).To(Equal("https://uaa.example.com"))
+				Expect(c.CredStoreConfig.BackendName()).To(Equal(CredHubBackend))
+			})
+
+			It("parses vault config", func() {
+				os.Setenv("CREDSTORE_BACKEND", "vault")
+				os.Setenv("VAULT_ADDR", "https://vault.example.com")
+				os.Setenv("VAULT_TOKEN", "my-token")
+				os.Setenv("VAULT_SKIP_VERIFY", "true")
+
+				c, err := Parse()
+				Expect(err).To(BeNil())
+
+				Expect(c.CredStoreConfig.BackendName()).To(Equal(VaultBackend))
+				Expect(c.CredStoreConfig.Vault).To(Equal(VaultConfig{
+					Address:           "https://vault.example.com",
+					Token:             "my-token",
+					Mount:             "secret",
+					SkipSSLValidation: true,
+				}))
+			})
+
+			It("parses kubernetes config", func() {
+				os.Setenv("CREDSTORE_BACKEND", "kubernetes")
+				os.Setenv("CREDSTORE_KUBERNETES_NAMESPACE", "brokers")
+
+				c, err := Parse()
+				Expect(err).To(BeNil())
+
+				Expect(c.CredStoreConfig.BackendName()).To(Equal(KubernetesBackend))
+				Expect(c.CredStoreConfig.Kubernetes.Namespace).To(Equal("brokers"))
+			})
+
+			It("parses file config", func() {
+				os.Setenv("CREDSTORE_BACKEND", "file")
+				os.Setenv("CREDSTORE_FILE_DIRECTORY", "/tmp/credentials")
+
+				c, err := Parse()
+				Expect(err).To(BeNil())
+
+				Expect(c.CredStoreConfig.BackendName()).To(Equal(FileBackend))
+				Expect(c.CredStoreConfig.File.Directory).To(Equal("/tmp/credentials"))
 			})
 		})
 	})
@@ -15,7 +15,12 @@
 package credstore
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
 	"os"
 
 	"code.cloudfoundry.org/credhub-cli/credhub"
@@ -38,6 +43,28 @@ type CredStore interface {
 	DeletePermission(path string) error
 }
 
+// ErrNotFound is returned when getting a credential that does not exist.
+var ErrNotFound = errors.New("credential not found")
+
+// New creates the credential store of the configured backend, or returns nil
+// if no credential store is configured.
+func New(credStoreConfig *config.CredStoreConfig, logger lager.Logger) (CredStore, error) {
+	switch backend := credStoreConfig.BackendName(); backend {
+	case "":
+		return nil, nil
+	case config.CredHubBackend:
+		return NewCredhubStore(credStoreConfig, logger)
+	case config.VaultBackend:
+		return NewVaultStore(&credStoreConfig.Vault, logger)
+	case config.KubernetesBackend:
+		return NewKubernetesStore(&credStoreConfig.Kubernetes, logger)
+	case config.FileBackend:
+		return NewFileStore(credStoreConfig.File.Directory)
+	default:
+		return nil, fmt.Errorf("unknown credential store backend %q, must be one of: %s, %s, %s, %s", backend, config.CredHubBackend, config.VaultBackend, config.KubernetesBackend, config.FileBackend)
+	}
+}
+
 type credhubStore struct {
 	credHubClient *credhub.CredHub
 	logger        lager.Logger
@@ -130,3 +157,45 @@ func (c *credhubStore) DeletePermission(path string) error {
 
 	return err
 }
+
+func notFoundError(key string) error {
+	return fmt.Errorf("%w: %q", ErrNotFound, key)
+}
+
+// toJSONObject converts credentials to a JSON object, which like in CredHub is
+// the only type that JSON credentials can have.
+func toJSONObject(credentials interface{}) (map[string]interface{}, error) {
+	data, err := json.Marshal(credentials)
+	if err != nil {
+		return nil, err
+	}
+
+	var object map[string]interface{}
+	if err := json.Unmarshal(data, &object); err != nil || object == nil {
+		return nil, fmt.Errorf("credentials must be a JSON object, not %T", credentials)
+	}
+	return object, nil
+}
+
+// newHTTPClient creates a client for the API of a store, trusting the CA
+// certificates in the file if it is set.
+func newHTTPClient(caCertFile string, skipSSLValidation bool) (*http.Client, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: skipSSLValidation}
+
+	if caCertFile != "" {
+		data, err := os.ReadFile(caCertFile)
+		if err != nil {
+			return nil, err
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("certificate is not valid: %s", caCertFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return &http.Client{Transport: transport}, nil
+}
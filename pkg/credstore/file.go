@@ -0,0 +1,174 @@
+// Copyright 2021 the Service Broker Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package credstore
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"code.cloudfoundry.org/credhub-cli/credhub/permissions"
+)
+
+// fileCredential is the content of a file written by the file store.
+type fileCredential struct {
+	Value       interface{}              `json:"value,omitempty"`
+	Permissions []permissions.Permission `json:"permissions,omitempty"`
+}
+
+type fileStore struct {
+	directory string
+	mutex     sync.Mutex
+}
+
+// NewFileStore creates a store that keeps each credential, and the permissions
+// on it, in a JSON file in the directory. It stands in for a real store in
+// development and tests, and offers no protection to the credentials.
+func NewFileStore(directory string) (CredStore, error) {
+	if directory == "" {
+		return nil, errors.New("a directory is required for the file credential store")
+	}
+	if err := os.MkdirAll(directory, 0700); err != nil {
+		return nil, fmt.Errorf("error creating directory for the file credential store: %w", err)
+	}
+
+	return &fileStore{directory: directory}, nil
+}
+
+func (f *fileStore) Put(key string, credentials interface{}) (interface{}, error) {
+	if _, err := toJSONObject(credentials); err != nil {
+		return nil, err
+	}
+
+	return credentials, f.update(key, func(c *fileCredential) { c.Value = credentials })
+}
+
+func (f *fileStore) PutValue(key string, credentials interface{}) (interface{}, error) {
+	value, ok := credentials.(string)
+	if !ok {
+		return nil, fmt.Errorf("value credentials must be a string, not %T", credentials)
+	}
+
+	return value, f.update(key, func(c *fileCredential) { c.Value = value })
+}
+
+func (f *fileStore) Get(key string) (interface{}, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	c, err := f.read(key)
+	switch {
+	case err != nil:
+		return nil, err
+	case c.Value == nil:
+		return nil, notFoundError(key)
+	}
+
+	return c.Value, nil
+}
+
+func (f *fileStore) GetValue(key string) (string, error) {
+	value, err := f.Get(key)
+	if err != nil {
+		return "", err
+	}
+
+	s, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("credential %q is not a value", key)
+	}
+	return s, nil
+}
+
+func (f *fileStore) Delete(key string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if err := os.Remove(f.path(key)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (f *fileStore) AddPermission(path string, actor string, ops []string) (*permissions.Permission, error) {
+	permission := permissions.Permission{Actor: actor, Operations: ops, Path: path}
+	err := f.update(path, func(c *fileCredential) {
+		for i, p := range c.Permissions {
+			if p.Actor == actor {
+				c.Permissions[i] = permission
+				return
+			}
+		}
+		c.Permissions = append(c.Permissions, permission)
+	})
+
+	return &permission, err
+}
+
+func (f *fileStore) DeletePermission(path string) error {
+	return f.update(path, func(c *fileCredential) { c.Permissions = nil })
+}
+
+// update changes the file of the credential, creating it if it does not exist.
+func (f *fileStore) update(key string, change func(c *fileCredential)) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	c, err := f.read(key)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		c = &fileCredential{}
+	case err != nil:
+		return err
+	}
+
+	change(c)
+	if c.Value == nil && len(c.Permissions) == 0 {
+		if err := os.Remove(f.path(key)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(f.path(key), data, 0600)
+}
+
+func (f *fileStore) read(key string) (*fileCredential, error) {
+	data, err := os.ReadFile(f.path(key))
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return nil, notFoundError(key)
+	case err != nil:
+		return nil, err
+	}
+
+	var c fileCredential
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("error reading credential %q: %w", key, err)
+	}
+	return &c, nil
+}
+
+func (f *fileStore) path(key string) string {
+	return filepath.Join(f.directory, url.PathEscape(key)+".json")
+}
@@ -0,0 +1,90 @@
+// Copyright 2021 the Service Broker Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package credstore_test
+
+import (
+	"errors"
+	"os"
+
+	"github.com/cloudfoundry-incubator/cloud-service-broker/pkg/credstore"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("File Store", func() {
+	var (
+		directory string
+		store     credstore.CredStore
+	)
+
+	BeforeEach(func() {
+		var err error
+		directory, err = os.MkdirTemp("", "credstore")
+		Expect(err).NotTo(HaveOccurred())
+
+		store, err = credstore.NewFileStore(directory)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(directory)
+	})
+
+	It("stores and deletes credentials", func() {
+		_, err := store.Put("/c/broker/service/binding/secrets", map[string]interface{}{"password": "secret"})
+		Expect(err).NotTo(HaveOccurred())
+
+		credentials, err := store.Get("/c/broker/service/binding/secrets")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(credentials).To(Equal(map[string]interface{}{"password": "secret"}))
+
+		Expect(store.Delete("/c/broker/service/binding/secrets")).To(Succeed())
+		_, err = store.Get("/c/broker/service/binding/secrets")
+		Expect(errors.Is(err, credstore.ErrNotFound)).To(BeTrue())
+	})
+
+	It("stores values", func() {
+		_, err := store.PutValue("/c/broker/service/binding/value", "secret")
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(store.GetValue("/c/broker/service/binding/value")).To(Equal("secret"))
+	})
+
+	It("rejects credentials that are not a JSON object", func() {
+		_, err := store.Put("/c/broker/service/binding/secrets", []string{"secret"})
+		Expect(err).To(MatchError("credentials must be a JSON object, not []string"))
+	})
+
+	It("keeps one permission per actor", func() {
+		_, err := store.Put("/c/broker/service/binding/secrets", map[string]interface{}{"password": "secret"})
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = store.AddPermission("/c/broker/service/binding/secrets", "mtls-app:app-guid", []string{"read"})
+		Expect(err).NotTo(HaveOccurred())
+		_, err = store.AddPermission("/c/broker/service/binding/secrets", "mtls-app:app-guid", []string{"read", "write"})
+		Expect(err).NotTo(HaveOccurred())
+
+		data, err := os.ReadFile(directory + "/%2Fc%2Fbroker%2Fservice%2Fbinding%2Fsecrets.json")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(data).To(MatchJSON(`{
+			"value": {"password": "secret"},
+			"permissions": [{"actor": "mtls-app:app-guid", "operations": ["read", "write"], "path": "/c/broker/service/binding/secrets", "uuid": ""}]
+		}`))
+
+		Expect(store.DeletePermission("/c/broker/service/binding/secrets")).To(Succeed())
+		Expect(store.Delete("/c/broker/service/binding/secrets")).To(Succeed())
+		Expect(os.ReadDir(directory)).To(BeEmpty())
+	})
+})
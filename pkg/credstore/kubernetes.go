@@ -0,0 +1,337 @@
+// Copyright 2021 the Service Broker Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package credstore
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+
+	"code.cloudfoundry.org/credhub-cli/credhub/permissions"
+	"code.cloudfoundry.org/lager"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/pkg/config"
+)
+
+const (
+	serviceAccountDirectory = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+	// kubernetesFieldManager owns the fields of the objects the store applies.
+	kubernetesFieldManager = "cloud-service-broker"
+	// kubernetesCredentialLabel is set to a digest of the key on every object
+	// the store creates for a credential, so they can be found again.
+	kubernetesCredentialLabel = "cloud-service-broker/credential"
+	kubernetesKeyAnnotation   = "cloud-service-broker/key"
+	kubernetesActorAnnotation = "cloud-service-broker/actor"
+
+	// the data fields of the secret that JSON and value credentials are kept in
+	kubernetesCredentialsField = "credentials"
+	kubernetesValueField       = "value"
+)
+
+// kubernetesVerbs maps CredHub operations to the RBAC verbs on the secret.
+var kubernetesVerbs = map[string][]string{
+	"read":   {"get"},
+	"write":  {"update", "patch"},
+	"delete": {"delete"},
+}
+
+var kubernetesNameReplacer = regexp.MustCompile(`[^a-z0-9-]+`)
+
+type kubernetesStore struct {
+	apiServer string
+	namespace string
+	tokenFile string
+	client    *http.Client
+	logger    lager.Logger
+}
+
+// NewKubernetesStore creates a store that keeps credentials in Kubernetes
+// Secrets. Permissions are granted with a Role and RoleBinding per actor, whose
+// subject is a user named after the actor. Values that are not configured
+// default to those of the service account of the pod the broker runs in.
+func NewKubernetesStore(kubernetesConfig *config.KubernetesConfig, logger lager.Logger) (CredStore, error) {
+	apiServer := kubernetesConfig.APIServer
+	if apiServer == "" {
+		host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+		if host == "" || port == "" {
+			return nil, errors.New("an API server is required for the kubernetes credential store when not running in a pod")
+		}
+		apiServer = "https://" + net.JoinHostPort(host, port)
+	}
+
+	namespace := kubernetesConfig.Namespace
+	if namespace == "" {
+		data, err := os.ReadFile(serviceAccountDirectory + "/namespace")
+		if err != nil {
+			return nil, fmt.Errorf("a namespace is required for the kubernetes credential store: %w", err)
+		}
+		namespace = strings.TrimSpace(string(data))
+	}
+
+	tokenFile := kubernetesConfig.TokenFile
+	if tokenFile == "" {
+		tokenFile = serviceAccountDirectory + "/token"
+	}
+
+	caCertFile := kubernetesConfig.CaCertFile
+	if caCertFile == "" && kubernetesConfig.APIServer == "" {
+		caCertFile = serviceAccountDirectory + "/ca.crt"
+	}
+
+	client, err := newHTTPClient(caCertFile, kubernetesConfig.SkipSSLValidation)
+	if err != nil {
+		return nil, err
+	}
+
+	return &kubernetesStore{
+		apiServer: strings.TrimSuffix(apiServer, "/"),
+		namespace: namespace,
+		tokenFile: tokenFile,
+		client:    client,
+		logger:    logger,
+	}, nil
+}
+
+func (k *kubernetesStore) Put(key string, credentials interface{}) (interface{}, error) {
+	if _, err := toJSONObject(credentials); err != nil {
+		return nil, err
+	}
+
+	data, err := json.Marshal(credentials)
+	if err != nil {
+		return nil, err
+	}
+
+	return credentials, k.applySecret(key, map[string][]byte{kubernetesCredentialsField: data})
+}
+
+func (k *kubernetesStore) PutValue(key string, credentials interface{}) (interface{}, error) {
+	value, ok := credentials.(string)
+	if !ok {
+		return nil, fmt.Errorf("value credentials must be a string, not %T", credentials)
+	}
+
+	return value, k.applySecret(key, map[string][]byte{kubernetesValueField: []byte(value)})
+}
+
+func (k *kubernetesStore) Get(key string) (interface{}, error) {
+	data, err := k.secretData(key, kubernetesCredentialsField)
+	if err != nil {
+		return nil, err
+	}
+
+	var credentials interface{}
+	if err := json.Unmarshal(data, &credentials); err != nil {
+		return nil, fmt.Errorf("error reading credential %q: %w", key, err)
+	}
+	return credentials, nil
+}
+
+func (k *kubernetesStore) GetValue(key string) (string, error) {
+	data, err := k.secretData(key, kubernetesValueField)
+	return string(data), err
+}
+
+func (k *kubernetesStore) Delete(key string) error {
+	return k.do(http.MethodDelete, k.secretPath(key), "", nil, nil)
+}
+
+func (k *kubernetesStore) AddPermission(path string, actor string, ops []string) (*permissions.Permission, error) {
+	var verbs []string
+	for _, op := range ops {
+		v, ok := kubernetesVerbs[op]
+		if !ok {
+			return nil, fmt.Errorf("unknown operation %q", op)
+		}
+		verbs = append(verbs, v...)
+	}
+
+	name := kubernetesName(path) + "-" + digest(actor)[:8]
+	meta := k.objectMeta(name, path, map[string]string{kubernetesActorAnnotation: actor})
+
+	role := map[string]interface{}{
+		"apiVersion": "rbac.authorization.k8s.io/v1",
+		"kind":       "Role",
+		"metadata":   meta,
+		"rules": []map[string]interface{}{{
+			"apiGroups":     []string{""},
+			"resources":     []string{"secrets"},
+			"resourceNames": []string{kubernetesName(path)},
+			"verbs":         verbs,
+		}},
+	}
+	if err := k.apply(k.rbacPath("roles", name), role); err != nil {
+		return nil, err
+	}
+
+	binding := map[string]interface{}{
+		"apiVersion": "rbac.authorization.k8s.io/v1",
+		"kind":       "RoleBinding",
+		"metadata":   meta,
+		"roleRef":    map[string]string{"apiGroup": "rbac.authorization.k8s.io", "kind": "Role", "name": name},
+		"subjects":   []map[string]string{{"apiGroup": "rbac.authorization.k8s.io", "kind": "User", "name": actor}},
+	}
+	if err := k.apply(k.rbacPath("rolebindings", name), binding); err != nil {
+		return nil, err
+	}
+
+	return &permissions.Permission{Actor: actor, Operations: ops, Path: path}, nil
+}
+
+// DeletePermission removes the Roles and RoleBindings of every actor that was
+// given permission on the credential.
+func (k *kubernetesStore) DeletePermission(path string) error {
+	query := "?labelSelector=" + url.QueryEscape(kubernetesCredentialLabel+"="+digest(path)[:16])
+	for _, resource := range []string{"rolebindings", "roles"} {
+		if err := k.do(http.MethodDelete, k.rbacPath(resource, "")+query, "", nil, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (k *kubernetesStore) applySecret(key string, data map[string][]byte) error {
+	secret := map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Secret",
+		"type":       "Opaque",
+		"metadata":   k.objectMeta(kubernetesName(key), key, nil),
+		"data":       data,
+	}
+
+	return k.apply(k.secretPath(key), secret)
+}
+
+func (k *kubernetesStore) secretData(key, field string) ([]byte, error) {
+	var secret struct {
+		Data map[string][]byte `json:"data"`
+	}
+	if err := k.do(http.MethodGet, k.secretPath(key), "", nil, &secret); err != nil {
+		return nil, err
+	}
+
+	data, ok := secret.Data[field]
+	if !ok {
+		return nil, fmt.Errorf("credential %q has no %s", key, field)
+	}
+	return data, nil
+}
+
+func (k *kubernetesStore) objectMeta(name, key string, annotations map[string]string) map[string]interface{} {
+	all := map[string]string{kubernetesKeyAnnotation: key}
+	for a, v := range annotations {
+		all[a] = v
+	}
+
+	return map[string]interface{}{
+		"name":        name,
+		"namespace":   k.namespace,
+		"labels":      map[string]string{kubernetesCredentialLabel: digest(key)[:16]},
+		"annotations": all,
+	}
+}
+
+// apply creates or updates the object with server-side apply.
+func (k *kubernetesStore) apply(path string, object interface{}) error {
+	query := "?force=true&fieldManager=" + kubernetesFieldManager
+	return k.do(http.MethodPatch, path+query, "application/apply-patch+yaml", object, nil)
+}
+
+func (k *kubernetesStore) secretPath(key string) string {
+	return fmt.Sprintf("/api/v1/namespaces/%s/secrets/%s", k.namespace, kubernetesName(key))
+}
+
+func (k *kubernetesStore) rbacPath(resource, name string) string {
+	path := fmt.Sprintf("/apis/rbac.authorization.k8s.io/v1/namespaces/%s/%s", k.namespace, resource)
+	if name != "" {
+		path += "/" + name
+	}
+	return path
+}
+
+// do calls the Kubernetes API, decoding the response into result if it is set.
+// A missing object is reported as ErrNotFound, except when deleting.
+func (k *kubernetesStore) do(method, path, contentType string, body, result interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, k.apiServer+path, reader)
+	if err != nil {
+		return err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	// the token is read for every request because service account tokens
+	// are rotated while the broker runs
+	if token, err := os.ReadFile(k.tokenFile); err == nil {
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("error reading kubernetes token: %w", err)
+	}
+
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error calling kubernetes: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound && method == http.MethodDelete:
+		return nil
+	case resp.StatusCode == http.StatusNotFound:
+		return notFoundError(path)
+	case resp.StatusCode >= 300:
+		var status struct {
+			Message string `json:"message"`
+		}
+		json.NewDecoder(resp.Body).Decode(&status)
+		return fmt.Errorf("kubernetes returned %s for %s %s: %s", resp.Status, method, path, status.Message)
+	case result == nil:
+		return nil
+	}
+
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+// kubernetesName gets a valid and unique object name for the key.
+func kubernetesName(key string) string {
+	name := strings.Trim(kubernetesNameReplacer.ReplaceAllString(strings.ToLower(key), "-"), "-")
+	if len(name) > 200 {
+		name = name[:200]
+	}
+	return "csb-" + name + "-" + digest(key)[:16]
+}
+
+func digest(value string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(value)))
+}
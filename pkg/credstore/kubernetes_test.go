@@ -0,0 +1,186 @@
+// Copyright 2021 the Service Broker Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package credstore_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/pkg/config"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/pkg/credstore"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Kubernetes Store", func() {
+	const (
+		secretsPath      = "/api/v1/namespaces/brokers/secrets/"
+		rolesPath        = "/apis/rbac.authorization.k8s.io/v1/namespaces/brokers/roles"
+		roleBindingsPath = "/apis/rbac.authorization.k8s.io/v1/namespaces/brokers/rolebindings"
+	)
+
+	var (
+		directory string
+		server    *httptest.Server
+		store     credstore.CredStore
+		mutex     sync.Mutex
+		// objects are the objects applied to each path of the fake API server
+		objects map[string]map[string]interface{}
+	)
+
+	// labelSelected reports whether the object has the label in the selector
+	labelSelected := func(object map[string]interface{}, selector string) bool {
+		parts := strings.SplitN(selector, "=", 2)
+		labels := object["metadata"].(map[string]interface{})["labels"].(map[string]interface{})
+		return labels[parts[0]] == parts[1]
+	}
+
+	BeforeEach(func() {
+		var err error
+		directory, err = os.MkdirTemp("", "credstore")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(os.WriteFile(filepath.Join(directory, "token"), []byte("my-token\n"), 0600)).To(Succeed())
+
+		objects = make(map[string]map[string]interface{})
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			if r.Header.Get("Authorization") != "Bearer my-token" {
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte(`{"message": "Unauthorized"}`))
+				return
+			}
+
+			switch r.Method {
+			case http.MethodPatch:
+				Expect(r.Header.Get("Content-Type")).To(Equal("application/apply-patch+yaml"))
+				Expect(r.URL.Query().Get("fieldManager")).To(Equal("cloud-service-broker"))
+
+				var object map[string]interface{}
+				json.NewDecoder(r.Body).Decode(&object)
+				objects[r.URL.Path] = object
+			case http.MethodGet:
+				object, ok := objects[r.URL.Path]
+				if !ok {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+				json.NewEncoder(w).Encode(object)
+			case http.MethodDelete:
+				if selector := r.URL.Query().Get("labelSelector"); selector != "" {
+					for path, object := range objects {
+						if strings.HasPrefix(path, r.URL.Path+"/") && labelSelected(object, selector) {
+							delete(objects, path)
+						}
+					}
+					return
+				}
+				if _, ok := objects[r.URL.Path]; !ok {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+				delete(objects, r.URL.Path)
+			}
+		}))
+
+		store, err = credstore.NewKubernetesStore(&config.KubernetesConfig{
+			APIServer: server.URL,
+			Namespace: "brokers",
+			TokenFile: filepath.Join(directory, "token"),
+		}, lager.NewLogger("test"))
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		server.Close()
+		os.RemoveAll(directory)
+	})
+
+	It("stores and deletes credentials in secrets", func() {
+		_, err := store.Put("/c/broker/service/binding/secrets", map[string]interface{}{"password": "secret"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(objects).To(HaveLen(1))
+		for path, object := range objects {
+			Expect(path).To(HavePrefix(secretsPath + "csb-c-broker-service-binding-secrets-"))
+			Expect(object).To(HaveKeyWithValue("kind", "Secret"))
+			Expect(object["metadata"]).To(HaveKeyWithValue("annotations", map[string]interface{}{
+				"cloud-service-broker/key": "/c/broker/service/binding/secrets",
+			}))
+		}
+
+		credentials, err := store.Get("/c/broker/service/binding/secrets")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(credentials).To(Equal(map[string]interface{}{"password": "secret"}))
+
+		Expect(store.Delete("/c/broker/service/binding/secrets")).To(Succeed())
+		Expect(objects).To(BeEmpty())
+		Expect(store.Delete("/c/broker/service/binding/secrets")).To(Succeed())
+
+		_, err = store.Get("/c/broker/service/binding/secrets")
+		Expect(errors.Is(err, credstore.ErrNotFound)).To(BeTrue())
+	})
+
+	It("stores values", func() {
+		_, err := store.PutValue("/c/broker/service/binding/value", "secret")
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(store.GetValue("/c/broker/service/binding/value")).To(Equal("secret"))
+	})
+
+	It("grants permissions with a role binding per actor", func() {
+		_, err := store.AddPermission("/c/broker/service/binding/secrets", "mtls-app:app-guid", []string{"read"})
+		Expect(err).NotTo(HaveOccurred())
+		_, err = store.AddPermission("/c/broker/service/binding/secrets", "mtls-app:other-app-guid", []string{"read", "delete"})
+		Expect(err).NotTo(HaveOccurred())
+		_, err = store.AddPermission("/c/broker/service/other-binding/secrets", "mtls-app:app-guid", []string{"read"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(objects).To(HaveLen(6))
+
+		var roles, bindings []map[string]interface{}
+		for path, object := range objects {
+			switch {
+			case strings.HasPrefix(path, rolesPath+"/"):
+				roles = append(roles, object)
+			case strings.HasPrefix(path, roleBindingsPath+"/"):
+				bindings = append(bindings, object)
+			}
+		}
+		Expect(roles).To(ContainElement(HaveKeyWithValue("rules", ConsistOf(SatisfyAll(
+			HaveKeyWithValue("resources", ConsistOf("secrets")),
+			HaveKeyWithValue("verbs", ConsistOf("get", "delete")),
+		)))))
+		Expect(bindings).To(ContainElement(HaveKeyWithValue("subjects", ConsistOf(
+			HaveKeyWithValue("name", "mtls-app:other-app-guid"),
+		))))
+
+		Expect(store.DeletePermission("/c/broker/service/binding/secrets")).To(Succeed())
+		Expect(objects).To(HaveLen(2))
+	})
+
+	It("reports errors from the API server", func() {
+		Expect(os.WriteFile(filepath.Join(directory, "token"), []byte("expired-token"), 0600)).To(Succeed())
+
+		_, err := store.GetValue("/c/broker/service/binding/value")
+		Expect(err).To(MatchError(ContainSubstring("kubernetes returned 401 Unauthorized")))
+	})
+})
@@ -0,0 +1,75 @@
+// Copyright 2021 the Service Broker Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package credstore
+
+import (
+	"time"
+
+	"code.cloudfoundry.org/credhub-cli/credhub/permissions"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/pkg/metrics"
+)
+
+type instrumentedStore struct {
+	store CredStore
+}
+
+// NewInstrumentedStore wraps the store so the latency of every call to it is
+// recorded in the metrics of the broker.
+func NewInstrumentedStore(store CredStore) CredStore {
+	return &instrumentedStore{store: store}
+}
+
+func (s *instrumentedStore) Put(key string, credentials interface{}) (result interface{}, err error) {
+	defer observe("put", time.Now())(&err)
+	return s.store.Put(key, credentials)
+}
+
+func (s *instrumentedStore) PutValue(key string, credentials interface{}) (result interface{}, err error) {
+	defer observe("put_value", time.Now())(&err)
+	return s.store.PutValue(key, credentials)
+}
+
+func (s *instrumentedStore) Get(key string) (result interface{}, err error) {
+	defer observe("get", time.Now())(&err)
+	return s.store.Get(key)
+}
+
+func (s *instrumentedStore) GetValue(key string) (result string, err error) {
+	defer observe("get_value", time.Now())(&err)
+	return s.store.GetValue(key)
+}
+
+func (s *instrumentedStore) Delete(key string) (err error) {
+	defer observe("delete", time.Now())(&err)
+	return s.store.Delete(key)
+}
+
+func (s *instrumentedStore) AddPermission(path string, actor string, ops []string) (result *permissions.Permission, err error) {
+	defer observe("add_permission", time.Now())(&err)
+	return s.store.AddPermission(path, actor, ops)
+}
+
+func (s *instrumentedStore) DeletePermission(path string) (err error) {
+	defer observe("delete_permission", time.Now())(&err)
+	return s.store.DeletePermission(path)
+}
+
+// observe returns a function that records a call which started at the given
+// time, with the outcome of the error it points to.
+func observe(method string, start time.Time) func(err *error) {
+	return func(err *error) {
+		metrics.CredStoreCallDuration.WithLabelValues(method, metrics.Outcome(*err)).Observe(metrics.Since(start))
+	}
+}
@@ -0,0 +1,240 @@
+// Copyright 2021 the Service Broker Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package credstore
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"code.cloudfoundry.org/credhub-cli/credhub/permissions"
+	"code.cloudfoundry.org/lager"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/pkg/config"
+)
+
+// vaultValueField is the field of the secret that value credentials are kept in.
+const vaultValueField = "value"
+
+// vaultCapabilities maps CredHub operations to Vault policy capabilities.
+var vaultCapabilities = map[string][]string{
+	"read":   {"read"},
+	"write":  {"create", "update"},
+	"delete": {"delete"},
+}
+
+var vaultPolicyNameReplacer = regexp.MustCompile(`[^a-z0-9-]+`)
+
+type vaultStore struct {
+	address string
+	token   string
+	mount   string
+	client  *http.Client
+	logger  lager.Logger
+}
+
+// NewVaultStore creates a store that keeps credentials in a HashiCorp Vault KV
+// version 2 secrets engine. Permissions are granted with an ACL policy per
+// credential, which the operator binds to the identity of the actor in Vault.
+func NewVaultStore(vaultConfig *config.VaultConfig, logger lager.Logger) (CredStore, error) {
+	if vaultConfig.Address == "" {
+		return nil, errors.New("an address is required for the vault credential store")
+	}
+
+	client, err := newHTTPClient(vaultConfig.CaCertFile, vaultConfig.SkipSSLValidation)
+	if err != nil {
+		return nil, err
+	}
+
+	mount := vaultConfig.Mount
+	if mount == "" {
+		mount = "secret"
+	}
+
+	return &vaultStore{
+		address: strings.TrimSuffix(vaultConfig.Address, "/"),
+		token:   vaultConfig.Token,
+		mount:   strings.Trim(mount, "/"),
+		client:  client,
+		logger:  logger,
+	}, nil
+}
+
+func (v *vaultStore) Put(key string, credentials interface{}) (interface{}, error) {
+	data, err := toJSONObject(credentials)
+	if err != nil {
+		return nil, err
+	}
+
+	return credentials, v.write(key, data)
+}
+
+func (v *vaultStore) PutValue(key string, credentials interface{}) (interface{}, error) {
+	value, ok := credentials.(string)
+	if !ok {
+		return nil, fmt.Errorf("value credentials must be a string, not %T", credentials)
+	}
+
+	return value, v.write(key, map[string]interface{}{vaultValueField: value})
+}
+
+func (v *vaultStore) Get(key string) (interface{}, error) {
+	var response struct {
+		Data struct {
+			Data map[string]interface{} `json:"data"`
+		} `json:"data"`
+	}
+	if err := v.do(http.MethodGet, v.dataPath(key), nil, &response); err != nil {
+		return nil, err
+	}
+	if response.Data.Data == nil {
+		// the latest version of the secret was deleted
+		return nil, notFoundError(key)
+	}
+
+	return response.Data.Data, nil
+}
+
+func (v *vaultStore) GetValue(key string) (string, error) {
+	data, err := v.Get(key)
+	if err != nil {
+		return "", err
+	}
+
+	value, ok := data.(map[string]interface{})[vaultValueField].(string)
+	if !ok {
+		return "", fmt.Errorf("credential %q is not a value", key)
+	}
+	return value, nil
+}
+
+// Delete removes every version of the secret, as CredHub does.
+func (v *vaultStore) Delete(key string) error {
+	return v.do(http.MethodDelete, fmt.Sprintf("/v1/%s/metadata/%s", v.mount, trimKey(key)), nil, nil)
+}
+
+func (v *vaultStore) AddPermission(path string, actor string, ops []string) (*permissions.Permission, error) {
+	capabilities, err := vaultPolicyCapabilities(ops)
+	if err != nil {
+		return nil, err
+	}
+
+	policy := fmt.Sprintf("# actor: %s\npath %q {\n  capabilities = [%s]\n}\n", actor, v.dataPath(path)[len("/v1/"):], quoteAll(capabilities))
+	if err := v.do(http.MethodPut, v.policyPath(path), map[string]interface{}{"policy": policy}, nil); err != nil {
+		return nil, err
+	}
+
+	return &permissions.Permission{Actor: actor, Operations: ops, Path: path}, nil
+}
+
+func (v *vaultStore) DeletePermission(path string) error {
+	return v.do(http.MethodDelete, v.policyPath(path), nil, nil)
+}
+
+func (v *vaultStore) write(key string, data map[string]interface{}) error {
+	return v.do(http.MethodPost, v.dataPath(key), map[string]interface{}{"data": data}, nil)
+}
+
+func (v *vaultStore) dataPath(key string) string {
+	return fmt.Sprintf("/v1/%s/data/%s", v.mount, trimKey(key))
+}
+
+// policyPath gets the path of the policy that grants permissions on the
+// credential, named after it so that it can be found again to be deleted.
+func (v *vaultStore) policyPath(key string) string {
+	name := "csb-" + vaultPolicyNameReplacer.ReplaceAllString(strings.ToLower(trimKey(key)), "-")
+	return "/v1/sys/policies/acl/" + name
+}
+
+// do calls the Vault API, decoding the response into result if it is set.
+// A missing secret or policy is reported as ErrNotFound, except when deleting.
+func (v *vaultStore) do(method, path string, body, result interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, v.address+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Vault-Token", v.token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error calling vault: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound && method == http.MethodDelete:
+		return nil
+	case resp.StatusCode == http.StatusNotFound:
+		return notFoundError(path)
+	case resp.StatusCode >= 300:
+		var errorResponse struct {
+			Errors []string `json:"errors"`
+		}
+		json.NewDecoder(resp.Body).Decode(&errorResponse)
+		return fmt.Errorf("vault returned %s for %s %s: %s", resp.Status, method, path, strings.Join(errorResponse.Errors, ", "))
+	case result == nil:
+		return nil
+	}
+
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+func vaultPolicyCapabilities(ops []string) ([]string, error) {
+	set := make(map[string]bool)
+	for _, op := range ops {
+		capabilities, ok := vaultCapabilities[op]
+		if !ok {
+			return nil, fmt.Errorf("unknown operation %q", op)
+		}
+		for _, c := range capabilities {
+			set[c] = true
+		}
+	}
+
+	var capabilities []string
+	for c := range set {
+		capabilities = append(capabilities, c)
+	}
+	sort.Strings(capabilities)
+	return capabilities, nil
+}
+
+func quoteAll(values []string) string {
+	quoted := make([]string, len(values))
+	for i, value := range values {
+		quoted[i] = fmt.Sprintf("%q", value)
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// trimKey gets the key without the leading slash that CredHub names have.
+func trimKey(key string) string {
+	return strings.TrimPrefix(key, "/")
+}
@@ -0,0 +1,138 @@
+// Copyright 2021 the Service Broker Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package credstore_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/pkg/config"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/pkg/credstore"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Vault Store", func() {
+	var (
+		server *httptest.Server
+		store  credstore.CredStore
+		mutex  sync.Mutex
+		// objects are the request bodies written to each path of the fake Vault
+		objects map[string]map[string]interface{}
+	)
+
+	BeforeEach(func() {
+		objects = make(map[string]map[string]interface{})
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			if r.Header.Get("X-Vault-Token") != "my-token" {
+				w.WriteHeader(http.StatusForbidden)
+				w.Write([]byte(`{"errors": ["permission denied"]}`))
+				return
+			}
+
+			switch r.Method {
+			case http.MethodPost, http.MethodPut:
+				var body map[string]interface{}
+				json.NewDecoder(r.Body).Decode(&body)
+				objects[r.URL.Path] = body
+			case http.MethodGet:
+				body, ok := objects[r.URL.Path]
+				if !ok {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+				json.NewEncoder(w).Encode(map[string]interface{}{"data": body})
+			case http.MethodDelete:
+				path := strings.Replace(r.URL.Path, "/metadata/", "/data/", 1)
+				if _, ok := objects[path]; !ok {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+				delete(objects, path)
+				w.WriteHeader(http.StatusNoContent)
+			}
+		}))
+
+		var err error
+		store, err = credstore.NewVaultStore(&config.VaultConfig{Address: server.URL, Token: "my-token", Mount: "kv"}, lager.NewLogger("test"))
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("stores and deletes credentials in the KV secrets engine", func() {
+		_, err := store.Put("/c/broker/service/binding/secrets", map[string]interface{}{"password": "secret"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(objects).To(HaveKeyWithValue("/v1/kv/data/c/broker/service/binding/secrets", map[string]interface{}{
+			"data": map[string]interface{}{"password": "secret"},
+		}))
+
+		credentials, err := store.Get("/c/broker/service/binding/secrets")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(credentials).To(Equal(map[string]interface{}{"password": "secret"}))
+
+		Expect(store.Delete("/c/broker/service/binding/secrets")).To(Succeed())
+		Expect(objects).To(BeEmpty())
+		Expect(store.Delete("/c/broker/service/binding/secrets")).To(Succeed())
+
+		_, err = store.Get("/c/broker/service/binding/secrets")
+		Expect(errors.Is(err, credstore.ErrNotFound)).To(BeTrue())
+	})
+
+	It("stores values", func() {
+		_, err := store.PutValue("/c/broker/service/binding/value", "secret")
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(store.GetValue("/c/broker/service/binding/value")).To(Equal("secret"))
+	})
+
+	It("grants permissions with a policy", func() {
+		_, err := store.AddPermission("/c/broker/service/binding/secrets", "mtls-app:app-guid", []string{"read", "write"})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(objects).To(HaveKeyWithValue("/v1/sys/policies/acl/csb-c-broker-service-binding-secrets", map[string]interface{}{
+			"policy": "# actor: mtls-app:app-guid\n" +
+				"path \"kv/data/c/broker/service/binding/secrets\" {\n" +
+				"  capabilities = [\"create\", \"read\", \"update\"]\n" +
+				"}\n",
+		}))
+
+		Expect(store.DeletePermission("/c/broker/service/binding/secrets")).To(Succeed())
+		Expect(objects).To(BeEmpty())
+	})
+
+	It("rejects unknown operations", func() {
+		_, err := store.AddPermission("/c/broker/service/binding/secrets", "mtls-app:app-guid", []string{"read_acl"})
+		Expect(err).To(MatchError(`unknown operation "read_acl"`))
+	})
+
+	It("reports errors from Vault", func() {
+		store, err := credstore.NewVaultStore(&config.VaultConfig{Address: server.URL, Token: "other-token"}, lager.NewLogger("test"))
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = store.Get("/c/broker/service/binding/secrets")
+		Expect(err).To(MatchError(ContainSubstring("vault returned 403 Forbidden for GET /v1/secret/data/c/broker/service/binding/secrets: permission denied")))
+	})
+})
@@ -0,0 +1,154 @@
+// Copyright 2021 the Service Broker Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package metrics holds the Prometheus metrics that the broker exposes on its
+// /metrics endpoint.
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "csb"
+
+// Outcomes of requests and operations.
+const (
+	Succeeded = "succeeded"
+	Failed    = "failed"
+)
+
+var (
+	// OSBRequests counts the Open Service Broker API requests the broker has
+	// handled.
+	OSBRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "osb",
+		Name:      "requests_total",
+		Help:      "Open Service Broker API requests handled, by verb, service, plan and outcome.",
+	}, []string{"verb", "service_id", "plan_id", "outcome"})
+
+	// OSBRequestDuration is how long the broker took to handle Open Service
+	// Broker API requests.
+	OSBRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "osb",
+		Name:      "request_duration_seconds",
+		Help:      "Time taken to handle Open Service Broker API requests, by verb, service and plan.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"verb", "service_id", "plan_id"})
+
+	// TerraformOperations counts the Terraform operations that have finished.
+	TerraformOperations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "terraform",
+		Name:      "operations_total",
+		Help:      "Terraform operations finished, by service, operation type and outcome.",
+	}, []string{"service_id", "operation", "outcome"})
+
+	// TerraformOperationDuration is how long Terraform operations ran for,
+	// not counting the time they were queued.
+	TerraformOperationDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "terraform",
+		Name:      "operation_duration_seconds",
+		Help:      "Time taken by Terraform operations, by service, operation type and outcome.",
+		Buckets:   prometheus.ExponentialBuckets(1, 2, 14),
+	}, []string{"service_id", "operation", "outcome"})
+
+	// TerraformJobsInProgress is how many Terraform operations are running
+	// or queued in this broker.
+	TerraformJobsInProgress = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "terraform",
+		Name:      "jobs_in_progress",
+		Help:      "Terraform operations running or queued in this broker, by service.",
+	}, []string{"service_id"})
+
+	// CredStoreCallDuration is how long calls to the credential store took.
+	CredStoreCallDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "credstore",
+		Name:      "call_duration_seconds",
+		Help:      "Time taken by calls to the credential store, by method and outcome.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "outcome"})
+
+	// EncryptionRotationInProgress is 1 while the database is being
+	// re-encrypted with a new primary password.
+	EncryptionRotationInProgress = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "database_encryption",
+		Name:      "rotation_in_progress",
+		Help:      "Whether the database is being re-encrypted with a new primary password.",
+	})
+
+	// EncryptionLastRotation is when the database was last re-encrypted.
+	EncryptionLastRotation = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "database_encryption",
+		Name:      "last_rotation_timestamp_seconds",
+		Help:      "Unix time at which this broker last finished re-encrypting the database.",
+	})
+
+	// EncryptionPrimary has a value of 1 for the label of the password that
+	// the database is encrypted with, or "none" if it is not encrypted.
+	EncryptionPrimary = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "database_encryption",
+		Name:      "primary",
+		Help:      "The label of the password the database is encrypted with.",
+	}, []string{"label"})
+
+	registry = prometheus.NewRegistry()
+)
+
+func init() {
+	registry.MustRegister(
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		OSBRequests,
+		OSBRequestDuration,
+		TerraformOperations,
+		TerraformOperationDuration,
+		TerraformJobsInProgress,
+		CredStoreCallDuration,
+		EncryptionRotationInProgress,
+		EncryptionLastRotation,
+		EncryptionPrimary,
+	)
+}
+
+// Handler serves the metrics in the Prometheus exposition format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// Outcome is the outcome label for a request or operation that returned the
+// error.
+func Outcome(err error) string {
+	if err != nil {
+		return Failed
+	}
+
+	return Succeeded
+}
+
+// Since is the time since start in seconds, for observing durations.
+func Since(start time.Time) float64 {
+	return time.Since(start).Seconds()
+}
@@ -18,6 +18,9 @@ import (
 	"fmt"
 	"os"
 	"path"
+	"regexp"
+	"strings"
+	"time"
 
 	"code.cloudfoundry.org/lager"
 	"github.com/cloudfoundry-incubator/cloud-service-broker/pkg/broker"
@@ -152,9 +155,28 @@ type TfServiceDefinitionV1 struct {
 	Examples          []broker.ServiceExample     `yaml:"examples"`
 	PlanUpdateable    bool                        `yaml:"plan_updateable"`
 
+	// MaxConcurrentOperations limits how many Terraform operations for the
+	// service can run at once, zero means there is no limit.
+	MaxConcurrentOperations int `yaml:"max_concurrent_operations,omitempty"`
+	// OperationTimeout is how long a Terraform operation for the service can
+	// run before it is stopped, as a duration such as "90m".
+	OperationTimeout string `yaml:"operation_timeout,omitempty"`
+	// PreventReplace lists the resource types, or resource addresses within
+	// the provision template, that updates must not replace or destroy.
+	PreventReplace []string `yaml:"prevent_replace,omitempty"`
+	// Retry is how applies and destroys for the service that fail with
+	// transient errors are retried.
+	Retry TfServiceDefinitionV1RetryPolicy `yaml:"retry,omitempty"`
+
 	// Internal SHOULD be set to true for Google maintained services.
 	Internal        bool `yaml:"-"`
 	RequiredEnvVars []string
+	// BrokerpakName is the name of the brokerpak the service was read from.
+	BrokerpakName string `yaml:"-"`
+	// BrokerpakVersion is the version of the brokerpak the service was read
+	// from. When it is a semantic version, it is the maintenance_info version
+	// of the plans that do not declare their own.
+	BrokerpakVersion string `yaml:"-"`
 }
 
 var _ validation.Validatable = (*TfServiceDefinitionV1)(nil)
@@ -176,6 +198,22 @@ func (tfb *TfServiceDefinitionV1) Validate() (errs *validation.FieldError) {
 		validation.ErrIfNotURL(tfb.SupportUrl, "support_url"),
 	)
 
+	if tfb.MaxConcurrentOperations < 0 {
+		errs = errs.Also(validation.ErrInvalidValue(tfb.MaxConcurrentOperations, "max_concurrent_operations"))
+	}
+
+	if tfb.OperationTimeout != "" {
+		if timeout, err := time.ParseDuration(tfb.OperationTimeout); err != nil || timeout < 0 {
+			errs = errs.Also(validation.ErrInvalidValue(tfb.OperationTimeout, "operation_timeout"))
+		}
+	}
+
+	for i, resource := range tfb.PreventReplace {
+		errs = errs.Also(validation.ErrIfBlank(resource, validation.CurrentField).ViaFieldIndex("prevent_replace", i))
+	}
+
+	errs = errs.Also(tfb.Retry.Validate().ViaField("retry"))
+
 	names := make(map[string]struct{})
 	ids := make(map[string]struct{})
 	for i, v := range tfb.Plans {
@@ -208,6 +246,36 @@ func (tfb *TfServiceDefinitionV1) resolveEnvVars() (map[string]string, error) {
 	return vars, nil
 }
 
+// OperationTimeoutProperty returns the Viper property name operators can set to
+// override the operation timeout of the service.
+func (tfb *TfServiceDefinitionV1) OperationTimeoutProperty() string {
+	return fmt.Sprintf("service.%s.operation_timeout", tfb.Name)
+}
+
+// resolveOperationTimeout gets the operation timeout of the service. The
+// operator's setting for the service takes precedence over the service
+// definition, which takes precedence over the operator's default for all
+// services.
+func (tfb *TfServiceDefinitionV1) resolveOperationTimeout() (time.Duration, error) {
+	timeout := viper.GetString(tfb.OperationTimeoutProperty())
+	if timeout == "" {
+		timeout = tfb.OperationTimeout
+	}
+	if timeout == "" {
+		timeout = viper.GetString(operationTimeoutKey)
+	}
+	if timeout == "" {
+		return 0, nil
+	}
+
+	d, err := time.ParseDuration(timeout)
+	if err != nil {
+		return 0, fmt.Errorf("invalid operation timeout for service %q: %w", tfb.Name, err)
+	}
+
+	return d, nil
+}
+
 func (tfb *TfServiceDefinitionV1) loadTemplates() error {
 	err := tfb.BindSettings.LoadTemplate(".")
 
@@ -234,9 +302,30 @@ func (tfb *TfServiceDefinitionV1) ToService(executor wrapper.TerraformExecutor)
 		return nil, err
 	}
 
+	operationTimeout, err := tfb.resolveOperationTimeout()
+	if err != nil {
+		return nil, err
+	}
+
+	retryPolicy, err := tfb.Retry.toRetryPolicy()
+	if err != nil {
+		return nil, err
+	}
+
+	stateStore, err := NewStateStoreFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	maintenanceInfo := tfb.maintenanceInfo()
 	var rawPlans []broker.ServicePlan
 	for _, plan := range tfb.Plans {
-		rawPlans = append(rawPlans, plan.ToPlan())
+		rawPlan := plan.ToPlan()
+		if rawPlan.MaintenanceInfo == nil && maintenanceInfo != nil {
+			info := *maintenanceInfo
+			rawPlan.MaintenanceInfo = &info
+		}
+		rawPlans = append(rawPlans, rawPlan)
 	}
 
 	// Bindings get special computed properties because the broker didn't
@@ -258,8 +347,25 @@ func (tfb *TfServiceDefinitionV1) ToService(executor wrapper.TerraformExecutor)
 		Name:      "tf_id",
 		Default:   "tf:${request.instance_id}:${request.binding_id}",
 		Overwrite: true,
+	}, varcontext.DefaultVariable{
+		Name:      planIdVariable,
+		Default:   "${request.plan_id}",
+		Overwrite: true,
+	}, varcontext.DefaultVariable{
+		Name:      credentialsSeedVariable,
+		Default:   "",
+		Overwrite: true,
+	}, varcontext.DefaultVariable{
+		Name:      previousCredentialsSeedVariable,
+		Default:   "",
+		Overwrite: true,
 	})
 
+	planLimits := make(map[string]int)
+	for _, plan := range tfb.Plans {
+		planLimits[plan.Id] = plan.MaxConcurrentOperations
+	}
+
 	constDefn := *tfb
 	return &broker.ServiceDefinition{
 		Id:               tfb.Id,
@@ -273,12 +379,17 @@ func (tfb *TfServiceDefinitionV1) ToService(executor wrapper.TerraformExecutor)
 		ImageUrl:         tfb.ImageUrl,
 		Tags:             tfb.Tags,
 		Plans:            rawPlans,
+		MaintenanceInfo:  maintenanceInfo,
 
 		ProvisionInputVariables: tfb.ProvisionSettings.UserInputs,
 		ProvisionComputedVariables: append(tfb.ProvisionSettings.Computed, varcontext.DefaultVariable{
 			Name:      "tf_id",
 			Default:   "tf:${request.instance_id}:",
 			Overwrite: true,
+		}, varcontext.DefaultVariable{
+			Name:      planIdVariable,
+			Default:   "${request.plan_id}",
+			Overwrite: true,
 		}),
 		BindInputVariables:    tfb.BindSettings.UserInputs,
 		BindComputedVariables: bindComputed,
@@ -288,11 +399,115 @@ func (tfb *TfServiceDefinitionV1) ToService(executor wrapper.TerraformExecutor)
 		ProviderBuilder: func(logger lager.Logger) broker.ServiceProvider {
 			jobRunner := NewTfJobRunnerForProject(envVars)
 			jobRunner.Executor = executor
+			jobRunner.ServiceId = constDefn.Id
+			jobRunner.ServiceLimit = constDefn.MaxConcurrentOperations
+			jobRunner.PlanLimits = planLimits
+			jobRunner.OperationTimeout = operationTimeout
+			jobRunner.StateStore = stateStore
+			jobRunner.PreventReplace = constDefn.PreventReplace
+			jobRunner.RetryPolicy = retryPolicy
 			return NewTerraformProvider(jobRunner, logger, constDefn)
 		},
 	}, nil
 }
 
+// maintenanceInfo gets the maintenance_info of plans that do not declare their
+// own, nil if the brokerpak version is not a semantic version as OSB requires.
+func (tfb *TfServiceDefinitionV1) maintenanceInfo() *domain.MaintenanceInfo {
+	if tfb.BrokerpakVersion == "" || validation.ErrIfNotSemver(tfb.BrokerpakVersion, "") != nil {
+		return nil
+	}
+
+	return &domain.MaintenanceInfo{Version: tfb.BrokerpakVersion}
+}
+
+// provenance gets the provenance of workspaces created from the module, which
+// is made from the templates of one of the actions of the service.
+func (tfb *TfServiceDefinitionV1) provenance(module wrapper.ModuleDefinition) *wrapper.Provenance {
+	return &wrapper.Provenance{
+		BrokerpakName:    tfb.BrokerpakName,
+		BrokerpakVersion: tfb.BrokerpakVersion,
+		ServiceId:        tfb.Id,
+		TemplateDigest:   module.Digest(),
+	}
+}
+
+// TfServiceDefinitionV1RetryPolicy describes how Terraform operations that fail
+// with transient errors are retried.
+type TfServiceDefinitionV1RetryPolicy struct {
+	// MaxAttempts is how many times an operation is attempted in total, zero
+	// or one means operations are not retried.
+	MaxAttempts int `yaml:"max_attempts,omitempty"`
+	// Backoff is the wait before the first retry, as a duration such as "30s".
+	// It doubles for each retry after that.
+	Backoff string `yaml:"backoff,omitempty"`
+	// MaxBackoff is the longest wait between retries.
+	MaxBackoff string `yaml:"max_backoff,omitempty"`
+	// RetryableErrors are regular expressions that match the errors that are
+	// retried, other errors fail the operation straight away.
+	RetryableErrors []string `yaml:"retryable_errors,omitempty"`
+}
+
+var _ validation.Validatable = (*TfServiceDefinitionV1RetryPolicy)(nil)
+
+// Validate implements validation.Validatable.
+func (policy *TfServiceDefinitionV1RetryPolicy) Validate() (errs *validation.FieldError) {
+	if policy.MaxAttempts < 0 {
+		errs = errs.Also(validation.ErrInvalidValue(policy.MaxAttempts, "max_attempts"))
+	}
+
+	if policy.MaxAttempts > 1 && len(policy.RetryableErrors) == 0 {
+		errs = errs.Also(validation.ErrMissingField("retryable_errors"))
+	}
+
+	if policy.Backoff != "" {
+		if d, err := time.ParseDuration(policy.Backoff); err != nil || d < 0 {
+			errs = errs.Also(validation.ErrInvalidValue(policy.Backoff, "backoff"))
+		}
+	}
+
+	if policy.MaxBackoff != "" {
+		if d, err := time.ParseDuration(policy.MaxBackoff); err != nil || d < 0 {
+			errs = errs.Also(validation.ErrInvalidValue(policy.MaxBackoff, "max_backoff"))
+		}
+	}
+
+	for i, expr := range policy.RetryableErrors {
+		if _, err := regexp.Compile(expr); err != nil {
+			errs = errs.Also(validation.ErrInvalidValue(expr, validation.CurrentField).ViaFieldIndex("retryable_errors", i))
+		}
+	}
+
+	return errs
+}
+
+// toRetryPolicy converts the policy into the form used by TfJobRunner.
+func (policy *TfServiceDefinitionV1RetryPolicy) toRetryPolicy() (RetryPolicy, error) {
+	result := RetryPolicy{MaxAttempts: policy.MaxAttempts}
+
+	var err error
+	if policy.Backoff != "" {
+		if result.Backoff, err = time.ParseDuration(policy.Backoff); err != nil {
+			return RetryPolicy{}, fmt.Errorf("invalid retry backoff: %w", err)
+		}
+	}
+	if policy.MaxBackoff != "" {
+		if result.MaxBackoff, err = time.ParseDuration(policy.MaxBackoff); err != nil {
+			return RetryPolicy{}, fmt.Errorf("invalid retry max backoff: %w", err)
+		}
+	}
+
+	for _, expr := range policy.RetryableErrors {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return RetryPolicy{}, fmt.Errorf("invalid retryable error: %w", err)
+		}
+		result.RetryableErrors = append(result.RetryableErrors, re)
+	}
+
+	return result, nil
+}
+
 // TfServiceDefinitionV1Plan represents a service plan in a human-friendly format
 // that can be converted into an OSB compatible plan.
 type TfServiceDefinitionV1Plan struct {
@@ -305,18 +520,43 @@ type TfServiceDefinitionV1Plan struct {
 	Properties         map[string]interface{} `yaml:"properties"`
 	ProvisionOverrides map[string]interface{} `yaml:"provision_overrides,omitempty"`
 	BindOverrides      map[string]interface{} `yaml:"bind_overrides,omitempty"`
+
+	// MaxConcurrentOperations limits how many Terraform operations for the
+	// plan can run at once, zero means there is no limit.
+	MaxConcurrentOperations int `yaml:"max_concurrent_operations,omitempty"`
+	// MaintenanceInfo overrides the maintenance_info the plan gets from the
+	// version of the brokerpak.
+	MaintenanceInfo *TfServiceDefinitionV1PlanMaintenanceInfo `yaml:"maintenance_info,omitempty"`
+}
+
+// TfServiceDefinitionV1PlanMaintenanceInfo is the OSB maintenance_info of a
+// plan. Instances whose version differs from the plan's can be upgraded,
+// which applies the current templates of the service to them.
+type TfServiceDefinitionV1PlanMaintenanceInfo struct {
+	Version     string `yaml:"version"`
+	Description string `yaml:"description,omitempty"`
 }
 
 var _ validation.Validatable = (*TfServiceDefinitionV1Plan)(nil)
 
 // Validate implements validation.Validatable.
 func (plan *TfServiceDefinitionV1Plan) Validate() (errs *validation.FieldError) {
-	return errs.Also(
+	errs = errs.Also(
 		validation.ErrIfBlank(plan.Name, "name"),
 		validation.ErrIfNotUUID(plan.Id, "id"),
 		validation.ErrIfBlank(plan.Description, "description"),
 		validation.ErrIfBlank(plan.DisplayName, "display_name"),
 	)
+
+	if plan.MaxConcurrentOperations < 0 {
+		errs = errs.Also(validation.ErrInvalidValue(plan.MaxConcurrentOperations, "max_concurrent_operations"))
+	}
+
+	if plan.MaintenanceInfo != nil {
+		errs = errs.Also(validation.ErrIfNotSemver(plan.MaintenanceInfo.Version, "maintenance_info.version"))
+	}
+
+	return errs
 }
 
 // ToPlan converts this plan definition to a broker.ServicePlan.
@@ -332,6 +572,13 @@ func (plan *TfServiceDefinitionV1Plan) ToPlan() broker.ServicePlan {
 		},
 	}
 
+	if plan.MaintenanceInfo != nil {
+		masterPlan.MaintenanceInfo = &domain.MaintenanceInfo{
+			Version:     plan.MaintenanceInfo.Version,
+			Description: plan.MaintenanceInfo.Description,
+		}
+	}
+
 	return broker.ServicePlan{
 		ServicePlan:        masterPlan,
 		ServiceProperties:  plan.Properties,
@@ -515,6 +762,21 @@ func generateTfId(instanceId, bindingId string) string {
 	return fmt.Sprintf("tf:%s:%s", instanceId, bindingId)
 }
 
+// planIdVariable is a computed variable holding the plan of the request, which
+// is used to apply the plan's limit on concurrent operations.
+const planIdVariable = "tf_plan_id"
+
+// parseTfId splits an id created by generateTfId into its instance and binding
+// ids. The binding id is empty for the ids of instances.
+func parseTfId(tfId string) (instanceId, bindingId string, err error) {
+	parts := strings.Split(tfId, ":")
+	if len(parts) != 3 || parts[0] != "tf" {
+		return "", "", fmt.Errorf("malformed terraform deployment id %q", tfId)
+	}
+
+	return parts[1], parts[2], nil
+}
+
 // ImportVariable Variable definition for TF import support
 type ImportVariable struct {
 	Name       string `yaml:"field_name"`
@@ -20,11 +20,13 @@ import (
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/cloudfoundry-incubator/cloud-service-broker/pkg/broker"
 	"github.com/cloudfoundry-incubator/cloud-service-broker/pkg/varcontext"
 	. "github.com/onsi/gomega"
 	"github.com/pivotal-cf/brokerapi/v8/domain"
+	"github.com/spf13/viper"
 	"gopkg.in/yaml.v3"
 )
 
@@ -627,6 +629,28 @@ func TestTfServiceDefinitionV1Plan_ToPlan(t *testing.T) {
 				},
 				ServiceProperties: map[string]interface{}{"domain": "example.com"}},
 		},
+		"maintenance-info": {
+			Definition: TfServiceDefinitionV1Plan{
+				Id:   "00000000-0000-0000-0000-000000000001",
+				Name: "example-email-plan",
+				MaintenanceInfo: &TfServiceDefinitionV1PlanMaintenanceInfo{
+					Version:     "1.2.0",
+					Description: "Adds IPv6 support.",
+				},
+			},
+			Expected: broker.ServicePlan{
+				ServicePlan: domain.ServicePlan{
+					ID:       "00000000-0000-0000-0000-000000000001",
+					Name:     "example-email-plan",
+					Free:     domain.FreeValue(false),
+					Metadata: &domain.ServicePlanMetadata{},
+					MaintenanceInfo: &domain.MaintenanceInfo{
+						Version:     "1.2.0",
+						Description: "Adds IPv6 support.",
+					},
+				},
+			},
+		},
 	}
 
 	for tn, tc := range cases {
@@ -743,6 +767,11 @@ func TestTfServiceDefinitionV1_ToService(t *testing.T) {
 				Default:   "tf:${request.instance_id}:",
 				Overwrite: true,
 			},
+			{
+				Name:      "tf_plan_id",
+				Default:   "${request.plan_id}",
+				Overwrite: true,
+			},
 		}, service.ProvisionComputedVariables)
 		expectEqual("PlanVariables", append(definition.ProvisionSettings.PlanInputs, definition.BindSettings.PlanInputs...), service.PlanVariables)
 		expectEqual("BindInputVariables", definition.BindSettings.UserInputs, service.BindInputVariables)
@@ -750,6 +779,9 @@ func TestTfServiceDefinitionV1_ToService(t *testing.T) {
 			{Name: "plan-input-bind", Default: "${request.plan_properties[\"plan-input-bind\"]}", Overwrite: true, Type: "integer"},
 			{Name: "computed-input-bind", Default: "", Overwrite: false, Type: ""},
 			{Name: "tf_id", Default: "tf:${request.instance_id}:${request.binding_id}", Overwrite: true, Type: ""},
+			{Name: "tf_plan_id", Default: "${request.plan_id}", Overwrite: true, Type: ""},
+			{Name: "tf_credentials_seed", Default: "", Overwrite: true, Type: ""},
+			{Name: "tf_previous_credentials_seed", Default: "", Overwrite: true, Type: ""},
 		}, service.BindComputedVariables)
 		expectEqual("BindOutputVariables", append(definition.ProvisionSettings.Outputs, definition.BindSettings.Outputs...), service.BindOutputVariables)
 	})
@@ -765,6 +797,69 @@ func TestTfServiceDefinitionV1_ToService(t *testing.T) {
 	})
 }
 
+func TestTfServiceDefinitionV1_ToService_MaintenanceInfo(t *testing.T) {
+	cases := map[string]struct {
+		BrokerpakVersion string
+		Expected         []*domain.MaintenanceInfo
+	}{
+		"semver": {
+			BrokerpakVersion: "1.1.0",
+			Expected: []*domain.MaintenanceInfo{
+				{Version: "1.1.0"},
+				{Version: "2.0.0", Description: "Moves to a regional instance."},
+			},
+		},
+		"not semver": {
+			BrokerpakVersion: "v1",
+			Expected: []*domain.MaintenanceInfo{
+				nil,
+				{Version: "2.0.0", Description: "Moves to a regional instance."},
+			},
+		},
+	}
+
+	for tn, tc := range cases {
+		t.Run(tn, func(t *testing.T) {
+			definition := TfServiceDefinitionV1{
+				Version:          1,
+				Id:               "d34705c8-3edf-4ab8-93b3-d97f080da24c",
+				Name:             "my-service-name",
+				Description:      "my-service-description",
+				DisplayName:      "My Service Name",
+				ImageUrl:         "https://example.com/image.png",
+				SupportUrl:       "https://example.com/support",
+				DocumentationUrl: "https://example.com/docs",
+				BrokerpakVersion: tc.BrokerpakVersion,
+				Plans: []TfServiceDefinitionV1Plan{
+					{
+						Id:          "e4e6d2e4-3a0a-11ec-9a4a-3f5e23a1cbb8",
+						Name:        "default",
+						Description: "A plan without maintenance info.",
+						DisplayName: "Default",
+					},
+					{
+						Id:          "ec2d1bba-3a0a-11ec-8c5d-7b2b0cbd4b8f",
+						Name:        "declared",
+						Description: "A plan with its own maintenance info.",
+						DisplayName: "Declared",
+						MaintenanceInfo: &TfServiceDefinitionV1PlanMaintenanceInfo{
+							Version:     "2.0.0",
+							Description: "Moves to a regional instance.",
+						},
+					},
+				},
+			}
+
+			service, err := definition.ToService(nil)
+			g := NewGomegaWithT(t)
+			g.Expect(err).NotTo(HaveOccurred())
+			g.Expect(service.Plans).To(HaveLen(2))
+			g.Expect(service.Plans[0].MaintenanceInfo).To(Equal(tc.Expected[0]))
+			g.Expect(service.Plans[1].MaintenanceInfo).To(Equal(tc.Expected[1]))
+		})
+	}
+}
+
 func TestTfServiceDefinitionV1_Validate(t *testing.T) {
 	t.Run("duplicate plan name", func(t *testing.T) {
 		s := TfServiceDefinitionV1{
@@ -793,6 +888,68 @@ func TestTfServiceDefinitionV1_Validate(t *testing.T) {
 			"duplicated value, must be unique: ae3a8ac4-b269-11eb-b8f9-e317511cded7: plans[2].Id\n",
 		)))
 	})
+
+	t.Run("negative concurrency limits", func(t *testing.T) {
+		s := TfServiceDefinitionV1{
+			MaxConcurrentOperations: -1,
+			Plans: []TfServiceDefinitionV1Plan{
+				{MaxConcurrentOperations: -2},
+			},
+		}
+
+		err := s.Validate()
+		NewGomegaWithT(t).Expect(err).To(MatchError(ContainSubstring("invalid value: -1: max_concurrent_operations\n")))
+		NewGomegaWithT(t).Expect(err).To(MatchError(ContainSubstring("invalid value: -2: plans[0].max_concurrent_operations\n")))
+	})
+
+	t.Run("blank prevent_replace resource", func(t *testing.T) {
+		s := TfServiceDefinitionV1{PreventReplace: []string{"google_sql_database_instance", ""}}
+
+		NewGomegaWithT(t).Expect(s.Validate()).To(MatchError(ContainSubstring(
+			"prevent_replace[1]",
+		)))
+	})
+
+	t.Run("invalid retry policy", func(t *testing.T) {
+		s := TfServiceDefinitionV1{Retry: TfServiceDefinitionV1RetryPolicy{
+			MaxAttempts:     -1,
+			Backoff:         "soon",
+			RetryableErrors: []string{"RequestLimitExceeded", "("},
+		}}
+
+		err := s.Validate()
+		NewGomegaWithT(t).Expect(err).To(MatchError(ContainSubstring("invalid value: -1: retry.max_attempts\n")))
+		NewGomegaWithT(t).Expect(err).To(MatchError(ContainSubstring("invalid value: soon: retry.backoff\n")))
+		NewGomegaWithT(t).Expect(err).To(MatchError(ContainSubstring("retry.retryable_errors[1]")))
+	})
+
+	t.Run("retries without retryable errors", func(t *testing.T) {
+		s := TfServiceDefinitionV1{Retry: TfServiceDefinitionV1RetryPolicy{MaxAttempts: 3}}
+
+		NewGomegaWithT(t).Expect(s.Validate()).To(MatchError(ContainSubstring(
+			"retry.retryable_errors",
+		)))
+	})
+
+	t.Run("invalid maintenance info version", func(t *testing.T) {
+		s := TfServiceDefinitionV1{
+			Plans: []TfServiceDefinitionV1Plan{
+				{MaintenanceInfo: &TfServiceDefinitionV1PlanMaintenanceInfo{Version: "latest"}},
+			},
+		}
+
+		NewGomegaWithT(t).Expect(s.Validate()).To(MatchError(ContainSubstring(
+			"plans[0].maintenance_info.version",
+		)))
+	})
+
+	t.Run("invalid operation timeout", func(t *testing.T) {
+		s := TfServiceDefinitionV1{OperationTimeout: "forever"}
+
+		NewGomegaWithT(t).Expect(s.Validate()).To(MatchError(ContainSubstring(
+			"invalid value: forever: operation_timeout\n",
+		)))
+	})
 }
 
 func TestTfCatalogDefinitionV1_Validate(t *testing.T) {
@@ -841,3 +998,42 @@ func TestTfCatalogDefinitionV1_Validate(t *testing.T) {
 		)))
 	})
 }
+
+func TestParseTfId(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	instanceId, bindingId, err := parseTfId(generateTfId("instance", "binding"))
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(instanceId).To(Equal("instance"))
+	g.Expect(bindingId).To(Equal("binding"))
+
+	_, _, err = parseTfId("instance")
+	g.Expect(err).To(MatchError(`malformed terraform deployment id "instance"`))
+}
+
+func TestTfServiceDefinitionV1_ResolveOperationTimeout(t *testing.T) {
+	cases := map[string]struct {
+		definition      string
+		serviceOverride string
+		globalDefault   string
+		expected        time.Duration
+	}{
+		"none":             {expected: 0},
+		"global default":   {globalDefault: "2h", expected: 2 * time.Hour},
+		"definition":       {definition: "30m", globalDefault: "2h", expected: 30 * time.Minute},
+		"service override": {definition: "30m", serviceOverride: "45m", globalDefault: "2h", expected: 45 * time.Minute},
+	}
+
+	for tn, tc := range cases {
+		t.Run(tn, func(t *testing.T) {
+			g := NewGomegaWithT(t)
+			s := TfServiceDefinitionV1{Name: "my-service", OperationTimeout: tc.definition}
+
+			viper.Set(s.OperationTimeoutProperty(), tc.serviceOverride)
+			viper.Set(operationTimeoutKey, tc.globalDefault)
+			defer viper.Reset()
+
+			g.Expect(s.resolveOperationTimeout()).To(Equal(tc.expected))
+		})
+	}
+}
@@ -0,0 +1,193 @@
+// Copyright 2021 the Service Broker Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tf
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/db_service"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/db_service/models"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/pkg/broker"
+	"github.com/spf13/viper"
+)
+
+const (
+	driftCheckIntervalKey = "terraform.drift_check_interval"
+
+	// DriftNone, DriftDetected and DriftCheckFailed are the results of drift
+	// checks recorded on deployments.
+	DriftNone        = "none"
+	DriftDetected    = "drifted"
+	DriftCheckFailed = "failed"
+)
+
+func init() {
+	viper.BindEnv(driftCheckIntervalKey, "TF_DRIFT_CHECK_INTERVAL")
+}
+
+// CheckDrift runs `terraform plan -refresh-only` on the deployment to find
+// changes made to its resources outside of the broker, and records the result
+// on the deployment. Neither the state nor the resources are changed.
+//
+// It fails with ErrDeploymentLocked if an operation is running on the
+// deployment. If the check itself fails, that is recorded on the deployment,
+// which is returned along with the error.
+func (runner *TfJobRunner) CheckDrift(ctx context.Context, id string) (*models.TerraformDeployment, error) {
+	lock, err := lockDeployment(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	defer lock.release()
+
+	deployment, err := db_service.GetTerraformDeploymentById(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("error getting TF deployment: %w", err)
+	}
+
+	workspace, err := runner.hydrateWorkspace(ctx, deployment)
+	if err != nil {
+		return nil, err
+	}
+
+	summary, checkErr := workspace.DetectDrift(ctx)
+
+	now := time.Now()
+	deployment.DriftCheckedAt = &now
+	switch {
+	case checkErr != nil:
+		deployment.DriftStatus = DriftCheckFailed
+		deployment.DriftMessage = checkErr.Error()
+	case summary.HasChanges():
+		deployment.DriftStatus = DriftDetected
+		deployment.DriftMessage = summary.String()
+	default:
+		deployment.DriftStatus = DriftNone
+		deployment.DriftMessage = ""
+	}
+
+	if err := db_service.SaveTerraformDeployment(ctx, deployment); err != nil {
+		return nil, err
+	}
+
+	return deployment, checkErr
+}
+
+// CheckAllDrift checks every deployment that has resources for drift, one at
+// a time. Deployments that were checked after checkedBefore, and deployments
+// that have operations running on them, are skipped. The deployments that were
+// checked are returned, including those where the check failed.
+func CheckAllDrift(ctx context.Context, registry broker.BrokerRegistry, checkedBefore time.Time, logger lager.Logger) ([]models.TerraformDeployment, error) {
+	deployments, err := db_service.GetTerraformDeployments(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error listing deployments: %w", err)
+	}
+
+	var checked []models.TerraformDeployment
+	for _, deployment := range deployments {
+		if !hasResources(deployment) || (deployment.DriftCheckedAt != nil && deployment.DriftCheckedAt.After(checkedBefore)) {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return checked, err
+		}
+
+		data := lager.Data{"deployment": deployment.ID}
+		runner, err := NewTfJobRunnerForDeployment(ctx, registry, deployment.ID, logger)
+		if err != nil {
+			logger.Error("check-drift", err, data)
+			continue
+		}
+
+		result, err := runner.CheckDrift(ctx, deployment.ID)
+		switch {
+		case errors.Is(err, ErrDeploymentLocked):
+			continue
+		case result == nil:
+			logger.Error("check-drift", err, data)
+			continue
+		case err != nil:
+			logger.Error("check-drift", err, data)
+		}
+
+		checked = append(checked, *result)
+	}
+
+	return checked, nil
+}
+
+// StartDriftSweeper checks deployments for drift in the background at the
+// configured interval until the context is done. It does nothing if no
+// interval is configured. Brokers sharing a database skip the deployments
+// that another broker has checked recently.
+func StartDriftSweeper(ctx context.Context, registry broker.BrokerRegistry, logger lager.Logger) error {
+	interval, err := driftCheckInterval()
+	if err != nil || interval == 0 {
+		return err
+	}
+
+	logger = logger.Session("drift-sweeper")
+	logger.Info("starting", lager.Data{"interval": interval.String()})
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				checked, err := CheckAllDrift(ctx, registry, time.Now().Add(-interval/2), logger)
+				if err != nil {
+					logger.Error("sweep", err)
+				}
+				logger.Info("swept", lager.Data{"checked": len(checked)})
+			}
+		}
+	}()
+
+	return nil
+}
+
+func driftCheckInterval() (time.Duration, error) {
+	value := viper.GetString(driftCheckIntervalKey)
+	if value == "" {
+		return 0, nil
+	}
+
+	interval, err := time.ParseDuration(value)
+	if err != nil || interval < 0 {
+		return 0, fmt.Errorf("invalid drift check interval %q", value)
+	}
+
+	return interval, nil
+}
+
+// hasResources reports whether the deployment may have resources that can
+// drift: no operation is in progress on it and it has not been deprovisioned.
+func hasResources(deployment models.TerraformDeployment) bool {
+	switch {
+	case deployment.LastOperationState == InProgress:
+		return false
+	case deployment.LastOperationType == models.DeprovisionOperationType && deployment.LastOperationState == Succeeded:
+		return false
+	default:
+		return true
+	}
+}
@@ -0,0 +1,177 @@
+// Copyright 2021 the Service Broker Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tf
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/cloudfoundry-incubator/cloud-service-broker/db_service"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/db_service/models"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/pkg/providers/tf/wrapper"
+	. "github.com/onsi/gomega"
+	"github.com/spf13/viper"
+)
+
+func TestTfJobRunner_CheckDrift(t *testing.T) {
+	cases := map[string]struct {
+		version         string
+		showOutput      string
+		showError       error
+		expectedStatus  string
+		expectedMessage string
+		expectedError   string
+	}{
+		"no drift": {
+			showOutput:     `{"resource_drift":[]}`,
+			expectedStatus: DriftNone,
+		},
+		"drifted": {
+			showOutput:      `{"resource_drift":[{"address":"google_sql_database_instance.instance","change":{"actions":["update"]}}]}`,
+			expectedStatus:  DriftDetected,
+			expectedMessage: `{"create":[],"update":["google_sql_database_instance.instance"],"replace":[],"destroy":[]}`,
+		},
+		"check failed": {
+			showError:       errors.New("credentials expired"),
+			expectedStatus:  DriftCheckFailed,
+			expectedMessage: "credentials expired",
+			expectedError:   "credentials expired",
+		},
+		"terraform too old": {
+			version:         "Terraform v0.12.30\n",
+			expectedStatus:  DriftCheckFailed,
+			expectedMessage: "drift detection requires Terraform 0.15.4 or later, but the workspace uses Terraform 0.12.30",
+			expectedError:   "drift detection requires Terraform 0.15.4 or later",
+		},
+	}
+
+	for tn, tc := range cases {
+		t.Run(tn, func(t *testing.T) {
+			g := NewGomegaWithT(t)
+			ctx := context.Background()
+			deployment := setupUpdatableDeployment(g)
+
+			version := "Terraform v0.15.4\non linux_amd64\n"
+			if tc.version != "" {
+				version = tc.version
+			}
+
+			var planArgs []string
+			runner := NewTfJobRunnerForProject(map[string]string{})
+			runner.Executor = func(ctx context.Context, cmd *exec.Cmd) (wrapper.ExecutionOutput, error) {
+				switch cmd.Args[1] {
+				case "version":
+					return wrapper.ExecutionOutput{StdOut: version}, nil
+				case "plan":
+					planArgs = cmd.Args
+				case "show":
+					return wrapper.ExecutionOutput{StdOut: tc.showOutput}, tc.showError
+				}
+				return wrapper.ExecutionOutput{}, nil
+			}
+
+			result, err := runner.CheckDrift(ctx, deployment.ID)
+			if tc.expectedError != "" {
+				g.Expect(err).To(MatchError(ContainSubstring(tc.expectedError)))
+			} else {
+				g.Expect(err).NotTo(HaveOccurred())
+			}
+			if tc.version == "" {
+				g.Expect(planArgs).To(ContainElement("-refresh-only"))
+			} else {
+				g.Expect(planArgs).To(BeEmpty(), "older versions of Terraform should not be asked to plan")
+			}
+
+			g.Expect(result).NotTo(BeNil())
+			saved, err := db_service.GetTerraformDeploymentById(ctx, deployment.ID)
+			g.Expect(err).NotTo(HaveOccurred())
+			for _, d := range []*models.TerraformDeployment{result, saved} {
+				g.Expect(d.DriftStatus).To(Equal(tc.expectedStatus))
+				g.Expect(d.DriftMessage).To(ContainSubstring(tc.expectedMessage))
+				g.Expect(d.DriftCheckedAt).NotTo(BeNil())
+			}
+			g.Expect(saved.LastOperationType).To(Equal(models.ProvisionOperationType), "checking drift is not an operation")
+			g.Expect(db_service.IsTerraformDeploymentLocked(ctx, deployment.ID)).To(BeFalse())
+		})
+	}
+
+	t.Run("locked", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		ctx := context.Background()
+		deployment := setupUpdatableDeployment(g)
+
+		lock, err := lockDeployment(ctx, deployment.ID)
+		g.Expect(err).NotTo(HaveOccurred())
+		defer lock.release()
+
+		runner := NewTfJobRunnerForProject(map[string]string{})
+		_, err = runner.CheckDrift(ctx, deployment.ID)
+		g.Expect(errors.Is(err, ErrDeploymentLocked)).To(BeTrue())
+	})
+}
+
+func TestHasResources(t *testing.T) {
+	cases := map[string]struct {
+		operationType  string
+		operationState string
+		expected       bool
+	}{
+		"provisioned":           {operationType: models.ProvisionOperationType, operationState: Succeeded, expected: true},
+		"failed to provision":   {operationType: models.ProvisionOperationType, operationState: Failed, expected: true},
+		"updating":              {operationType: models.UpdateOperationType, operationState: InProgress, expected: false},
+		"deprovisioned":         {operationType: models.DeprovisionOperationType, operationState: Succeeded, expected: false},
+		"failed to deprovision": {operationType: models.DeprovisionOperationType, operationState: Failed, expected: true},
+	}
+
+	for tn, tc := range cases {
+		t.Run(tn, func(t *testing.T) {
+			g := NewGomegaWithT(t)
+			deployment := models.TerraformDeployment{LastOperationType: tc.operationType, LastOperationState: tc.operationState}
+			g.Expect(hasResources(deployment)).To(Equal(tc.expected))
+		})
+	}
+}
+
+func TestDriftCheckInterval(t *testing.T) {
+	cases := map[string]struct {
+		value         string
+		expected      time.Duration
+		expectedError string
+	}{
+		"unset":    {},
+		"valid":    {value: "6h", expected: 6 * time.Hour},
+		"invalid":  {value: "daily", expectedError: `invalid drift check interval "daily"`},
+		"negative": {value: "-1h", expectedError: `invalid drift check interval "-1h"`},
+	}
+
+	for tn, tc := range cases {
+		t.Run(tn, func(t *testing.T) {
+			g := NewGomegaWithT(t)
+			defer viper.Reset()
+			viper.Set(driftCheckIntervalKey, tc.value)
+
+			interval, err := driftCheckInterval()
+			if tc.expectedError != "" {
+				g.Expect(err).To(MatchError(tc.expectedError))
+			} else {
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(interval).To(Equal(tc.expected))
+			}
+		})
+	}
+}
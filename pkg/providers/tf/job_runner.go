@@ -18,14 +18,19 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"code.cloudfoundry.org/lager"
 	"github.com/cloudfoundry-incubator/cloud-service-broker/db_service"
 	"github.com/cloudfoundry-incubator/cloud-service-broker/db_service/models"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/pkg/metrics"
 	"github.com/cloudfoundry-incubator/cloud-service-broker/pkg/providers/tf/wrapper"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/pkg/tracing"
 	"github.com/cloudfoundry-incubator/cloud-service-broker/utils"
 	"github.com/cloudfoundry-incubator/cloud-service-broker/utils/correlation"
+	"github.com/spf13/viper"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 const (
@@ -34,15 +39,35 @@ const (
 	Failed     = "failed"
 )
 
+// cancellationPollInterval is how often running operations check whether an
+// operator has asked for them to be cancelled.
+var cancellationPollInterval = 10 * time.Second
+
+// importOperationType is recorded on the jobs of imports so that they are not
+// mistaken for provisions, which unlike imports can be safely restarted.
+const importOperationType = "import"
+
+// planOperationType is recorded for previews of updates, which plan changes
+// without applying them.
+const planOperationType = "plan"
+
 // NewTfJobRunerFromEnv creates a new TfJobRunner with default configuration values.
 func NewTfJobRunerFromEnv() (*TfJobRunner, error) {
-	return NewTfJobRunnerForProject(map[string]string{}), nil
+	stateStore, err := NewStateStoreFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	runner := NewTfJobRunnerForProject(map[string]string{})
+	runner.StateStore = stateStore
+	return runner, nil
 }
 
 // NewTfJobRunnerForProject constructs a new JobRunner for the given project.
 func NewTfJobRunnerForProject(envVars map[string]string) *TfJobRunner {
 	return &TfJobRunner{
-		EnvVars: envVars,
+		EnvVars:    envVars,
+		StateStore: DbStateStore{},
 	}
 }
 
@@ -62,11 +87,50 @@ type TfJobRunner struct {
 	EnvVars map[string]string
 	// Executor holds a custom executor that will be called when commands are run.
 	Executor wrapper.TerraformExecutor
+	// ServiceId is the service the jobs belong to. Along with ServiceLimit and
+	// PlanLimits it restricts how many of the service's jobs run at once, in
+	// addition to the global limit on all jobs.
+	ServiceId string
+	// ServiceLimit is the maximum number of jobs for the service that can run
+	// at once, zero means there is no limit.
+	ServiceLimit int
+	// PlanLimits holds the maximum number of jobs that can run at once for each
+	// plan id, plans that are not listed have no limit.
+	PlanLimits map[string]int
+	// OperationTimeout is how long an operation can run before it is stopped
+	// and marked as failed, zero means operations can run indefinitely.
+	OperationTimeout time.Duration
+	// StateStore is where the Terraform state of deployments is kept.
+	StateStore StateStore
+	// PreventReplace lists resource types and addresses that updates must not
+	// replace or destroy. If it is set, updates are planned before they are
+	// applied and fail if the plan would replace or destroy any of them.
+	PreventReplace []string
+	// RetryPolicy is how applies and destroys that fail with transient errors
+	// are retried.
+	RetryPolicy RetryPolicy
+}
+
+// ReplaceBlockedError is the error an update fails with when it would replace
+// or destroy resources that are protected by the PreventReplace rules of the
+// runner.
+type ReplaceBlockedError struct {
+	Resources []string
+}
+
+func (e *ReplaceBlockedError) Error() string {
+	return fmt.Sprintf("the update would replace or destroy protected resources: %s", strings.Join(e.Resources, ", "))
 }
 
 // StageJob stages a job to be executed. Before the workspace is saved to the
 // database, the modules and inputs are validated by Terraform.
 func (runner *TfJobRunner) StageJob(ctx context.Context, jobId string, workspace *wrapper.TerraformWorkspace) error {
+	lock, err := lockDeployment(ctx, jobId)
+	if err != nil {
+		return err
+	}
+	defer lock.release()
+
 	workspace.Executor = runner.Executor
 
 	exists, err := db_service.ExistsTerraformDeploymentById(ctx, jobId)
@@ -86,7 +150,7 @@ func (runner *TfJobRunner) StageJob(ctx context.Context, jobId string, workspace
 		}
 	}
 
-	workspaceString, err := workspace.Serialize()
+	workspaceString, err := runner.serializeWorkspace(ctx, deployment.ID, workspace)
 	if err != nil {
 		return err
 	}
@@ -95,12 +159,26 @@ func (runner *TfJobRunner) StageJob(ctx context.Context, jobId string, workspace
 	}
 
 	deployment.LastOperationType = "validation"
-	return runner.operationFinished(nil, workspace, deployment)
+	return runner.operationFinished(nil, "", workspace, deployment)
 }
 
-func (runner *TfJobRunner) markJobStarted(ctx context.Context, deployment *models.TerraformDeployment, operationType string) error {
+// markJobStarted records that an operation has started on the deployment. The
+// workspace is saved along with it so that the operation can be resumed if the
+// broker stops before it completes.
+func (runner *TfJobRunner) markJobStarted(ctx context.Context, deployment *models.TerraformDeployment, workspace *wrapper.TerraformWorkspace, operationType string) error {
+	workspaceString, err := runner.serializeWorkspace(ctx, deployment.ID, workspace)
+	if err != nil {
+		return err
+	}
+	if err := deployment.SetWorkspace(workspaceString); err != nil {
+		return err
+	}
+
 	// update the deployment info
 	deployment.LastOperationType = operationType
+	if operationType == importOperationType {
+		deployment.LastOperationType = models.ProvisionOperationType
+	}
 	deployment.LastOperationState = InProgress
 	deployment.LastOperationMessage = ""
 
@@ -108,15 +186,38 @@ func (runner *TfJobRunner) markJobStarted(ctx context.Context, deployment *model
 		return err
 	}
 
-	return nil
+	job := &models.TerraformJob{
+		DeploymentId:  deployment.ID,
+		OperationType: operationType,
+		State:         InProgress,
+	}
+
+	return db_service.CreateTerraformJob(ctx, job)
 }
 
-func (runner *TfJobRunner) hydrateWorkspace(ctx context.Context, deployment *models.TerraformDeployment) (*wrapper.TerraformWorkspace, error) {
-	w, err := deployment.GetWorkspace()
+// markJobsFinished closes out any jobs on the deployment that are still in
+// progress with the state of the last operation on the deployment.
+func (runner *TfJobRunner) markJobsFinished(ctx context.Context, deployment *models.TerraformDeployment) error {
+	jobs, err := db_service.GetTerraformJobsByDeploymentIdAndState(ctx, deployment.ID, InProgress)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	ws, err := wrapper.DeserializeWorkspace(w)
+
+	now := time.Now()
+	for i := range jobs {
+		jobs[i].State = deployment.LastOperationState
+		jobs[i].Message = deployment.LastOperationMessage
+		jobs[i].FinishedAt = &now
+		if err := db_service.SaveTerraformJob(ctx, &jobs[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (runner *TfJobRunner) hydrateWorkspace(ctx context.Context, deployment *models.TerraformDeployment) (*wrapper.TerraformWorkspace, error) {
+	ws, err := runner.loadWorkspace(ctx, deployment)
 	if err != nil {
 		return nil, err
 	}
@@ -139,7 +240,17 @@ type ImportResource struct {
 
 // Import runs `terraform import` and `terraform apply` on the given workspace in the background.
 // The status of the job can be found by polling the Status function.
-func (runner *TfJobRunner) Import(ctx context.Context, id string, importResources []ImportResource) error {
+func (runner *TfJobRunner) Import(ctx context.Context, id, planId string, importResources []ImportResource) (err error) {
+	lock, err := lockDeployment(ctx, id)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			lock.release()
+		}
+	}()
+
 	deployment, err := db_service.GetTerraformDeploymentById(ctx, id)
 	if err != nil {
 		return err
@@ -150,11 +261,13 @@ func (runner *TfJobRunner) Import(ctx context.Context, id string, importResource
 		return err
 	}
 
-	if err := runner.markJobStarted(ctx, deployment, models.ProvisionOperationType); err != nil {
+	if err := runner.markJobStarted(ctx, deployment, workspace, importOperationType); err != nil {
 		return err
 	}
 
-	go func() {
+	// Imports are not retried because a partly imported workspace can't be
+	// imported again.
+	runner.run(ctx, lock, deployment, workspace, planId, func(ctx context.Context) error {
 		logger := utils.NewLogger("Import").WithData(correlation.ID(ctx))
 		resources := make(map[string]string)
 		for _, resource := range importResources {
@@ -162,8 +275,7 @@ func (runner *TfJobRunner) Import(ctx context.Context, id string, importResource
 		}
 		if err := workspace.Import(ctx, resources); err != nil {
 			logger.Error("Import Failed", err)
-			runner.operationFinished(err, workspace, deployment)
-			return
+			return err
 		}
 		mainTf, err := workspace.Show(ctx)
 		if err == nil {
@@ -189,15 +301,25 @@ func (runner *TfJobRunner) Import(ctx context.Context, id string, importResource
 				}
 			}
 		}
-		runner.operationFinished(err, workspace, deployment)
-	}()
+		return err
+	})
 
 	return nil
 }
 
 // Create runs `terraform apply` on the given workspace in the background.
 // The status of the job can be found by polling the Status function.
-func (runner *TfJobRunner) Create(ctx context.Context, id string) error {
+func (runner *TfJobRunner) Create(ctx context.Context, id, planId string) (err error) {
+	lock, err := lockDeployment(ctx, id)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			lock.release()
+		}
+	}()
+
 	deployment, err := db_service.GetTerraformDeploymentById(ctx, id)
 	if err != nil {
 		return fmt.Errorf("error getting TF deployment: %w", err)
@@ -208,56 +330,83 @@ func (runner *TfJobRunner) Create(ctx context.Context, id string) error {
 		return fmt.Errorf("error hydrating workspace: %w", err)
 	}
 
-	if err := runner.markJobStarted(ctx, deployment, models.ProvisionOperationType); err != nil {
+	if err := runner.markJobStarted(ctx, deployment, workspace, models.ProvisionOperationType); err != nil {
 		return fmt.Errorf("error marking job started: %w", err)
 	}
 
-	go func() {
-		err := workspace.Apply(ctx)
-		runner.operationFinished(err, workspace, deployment)
-	}()
+	runner.runWithRetries(ctx, lock, deployment, workspace, planId, workspace.Apply)
 
 	return nil
 }
 
-func (runner *TfJobRunner) Update(ctx context.Context, id string, templateVars map[string]interface{}) error {
-	deployment, err := db_service.GetTerraformDeploymentById(ctx, id)
+// Update runs `terraform apply` in the background on the given workspace with
+// new template variables.
+func (runner *TfJobRunner) Update(ctx context.Context, id, planId string, templateVars map[string]interface{}) error {
+	return runner.update(ctx, id, planId, templateVars, nil, nil)
+}
+
+// Upgrade is like Update, but also replaces the module and provenance of the
+// workspace, so that the deployment is changed to match the current templates
+// of the service. The Terraform state is kept.
+func (runner *TfJobRunner) Upgrade(ctx context.Context, id, planId string, templateVars map[string]interface{}, module wrapper.ModuleDefinition, provenance *wrapper.Provenance) error {
+	return runner.update(ctx, id, planId, templateVars, &module, provenance)
+}
+
+func (runner *TfJobRunner) update(ctx context.Context, id, planId string, templateVars map[string]interface{}, module *wrapper.ModuleDefinition, provenance *wrapper.Provenance) (err error) {
+	lock, err := lockDeployment(ctx, id)
 	if err != nil {
 		return err
 	}
+	defer func() {
+		if err != nil {
+			lock.release()
+		}
+	}()
 
-	workspace, err := runner.hydrateWorkspace(ctx, deployment)
+	deployment, err := db_service.GetTerraformDeploymentById(ctx, id)
 	if err != nil {
 		return err
 	}
 
-	inputList, err := workspace.Modules[0].Inputs()
+	workspace, err := runner.hydrateWorkspace(ctx, deployment)
 	if err != nil {
 		return err
 	}
 
-	limitedConfig := make(map[string]interface{})
-	for _, name := range inputList {
-		limitedConfig[name] = templateVars[name]
+	if module != nil {
+		workspace.Modules = []wrapper.ModuleDefinition{*module}
+		workspace.Provenance = provenance
 	}
 
-	workspace.Instances[0].Configuration = limitedConfig
+	if err := setConfiguration(workspace, templateVars); err != nil {
+		return err
+	}
 
-	if err := runner.markJobStarted(ctx, deployment, models.UpdateOperationType); err != nil {
+	if err := runner.markJobStarted(ctx, deployment, workspace, models.UpdateOperationType); err != nil {
 		return err
 	}
 
-	go func() {
-		err := workspace.Apply(ctx)
-		runner.operationFinished(err, workspace, deployment)
-	}()
+	apply := runner.withRetries(deployment, workspace, workspace.Apply)
+	runner.runReporting(ctx, lock, deployment, workspace, planId, runner.preventingReplace(workspace, apply))
 
 	return nil
 }
 
-// Destroy runs `terraform destroy` on the given workspace in the background.
-// The status of the job can be found by polling the Status function.
-func (runner *TfJobRunner) Destroy(ctx context.Context, id string, templateVars map[string]interface{}) error {
+// Preview runs `terraform plan` in the background for an update of the given
+// workspace to the template variables, without applying it or saving the new
+// configuration. When it succeeds, the message of the operation is a JSON
+// summary of the changes the update would make.
+func (runner *TfJobRunner) Preview(ctx context.Context, id, planId string, templateVars map[string]interface{}) (err error) {
+	lock, err := lockDeployment(ctx, id)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			lock.release()
+		}
+	}()
+
 	deployment, err := db_service.GetTerraformDeploymentById(ctx, id)
 	if err != nil {
 		return err
@@ -268,6 +417,68 @@ func (runner *TfJobRunner) Destroy(ctx context.Context, id string, templateVars
 		return err
 	}
 
+	preview, err := runner.hydrateWorkspace(ctx, deployment)
+	if err != nil {
+		return err
+	}
+
+	if err := setConfiguration(preview, templateVars); err != nil {
+		return err
+	}
+
+	// The result of the preview is kept on its job. The last operation on the
+	// deployment is put back once the preview finishes, so that the preview
+	// does not hide a failed operation that is still to be retried.
+	previousType, previousState, previousMessage := deployment.LastOperationType, deployment.LastOperationState, deployment.LastOperationMessage
+
+	if err := runner.markJobStarted(ctx, deployment, workspace, planOperationType); err != nil {
+		return err
+	}
+
+	runner.runReportingThen(ctx, lock, deployment, workspace, planId, func(ctx context.Context) (string, error) {
+		summary, err := preview.Preview(ctx)
+		if err != nil {
+			return "", err
+		}
+		return summary.String(), nil
+	}, func() {
+		deployment.LastOperationType = previousType
+		deployment.LastOperationState = previousState
+		deployment.LastOperationMessage = previousMessage
+		if err := saveFinishedDeployment(context.Background(), deployment); err != nil {
+			utils.NewLogger("job-runner").Error("restore-last-operation", err, lager.Data{"deployment": deployment.ID})
+		}
+	})
+
+	return nil
+}
+
+// preventingReplace wraps the update so that it is planned before it is
+// applied, in the background job rather than while the request waits. If the
+// update would replace or destroy protected resources, it fails with a
+// ReplaceBlockedError without being applied.
+func (runner *TfJobRunner) preventingReplace(workspace *wrapper.TerraformWorkspace, operation func(context.Context) (string, error)) func(context.Context) (string, error) {
+	if len(runner.PreventReplace) == 0 {
+		return operation
+	}
+
+	return func(ctx context.Context) (string, error) {
+		summary, err := workspace.Preview(ctx)
+		if err != nil {
+			return "", fmt.Errorf("error planning update: %w", err)
+		}
+
+		if resources := summary.ReplacedOrDestroyed(runner.PreventReplace); len(resources) > 0 {
+			return "", &ReplaceBlockedError{Resources: resources}
+		}
+
+		return operation(ctx)
+	}
+}
+
+// setConfiguration sets the configuration of the workspace's instance to the
+// template variables that are inputs of its module.
+func setConfiguration(workspace *wrapper.TerraformWorkspace, templateVars map[string]interface{}) error {
 	inputList, err := workspace.Modules[0].Inputs()
 	if err != nil {
 		return err
@@ -279,29 +490,246 @@ func (runner *TfJobRunner) Destroy(ctx context.Context, id string, templateVars
 	}
 
 	workspace.Instances[0].Configuration = limitedConfig
+	return nil
+}
+
+// Destroy runs `terraform destroy` on the given workspace in the background.
+// The status of the job can be found by polling the Status function.
+func (runner *TfJobRunner) Destroy(ctx context.Context, id, planId string, templateVars map[string]interface{}) (err error) {
+	lock, err := lockDeployment(ctx, id)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			lock.release()
+		}
+	}()
+
+	deployment, err := db_service.GetTerraformDeploymentById(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	workspace, err := runner.hydrateWorkspace(ctx, deployment)
+	if err != nil {
+		return err
+	}
 
-	if err := runner.markJobStarted(ctx, deployment, models.DeprovisionOperationType); err != nil {
+	if err := setConfiguration(workspace, templateVars); err != nil {
 		return err
 	}
 
+	if err := runner.markJobStarted(ctx, deployment, workspace, models.DeprovisionOperationType); err != nil {
+		return err
+	}
+
+	runner.runWithRetries(ctx, lock, deployment, workspace, planId, workspace.Destroy)
+
+	return nil
+}
+
+// run executes the operation in the background once the worker pool has
+// capacity for it. While the operation waits, the deployment reports that it
+// is queued. The lock on the deployment is released when the operation ends.
+//
+// The operation outlives the request that started it, so it does not use the
+// request's context. It is stopped if it is cancelled by an operator, or if it
+// runs for longer than the OperationTimeout.
+func (runner *TfJobRunner) run(ctx context.Context, lock *deploymentLock, deployment *models.TerraformDeployment, workspace *wrapper.TerraformWorkspace, planId string, operation func(context.Context) error) {
+	runner.runReporting(ctx, lock, deployment, workspace, planId, func(ctx context.Context) (string, error) {
+		return "", operation(ctx)
+	})
+}
+
+// runReporting is like run, but the operation can report the message for the
+// operation when it succeeds instead of the status output of the workspace.
+func (runner *TfJobRunner) runReporting(ctx context.Context, lock *deploymentLock, deployment *models.TerraformDeployment, workspace *wrapper.TerraformWorkspace, planId string, operation func(context.Context) (string, error)) {
+	runner.runReportingThen(ctx, lock, deployment, workspace, planId, operation, nil)
+}
+
+// runReportingThen is like runReporting, but finished is called once the
+// operation and its jobs have been closed out, before the deployment is
+// unlocked.
+func (runner *TfJobRunner) runReportingThen(ctx context.Context, lock *deploymentLock, deployment *models.TerraformDeployment, workspace *wrapper.TerraformWorkspace, planId string, operation func(context.Context) (string, error), finished func()) {
 	go func() {
-		err := workspace.Destroy(ctx)
-		runner.operationFinished(err, workspace, deployment)
+		defer lock.release()
+		if finished != nil {
+			defer finished()
+		}
+
+		jobs := metrics.TerraformJobsInProgress.WithLabelValues(runner.ServiceId)
+		jobs.Inc()
+		defer jobs.Dec()
+
+		logger := utils.NewLogger("job-runner").WithData(correlation.ID(ctx))
+
+		ctx, span := tracing.Start(correlation.Background(ctx), "terraform."+deployment.LastOperationType, attribute.String("deployment", deployment.ID))
+		defer func() {
+			var err error
+			if deployment.LastOperationState == Failed {
+				err = errors.New(deployment.LastOperationMessage)
+			}
+			tracing.End(span, err)
+		}()
+
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go watchForCancellation(ctx, deployment.ID, cancel, logger)
+
+		queued := false
+		release, err := workers.acquire(ctx, runner.limits(planId), func() {
+			queued = true
+			runner.setOperationMessage(deployment, Queued, logger)
+		})
+		if err != nil {
+			if err := runner.operationFinished(runner.stoppedError(err), "", workspace, deployment); err != nil {
+				logger.Error("operation-finished", err, lager.Data{"deployment": deployment.ID})
+			}
+			return
+		}
+		defer release()
+
+		if queued {
+			runner.setOperationMessage(deployment, "", logger)
+		}
+
+		if runner.OperationTimeout > 0 {
+			var cancelTimeout context.CancelFunc
+			ctx, cancelTimeout = context.WithTimeout(ctx, runner.OperationTimeout)
+			defer cancelTimeout()
+		}
+
+		start := time.Now()
+		message, err := operation(ctx)
+		if ctx.Err() != nil {
+			err = runner.stoppedError(ctx.Err())
+		}
+		if err := runner.operationFinished(err, message, workspace, deployment); err != nil {
+			logger.Error("operation-finished", err, lager.Data{"deployment": deployment.ID})
+		}
+
+		labels := []string{runner.ServiceId, deployment.LastOperationType, deployment.LastOperationState}
+		metrics.TerraformOperations.WithLabelValues(labels...).Inc()
+		metrics.TerraformOperationDuration.WithLabelValues(labels...).Observe(metrics.Since(start))
 	}()
+}
+
+// runWithRetries is like run, but the operation is retried according to the
+// RetryPolicy. While it waits to retry, the message of the operation describes
+// the failed attempt, and the final message says how many attempts were made.
+func (runner *TfJobRunner) runWithRetries(ctx context.Context, lock *deploymentLock, deployment *models.TerraformDeployment, workspace *wrapper.TerraformWorkspace, planId string, operation func(context.Context) error) {
+	runner.runReporting(ctx, lock, deployment, workspace, planId, runner.withRetries(deployment, workspace, operation))
+}
+
+// withRetries wraps the operation so that it is retried according to the
+// RetryPolicy, for runReporting, as runWithRetries does.
+func (runner *TfJobRunner) withRetries(deployment *models.TerraformDeployment, workspace *wrapper.TerraformWorkspace, operation func(context.Context) error) func(context.Context) (string, error) {
+	return func(ctx context.Context) (string, error) {
+		logger := utils.NewLogger("job-runner").WithData(correlation.ID(ctx))
+		attempts, err := runner.RetryPolicy.run(ctx, operation, func(attempt int, delay time.Duration, err error) {
+			logger.Info("retrying", lager.Data{"deployment": deployment.ID, "attempt": attempt, "delay": delay.String(), "error": err.Error()})
+			runner.setOperationMessage(deployment, fmt.Sprintf("attempt %d of %d failed, retrying in %s: %s", attempt, runner.RetryPolicy.MaxAttempts, delay, err), logger)
+		})
+
+		switch {
+		case attempts == 1:
+			return "", err
+		case err != nil:
+			return "", fmt.Errorf("failed after %d attempts: %w", attempts, err)
+		default:
+			message := fmt.Sprintf("succeeded after %d attempts", attempts)
+			if status := statusOutput(workspace); status != "" {
+				message = fmt.Sprintf("%s (%s)", status, message)
+			}
+			return message, nil
+		}
+	}
+}
+
+// stoppedError describes why an operation was stopped before it completed.
+func (runner *TfJobRunner) stoppedError(err error) error {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("operation timed out after %s", runner.OperationTimeout)
+	}
+
+	return errors.New("operation was cancelled by an operator")
+}
+
+// watchForCancellation periodically checks whether an operator has asked for
+// the operation on the deployment to be cancelled, and cancels it if they have.
+func watchForCancellation(ctx context.Context, deploymentId string, cancel context.CancelFunc, logger lager.Logger) {
+	ticker := time.NewTicker(cancellationPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			jobs, err := db_service.GetTerraformJobsByDeploymentIdAndState(ctx, deploymentId, InProgress)
+			if err != nil {
+				logger.Error("check-cancellation", err)
+				continue
+			}
+
+			for _, job := range jobs {
+				if job.CancelRequested {
+					logger.Info("cancelling", lager.Data{"deployment": deploymentId})
+					cancel()
+					return
+				}
+			}
+		}
+	}
+}
+
+// Cancel asks for the operation in progress on the deployment to be stopped.
+// The broker running the operation, which need not be this one, stops it the
+// next time it checks for cancellation and marks the deployment as failed.
+func (runner *TfJobRunner) Cancel(ctx context.Context, id string) error {
+	jobs, err := db_service.GetTerraformJobsByDeploymentIdAndState(ctx, id, InProgress)
+	switch {
+	case err != nil:
+		return err
+	case len(jobs) == 0:
+		return fmt.Errorf("no operation is in progress on deployment %q", id)
+	}
+
+	for i := range jobs {
+		jobs[i].CancelRequested = true
+		if err := db_service.SaveTerraformJob(ctx, &jobs[i]); err != nil {
+			return err
+		}
+	}
 
 	return nil
 }
 
+// limits lists the limits that apply to jobs for the plan, narrowest first.
+func (runner *TfJobRunner) limits(planId string) []jobLimit {
+	return []jobLimit{
+		{key: "plan:" + planId, max: runner.PlanLimits[planId]},
+		{key: "service:" + runner.ServiceId, max: runner.ServiceLimit},
+		{key: "global", max: viper.GetInt(maxConcurrentJobsKey)},
+	}
+}
+
+func (runner *TfJobRunner) setOperationMessage(deployment *models.TerraformDeployment, message string, logger lager.Logger) {
+	deployment.LastOperationMessage = message
+	if err := db_service.SaveTerraformDeployment(context.Background(), deployment); err != nil {
+		logger.Error("set-operation-message", err, lager.Data{"deployment": deployment.ID})
+	}
+}
+
 // operationFinished closes out the state of the background job so clients that
-// are polling can get the results.
-func (runner *TfJobRunner) operationFinished(err error, workspace *wrapper.TerraformWorkspace, deployment *models.TerraformDeployment) error {
+// are polling can get the results. If the operation succeeded and there is no
+// message, the status output of the workspace is used as the message.
+func (runner *TfJobRunner) operationFinished(err error, message string, workspace *wrapper.TerraformWorkspace, deployment *models.TerraformDeployment) error {
 	if err == nil {
-		lastOperationMessage := ""
-		outputs, err := workspace.Outputs(workspace.Instances[0].InstanceName)
-		if err == nil {
-			if status, ok := outputs["status"]; ok {
-				lastOperationMessage = fmt.Sprintf("%v", status)
-			}
+		lastOperationMessage := message
+		if lastOperationMessage == "" {
+			lastOperationMessage = statusOutput(workspace)
 		}
 		deployment.LastOperationState = Succeeded
 		deployment.LastOperationMessage = lastOperationMessage
@@ -310,36 +738,161 @@ func (runner *TfJobRunner) operationFinished(err error, workspace *wrapper.Terra
 		deployment.LastOperationMessage = err.Error()
 	}
 
-	workspaceString, err := workspace.Serialize()
+	workspaceString, err := runner.serializeWorkspace(context.Background(), deployment.ID, workspace)
 	if err != nil {
+		// the state is kept in the workspace instead, so that it is not lost, and
+		// is moved to the state store the next time the workspace is saved
+		utils.NewLogger("job-runner").Error("put-terraform-state", err, lager.Data{"deployment": deployment.ID})
+		workspaceString, err = workspace.Serialize()
+	}
+
+	switch {
+	case err != nil:
+		// the workspace stored when the operation started is kept
 		deployment.LastOperationState = Failed
 		deployment.LastOperationMessage = fmt.Sprintf("couldn't serialize workspace, contact your operator for cleanup: %s", err.Error())
+	default:
+		if err := deployment.SetWorkspace(workspaceString); err != nil {
+			deployment.LastOperationState = Failed
+			deployment.LastOperationMessage = fmt.Sprintf("couldn't save workspace, contact your operator for cleanup: %s", err.Error())
+		}
 	}
 
-	if err := deployment.SetWorkspace(workspaceString); err != nil {
-		deployment.LastOperationState = Failed
-		deployment.LastOperationMessage = fmt.Sprintf("couldn't save workspace, contact your operator for cleanup: %s", err.Error())
+	if err := saveFinishedDeployment(context.Background(), deployment); err != nil {
+		return err
 	}
 
-	return db_service.SaveTerraformDeployment(context.Background(), deployment)
+	return runner.markJobsFinished(context.Background(), deployment)
+}
+
+// saveFinishedAttempts is how many times the final state of an operation is
+// saved when the deployment is saved concurrently.
+const saveFinishedAttempts = 3
+
+// saveFinishedDeployment saves the final state of the operation on the
+// deployment. If the deployment was saved since it was read, it is reloaded and
+// the final state is applied to it again.
+func saveFinishedDeployment(ctx context.Context, deployment *models.TerraformDeployment) error {
+	for attempt := 1; ; attempt++ {
+		err := db_service.SaveTerraformDeployment(ctx, deployment)
+		if !errors.Is(err, db_service.ErrVersionConflict) || attempt == saveFinishedAttempts {
+			return err
+		}
+
+		current, err := db_service.GetTerraformDeploymentById(ctx, deployment.ID)
+		if err != nil {
+			return err
+		}
+		current.Workspace = deployment.Workspace
+		current.LastOperationType = deployment.LastOperationType
+		current.LastOperationState = deployment.LastOperationState
+		current.LastOperationMessage = deployment.LastOperationMessage
+		current.CredentialsRotatedAt = deployment.CredentialsRotatedAt
+		current.PreviousCredentialsExpireAt = deployment.PreviousCredentialsExpireAt
+		current.CredentialRetirementFailures = deployment.CredentialRetirementFailures
+		*deployment = *current
+	}
+}
+
+// statusOutput gets the "status" output of the workspace, which is the message
+// for operations that succeed.
+func statusOutput(workspace *wrapper.TerraformWorkspace) string {
+	outputs, err := workspace.Outputs(workspace.Instances[0].InstanceName)
+	if err != nil {
+		return ""
+	}
+
+	if status, ok := outputs["status"]; ok {
+		return fmt.Sprintf("%v", status)
+	}
+
+	return ""
+}
+
+// Resume restarts an operation on the given deployment that was interrupted
+// before it completed, using the workspace that was stored when it started.
+// Imports cannot be resumed because the stored workspace does not yet contain
+// the imported resources.
+func (runner *TfJobRunner) Resume(ctx context.Context, id, planId, operationType string) error {
+	return runner.resume(ctx, id, planId, operationType, "")
+}
+
+// resume is Resume, but if state is set, the operation is only resumed if the
+// deployment is in that state once it is locked, and errUnexpectedState is
+// returned otherwise.
+func (runner *TfJobRunner) resume(ctx context.Context, id, planId, operationType, state string) (err error) {
+	lock, err := lockDeployment(ctx, id)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			lock.release()
+		}
+	}()
+
+	deployment, err := db_service.GetTerraformDeploymentById(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if state != "" && deployment.LastOperationState != state {
+		return errUnexpectedState
+	}
+
+	workspace, err := runner.hydrateWorkspace(ctx, deployment)
+	if err != nil {
+		return err
+	}
+
+	var operation func(context.Context) error
+	switch operationType {
+	case models.ProvisionOperationType, models.UpdateOperationType:
+		operation = workspace.Apply
+	case models.DeprovisionOperationType:
+		operation = workspace.Destroy
+	default:
+		return fmt.Errorf("cannot resume %q operation", operationType)
+	}
+
+	if err := runner.markJobStarted(ctx, deployment, workspace, operationType); err != nil {
+		return err
+	}
+
+	runner.runWithRetries(ctx, lock, deployment, workspace, planId, operation)
+
+	return nil
 }
 
 // Status gets the status of the most recent job on the workspace.
 // If isDone is true, then the status of the operation will not change again.
 // if isDone is false, then the operation is ongoing.
+// Finished previews of updates are only recorded on their job, so when the
+// most recent job is a preview its result is reported.
 func (runner *TfJobRunner) Status(ctx context.Context, id string) (bool, string, error) {
 	deployment, err := db_service.GetTerraformDeploymentById(ctx, id)
 	if err != nil {
 		return true, "", err
 	}
 
-	switch deployment.LastOperationState {
+	state, message := deployment.LastOperationState, deployment.LastOperationMessage
+	if state != InProgress {
+		job, err := db_service.GetLastTerraformJobByDeploymentId(ctx, id)
+		switch {
+		case err != nil:
+			return true, "", err
+		case job != nil && job.OperationType == planOperationType:
+			state, message = job.State, job.Message
+		}
+	}
+
+	switch state {
 	case Succeeded:
-		return true, deployment.LastOperationMessage, nil
+		return true, message, nil
 	case Failed:
-		return true, deployment.LastOperationMessage, errors.New(deployment.LastOperationMessage)
+		return true, message, errors.New(message)
 	default:
-		return false, deployment.LastOperationMessage, nil
+		return false, message, nil
 	}
 }
 
@@ -350,6 +903,48 @@ func (runner *TfJobRunner) Outputs(ctx context.Context, id, instanceName string)
 		return nil, fmt.Errorf("error getting TF deployment: %w", err)
 	}
 
+	ws, err := runner.loadWorkspace(ctx, deployment)
+	if err != nil {
+		return nil, err
+	}
+
+	return ws.Outputs(instanceName)
+}
+
+// Workspace gets the workspace of the deployment, including its state.
+func (runner *TfJobRunner) Workspace(ctx context.Context, id string) (*wrapper.TerraformWorkspace, error) {
+	deployment, err := db_service.GetTerraformDeploymentById(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("error getting TF deployment: %w", err)
+	}
+
+	return runner.loadWorkspace(ctx, deployment)
+}
+
+// serializeWorkspace converts the workspace into the form saved with the
+// deployment. If the state is not kept inline it is put in the state store and
+// left out of the serialized workspace.
+func (runner *TfJobRunner) serializeWorkspace(ctx context.Context, deploymentId string, workspace *wrapper.TerraformWorkspace) (string, error) {
+	if runner.StateStore.Inline() {
+		return workspace.Serialize()
+	}
+
+	state := workspace.State
+	if len(state) > 0 {
+		if err := runner.StateStore.Put(ctx, deploymentId, state); err != nil {
+			return "", err
+		}
+	}
+
+	workspace.State = nil
+	defer func() { workspace.State = state }()
+	return workspace.Serialize()
+}
+
+// loadWorkspace reads the workspace saved with the deployment and fills in its
+// state from the state store. Workspaces saved before the state store was
+// configured still hold their state, which is used until it is next saved.
+func (runner *TfJobRunner) loadWorkspace(ctx context.Context, deployment *models.TerraformDeployment) (*wrapper.TerraformWorkspace, error) {
 	w, err := deployment.GetWorkspace()
 	if err != nil {
 		return nil, fmt.Errorf("error reading workspace: %w", err)
@@ -359,7 +954,13 @@ func (runner *TfJobRunner) Outputs(ctx context.Context, id, instanceName string)
 		return nil, fmt.Errorf("error deserializing workspace: %w", err)
 	}
 
-	return ws.Outputs(instanceName)
+	if !runner.StateStore.Inline() && len(ws.State) == 0 {
+		if ws.State, err = runner.StateStore.Get(ctx, deployment.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	return ws, nil
 }
 
 // Wait waits for an operation to complete, polling its status once per second.
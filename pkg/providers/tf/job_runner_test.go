@@ -0,0 +1,395 @@
+// Copyright 2021 the Service Broker Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tf
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/cloudfoundry-incubator/cloud-service-broker/db_service"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/db_service/models"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/internal/encryption/noopencryptor"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/pkg/metrics"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/pkg/providers/tf/wrapper"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestTfJobRunner_StopsOperations(t *testing.T) {
+	cases := map[string]struct {
+		timeout         time.Duration
+		cancel          bool
+		expectedMessage string
+	}{
+		"timeout": {
+			timeout:         50 * time.Millisecond,
+			expectedMessage: "operation timed out after 50ms",
+		},
+		"cancel": {
+			cancel:          true,
+			expectedMessage: "operation was cancelled by an operator",
+		},
+	}
+
+	for tn, tc := range cases {
+		t.Run(tn, func(t *testing.T) {
+			g := NewGomegaWithT(t)
+			ctx := context.Background()
+
+			db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+			g.Expect(err).NotTo(HaveOccurred())
+			// the operation runs in the background, so all goroutines must share the one in-memory database
+			sqlDB, err := db.DB()
+			g.Expect(err).NotTo(HaveOccurred())
+			sqlDB.SetMaxOpenConns(1)
+			g.Expect(db.Migrator().CreateTable(models.TerraformDeployment{}, models.TerraformJob{}, models.TerraformDeploymentLock{})).To(Succeed())
+			db_service.DbConnection = db
+			models.SetEncryptor(noopencryptor.New())
+
+			defer func(interval time.Duration) { cancellationPollInterval = interval }(cancellationPollInterval)
+			cancellationPollInterval = 10 * time.Millisecond
+
+			workspace, err := wrapper.NewWorkspace(map[string]interface{}{}, `output status { value = "done" }`, nil, nil, nil, nil)
+			g.Expect(err).NotTo(HaveOccurred())
+
+			runner := NewTfJobRunnerForProject(map[string]string{})
+			runner.OperationTimeout = tc.timeout
+
+			deployment := &models.TerraformDeployment{ID: "tf:instance:"}
+			g.Expect(db_service.CreateTerraformDeployment(ctx, deployment)).To(Succeed())
+			g.Expect(runner.markJobStarted(ctx, deployment, workspace, models.ProvisionOperationType)).To(Succeed())
+
+			lock, err := lockDeployment(ctx, deployment.ID)
+			g.Expect(err).NotTo(HaveOccurred())
+
+			started := make(chan struct{})
+			runner.run(ctx, lock, deployment, workspace, "plan", func(ctx context.Context) error {
+				close(started)
+				<-ctx.Done()
+				return ctx.Err()
+			})
+
+			g.Eventually(started).Should(BeClosed())
+			if tc.cancel {
+				g.Expect(runner.Cancel(ctx, deployment.ID)).To(Succeed())
+			}
+
+			g.Eventually(func() bool {
+				done, _, _ := runner.Status(ctx, deployment.ID)
+				return done
+			}).Should(BeTrue())
+
+			_, message, err := runner.Status(ctx, deployment.ID)
+			g.Expect(err).To(HaveOccurred())
+			g.Expect(message).To(Equal(tc.expectedMessage))
+			g.Eventually(func() (bool, error) {
+				return db_service.IsTerraformDeploymentLocked(ctx, deployment.ID)
+			}).Should(BeFalse())
+		})
+	}
+}
+
+func TestTfJobRunner_CancelWithoutOperation(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(db.Migrator().CreateTable(models.TerraformJob{})).To(Succeed())
+	db_service.DbConnection = db
+
+	err = NewTfJobRunnerForProject(map[string]string{}).Cancel(context.Background(), "tf:instance:")
+	g.Expect(err).To(MatchError(`no operation is in progress on deployment "tf:instance:"`))
+}
+
+func TestTfJobRunner_Preview(t *testing.T) {
+	g := NewGomegaWithT(t)
+	ctx := context.Background()
+	deployment := setupUpdatableDeployment(g)
+	deployment.LastOperationType = models.UpdateOperationType
+	deployment.LastOperationState = Failed
+	deployment.LastOperationMessage = "update failed"
+	g.Expect(db_service.SaveTerraformDeployment(ctx, deployment)).To(Succeed())
+	g.Expect(db_service.CreateTerraformJob(ctx, &models.TerraformJob{DeploymentId: deployment.ID, OperationType: models.UpdateOperationType, State: Failed, Message: "update failed"})).To(Succeed())
+
+	var plannedConfig []byte
+	runner := NewTfJobRunnerForProject(map[string]string{})
+	runner.Executor = fakePlanExecutor("google_sql_database_instance.instance", func(cmd *exec.Cmd) {
+		plannedConfig, _ = os.ReadFile(filepath.Join(cmd.Dir, "instance.tf.json"))
+	})
+
+	g.Expect(runner.Preview(ctx, deployment.ID, "plan", map[string]interface{}{"size": "large"})).To(Succeed())
+	g.Expect(runner.Wait(ctx, deployment.ID)).To(Succeed())
+
+	done, message, err := runner.Status(ctx, deployment.ID)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(done).To(BeTrue())
+	g.Expect(message).To(MatchJSON(`{"create":[],"update":[],"replace":["google_sql_database_instance.instance"],"destroy":[]}`))
+	g.Expect(string(plannedConfig)).To(ContainSubstring(`"large"`))
+
+	saved, err := runner.Workspace(ctx, deployment.ID)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(saved.Instances[0].Configuration).To(Equal(map[string]interface{}{"size": "small"}), "the preview should not change the saved configuration")
+
+	g.Eventually(func() (bool, error) {
+		return db_service.IsTerraformDeploymentLocked(ctx, deployment.ID)
+	}).Should(BeFalse())
+	previewed, err := db_service.GetTerraformDeploymentById(ctx, deployment.ID)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(previewed.LastOperationType).To(Equal(models.UpdateOperationType), "the preview should not replace the last operation")
+	g.Expect(previewed.LastOperationState).To(Equal(Failed))
+	g.Expect(previewed.LastOperationMessage).To(Equal("update failed"))
+	g.Expect(lastOperationType(ctx, previewed)).To(Equal(models.UpdateOperationType), "the failed update should still be retried")
+
+	job, err := db_service.GetLastTerraformJobByDeploymentId(ctx, deployment.ID)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(job.OperationType).To(Equal(planOperationType))
+	g.Expect(job.State).To(Equal(Succeeded))
+	g.Expect(job.Message).To(Equal(message))
+}
+
+func TestTfJobRunner_PreventReplace(t *testing.T) {
+	cases := map[string]struct {
+		replaced      string
+		expectedError string
+	}{
+		"protected": {
+			replaced:      "google_sql_database_instance.instance",
+			expectedError: "the update would replace or destroy protected resources: google_sql_database_instance.instance",
+		},
+		"unprotected": {
+			replaced: "random_password.password",
+		},
+	}
+
+	for tn, tc := range cases {
+		t.Run(tn, func(t *testing.T) {
+			g := NewGomegaWithT(t)
+			ctx := context.Background()
+			deployment := setupUpdatableDeployment(g)
+
+			var applied bool
+			plan := fakePlanExecutor(tc.replaced, func(*exec.Cmd) {})
+			runner := NewTfJobRunnerForProject(map[string]string{})
+			runner.PreventReplace = []string{"google_sql_database_instance"}
+			runner.Executor = func(ctx context.Context, cmd *exec.Cmd) (wrapper.ExecutionOutput, error) {
+				applied = applied || cmd.Args[1] == "apply"
+				return plan(ctx, cmd)
+			}
+
+			// the update is checked in the background, so the request does not wait for the plan
+			g.Expect(runner.Update(ctx, deployment.ID, "plan", map[string]interface{}{"size": "large"})).To(Succeed())
+			waitErr := runner.Wait(ctx, deployment.ID)
+			g.Eventually(func() (bool, error) {
+				return db_service.IsTerraformDeploymentLocked(ctx, deployment.ID)
+			}).Should(BeFalse())
+
+			saved, err := db_service.GetTerraformDeploymentById(ctx, deployment.ID)
+			g.Expect(err).NotTo(HaveOccurred())
+			g.Expect(saved.LastOperationType).To(Equal(models.UpdateOperationType))
+
+			if tc.expectedError == "" {
+				g.Expect(waitErr).NotTo(HaveOccurred())
+				g.Expect(applied).To(BeTrue())
+				return
+			}
+
+			g.Expect(waitErr).To(MatchError(tc.expectedError))
+			g.Expect(applied).To(BeFalse(), "the update should not have been applied")
+			g.Expect(saved.LastOperationState).To(Equal(Failed))
+		})
+	}
+}
+
+func TestTfJobRunner_Upgrade(t *testing.T) {
+	g := NewGomegaWithT(t)
+	ctx := context.Background()
+	deployment := setupUpdatableDeployment(g)
+
+	runner := NewTfJobRunnerForProject(map[string]string{})
+	runner.Executor = fakePlanExecutor("random_password.password", func(*exec.Cmd) {})
+
+	module := wrapper.NewModule(`variable size { type = string }
+output version { value = "2" }`, nil)
+	provenance := &wrapper.Provenance{BrokerpakName: "pak", BrokerpakVersion: "2.0.0", ServiceId: "service-id", TemplateDigest: module.Digest()}
+	g.Expect(runner.Upgrade(ctx, deployment.ID, "plan", map[string]interface{}{"size": "small"}, module, provenance)).To(Succeed())
+	g.Expect(runner.Wait(ctx, deployment.ID)).To(Succeed())
+
+	saved, err := runner.Workspace(ctx, deployment.ID)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(saved.Modules).To(Equal([]wrapper.ModuleDefinition{module}))
+	g.Expect(saved.Instances[0].ModuleName).To(Equal(module.Name))
+	g.Expect(saved.Provenance).To(Equal(provenance))
+
+	updated, err := db_service.GetTerraformDeploymentById(ctx, deployment.ID)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(updated.LastOperationType).To(Equal(models.UpdateOperationType))
+}
+
+func TestTfJobRunner_RetryPolicy(t *testing.T) {
+	cases := map[string]struct {
+		failures        int
+		failure         string
+		expectedState   string
+		expectedMessage string
+	}{
+		"retried": {
+			failures:        1,
+			failure:         "Error: RequestLimitExceeded",
+			expectedState:   Succeeded,
+			expectedMessage: "succeeded after 2 attempts",
+		},
+		"not retryable": {
+			failures:        1,
+			failure:         "Error: InvalidParameterValue",
+			expectedState:   Failed,
+			expectedMessage: "Error: InvalidParameterValue",
+		},
+		"too many failures": {
+			failures:        3,
+			failure:         "Error: RequestLimitExceeded",
+			expectedState:   Failed,
+			expectedMessage: "failed after 2 attempts: Error: RequestLimitExceeded",
+		},
+	}
+
+	for tn, tc := range cases {
+		t.Run(tn, func(t *testing.T) {
+			g := NewGomegaWithT(t)
+			ctx := context.Background()
+			deployment := setupUpdatableDeployment(g)
+
+			applies := 0
+			runner := NewTfJobRunnerForProject(map[string]string{})
+			runner.RetryPolicy = RetryPolicy{
+				MaxAttempts:     2,
+				Backoff:         time.Millisecond,
+				RetryableErrors: []*regexp.Regexp{regexp.MustCompile("RequestLimitExceeded")},
+			}
+			runner.Executor = func(ctx context.Context, cmd *exec.Cmd) (wrapper.ExecutionOutput, error) {
+				if cmd.Args[1] == "apply" {
+					applies++
+					if applies <= tc.failures {
+						return wrapper.ExecutionOutput{}, errors.New(tc.failure)
+					}
+				}
+				return wrapper.ExecutionOutput{}, nil
+			}
+
+			g.Expect(runner.Update(ctx, deployment.ID, "plan", map[string]interface{}{"size": "large"})).To(Succeed())
+			runner.Wait(ctx, deployment.ID)
+
+			saved, err := db_service.GetTerraformDeploymentById(ctx, deployment.ID)
+			g.Expect(err).NotTo(HaveOccurred())
+			g.Expect(saved.LastOperationState).To(Equal(tc.expectedState))
+			g.Expect(saved.LastOperationMessage).To(Equal(tc.expectedMessage))
+		})
+	}
+}
+
+func TestTfJobRunner_Metrics(t *testing.T) {
+	g := NewGomegaWithT(t)
+	ctx := context.Background()
+	deployment := setupUpdatableDeployment(g)
+
+	runner := NewTfJobRunnerForProject(map[string]string{})
+	runner.ServiceId = "metrics-service"
+	runner.Executor = fakePlanExecutor("random_password.password", func(*exec.Cmd) {})
+
+	g.Expect(runner.Update(ctx, deployment.ID, "plan", map[string]interface{}{"size": "large"})).To(Succeed())
+	g.Expect(runner.Wait(ctx, deployment.ID)).To(Succeed())
+
+	operations := metrics.TerraformOperations.WithLabelValues("metrics-service", models.UpdateOperationType, Succeeded)
+	g.Expect(testutil.ToFloat64(operations)).To(Equal(1.0))
+	g.Eventually(func() float64 {
+		return testutil.ToFloat64(metrics.TerraformJobsInProgress.WithLabelValues("metrics-service"))
+	}).Should(BeZero())
+}
+
+// setupUpdatableDeployment creates a provisioned deployment, with a "size"
+// input that is set to "small", in a new in-memory database.
+func setupUpdatableDeployment(g *GomegaWithT) *models.TerraformDeployment {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	g.Expect(err).NotTo(HaveOccurred())
+	sqlDB, err := db.DB()
+	g.Expect(err).NotTo(HaveOccurred())
+	sqlDB.SetMaxOpenConns(1)
+	g.Expect(db.Migrator().CreateTable(models.TerraformDeployment{}, models.TerraformJob{}, models.TerraformDeploymentLock{})).To(Succeed())
+	db_service.DbConnection = db
+	models.SetEncryptor(noopencryptor.New())
+
+	workspace, err := wrapper.NewWorkspace(map[string]interface{}{"size": "small"}, `variable size { type = string }`, nil, nil, nil, nil)
+	g.Expect(err).NotTo(HaveOccurred())
+	serialized, err := workspace.Serialize()
+	g.Expect(err).NotTo(HaveOccurred())
+	deployment := &models.TerraformDeployment{ID: "tf:instance:", LastOperationType: models.ProvisionOperationType, LastOperationState: Succeeded}
+	g.Expect(deployment.SetWorkspace(serialized)).To(Succeed())
+	g.Expect(db_service.CreateTerraformDeployment(context.Background(), deployment)).To(Succeed())
+
+	return deployment
+}
+
+// fakePlanExecutor pretends to run Terraform, with plans that replace the
+// resource at the given address. onPlan is called when the plan is made.
+func fakePlanExecutor(replaced string, onPlan func(*exec.Cmd)) wrapper.TerraformExecutor {
+	return func(ctx context.Context, cmd *exec.Cmd) (wrapper.ExecutionOutput, error) {
+		switch cmd.Args[1] {
+		case "plan":
+			onPlan(cmd)
+		case "show":
+			return wrapper.ExecutionOutput{StdOut: fmt.Sprintf(`{"resource_changes":[{"address":%q,"change":{"actions":["delete","create"]}}]}`, replaced)}, nil
+		}
+		return wrapper.ExecutionOutput{}, nil
+	}
+}
+
+func TestSaveFinishedDeployment(t *testing.T) {
+	g := NewGomegaWithT(t)
+	ctx := context.Background()
+
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(db.Migrator().CreateTable(models.TerraformDeployment{})).To(Succeed())
+	db_service.DbConnection = db
+	models.SetEncryptor(noopencryptor.New())
+
+	g.Expect(db_service.CreateTerraformDeployment(ctx, &models.TerraformDeployment{ID: "tf:instance:", LastOperationState: InProgress})).To(Succeed())
+	finished, err := db_service.GetTerraformDeploymentById(ctx, "tf:instance:")
+	g.Expect(err).NotTo(HaveOccurred())
+
+	// another save, such as a drift check, after the operation read the deployment
+	concurrent, err := db_service.GetTerraformDeploymentById(ctx, "tf:instance:")
+	g.Expect(err).NotTo(HaveOccurred())
+	concurrent.DriftStatus = "drifted"
+	g.Expect(db_service.SaveTerraformDeployment(ctx, concurrent)).To(Succeed())
+
+	finished.LastOperationState = Succeeded
+	finished.LastOperationMessage = "done"
+	g.Expect(saveFinishedDeployment(ctx, finished)).To(Succeed())
+
+	saved, err := db_service.GetTerraformDeploymentById(ctx, "tf:instance:")
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(saved.LastOperationState).To(Equal(Succeeded))
+	g.Expect(saved.LastOperationMessage).To(Equal("done"))
+	g.Expect(saved.DriftStatus).To(Equal("drifted"))
+}
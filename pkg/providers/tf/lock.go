@@ -0,0 +1,101 @@
+// Copyright 2021 the Service Broker Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tf
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/db_service"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/utils"
+	"github.com/pborman/uuid"
+)
+
+// ErrDeploymentLocked is returned when an operation is attempted on a
+// deployment that another operation, possibly on another broker, is running on.
+var ErrDeploymentLocked = errors.New("another operation is in progress on the deployment")
+
+// deploymentLockDuration is how long a lock lasts without being renewed. Locks
+// are renewed well within this time while they are held, so it only matters
+// when a broker stops without releasing its locks.
+var deploymentLockDuration = 2 * time.Minute
+
+// deploymentLock is a lease on a deployment stored in the database. It is
+// renewed in the background until it is released.
+type deploymentLock struct {
+	deploymentId string
+	owner        string
+	logger       lager.Logger
+	stop         chan struct{}
+	stopped      chan struct{}
+}
+
+// lockDeployment takes the lock on the deployment, or fails with
+// ErrDeploymentLocked if it is already held.
+func lockDeployment(ctx context.Context, deploymentId string) (*deploymentLock, error) {
+	lock := &deploymentLock{
+		deploymentId: deploymentId,
+		owner:        uuid.New(),
+		logger:       utils.NewLogger("deployment-lock").WithData(lager.Data{"deployment": deploymentId}),
+		stop:         make(chan struct{}),
+		stopped:      make(chan struct{}),
+	}
+
+	acquired, err := db_service.AcquireTerraformDeploymentLock(ctx, deploymentId, lock.owner, time.Now().Add(deploymentLockDuration))
+	switch {
+	case err != nil:
+		return nil, fmt.Errorf("error locking deployment %q: %w", deploymentId, err)
+	case !acquired:
+		return nil, fmt.Errorf("%w: %s", ErrDeploymentLocked, deploymentId)
+	}
+
+	go lock.renew()
+	return lock, nil
+}
+
+func (lock *deploymentLock) renew() {
+	defer close(lock.stopped)
+
+	ticker := time.NewTicker(deploymentLockDuration / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-lock.stop:
+			return
+		case <-ticker.C:
+			acquired, err := db_service.AcquireTerraformDeploymentLock(context.Background(), lock.deploymentId, lock.owner, time.Now().Add(deploymentLockDuration))
+			switch {
+			case err != nil:
+				lock.logger.Error("renew", err)
+			case !acquired:
+				lock.logger.Error("renew", ErrDeploymentLocked)
+			}
+		}
+	}
+}
+
+// release stops renewing the lock and removes it so another operation can run.
+func (lock *deploymentLock) release() {
+	close(lock.stop)
+	<-lock.stopped
+
+	if err := db_service.ReleaseTerraformDeploymentLock(context.Background(), lock.deploymentId, lock.owner); err != nil {
+		lock.logger.Error("release", err)
+	}
+}
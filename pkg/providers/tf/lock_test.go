@@ -0,0 +1,53 @@
+// Copyright 2021 the Service Broker Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tf
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cloudfoundry-incubator/cloud-service-broker/db_service"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/db_service/models"
+	. "github.com/onsi/gomega"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestLockDeployment(t *testing.T) {
+	g := NewGomegaWithT(t)
+	ctx := context.Background()
+
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(db.Migrator().CreateTable(models.TerraformDeploymentLock{})).To(Succeed())
+	db_service.DbConnection = db
+
+	lock, err := lockDeployment(ctx, "tf:instance:")
+	g.Expect(err).NotTo(HaveOccurred())
+
+	_, err = lockDeployment(ctx, "tf:instance:")
+	g.Expect(err).To(MatchError(ErrDeploymentLocked))
+
+	other, err := lockDeployment(ctx, "tf:other:")
+	g.Expect(err).NotTo(HaveOccurred())
+	other.release()
+
+	lock.release()
+	g.Expect(db_service.IsTerraformDeploymentLocked(ctx, "tf:instance:")).To(BeFalse())
+
+	lock, err = lockDeployment(ctx, "tf:instance:")
+	g.Expect(err).NotTo(HaveOccurred())
+	lock.release()
+}
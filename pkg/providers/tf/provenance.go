@@ -0,0 +1,83 @@
+// Copyright 2021 the Service Broker Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tf
+
+import (
+	"context"
+	"fmt"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/db_service/models"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/pkg/broker"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/pkg/providers/tf/wrapper"
+)
+
+// TemplateStatus describes the templates that the workspace of a deployment
+// was created from, compared to the current templates of its service.
+type TemplateStatus struct {
+	// Provenance is where the templates came from, or nil if the workspace
+	// was created before it was recorded.
+	Provenance *wrapper.Provenance
+	// Digest is the digest of the templates of the workspace.
+	Digest string
+	// CurrentDigest is the digest of the current templates of the service.
+	CurrentDigest string
+}
+
+// Outdated reports whether the workspace was created from templates other than
+// the current ones.
+func (status TemplateStatus) Outdated() bool {
+	return status.Digest != status.CurrentDigest
+}
+
+// CheckTemplates compares the templates of the workspace of the deployment with
+// the templates that the service it belongs to in the registry would use now.
+// The modules are compared rather than the provenance, so that workspaces
+// created before provenance was recorded can be checked too.
+func CheckTemplates(ctx context.Context, registry broker.BrokerRegistry, deployment *models.TerraformDeployment, logger lager.Logger) (*TemplateStatus, error) {
+	_, bindingID, err := parseTfId(deployment.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	provider, _, err := providerForDeployment(ctx, registry, deployment.ID, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	w, err := deployment.GetWorkspace()
+	if err != nil {
+		return nil, fmt.Errorf("error reading workspace: %w", err)
+	}
+	workspace, err := wrapper.DeserializeWorkspace(w)
+	if err != nil {
+		return nil, fmt.Errorf("error deserializing workspace: %w", err)
+	}
+	if len(workspace.Modules) != 1 {
+		return nil, fmt.Errorf("workspace of deployment %q has %d modules, expected 1", deployment.ID, len(workspace.Modules))
+	}
+
+	action := provider.serviceDefinition.ProvisionSettings
+	if bindingID != "" {
+		action = provider.serviceDefinition.BindSettings
+	}
+
+	current := wrapper.NewModule(action.Template, action.Templates)
+	return &TemplateStatus{
+		Provenance:    workspace.Provenance,
+		Digest:        workspace.Modules[0].Digest(),
+		CurrentDigest: current.Digest(),
+	}, nil
+}
@@ -0,0 +1,63 @@
+// Copyright 2021 the Service Broker Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tf
+
+import (
+	"context"
+	"testing"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/db_service"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/pkg/providers/tf/wrapper"
+	. "github.com/onsi/gomega"
+)
+
+func TestCheckTemplates(t *testing.T) {
+	t.Run("outdated", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		deployment := setupUpdatableDeployment(g)
+		registry := setupUpgradeRegistry(g, NewTfJobRunnerForProject(map[string]string{}))
+
+		status, err := CheckTemplates(context.Background(), registry, deployment, lager.NewLogger("test"))
+
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(status.Provenance).To(BeNil())
+		g.Expect(status.Outdated()).To(BeTrue())
+	})
+
+	t.Run("current", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		ctx := context.Background()
+		deployment := setupUpdatableDeployment(g)
+		registry := setupUpgradeRegistry(g, NewTfJobRunnerForProject(map[string]string{}))
+
+		module := wrapper.NewModule(upgradedTemplate, nil)
+		provenance := &wrapper.Provenance{BrokerpakName: "pak", BrokerpakVersion: "2.0.0", ServiceId: "service-id", TemplateDigest: module.Digest()}
+		workspace, err := wrapper.NewWorkspace(map[string]interface{}{"size": "small"}, upgradedTemplate, nil, nil, nil, nil)
+		g.Expect(err).NotTo(HaveOccurred())
+		workspace.Provenance = provenance
+		serialized, err := workspace.Serialize()
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(deployment.SetWorkspace(serialized)).To(Succeed())
+		g.Expect(db_service.SaveTerraformDeployment(ctx, deployment)).To(Succeed())
+
+		status, err := CheckTemplates(ctx, registry, deployment, lager.NewLogger("test"))
+
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(status.Provenance).To(Equal(provenance))
+		g.Expect(status.Outdated()).To(BeFalse())
+		g.Expect(status.CurrentDigest).To(Equal(provenance.TemplateDigest))
+	})
+}
@@ -79,16 +79,50 @@ func (provider *terraformProvider) Update(ctx context.Context, provisionContext
 		"context": provisionContext.ToMap(),
 	})
 
-	if provider.serviceDefinition.ProvisionSettings.IsTfImport(provisionContext) {
-		return models.ServiceInstanceDetails{}, fmt.Errorf("cannot update to subsume plan\n\nFor OpsMan Tile users see documentation here: https://via.vmw.com/ENs4\n\nFor Open Source users deployed via 'cf push' see documentation here:  https://via.vmw.com/ENw4")
+	return provider.update(ctx, provisionContext, provider.jobRunner.Update)
+}
+
+// Upgrade applies the current provision template of the service to the
+// instance, along with the new desired configuration.
+func (provider *terraformProvider) Upgrade(ctx context.Context, provisionContext *varcontext.VarContext) (models.ServiceInstanceDetails, error) {
+	provider.logger.Debug("upgrade", correlation.ID(ctx), lager.Data{
+		"context": provisionContext.ToMap(),
+	})
+
+	settings := provider.serviceDefinition.ProvisionSettings
+	module := wrapper.NewModule(settings.Template, settings.Templates)
+	return provider.update(ctx, provisionContext, func(ctx context.Context, id, planId string, templateVars map[string]interface{}) error {
+		return provider.jobRunner.Upgrade(ctx, id, planId, templateVars, module, provider.serviceDefinition.provenance(module))
+	})
+}
+
+func (provider *terraformProvider) update(ctx context.Context, provisionContext *varcontext.VarContext, run func(ctx context.Context, id, planId string, templateVars map[string]interface{}) error) (models.ServiceInstanceDetails, error) {
+	tfId, err := provider.updateTfId(provisionContext)
+	if err != nil {
+		return models.ServiceInstanceDetails{}, err
 	}
 
-	tfId := provisionContext.GetString("tf_id")
-	if err := provisionContext.Error(); err != nil {
+	err = run(ctx, tfId, planId(provisionContext), provisionContext.ToMap())
+
+	return models.ServiceInstanceDetails{
+		OperationId:   tfId,
+		OperationType: models.UpdateOperationType,
+	}, err
+}
+
+// PreviewUpdate plans the update in the background without applying it. The
+// changes it would make are reported as the message of the operation.
+func (provider *terraformProvider) PreviewUpdate(ctx context.Context, provisionContext *varcontext.VarContext) (models.ServiceInstanceDetails, error) {
+	provider.logger.Debug("preview-update", correlation.ID(ctx), lager.Data{
+		"context": provisionContext.ToMap(),
+	})
+
+	tfId, err := provider.updateTfId(provisionContext)
+	if err != nil {
 		return models.ServiceInstanceDetails{}, err
 	}
 
-	err := provider.jobRunner.Update(ctx, tfId, provisionContext.ToMap())
+	err = provider.jobRunner.Preview(ctx, tfId, planId(provisionContext), provisionContext.ToMap())
 
 	return models.ServiceInstanceDetails{
 		OperationId:   tfId,
@@ -96,6 +130,20 @@ func (provider *terraformProvider) Update(ctx context.Context, provisionContext
 	}, err
 }
 
+// updateTfId gets the id of the deployment to update, checking that it can be updated.
+func (provider *terraformProvider) updateTfId(provisionContext *varcontext.VarContext) (string, error) {
+	if provider.serviceDefinition.ProvisionSettings.IsTfImport(provisionContext) {
+		return "", fmt.Errorf("cannot update to subsume plan\n\nFor OpsMan Tile users see documentation here: https://via.vmw.com/ENs4\n\nFor Open Source users deployed via 'cf push' see documentation here:  https://via.vmw.com/ENw4")
+	}
+
+	tfId := provisionContext.GetString("tf_id")
+	if err := provisionContext.Error(); err != nil {
+		return "", err
+	}
+
+	return tfId, nil
+}
+
 // Bind creates a new backing Terraform job and executes it, waiting on the result.
 func (provider *terraformProvider) Bind(ctx context.Context, bindContext *varcontext.VarContext) (map[string]interface{}, error) {
 	provider.logger.Debug("terraform-bind", correlation.ID(ctx), lager.Data{
@@ -114,6 +162,25 @@ func (provider *terraformProvider) Bind(ctx context.Context, bindContext *varcon
 	return provider.jobRunner.Outputs(ctx, tfId, wrapper.DefaultInstanceName)
 }
 
+// BindAsync creates a new backing Terraform job and executes it in the background.
+func (provider *terraformProvider) BindAsync(ctx context.Context, bindContext *varcontext.VarContext) (string, error) {
+	provider.logger.Debug("terraform-bind-async", correlation.ID(ctx), lager.Data{
+		"context": bindContext.ToMap(),
+	})
+
+	tfId, err := provider.create(ctx, bindContext, provider.serviceDefinition.BindSettings)
+	if err != nil {
+		return "", fmt.Errorf("error from provider bind: %w", err)
+	}
+
+	return tfId, nil
+}
+
+// BindingOutputs gets the credentials created by the Terraform job of a binding.
+func (provider *terraformProvider) BindingOutputs(ctx context.Context, instanceID, bindingID string) (map[string]interface{}, error) {
+	return provider.jobRunner.Outputs(ctx, generateTfId(instanceID, bindingID), wrapper.DefaultInstanceName)
+}
+
 func (provider *terraformProvider) importCreate(ctx context.Context, vars *varcontext.VarContext, action TfServiceDefinitionV1Action) (string, error) {
 	varsMap := vars.ToMap()
 
@@ -159,13 +226,14 @@ func (provider *terraformProvider) importCreate(ctx context.Context, vars *varco
 	if err != nil {
 		return tfId, err
 	}
+	workspace.Provenance = provider.serviceDefinition.provenance(workspace.Modules[0])
 
 	if err := provider.jobRunner.StageJob(ctx, tfId, workspace); err != nil {
 		provider.logger.Error("terraform provider create failed", err)
 		return tfId, err
 	}
 
-	return tfId, provider.jobRunner.Import(ctx, tfId, importParams)
+	return tfId, provider.jobRunner.Import(ctx, tfId, planId(vars), importParams)
 }
 
 func (provider *terraformProvider) create(ctx context.Context, vars *varcontext.VarContext, action TfServiceDefinitionV1Action) (string, error) {
@@ -178,6 +246,7 @@ func (provider *terraformProvider) create(ctx context.Context, vars *varcontext.
 	if err != nil {
 		return tfId, fmt.Errorf("error creating workspace: %w", err)
 	}
+	workspace.Provenance = provider.serviceDefinition.provenance(workspace.Modules[0])
 
 	// if err = workspace.Validate(); err != nil {
 	// 	return tfId, err
@@ -188,7 +257,16 @@ func (provider *terraformProvider) create(ctx context.Context, vars *varcontext.
 		return tfId, fmt.Errorf("terraform provider create failed: %w", err)
 	}
 
-	return tfId, provider.jobRunner.Create(ctx, tfId)
+	return tfId, provider.jobRunner.Create(ctx, tfId, planId(vars))
+}
+
+// planId gets the plan of the request from the computed variables.
+func planId(vars *varcontext.VarContext) string {
+	if !vars.HasKey(planIdVariable) {
+		return ""
+	}
+
+	return vars.GetString(planIdVariable)
 }
 
 // Unbind performs a terraform destroy on the binding.
@@ -200,13 +278,29 @@ func (provider *terraformProvider) Unbind(ctx context.Context, instanceRecord mo
 		"tfId":     tfId,
 	})
 
-	if err := provider.jobRunner.Destroy(ctx, tfId, vc.ToMap()); err != nil {
+	if err := provider.jobRunner.Destroy(ctx, tfId, instanceRecord.PlanId, vc.ToMap()); err != nil {
 		return err
 	}
 
 	return provider.jobRunner.Wait(ctx, tfId)
 }
 
+// UnbindAsync performs a terraform destroy on the binding in the background.
+func (provider *terraformProvider) UnbindAsync(ctx context.Context, instanceRecord models.ServiceInstanceDetails, bindRecord models.ServiceBindingCredentials, vc *varcontext.VarContext) (string, error) {
+	tfId := generateTfId(instanceRecord.ID, bindRecord.BindingId)
+	provider.logger.Debug("terraform-unbind-async", correlation.ID(ctx), lager.Data{
+		"instance": instanceRecord.ID,
+		"binding":  bindRecord.ID,
+		"tfId":     tfId,
+	})
+
+	if err := provider.jobRunner.Destroy(ctx, tfId, instanceRecord.PlanId, vc.ToMap()); err != nil {
+		return "", err
+	}
+
+	return tfId, nil
+}
+
 // Deprovision performs a terraform destroy on the instance.
 func (provider *terraformProvider) Deprovision(ctx context.Context, instance models.ServiceInstanceDetails, details domain.DeprovisionDetails, vc *varcontext.VarContext) (operationId *string, err error) {
 	provider.logger.Debug("terraform-deprovision", correlation.ID(ctx), lager.Data{
@@ -214,7 +308,7 @@ func (provider *terraformProvider) Deprovision(ctx context.Context, instance mod
 	})
 
 	tfId := generateTfId(instance.ID, "")
-	if err := provider.jobRunner.Destroy(ctx, tfId, vc.ToMap()); err != nil {
+	if err := provider.jobRunner.Destroy(ctx, tfId, instance.PlanId, vc.ToMap()); err != nil {
 		return nil, err
 	}
 
@@ -226,6 +320,11 @@ func (provider *terraformProvider) PollInstance(ctx context.Context, instance mo
 	return provider.jobRunner.Status(ctx, generateTfId(instance.ID, ""))
 }
 
+// PollBinding returns the status of the backing job of a binding.
+func (provider *terraformProvider) PollBinding(ctx context.Context, instanceID, bindingID string) (bool, string, error) {
+	return provider.jobRunner.Status(ctx, generateTfId(instanceID, bindingID))
+}
+
 // ProvisionsAsync is always true for Terraformprovider.
 func (provider *terraformProvider) ProvisionsAsync() bool {
 	return true
@@ -236,6 +335,11 @@ func (provider *terraformProvider) DeprovisionsAsync() bool {
 	return true
 }
 
+// BindsAsync is always true for Terraformprovider.
+func (provider *terraformProvider) BindsAsync() bool {
+	return true
+}
+
 // UpdateInstanceDetails updates the ServiceInstanceDetails with the most recent state from GCP.
 // This function is optional, but will be called after async provisions, updates, and possibly
 // on broker version changes.
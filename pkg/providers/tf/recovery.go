@@ -0,0 +1,242 @@
+// Copyright 2021 the Service Broker Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tf
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/db_service"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/db_service/models"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/pkg/broker"
+)
+
+const interruptedMessage = "the operation was interrupted because the broker stopped, please retry the operation"
+
+// errUnexpectedState is returned when a deployment is no longer in the state it
+// was found in by the time it is locked.
+var errUnexpectedState = errors.New("the state of the deployment has changed")
+
+// RecoverInterruptedJobs finds deployments that are marked as in progress but
+// that no broker is running an operation on, because the broker that was
+// running it stopped. Operations hold a lock on their deployment, renewed
+// while they run, so such deployments are those whose lock has been released
+// or has expired.
+//
+// If resume is true, the interrupted operation is restarted using the workspace
+// that was stored when it started. Otherwise, or if the operation cannot be
+// restarted, the deployment is marked as failed so the platform can retry it.
+// Previews of updates are never restarted, as nothing waits for their result
+// once the request that asked for them has been answered.
+func RecoverInterruptedJobs(ctx context.Context, registry broker.BrokerRegistry, resume bool, logger lager.Logger) error {
+	deployments, err := db_service.GetTerraformDeploymentsByLastOperationState(ctx, InProgress)
+	if err != nil {
+		return fmt.Errorf("error listing in progress deployments: %w", err)
+	}
+
+	for i := range deployments {
+		deployment := &deployments[i]
+
+		// another broker sharing the database may be running the operation
+		locked, err := db_service.IsTerraformDeploymentLocked(ctx, deployment.ID)
+		switch {
+		case err != nil:
+			return fmt.Errorf("error checking lock on deployment %q: %w", deployment.ID, err)
+		case locked:
+			continue
+		}
+
+		operationType, err := interruptedOperationType(ctx, deployment)
+		if err != nil {
+			return err
+		}
+
+		data := lager.Data{"deployment": deployment.ID, "operation": operationType}
+
+		if resume && operationType != planOperationType {
+			err := resumeJob(ctx, registry, deployment.ID, operationType, logger)
+			switch {
+			case err == nil:
+				logger.Info("resumed-interrupted-job", data)
+				continue
+			case errors.Is(err, ErrDeploymentLocked), errors.Is(err, errUnexpectedState):
+				// an operation was started or finished since the deployment was listed
+				continue
+			default:
+				logger.Error("resume-interrupted-job", err, data)
+			}
+		}
+
+		switch err := failDeployment(ctx, deployment, interruptedMessage); {
+		case errors.Is(err, ErrDeploymentLocked), errors.Is(err, errUnexpectedState):
+			continue
+		case err != nil:
+			return fmt.Errorf("error marking deployment %q as failed: %w", deployment.ID, err)
+		}
+		logger.Info("failed-interrupted-job", data)
+	}
+
+	return nil
+}
+
+// StartInterruptedJobSweeper recovers interrupted jobs in the background, as
+// RecoverInterruptedJobs does, until the context is done. Jobs are found once
+// the locks of the broker that was running them expire, which can be after
+// this broker started, if it is the one that stopped.
+func StartInterruptedJobSweeper(ctx context.Context, registry broker.BrokerRegistry, resume bool, logger lager.Logger) {
+	logger = logger.Session("interrupted-job-sweeper")
+
+	go func() {
+		ticker := time.NewTicker(deploymentLockDuration)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := RecoverInterruptedJobs(ctx, registry, resume, logger); err != nil {
+					logger.Error("sweep", err)
+				}
+			}
+		}
+	}()
+}
+
+// interruptedOperationType finds the type of the operation that was interrupted.
+// Deployments from before jobs were recorded fall back to the last operation type.
+func interruptedOperationType(ctx context.Context, deployment *models.TerraformDeployment) (string, error) {
+	jobs, err := db_service.GetTerraformJobsByDeploymentIdAndState(ctx, deployment.ID, InProgress)
+	switch {
+	case err != nil:
+		return "", fmt.Errorf("error listing jobs for deployment %q: %w", deployment.ID, err)
+	case len(jobs) == 0:
+		return deployment.LastOperationType, nil
+	default:
+		return jobs[len(jobs)-1].OperationType, nil
+	}
+}
+
+func resumeJob(ctx context.Context, registry broker.BrokerRegistry, deploymentID, operationType string, logger lager.Logger) error {
+	runner, instance, err := runnerForDeployment(ctx, registry, deploymentID, logger)
+	if err != nil {
+		return err
+	}
+
+	return runner.resume(ctx, deploymentID, instance.PlanId, operationType, InProgress)
+}
+
+// NewTfJobRunnerForDeployment creates the TfJobRunner of the service that the
+// deployment belongs to, so that it has the environment and limits the service
+// needs to run Terraform.
+func NewTfJobRunnerForDeployment(ctx context.Context, registry broker.BrokerRegistry, deploymentID string, logger lager.Logger) (*TfJobRunner, error) {
+	runner, _, err := runnerForDeployment(ctx, registry, deploymentID, logger)
+	return runner, err
+}
+
+func runnerForDeployment(ctx context.Context, registry broker.BrokerRegistry, deploymentID string, logger lager.Logger) (*TfJobRunner, *models.ServiceInstanceDetails, error) {
+	provider, instance, err := providerForDeployment(ctx, registry, deploymentID, logger)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return provider.jobRunner, instance, nil
+}
+
+func providerForDeployment(ctx context.Context, registry broker.BrokerRegistry, deploymentID string, logger lager.Logger) (*terraformProvider, *models.ServiceInstanceDetails, error) {
+	instanceID, _, err := parseTfId(deploymentID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	instance, err := db_service.GetServiceInstanceDetailsById(ctx, instanceID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error retrieving service instance details: %w", err)
+	}
+
+	defn, err := registry.GetServiceById(instance.ServiceId)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	provider, ok := defn.ProviderBuilder(logger).(*terraformProvider)
+	if !ok {
+		return nil, nil, fmt.Errorf("service %q is not backed by Terraform", defn.Name)
+	}
+
+	return provider, instance, nil
+}
+
+// failDeployment marks the operation in progress on the deployment, and any
+// jobs running it, as failed with the given message. A preview of an update
+// only fails its job, and the deployment is given back the last operation that
+// was recorded before it, as a finished preview would. If the deployment is no
+// longer in progress once it is locked, errUnexpectedState is returned.
+func failDeployment(ctx context.Context, deployment *models.TerraformDeployment, message string) error {
+	lock, err := lockDeployment(ctx, deployment.ID)
+	if err != nil {
+		return err
+	}
+	defer lock.release()
+
+	current, err := db_service.GetTerraformDeploymentById(ctx, deployment.ID)
+	switch {
+	case err != nil:
+		return err
+	case current.LastOperationState != InProgress:
+		return errUnexpectedState
+	}
+	*deployment = *current
+
+	deployment.LastOperationState = Failed
+	deployment.LastOperationMessage = message
+	if deployment.LastOperationType == planOperationType {
+		previous, err := db_service.GetLastTerraformJobByDeploymentIdExcludingOperationType(ctx, deployment.ID, planOperationType)
+		switch {
+		case err != nil:
+			return err
+		case previous != nil && previous.State != InProgress:
+			deployment.LastOperationType = previous.OperationType
+			if previous.OperationType == importOperationType {
+				deployment.LastOperationType = models.ProvisionOperationType
+			}
+			deployment.LastOperationState = previous.State
+			deployment.LastOperationMessage = previous.Message
+		}
+	}
+	if err := db_service.SaveTerraformDeployment(ctx, deployment); err != nil {
+		return err
+	}
+
+	jobs, err := db_service.GetTerraformJobsByDeploymentIdAndState(ctx, deployment.ID, InProgress)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for i := range jobs {
+		jobs[i].State = Failed
+		jobs[i].Message = message
+		jobs[i].FinishedAt = &now
+		if err := db_service.SaveTerraformJob(ctx, &jobs[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
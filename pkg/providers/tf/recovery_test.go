@@ -0,0 +1,166 @@
+// Copyright 2021 the Service Broker Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tf
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/db_service"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/db_service/models"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/internal/encryption/noopencryptor"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/pkg/broker"
+	. "github.com/onsi/gomega"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestRecoverInterruptedJobs(t *testing.T) {
+	cases := map[string]struct {
+		resume        bool
+		operationType string
+	}{
+		"without-resume": {resume: false},
+		// resuming fails because the service instance does not exist, so the job is failed instead
+		"resume-not-possible": {resume: true},
+		// previews are failed without being resumed
+		"preview": {resume: true, operationType: planOperationType},
+	}
+
+	for tn, tc := range cases {
+		t.Run(tn, func(t *testing.T) {
+			g := NewGomegaWithT(t)
+			ctx := context.Background()
+
+			db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+			g.Expect(err).NotTo(HaveOccurred())
+			g.Expect(db.Migrator().CreateTable(models.ServiceInstanceDetails{}, models.TerraformDeployment{}, models.TerraformJob{}, models.TerraformDeploymentLock{})).To(Succeed())
+			db_service.DbConnection = db
+			models.SetEncryptor(noopencryptor.New())
+
+			operationType := "provision"
+			if tc.operationType != "" {
+				operationType = tc.operationType
+			}
+
+			interrupted := models.TerraformDeployment{ID: "tf:instance:", LastOperationType: operationType, LastOperationState: InProgress}
+			finished := models.TerraformDeployment{ID: "tf:other:", LastOperationType: "provision", LastOperationState: Succeeded}
+			g.Expect(db_service.CreateTerraformDeployment(ctx, &interrupted)).To(Succeed())
+			g.Expect(db_service.CreateTerraformDeployment(ctx, &finished)).To(Succeed())
+			g.Expect(db_service.CreateTerraformJob(ctx, &models.TerraformJob{DeploymentId: interrupted.ID, OperationType: operationType, State: InProgress})).To(Succeed())
+
+			err = RecoverInterruptedJobs(ctx, broker.BrokerRegistry{}, tc.resume, lager.NewLogger("test"))
+			g.Expect(err).NotTo(HaveOccurred())
+
+			deployment, err := db_service.GetTerraformDeploymentById(ctx, interrupted.ID)
+			g.Expect(err).NotTo(HaveOccurred())
+			g.Expect(deployment.LastOperationState).To(Equal(Failed))
+			g.Expect(deployment.LastOperationMessage).To(Equal(interruptedMessage))
+
+			jobs, err := db_service.GetTerraformJobsByDeploymentIdAndState(ctx, interrupted.ID, InProgress)
+			g.Expect(err).NotTo(HaveOccurred())
+			g.Expect(jobs).To(BeEmpty())
+
+			deployment, err = db_service.GetTerraformDeploymentById(ctx, finished.ID)
+			g.Expect(err).NotTo(HaveOccurred())
+			g.Expect(deployment.LastOperationState).To(Equal(Succeeded))
+		})
+	}
+}
+
+func TestRecoverInterruptedJobs_PreviewAfterFailedUpdate(t *testing.T) {
+	g := NewGomegaWithT(t)
+	ctx := context.Background()
+
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(db.Migrator().CreateTable(models.ServiceInstanceDetails{}, models.TerraformDeployment{}, models.TerraformJob{}, models.TerraformDeploymentLock{})).To(Succeed())
+	db_service.DbConnection = db
+	models.SetEncryptor(noopencryptor.New())
+
+	interrupted := models.TerraformDeployment{ID: "tf:instance:", LastOperationType: planOperationType, LastOperationState: InProgress}
+	g.Expect(db_service.CreateTerraformDeployment(ctx, &interrupted)).To(Succeed())
+	g.Expect(db_service.CreateTerraformJob(ctx, &models.TerraformJob{DeploymentId: interrupted.ID, OperationType: models.UpdateOperationType, State: Failed, Message: "update failed"})).To(Succeed())
+	g.Expect(db_service.CreateTerraformJob(ctx, &models.TerraformJob{DeploymentId: interrupted.ID, OperationType: planOperationType, State: InProgress})).To(Succeed())
+
+	g.Expect(RecoverInterruptedJobs(ctx, broker.BrokerRegistry{}, true, lager.NewLogger("test"))).To(Succeed())
+
+	deployment, err := db_service.GetTerraformDeploymentById(ctx, interrupted.ID)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(deployment.LastOperationType).To(Equal(models.UpdateOperationType))
+	g.Expect(deployment.LastOperationState).To(Equal(Failed))
+	g.Expect(deployment.LastOperationMessage).To(Equal("update failed"))
+
+	job, err := db_service.GetLastTerraformJobByDeploymentId(ctx, interrupted.ID)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(job.State).To(Equal(Failed))
+	g.Expect(job.Message).To(Equal(interruptedMessage))
+}
+
+func TestRecoverInterruptedJobs_LockedByAnotherBroker(t *testing.T) {
+	g := NewGomegaWithT(t)
+	ctx := context.Background()
+
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(db.Migrator().CreateTable(models.ServiceInstanceDetails{}, models.TerraformDeployment{}, models.TerraformJob{}, models.TerraformDeploymentLock{})).To(Succeed())
+	db_service.DbConnection = db
+	models.SetEncryptor(noopencryptor.New())
+
+	running := models.TerraformDeployment{ID: "tf:instance:", LastOperationType: "provision", LastOperationState: InProgress}
+	g.Expect(db_service.CreateTerraformDeployment(ctx, &running)).To(Succeed())
+	g.Expect(db_service.AcquireTerraformDeploymentLock(ctx, running.ID, "other-broker", time.Now().Add(time.Minute))).To(BeTrue())
+
+	g.Expect(RecoverInterruptedJobs(ctx, broker.BrokerRegistry{}, false, lager.NewLogger("test"))).To(Succeed())
+
+	deployment, err := db_service.GetTerraformDeploymentById(ctx, running.ID)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(deployment.LastOperationState).To(Equal(InProgress))
+}
+
+func TestStartInterruptedJobSweeper(t *testing.T) {
+	g := NewGomegaWithT(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	defer func(duration time.Duration) { deploymentLockDuration = duration }(deploymentLockDuration)
+	deploymentLockDuration = 50 * time.Millisecond
+
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(db.Migrator().CreateTable(models.ServiceInstanceDetails{}, models.TerraformDeployment{}, models.TerraformJob{}, models.TerraformDeploymentLock{})).To(Succeed())
+	db_service.DbConnection = db
+	models.SetEncryptor(noopencryptor.New())
+
+	// the lock of a broker that stopped, which has not expired when recovery runs at startup
+	interrupted := models.TerraformDeployment{ID: "tf:instance:", LastOperationType: "provision", LastOperationState: InProgress}
+	g.Expect(db_service.CreateTerraformDeployment(ctx, &interrupted)).To(Succeed())
+	g.Expect(db_service.AcquireTerraformDeploymentLock(ctx, interrupted.ID, "stopped-broker", time.Now().Add(200*time.Millisecond))).To(BeTrue())
+
+	g.Expect(RecoverInterruptedJobs(ctx, broker.BrokerRegistry{}, false, lager.NewLogger("test"))).To(Succeed())
+	deployment, err := db_service.GetTerraformDeploymentById(ctx, interrupted.ID)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(deployment.LastOperationState).To(Equal(InProgress))
+
+	StartInterruptedJobSweeper(ctx, broker.BrokerRegistry{}, false, lager.NewLogger("test"))
+
+	g.Eventually(func() string {
+		deployment, err := db_service.GetTerraformDeploymentById(ctx, interrupted.ID)
+		g.Expect(err).NotTo(HaveOccurred())
+		return deployment.LastOperationState
+	}, time.Second, 10*time.Millisecond).Should(Equal(Failed))
+}
@@ -0,0 +1,128 @@
+// Copyright 2021 the Service Broker Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tf
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/db_service"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/db_service/models"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/pkg/broker"
+)
+
+const resetMessage = "the operation was reset by an operator, please retry the operation"
+
+// ErrOperationNotFailed is returned when retrying an operation that did not fail.
+var ErrOperationNotFailed = errors.New("the last operation on the deployment did not fail")
+
+// ErrPreviewNotRerunnable is returned when rerunning an operation that was a
+// preview of an update, which is run again by requesting the dry run again.
+var ErrPreviewNotRerunnable = errors.New("the last operation on the deployment is a preview of an update, which cannot be rerun")
+
+// ErrImportNotRerunnable is returned when rerunning an import, which could
+// create duplicates of the resources it imports.
+var ErrImportNotRerunnable = errors.New("the last operation on the deployment is an import, which cannot be rerun")
+
+// DeploymentId is the id of the TerraformDeployment of a service instance, or
+// of one of its bindings if bindingId is set.
+func DeploymentId(instanceId, bindingId string) string {
+	return generateTfId(instanceId, bindingId)
+}
+
+// ResetOperationState marks an operation that is stuck in progress on the
+// deployment as failed so that it can be retried. It does nothing if no
+// operation is in progress, and fails with ErrDeploymentLocked if the
+// operation is still running, in which case it should be cancelled instead.
+func ResetOperationState(ctx context.Context, id string) error {
+	deployment, err := db_service.GetTerraformDeploymentById(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if deployment.LastOperationState != InProgress {
+		return nil
+	}
+
+	if err := failDeployment(ctx, deployment, resetMessage); !errors.Is(err, errUnexpectedState) {
+		return err
+	}
+	return nil
+}
+
+// RerunLastOperation runs the last operation on the deployment again, using
+// the workspace that was stored when it started, with the runner of the
+// service that the deployment belongs to. It returns once the operation has
+// started. Previews of updates fail with ErrPreviewNotRerunnable, and imports
+// with ErrImportNotRerunnable.
+func RerunLastOperation(ctx context.Context, registry broker.BrokerRegistry, id string, logger lager.Logger) error {
+	deployment, err := db_service.GetTerraformDeploymentById(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	operationType, err := lastOperationType(ctx, deployment)
+	switch {
+	case err != nil:
+		return err
+	case operationType == planOperationType:
+		return ErrPreviewNotRerunnable
+	case operationType == importOperationType:
+		return ErrImportNotRerunnable
+	}
+
+	runner, instance, err := runnerForDeployment(ctx, registry, id, logger)
+	if err != nil {
+		return err
+	}
+
+	return runner.Resume(ctx, id, instance.PlanId, operationType)
+}
+
+// lastOperationType finds the type of the last operation on the deployment
+// from its jobs, as imports are recorded on the deployment as provisions.
+// Previews of updates are passed over, as they leave the last operation on the
+// deployment as it was. Deployments from before jobs were recorded fall back to
+// the last operation type.
+func lastOperationType(ctx context.Context, deployment *models.TerraformDeployment) (string, error) {
+	job, err := db_service.GetLastTerraformJobByDeploymentIdExcludingOperationType(ctx, deployment.ID, planOperationType)
+	switch {
+	case err != nil:
+		return "", fmt.Errorf("error getting the last job for deployment %q: %w", deployment.ID, err)
+	case job == nil:
+		return deployment.LastOperationType, nil
+	default:
+		return job.OperationType, nil
+	}
+}
+
+// RetryFailedOperation runs the last operation on the deployment again if it
+// failed, for example because of a transient error from the cloud provider.
+// The deployment is marked as in progress, so that the next time the platform
+// polls the last operation it sees the result of the retry.
+func RetryFailedOperation(ctx context.Context, registry broker.BrokerRegistry, id string, logger lager.Logger) error {
+	deployment, err := db_service.GetTerraformDeploymentById(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if deployment.LastOperationState != Failed {
+		return ErrOperationNotFailed
+	}
+
+	return RerunLastOperation(ctx, registry, id, logger)
+}
@@ -0,0 +1,206 @@
+// Copyright 2021 the Service Broker Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tf
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"testing"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/db_service"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/db_service/models"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/pkg/broker"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/pkg/providers/tf/wrapper"
+	. "github.com/onsi/gomega"
+)
+
+func TestResetOperationState(t *testing.T) {
+	t.Run("stuck", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		ctx := context.Background()
+		deployment := setupUpdatableDeployment(g)
+		deployment.LastOperationState = InProgress
+		g.Expect(db_service.SaveTerraformDeployment(ctx, deployment)).To(Succeed())
+		g.Expect(db_service.CreateTerraformJob(ctx, &models.TerraformJob{DeploymentId: deployment.ID, OperationType: models.UpdateOperationType, State: InProgress})).To(Succeed())
+
+		g.Expect(ResetOperationState(ctx, deployment.ID)).To(Succeed())
+
+		saved, err := db_service.GetTerraformDeploymentById(ctx, deployment.ID)
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(saved.LastOperationState).To(Equal(Failed))
+		g.Expect(saved.LastOperationMessage).To(Equal(resetMessage))
+
+		jobs, err := db_service.GetTerraformJobsByDeploymentIdAndState(ctx, deployment.ID, InProgress)
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(jobs).To(BeEmpty())
+	})
+
+	t.Run("finished", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		ctx := context.Background()
+		deployment := setupUpdatableDeployment(g)
+
+		g.Expect(ResetOperationState(ctx, deployment.ID)).To(Succeed())
+
+		saved, err := db_service.GetTerraformDeploymentById(ctx, deployment.ID)
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(saved.LastOperationState).To(Equal(Succeeded))
+	})
+
+	t.Run("running", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		ctx := context.Background()
+		deployment := setupUpdatableDeployment(g)
+		deployment.LastOperationState = InProgress
+		g.Expect(db_service.SaveTerraformDeployment(ctx, deployment)).To(Succeed())
+
+		lock, err := lockDeployment(ctx, deployment.ID)
+		g.Expect(err).NotTo(HaveOccurred())
+		defer lock.release()
+
+		err = ResetOperationState(ctx, deployment.ID)
+		g.Expect(errors.Is(err, ErrDeploymentLocked)).To(BeTrue())
+	})
+}
+
+func TestRetryFailedOperation(t *testing.T) {
+	cases := map[string]struct {
+		state            string
+		operationType    string
+		jobOperationType string
+		expectedError    error
+	}{
+		"failed":         {state: Failed},
+		"succeeded":      {state: Succeeded, expectedError: ErrOperationNotFailed},
+		"failed-preview": {state: Failed, operationType: planOperationType, expectedError: ErrPreviewNotRerunnable},
+		"failed-import":  {state: Failed, jobOperationType: importOperationType, expectedError: ErrImportNotRerunnable},
+	}
+
+	for tn, tc := range cases {
+		t.Run(tn, func(t *testing.T) {
+			g := NewGomegaWithT(t)
+			ctx := context.Background()
+			deployment := setupUpdatableDeployment(g)
+			deployment.LastOperationState = tc.state
+			if tc.operationType != "" {
+				deployment.LastOperationType = tc.operationType
+			}
+			g.Expect(db_service.SaveTerraformDeployment(ctx, deployment)).To(Succeed())
+			if tc.jobOperationType != "" {
+				g.Expect(db_service.CreateTerraformJob(ctx, &models.TerraformJob{DeploymentId: deployment.ID, OperationType: tc.jobOperationType, State: tc.state})).To(Succeed())
+			}
+
+			var applied bool
+			runner := NewTfJobRunnerForProject(map[string]string{})
+			runner.Executor = func(ctx context.Context, cmd *exec.Cmd) (wrapper.ExecutionOutput, error) {
+				applied = applied || cmd.Args[1] == "apply"
+				return wrapper.ExecutionOutput{}, nil
+			}
+			registry := setupRegistry(g, runner)
+
+			err := RetryFailedOperation(ctx, registry, deployment.ID, lager.NewLogger("test"))
+			if tc.expectedError != nil {
+				g.Expect(err).To(MatchError(tc.expectedError))
+				g.Expect(applied).To(BeFalse())
+				return
+			}
+
+			g.Expect(err).NotTo(HaveOccurred())
+			g.Expect(runner.Wait(ctx, deployment.ID)).To(Succeed())
+			g.Expect(applied).To(BeTrue())
+
+			saved, err := db_service.GetTerraformDeploymentById(ctx, deployment.ID)
+			g.Expect(err).NotTo(HaveOccurred())
+			g.Expect(saved.LastOperationType).To(Equal(models.ProvisionOperationType))
+			g.Expect(saved.LastOperationState).To(Equal(Succeeded))
+		})
+	}
+}
+
+func TestRerunLastOperation(t *testing.T) {
+	cases := map[string]struct {
+		operationType         string
+		jobOperationTypes     []string
+		expectedOperationType string
+		expectedError         error
+	}{
+		"update": {jobOperationTypes: []string{models.UpdateOperationType}, expectedOperationType: models.UpdateOperationType},
+		"import": {jobOperationTypes: []string{importOperationType}, expectedError: ErrImportNotRerunnable},
+		// previews leave the last operation on the deployment as it was
+		"update-then-preview": {jobOperationTypes: []string{models.UpdateOperationType, planOperationType}, expectedOperationType: models.UpdateOperationType},
+		// deployments previewed before previews were kept off the deployment
+		"preview": {operationType: planOperationType, expectedError: ErrPreviewNotRerunnable},
+	}
+
+	for tn, tc := range cases {
+		t.Run(tn, func(t *testing.T) {
+			g := NewGomegaWithT(t)
+			ctx := context.Background()
+			deployment := setupUpdatableDeployment(g)
+			deployment.LastOperationState = Failed
+			if tc.operationType != "" {
+				deployment.LastOperationType = tc.operationType
+			}
+			g.Expect(db_service.SaveTerraformDeployment(ctx, deployment)).To(Succeed())
+			for _, operationType := range tc.jobOperationTypes {
+				g.Expect(db_service.CreateTerraformJob(ctx, &models.TerraformJob{DeploymentId: deployment.ID, OperationType: operationType, State: Failed})).To(Succeed())
+			}
+
+			var applied bool
+			runner := NewTfJobRunnerForProject(map[string]string{})
+			runner.Executor = func(ctx context.Context, cmd *exec.Cmd) (wrapper.ExecutionOutput, error) {
+				applied = applied || cmd.Args[1] == "apply"
+				return wrapper.ExecutionOutput{}, nil
+			}
+			registry := setupRegistry(g, runner)
+
+			err := RerunLastOperation(ctx, registry, deployment.ID, lager.NewLogger("test"))
+			if tc.expectedError != nil {
+				g.Expect(err).To(MatchError(tc.expectedError))
+				g.Expect(applied).To(BeFalse())
+				return
+			}
+
+			g.Expect(err).NotTo(HaveOccurred())
+			g.Expect(runner.Wait(ctx, deployment.ID)).To(Succeed())
+			g.Expect(applied).To(BeTrue())
+
+			saved, err := db_service.GetTerraformDeploymentById(ctx, deployment.ID)
+			g.Expect(err).NotTo(HaveOccurred())
+			g.Expect(saved.LastOperationType).To(Equal(tc.expectedOperationType))
+			g.Expect(saved.LastOperationState).To(Equal(Succeeded))
+		})
+	}
+}
+
+// setupRegistry creates a service, backed by the runner, with an instance
+// that the deployment created by setupUpdatableDeployment belongs to.
+func setupRegistry(g *GomegaWithT, runner *TfJobRunner) broker.BrokerRegistry {
+	g.Expect(db_service.DbConnection.Migrator().CreateTable(models.ServiceInstanceDetails{})).To(Succeed())
+	instance := models.ServiceInstanceDetails{ID: "instance", ServiceId: "service-id", PlanId: "plan-id"}
+	g.Expect(db_service.CreateServiceInstanceDetails(context.Background(), &instance)).To(Succeed())
+
+	return broker.BrokerRegistry{
+		"service": &broker.ServiceDefinition{
+			Id:   "service-id",
+			Name: "service",
+			ProviderBuilder: func(logger lager.Logger) broker.ServiceProvider {
+				return NewTerraformProvider(runner, logger, TfServiceDefinitionV1{Name: "service"})
+			},
+		},
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright 2021 the Service Broker Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tf
+
+import (
+	"context"
+	"regexp"
+	"time"
+)
+
+// RetryPolicy describes how Terraform operations that fail with transient
+// errors, such as throttling or eventual consistency errors from the cloud
+// provider, are retried. The zero value never retries.
+type RetryPolicy struct {
+	// MaxAttempts is how many times an operation is attempted in total.
+	MaxAttempts int
+	// Backoff is the wait before the first retry. It doubles for each retry
+	// after that, up to MaxBackoff if it is set.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// RetryableErrors match the messages of the errors that are retried.
+	RetryableErrors []*regexp.Regexp
+}
+
+// retryable reports whether the operation should be retried after failing
+// with the error.
+func (policy RetryPolicy) retryable(err error) bool {
+	for _, re := range policy.RetryableErrors {
+		if re.MatchString(err.Error()) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// backoff is the wait after the given attempt, counting from 1, fails.
+func (policy RetryPolicy) backoff(attempt int) time.Duration {
+	delay := policy.Backoff
+	for i := 1; i < attempt; i++ {
+		delay *= 2
+		if policy.MaxBackoff > 0 && delay >= policy.MaxBackoff {
+			return policy.MaxBackoff
+		}
+	}
+
+	return delay
+}
+
+// run runs the operation until it succeeds, fails with an error that is not
+// retryable, has been attempted MaxAttempts times, or the context is done.
+// onRetry is called before waiting to retry. It returns the number of times
+// the operation was attempted.
+func (policy RetryPolicy) run(ctx context.Context, operation func(context.Context) error, onRetry func(attempt int, delay time.Duration, err error)) (int, error) {
+	for attempt := 1; ; attempt++ {
+		err := operation(ctx)
+		if err == nil || attempt >= policy.MaxAttempts || !policy.retryable(err) {
+			return attempt, err
+		}
+
+		delay := policy.backoff(attempt)
+		onRetry(attempt, delay, err)
+
+		select {
+		case <-ctx.Done():
+			return attempt, err
+		case <-time.After(delay):
+		}
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright 2021 the Service Broker Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tf
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	g := NewGomegaWithT(t)
+	policy := RetryPolicy{Backoff: time.Second, MaxBackoff: 5 * time.Second}
+
+	g.Expect(policy.backoff(1)).To(Equal(time.Second))
+	g.Expect(policy.backoff(2)).To(Equal(2 * time.Second))
+	g.Expect(policy.backoff(3)).To(Equal(4 * time.Second))
+	g.Expect(policy.backoff(4)).To(Equal(5 * time.Second))
+	g.Expect(policy.backoff(10)).To(Equal(5 * time.Second))
+}
+
+func TestRetryPolicy_Run(t *testing.T) {
+	throttled := errors.New("Error: Throttling: Rate exceeded")
+	invalid := errors.New("Error: invalid instance type")
+
+	cases := map[string]struct {
+		policy           RetryPolicy
+		errs             []error
+		expectedAttempts int
+		expectedError    error
+	}{
+		"zero value": {
+			errs:             []error{throttled},
+			expectedAttempts: 1,
+			expectedError:    throttled,
+		},
+		"succeeds after retrying": {
+			policy:           RetryPolicy{MaxAttempts: 3, RetryableErrors: []*regexp.Regexp{regexp.MustCompile("Throttling")}},
+			errs:             []error{throttled, throttled, nil},
+			expectedAttempts: 3,
+		},
+		"not retryable": {
+			policy:           RetryPolicy{MaxAttempts: 3, RetryableErrors: []*regexp.Regexp{regexp.MustCompile("Throttling")}},
+			errs:             []error{throttled, invalid},
+			expectedAttempts: 2,
+			expectedError:    invalid,
+		},
+		"too many attempts": {
+			policy:           RetryPolicy{MaxAttempts: 2, RetryableErrors: []*regexp.Regexp{regexp.MustCompile("Throttling")}},
+			errs:             []error{throttled, throttled, nil},
+			expectedAttempts: 2,
+			expectedError:    throttled,
+		},
+	}
+
+	for tn, tc := range cases {
+		t.Run(tn, func(t *testing.T) {
+			g := NewGomegaWithT(t)
+
+			var calls, retries int
+			attempts, err := tc.policy.run(context.Background(), func(context.Context) error {
+				calls++
+				return tc.errs[calls-1]
+			}, func(attempt int, delay time.Duration, err error) {
+				retries++
+				g.Expect(attempt).To(Equal(retries))
+			})
+
+			g.Expect(attempts).To(Equal(tc.expectedAttempts))
+			g.Expect(calls).To(Equal(tc.expectedAttempts))
+			g.Expect(retries).To(Equal(tc.expectedAttempts - 1))
+			if tc.expectedError == nil {
+				g.Expect(err).NotTo(HaveOccurred())
+			} else {
+				g.Expect(err).To(Equal(tc.expectedError))
+			}
+		})
+	}
+
+	t.Run("cancelled while waiting", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		ctx, cancel := context.WithCancel(context.Background())
+		policy := RetryPolicy{MaxAttempts: 3, Backoff: time.Hour, RetryableErrors: []*regexp.Regexp{regexp.MustCompile("Throttling")}}
+
+		attempts, err := policy.run(ctx, func(context.Context) error {
+			return throttled
+		}, func(int, time.Duration, error) {
+			cancel()
+		})
+
+		g.Expect(attempts).To(Equal(1))
+		g.Expect(err).To(Equal(throttled))
+	})
+}
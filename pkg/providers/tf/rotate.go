@@ -0,0 +1,324 @@
+// Copyright 2021 the Service Broker Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tf
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/db_service"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/db_service/models"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/pkg/broker"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/pkg/providers/tf/wrapper"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/utils/correlation"
+	"github.com/pborman/uuid"
+)
+
+const (
+	// credentialsSeedVariable is a computed variable of bindings that is
+	// changed to a new random value to rotate their credentials. Bind templates
+	// support rotation by declaring it and using it as a keeper of the random
+	// resources that generate secrets.
+	credentialsSeedVariable = "tf_credentials_seed"
+
+	// previousCredentialsSeedVariable holds the seed of the credentials that
+	// were replaced by a rotation while they are kept valid, and is empty
+	// otherwise. Bind templates that declare it can keep the previous
+	// credentials valid for a grace period.
+	previousCredentialsSeedVariable = "tf_previous_credentials_seed"
+
+	// credentialRetirementInterval is how often the previous credentials of
+	// bindings are checked to see if their grace period has ended.
+	credentialRetirementInterval = time.Minute
+
+	// maxCredentialRetirementAttempts is how many times removing the previous
+	// credentials of a binding is tried before it is left to an operator. The
+	// wait before each retry doubles, starting at twice the interval.
+	maxCredentialRetirementAttempts = 5
+)
+
+var (
+	// ErrRotationNotSupported is returned when rotating the credentials of a
+	// binding whose template does not declare the credentials seed variable.
+	ErrRotationNotSupported = fmt.Errorf("the binding template does not support credential rotation, it must declare the %s variable", credentialsSeedVariable)
+
+	// ErrGracePeriodNotSupported is returned when rotating the credentials of a
+	// binding with a grace period when its template cannot keep the previous
+	// credentials valid.
+	ErrGracePeriodNotSupported = fmt.Errorf("the binding template does not support a grace period, it must declare the %s variable", previousCredentialsSeedVariable)
+)
+
+// RotateCredentials runs `terraform apply` in the background on the workspace
+// of a binding with a new credentials seed, so that the secrets it generates
+// are replaced. If the grace period is not zero, the previous credentials are
+// kept valid until it ends, when RetireExpiredCredentials removes them. If the
+// apply fails, the seeds and the end of the grace period are restored to what
+// they were before the rotation.
+func (runner *TfJobRunner) RotateCredentials(ctx context.Context, id, planId string, gracePeriod time.Duration) (err error) {
+	lock, err := lockDeployment(ctx, id)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			lock.release()
+		}
+	}()
+
+	deployment, err := db_service.GetTerraformDeploymentById(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	workspace, err := runner.hydrateWorkspace(ctx, deployment)
+	if err != nil {
+		return err
+	}
+
+	inputs, err := moduleInputs(workspace)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case !inputs[credentialsSeedVariable]:
+		return ErrRotationNotSupported
+	case gracePeriod > 0 && !inputs[previousCredentialsSeedVariable]:
+		return ErrGracePeriodNotSupported
+	}
+
+	previousConfiguration := copyConfiguration(workspace)
+	previousRotatedAt, previousExpireAt := deployment.CredentialsRotatedAt, deployment.PreviousCredentialsExpireAt
+	previousFailures := deployment.CredentialRetirementFailures
+
+	templateVars := copyConfiguration(workspace)
+	previousSeed := templateVars[credentialsSeedVariable]
+	templateVars[credentialsSeedVariable] = uuid.New()
+	templateVars[previousCredentialsSeedVariable] = ""
+
+	now := time.Now()
+	deployment.CredentialsRotatedAt = &now
+	deployment.PreviousCredentialsExpireAt = nil
+	deployment.CredentialRetirementFailures = 0
+	if gracePeriod > 0 {
+		expireAt := now.Add(gracePeriod)
+		deployment.PreviousCredentialsExpireAt = &expireAt
+		templateVars[previousCredentialsSeedVariable] = previousSeed
+	}
+
+	if err := setConfiguration(workspace, templateVars); err != nil {
+		return err
+	}
+
+	if err := runner.markJobStarted(ctx, deployment, workspace, models.UpdateOperationType); err != nil {
+		return err
+	}
+
+	runner.applyCredentials(ctx, lock, deployment, workspace, planId, func() {}, func() {
+		workspace.Instances[0].Configuration = previousConfiguration
+		deployment.CredentialsRotatedAt = previousRotatedAt
+		deployment.PreviousCredentialsExpireAt = previousExpireAt
+		deployment.CredentialRetirementFailures = previousFailures
+	})
+
+	return nil
+}
+
+// RetirePreviousCredentials runs `terraform apply` in the background on the
+// workspace of a binding without the seed of its previous credentials, so that
+// they are removed. The end of their grace period is cleared once the apply
+// succeeds. If it fails, the failure is counted and the end of the grace
+// period is moved on, so that retiring them is tried again later.
+func (runner *TfJobRunner) RetirePreviousCredentials(ctx context.Context, id, planId string) (err error) {
+	lock, err := lockDeployment(ctx, id)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			lock.release()
+		}
+	}()
+
+	deployment, err := db_service.GetTerraformDeploymentById(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	workspace, err := runner.hydrateWorkspace(ctx, deployment)
+	if err != nil {
+		return err
+	}
+
+	previousConfiguration := copyConfiguration(workspace)
+
+	templateVars := copyConfiguration(workspace)
+	templateVars[previousCredentialsSeedVariable] = ""
+	if err := setConfiguration(workspace, templateVars); err != nil {
+		return err
+	}
+
+	if err := runner.markJobStarted(ctx, deployment, workspace, models.UpdateOperationType); err != nil {
+		return err
+	}
+
+	runner.applyCredentials(ctx, lock, deployment, workspace, planId, func() {
+		deployment.PreviousCredentialsExpireAt = nil
+		deployment.CredentialRetirementFailures = 0
+	}, func() {
+		workspace.Instances[0].Configuration = previousConfiguration
+		deployment.CredentialRetirementFailures++
+		retryAt := time.Now().Add(credentialRetirementInterval << deployment.CredentialRetirementFailures)
+		deployment.PreviousCredentialsExpireAt = &retryAt
+	})
+
+	return nil
+}
+
+// applyCredentials runs `terraform apply` in the background on the workspace
+// of a binding, retrying as runWithRetries does, once it has checked that the
+// update does not replace protected resources. Once it has finished, either
+// succeeded or failed is called to change the deployment or workspace, before
+// they are saved, according to the result.
+func (runner *TfJobRunner) applyCredentials(ctx context.Context, lock *deploymentLock, deployment *models.TerraformDeployment, workspace *wrapper.TerraformWorkspace, planId string, succeeded, failed func()) {
+	apply := runner.preventingReplace(workspace, runner.withRetries(deployment, workspace, workspace.Apply))
+	runner.runReporting(ctx, lock, deployment, workspace, planId, func(ctx context.Context) (string, error) {
+		message, err := apply(ctx)
+		if err == nil && ctx.Err() == nil {
+			succeeded()
+		} else {
+			failed()
+		}
+		return message, err
+	})
+}
+
+// RetireExpiredCredentials removes the previous credentials of every binding
+// whose grace period ended before now. Bindings that have operations running
+// on them are skipped, and retired the next time. Bindings whose credentials
+// failed to be retired maxCredentialRetirementAttempts times are skipped until
+// their credentials are rotated again. The ids of the deployments whose
+// credentials are being retired are returned.
+func RetireExpiredCredentials(ctx context.Context, registry broker.BrokerRegistry, now time.Time, logger lager.Logger) ([]string, error) {
+	deployments, err := db_service.GetTerraformDeploymentsWithPreviousCredentialsExpiredBy(ctx, now)
+	if err != nil {
+		return nil, fmt.Errorf("error listing deployments: %w", err)
+	}
+
+	var retired []string
+	for _, deployment := range deployments {
+		if err := ctx.Err(); err != nil {
+			return retired, err
+		}
+
+		if deployment.CredentialRetirementFailures >= maxCredentialRetirementAttempts {
+			continue
+		}
+
+		data := lager.Data{"deployment": deployment.ID}
+		runner, instance, err := runnerForDeployment(ctx, registry, deployment.ID, logger)
+		if err != nil {
+			logger.Error("retire-credentials", err, data)
+			continue
+		}
+
+		switch err := runner.RetirePreviousCredentials(ctx, deployment.ID, instance.PlanId); {
+		case errors.Is(err, ErrDeploymentLocked):
+			continue
+		case err != nil:
+			logger.Error("retire-credentials", err, data)
+			continue
+		}
+
+		logger.Info("retiring-credentials", data)
+		retired = append(retired, deployment.ID)
+	}
+
+	return retired, nil
+}
+
+// StartCredentialRetirementSweeper removes the previous credentials of bindings
+// in the background once their grace period has ended, until the context is
+// done.
+func StartCredentialRetirementSweeper(ctx context.Context, registry broker.BrokerRegistry, logger lager.Logger) {
+	logger = logger.Session("credential-retirement-sweeper")
+
+	go func() {
+		ticker := time.NewTicker(credentialRetirementInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				if _, err := RetireExpiredCredentials(ctx, registry, now, logger); err != nil {
+					logger.Error("sweep", err)
+				}
+			}
+		}
+	}()
+}
+
+// RotateBindingCredentials replaces the credentials of the binding by running
+// its Terraform with a new credentials seed, waiting on the result. It waits
+// even if the context is cancelled, as the outputs are only the new
+// credentials once the apply has finished.
+func (provider *terraformProvider) RotateBindingCredentials(ctx context.Context, instance models.ServiceInstanceDetails, bindRecord models.ServiceBindingCredentials, gracePeriod time.Duration) (map[string]interface{}, error) {
+	ctx = correlation.Background(ctx)
+	tfId := generateTfId(instance.ID, bindRecord.BindingId)
+	provider.logger.Info("terraform-rotate-credentials", lager.Data{
+		"tfId":         tfId,
+		"grace-period": gracePeriod.String(),
+	})
+
+	if err := provider.jobRunner.RotateCredentials(ctx, tfId, instance.PlanId, gracePeriod); err != nil {
+		return nil, err
+	}
+
+	if err := provider.jobRunner.Wait(ctx, tfId); err != nil {
+		return nil, fmt.Errorf("error from job runner: %w", err)
+	}
+
+	return provider.jobRunner.Outputs(ctx, tfId, wrapper.DefaultInstanceName)
+}
+
+// moduleInputs gets the set of variables that the module of the workspace
+// declares.
+func moduleInputs(workspace *wrapper.TerraformWorkspace) (map[string]bool, error) {
+	inputList, err := workspace.Modules[0].Inputs()
+	if err != nil {
+		return nil, err
+	}
+
+	inputs := make(map[string]bool)
+	for _, name := range inputList {
+		inputs[name] = true
+	}
+	return inputs, nil
+}
+
+// copyConfiguration gets a copy of the variables the workspace was last run
+// with, which can be changed and set back with setConfiguration.
+func copyConfiguration(workspace *wrapper.TerraformWorkspace) map[string]interface{} {
+	templateVars := make(map[string]interface{})
+	for name, value := range workspace.Instances[0].Configuration {
+		templateVars[name] = value
+	}
+	return templateVars
+}
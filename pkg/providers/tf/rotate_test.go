@@ -0,0 +1,256 @@
+// Copyright 2021 the Service Broker Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tf
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"testing"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/db_service"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/db_service/models"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/pkg/providers/tf/wrapper"
+	. "github.com/onsi/gomega"
+)
+
+const rotatableTemplate = `variable tf_credentials_seed { type = string }
+variable tf_previous_credentials_seed { type = string }`
+
+func TestTfJobRunner_RotateCredentials(t *testing.T) {
+	cases := map[string]struct {
+		template             string
+		gracePeriod          time.Duration
+		expectedPreviousSeed string
+		expectedError        error
+	}{
+		"without grace period": {
+			template: rotatableTemplate,
+		},
+		"with grace period": {
+			template:             rotatableTemplate,
+			gracePeriod:          time.Hour,
+			expectedPreviousSeed: "old-seed",
+		},
+		"not supported": {
+			template:      `variable size { type = string }`,
+			expectedError: ErrRotationNotSupported,
+		},
+		"grace period not supported": {
+			template:      `variable tf_credentials_seed { type = string }`,
+			gracePeriod:   time.Hour,
+			expectedError: ErrGracePeriodNotSupported,
+		},
+	}
+
+	for tn, tc := range cases {
+		t.Run(tn, func(t *testing.T) {
+			g := NewGomegaWithT(t)
+			ctx := context.Background()
+			deployment := setupBindingDeployment(g, tc.template)
+
+			var applied bool
+			runner := NewTfJobRunnerForProject(map[string]string{})
+			runner.Executor = func(ctx context.Context, cmd *exec.Cmd) (wrapper.ExecutionOutput, error) {
+				applied = applied || cmd.Args[1] == "apply"
+				return wrapper.ExecutionOutput{}, nil
+			}
+
+			err := runner.RotateCredentials(ctx, deployment.ID, "plan-id", tc.gracePeriod)
+			if tc.expectedError != nil {
+				g.Expect(err).To(MatchError(tc.expectedError))
+				g.Expect(applied).To(BeFalse())
+				g.Expect(db_service.IsTerraformDeploymentLocked(ctx, deployment.ID)).To(BeFalse())
+				return
+			}
+			g.Expect(err).NotTo(HaveOccurred())
+			g.Expect(runner.Wait(ctx, deployment.ID)).To(Succeed())
+			g.Expect(applied).To(BeTrue())
+
+			workspace, err := runner.Workspace(ctx, deployment.ID)
+			g.Expect(err).NotTo(HaveOccurred())
+			configuration := workspace.Instances[0].Configuration
+			g.Expect(configuration[credentialsSeedVariable]).NotTo(BeElementOf("", "old-seed"))
+			g.Expect(configuration[previousCredentialsSeedVariable]).To(Equal(tc.expectedPreviousSeed))
+
+			saved, err := db_service.GetTerraformDeploymentById(ctx, deployment.ID)
+			g.Expect(err).NotTo(HaveOccurred())
+			g.Expect(saved.LastOperationType).To(Equal(models.UpdateOperationType))
+			g.Expect(saved.CredentialsRotatedAt).NotTo(BeNil())
+			if tc.gracePeriod > 0 {
+				g.Expect(saved.PreviousCredentialsExpireAt).NotTo(BeNil())
+				g.Expect(*saved.PreviousCredentialsExpireAt).To(BeTemporally("~", time.Now().Add(tc.gracePeriod), time.Minute))
+			} else {
+				g.Expect(saved.PreviousCredentialsExpireAt).To(BeNil())
+			}
+		})
+	}
+}
+
+func TestTfJobRunner_RotateCredentialsFails(t *testing.T) {
+	g := NewGomegaWithT(t)
+	ctx := context.Background()
+	deployment := setupBindingDeployment(g, rotatableTemplate)
+
+	runner := NewTfJobRunnerForProject(map[string]string{})
+	runner.Executor = func(ctx context.Context, cmd *exec.Cmd) (wrapper.ExecutionOutput, error) {
+		if cmd.Args[1] == "apply" {
+			return wrapper.ExecutionOutput{}, errors.New("apply failed")
+		}
+		return wrapper.ExecutionOutput{}, nil
+	}
+
+	g.Expect(runner.RotateCredentials(ctx, deployment.ID, "plan-id", time.Hour)).To(Succeed())
+	g.Expect(runner.Wait(ctx, deployment.ID)).To(MatchError(ContainSubstring("apply failed")))
+
+	workspace, err := runner.Workspace(ctx, deployment.ID)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(workspace.Instances[0].Configuration[credentialsSeedVariable]).To(Equal("old-seed"))
+	g.Expect(workspace.Instances[0].Configuration[previousCredentialsSeedVariable]).To(Equal(""))
+
+	saved, err := db_service.GetTerraformDeploymentById(ctx, deployment.ID)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(saved.CredentialsRotatedAt).To(BeNil())
+	g.Expect(saved.PreviousCredentialsExpireAt).To(BeNil())
+}
+
+func TestTfJobRunner_RetirePreviousCredentialsFails(t *testing.T) {
+	g := NewGomegaWithT(t)
+	ctx := context.Background()
+	deployment := setupBindingDeployment(g, rotatableTemplate)
+
+	failApply := false
+	runner := NewTfJobRunnerForProject(map[string]string{})
+	runner.Executor = func(ctx context.Context, cmd *exec.Cmd) (wrapper.ExecutionOutput, error) {
+		if failApply && cmd.Args[1] == "apply" {
+			return wrapper.ExecutionOutput{}, errors.New("apply failed")
+		}
+		return wrapper.ExecutionOutput{}, nil
+	}
+
+	g.Expect(runner.RotateCredentials(ctx, deployment.ID, "plan-id", time.Hour)).To(Succeed())
+	g.Expect(runner.Wait(ctx, deployment.ID)).To(Succeed())
+
+	failApply = true
+	g.Expect(runner.RetirePreviousCredentials(ctx, deployment.ID, "plan-id")).To(Succeed())
+	g.Expect(runner.Wait(ctx, deployment.ID)).To(MatchError(ContainSubstring("apply failed")))
+
+	workspace, err := runner.Workspace(ctx, deployment.ID)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(workspace.Instances[0].Configuration[previousCredentialsSeedVariable]).To(Equal("old-seed"))
+
+	saved, err := db_service.GetTerraformDeploymentById(ctx, deployment.ID)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(saved.PreviousCredentialsExpireAt).NotTo(BeNil(), "the credentials should be retired again")
+	g.Expect(*saved.PreviousCredentialsExpireAt).To(BeTemporally("~", time.Now().Add(2*credentialRetirementInterval), time.Second))
+	g.Expect(saved.CredentialRetirementFailures).To(Equal(1))
+
+	g.Expect(runner.RetirePreviousCredentials(ctx, deployment.ID, "plan-id")).To(Succeed())
+	g.Expect(runner.Wait(ctx, deployment.ID)).To(MatchError(ContainSubstring("apply failed")))
+
+	saved, err = db_service.GetTerraformDeploymentById(ctx, deployment.ID)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(*saved.PreviousCredentialsExpireAt).To(BeTemporally("~", time.Now().Add(4*credentialRetirementInterval), time.Second), "the wait should double")
+	g.Expect(saved.CredentialRetirementFailures).To(Equal(2))
+
+	failApply = false
+	g.Expect(runner.RetirePreviousCredentials(ctx, deployment.ID, "plan-id")).To(Succeed())
+	g.Expect(runner.Wait(ctx, deployment.ID)).To(Succeed())
+
+	saved, err = db_service.GetTerraformDeploymentById(ctx, deployment.ID)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(saved.PreviousCredentialsExpireAt).To(BeNil())
+	g.Expect(saved.CredentialRetirementFailures).To(BeZero())
+}
+
+func TestRetireExpiredCredentials(t *testing.T) {
+	g := NewGomegaWithT(t)
+	ctx := context.Background()
+	deployment := setupBindingDeployment(g, rotatableTemplate)
+
+	runner := NewTfJobRunnerForProject(map[string]string{})
+	runner.Executor = func(ctx context.Context, cmd *exec.Cmd) (wrapper.ExecutionOutput, error) {
+		return wrapper.ExecutionOutput{}, nil
+	}
+	registry := setupRegistry(g, runner)
+
+	g.Expect(runner.RotateCredentials(ctx, deployment.ID, "plan-id", time.Hour)).To(Succeed())
+	g.Expect(runner.Wait(ctx, deployment.ID)).To(Succeed())
+
+	retired, err := RetireExpiredCredentials(ctx, registry, time.Now(), lager.NewLogger("test"))
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(retired).To(BeEmpty(), "the grace period has not ended")
+
+	retired, err = RetireExpiredCredentials(ctx, registry, time.Now().Add(2*time.Hour), lager.NewLogger("test"))
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(retired).To(Equal([]string{deployment.ID}))
+	g.Expect(runner.Wait(ctx, deployment.ID)).To(Succeed())
+
+	workspace, err := runner.Workspace(ctx, deployment.ID)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(workspace.Instances[0].Configuration[previousCredentialsSeedVariable]).To(Equal(""))
+	g.Expect(workspace.Instances[0].Configuration[credentialsSeedVariable]).NotTo(BeElementOf("", "old-seed"), "the new credentials are kept")
+
+	saved, err := db_service.GetTerraformDeploymentById(ctx, deployment.ID)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(saved.PreviousCredentialsExpireAt).To(BeNil())
+
+	t.Run("locked", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		expireAt := time.Now()
+		saved.PreviousCredentialsExpireAt = &expireAt
+		g.Expect(db_service.SaveTerraformDeployment(ctx, saved)).To(Succeed())
+
+		lock, err := lockDeployment(ctx, deployment.ID)
+		g.Expect(err).NotTo(HaveOccurred())
+		defer lock.release()
+
+		retired, err := RetireExpiredCredentials(ctx, registry, time.Now(), lager.NewLogger("test"))
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(retired).To(BeEmpty())
+	})
+
+	t.Run("failed too many times", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		saved, err := db_service.GetTerraformDeploymentById(ctx, deployment.ID)
+		g.Expect(err).NotTo(HaveOccurred())
+		expireAt := time.Now()
+		saved.PreviousCredentialsExpireAt = &expireAt
+		saved.CredentialRetirementFailures = maxCredentialRetirementAttempts
+		g.Expect(db_service.SaveTerraformDeployment(ctx, saved)).To(Succeed())
+
+		retired, err := RetireExpiredCredentials(ctx, registry, time.Now(), lager.NewLogger("test"))
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(retired).To(BeEmpty())
+	})
+}
+
+// setupBindingDeployment creates the deployment of a binding, made with the
+// template, whose credentials seed is "old-seed", in a new in-memory database.
+func setupBindingDeployment(g *GomegaWithT, template string) *models.TerraformDeployment {
+	setupUpdatableDeployment(g)
+
+	workspace, err := wrapper.NewWorkspace(map[string]interface{}{credentialsSeedVariable: "old-seed", previousCredentialsSeedVariable: ""}, template, nil, nil, nil, nil)
+	g.Expect(err).NotTo(HaveOccurred())
+	serialized, err := workspace.Serialize()
+	g.Expect(err).NotTo(HaveOccurred())
+	deployment := &models.TerraformDeployment{ID: "tf:instance:binding", LastOperationType: models.ProvisionOperationType, LastOperationState: Succeeded}
+	g.Expect(deployment.SetWorkspace(serialized)).To(Succeed())
+	g.Expect(db_service.CreateTerraformDeployment(context.Background(), deployment)).To(Succeed())
+
+	return deployment
+}
@@ -0,0 +1,105 @@
+// Copyright 2021 the Service Broker Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tf
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/cloudfoundry-incubator/cloud-service-broker/db_service"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/pkg/providers/tf/wrapper"
+)
+
+// ExportState gets the Terraform state of the deployment, decrypted and read
+// from the state store if there is one.
+func (runner *TfJobRunner) ExportState(ctx context.Context, id string) ([]byte, error) {
+	workspace, err := runner.Workspace(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return workspace.State, nil
+}
+
+// ImportState replaces the Terraform state of the deployment, which is saved
+// like the state from any operation. The state must be a version 4 tfstate.
+func (runner *TfJobRunner) ImportState(ctx context.Context, id string, state []byte) error {
+	if _, err := wrapper.NewTfstate(state); err != nil {
+		return fmt.Errorf("invalid terraform state: %w", err)
+	}
+
+	_, err := runner.modifyState(ctx, id, func(workspace *wrapper.TerraformWorkspace) (string, error) {
+		workspace.State = state
+		return "", nil
+	})
+	return err
+}
+
+// RemoveState runs `terraform state rm` on the deployment, so that Terraform
+// forgets the resources at the given addresses without destroying them.
+func (runner *TfJobRunner) RemoveState(ctx context.Context, id string, addresses ...string) (string, error) {
+	return runner.modifyState(ctx, id, func(workspace *wrapper.TerraformWorkspace) (string, error) {
+		return workspace.RemoveState(ctx, addresses...)
+	})
+}
+
+// MoveState runs `terraform state mv` on the deployment, so that the resource
+// at the source address is tracked at the destination address instead.
+func (runner *TfJobRunner) MoveState(ctx context.Context, id, source, destination string) (string, error) {
+	return runner.modifyState(ctx, id, func(workspace *wrapper.TerraformWorkspace) (string, error) {
+		return workspace.MoveState(ctx, source, destination)
+	})
+}
+
+// modifyState changes the state of the deployment with the lock held, and
+// saves the workspace if the change succeeds. It fails with ErrDeploymentLocked
+// if an operation is running on the deployment.
+func (runner *TfJobRunner) modifyState(ctx context.Context, id string, modify func(*wrapper.TerraformWorkspace) (string, error)) (string, error) {
+	lock, err := lockDeployment(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	defer lock.release()
+
+	deployment, err := db_service.GetTerraformDeploymentById(ctx, id)
+	if err != nil {
+		return "", fmt.Errorf("error getting TF deployment: %w", err)
+	}
+
+	workspace, err := runner.hydrateWorkspace(ctx, deployment)
+	if err != nil {
+		return "", err
+	}
+
+	output, err := modify(workspace)
+	if err != nil {
+		return "", err
+	}
+
+	serialized, err := runner.serializeWorkspace(ctx, id, workspace)
+	if err != nil {
+		return "", err
+	}
+
+	if err := deployment.SetWorkspace(serialized); err != nil {
+		return "", err
+	}
+
+	if err := db_service.SaveTerraformDeployment(ctx, deployment); err != nil {
+		return "", err
+	}
+
+	return output, nil
+}
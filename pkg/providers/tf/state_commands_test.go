@@ -0,0 +1,115 @@
+// Copyright 2021 the Service Broker Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tf
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/cloudfoundry-incubator/cloud-service-broker/db_service"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/pkg/providers/tf/wrapper"
+	. "github.com/onsi/gomega"
+)
+
+func TestTfJobRunner_ExportImportState(t *testing.T) {
+	g := NewGomegaWithT(t)
+	ctx := context.Background()
+	deployment := setupUpdatableDeployment(g)
+	runner := NewTfJobRunnerForProject(map[string]string{})
+
+	state := []byte(`{"version":4,"serial":3,"outputs":{"status":{"type":"string","value":"repaired"}}}`)
+	g.Expect(runner.ImportState(ctx, deployment.ID, state)).To(Succeed())
+
+	exported, err := runner.ExportState(ctx, deployment.ID)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(exported).To(MatchJSON(state))
+
+	saved, err := db_service.GetTerraformDeploymentById(ctx, deployment.ID)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(saved.Version).To(Equal(deployment.Version+1), "the state should be saved like any other change")
+
+	t.Run("invalid state", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		err := runner.ImportState(ctx, deployment.ID, []byte(`{"version":3}`))
+		g.Expect(err).To(MatchError("invalid terraform state: unsupported tfstate version: 3"))
+
+		exported, err := runner.ExportState(ctx, deployment.ID)
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(exported).To(MatchJSON(state))
+	})
+
+	t.Run("locked", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		lock, err := lockDeployment(ctx, deployment.ID)
+		g.Expect(err).NotTo(HaveOccurred())
+		defer lock.release()
+
+		err = runner.ImportState(ctx, deployment.ID, state)
+		g.Expect(errors.Is(err, ErrDeploymentLocked)).To(BeTrue())
+	})
+}
+
+func TestTfJobRunner_StateSurgery(t *testing.T) {
+	cases := map[string]struct {
+		run          func(runner *TfJobRunner, id string) (string, error)
+		expectedArgs []string
+	}{
+		"rm": {
+			run: func(runner *TfJobRunner, id string) (string, error) {
+				return runner.RemoveState(context.Background(), id, "random_password.password")
+			},
+			expectedArgs: []string{"terraform", "state", "rm", "random_password.password"},
+		},
+		"mv": {
+			run: func(runner *TfJobRunner, id string) (string, error) {
+				return runner.MoveState(context.Background(), id, "random_password.password", "random_password.admin")
+			},
+			expectedArgs: []string{"terraform", "state", "mv", "random_password.password", "random_password.admin"},
+		},
+	}
+
+	for tn, tc := range cases {
+		t.Run(tn, func(t *testing.T) {
+			g := NewGomegaWithT(t)
+			ctx := context.Background()
+			deployment := setupUpdatableDeployment(g)
+
+			var stateArgs []string
+			runner := NewTfJobRunnerForProject(map[string]string{})
+			g.Expect(runner.ImportState(ctx, deployment.ID, []byte(`{"version":4,"serial":1}`))).To(Succeed())
+			runner.Executor = func(ctx context.Context, cmd *exec.Cmd) (wrapper.ExecutionOutput, error) {
+				if cmd.Args[1] != "state" {
+					return wrapper.ExecutionOutput{}, nil
+				}
+				stateArgs = cmd.Args
+				err := os.WriteFile(filepath.Join(cmd.Dir, "terraform.tfstate"), []byte(`{"version":4,"serial":2}`), 0600)
+				return wrapper.ExecutionOutput{StdOut: "Successfully changed 1 resource instance(s)."}, err
+			}
+
+			output, err := tc.run(runner, deployment.ID)
+			g.Expect(err).NotTo(HaveOccurred())
+			g.Expect(output).To(Equal("Successfully changed 1 resource instance(s)."))
+			g.Expect(stateArgs).To(Equal(tc.expectedArgs))
+
+			exported, err := runner.ExportState(ctx, deployment.ID)
+			g.Expect(err).NotTo(HaveOccurred())
+			g.Expect(exported).To(MatchJSON(`{"version":4,"serial":2}`))
+		})
+	}
+}
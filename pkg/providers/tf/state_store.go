@@ -0,0 +1,420 @@
+// Copyright 2021 the Service Broker Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tf
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/db_service"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/db_service/models"
+	"github.com/spf13/viper"
+)
+
+const (
+	stateStoreKey         = "terraform.state_store"
+	stateStorePathKey     = "terraform.state_store_path"
+	stateStoreAddressKey  = "terraform.state_store_address"
+	stateStoreUsernameKey = "terraform.state_store_username"
+	stateStorePasswordKey = "terraform.state_store_password"
+	stateStoreEncryptKey  = "terraform.state_store_encrypt"
+
+	dbStateStoreType   = "db"
+	fileStateStoreType = "file"
+	httpStateStoreType = "http"
+
+	// httpStateStoreTimeout limits each request to the http state store, so
+	// that a server that stops responding fails the operation rather than
+	// blocking it forever.
+	httpStateStoreTimeout = time.Minute
+)
+
+func init() {
+	viper.BindEnv(stateStoreKey, "TF_STATE_STORE")
+	viper.SetDefault(stateStoreKey, dbStateStoreType)
+	viper.BindEnv(stateStorePathKey, "TF_STATE_STORE_PATH")
+	viper.BindEnv(stateStoreAddressKey, "TF_STATE_STORE_ADDRESS")
+	viper.BindEnv(stateStoreUsernameKey, "TF_STATE_STORE_USERNAME")
+	viper.BindEnv(stateStorePasswordKey, "TF_STATE_STORE_PASSWORD")
+	viper.BindEnv(stateStoreEncryptKey, "TF_STATE_STORE_ENCRYPT")
+}
+
+// StateStore persists the Terraform state of deployments.
+//
+// By default the state is kept inline in the workspace that is saved with the
+// deployment. Other stores keep it outside the database so that the size of
+// the state is not limited by the workspace column, and so that it can be
+// inspected with standard Terraform tooling.
+type StateStore interface {
+	// Inline reports whether the state is kept in the workspace saved with the
+	// deployment, in which case Get and Put are never called.
+	Inline() bool
+	// Get returns the state of the deployment, or nil if there is none.
+	Get(ctx context.Context, deploymentId string) ([]byte, error)
+	// Put replaces the state of the deployment.
+	Put(ctx context.Context, deploymentId string, state []byte) error
+}
+
+// NewStateStoreFromEnv creates the StateStore configured by the operator. If
+// the operator asked for the state to be encrypted, stores outside the
+// database are wrapped in an EncryptedStateStore.
+func NewStateStoreFromEnv() (StateStore, error) {
+	var store StateStore
+	var err error
+	switch storeType := viper.GetString(stateStoreKey); storeType {
+	case dbStateStoreType, "":
+		return DbStateStore{}, nil
+	case fileStateStoreType:
+		store, err = NewFileStateStore(viper.GetString(stateStorePathKey))
+	case httpStateStoreType:
+		store, err = NewHttpStateStore(
+			viper.GetString(stateStoreAddressKey),
+			viper.GetString(stateStoreUsernameKey),
+			viper.GetString(stateStorePasswordKey),
+		)
+	default:
+		return nil, fmt.Errorf("unknown terraform state store %q, must be one of %q, %q or %q", storeType, dbStateStoreType, fileStateStoreType, httpStateStoreType)
+	}
+
+	if err != nil || !viper.GetBool(stateStoreEncryptKey) {
+		return store, err
+	}
+	return NewEncryptedStateStore(store), nil
+}
+
+// DbStateStore keeps the state inline in the workspace saved with the
+// deployment in the database.
+type DbStateStore struct{}
+
+var _ StateStore = DbStateStore{}
+
+// Inline implements StateStore.
+func (DbStateStore) Inline() bool {
+	return true
+}
+
+// Get implements StateStore.
+func (DbStateStore) Get(context.Context, string) ([]byte, error) {
+	return nil, errors.New("state is stored in the workspace")
+}
+
+// Put implements StateStore.
+func (DbStateStore) Put(context.Context, string, []byte) error {
+	return errors.New("state is stored in the workspace")
+}
+
+// FileStateStore keeps the state of each deployment in a file in a directory,
+// named after the deployment, that can be passed to Terraform with -state.
+type FileStateStore struct {
+	dir string
+}
+
+var _ StateStore = (*FileStateStore)(nil)
+
+// NewFileStateStore creates a FileStateStore in the given directory, creating
+// the directory if needed.
+func NewFileStateStore(dir string) (*FileStateStore, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("%s must be set to use the %q terraform state store", stateStorePathKey, fileStateStoreType)
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("error creating terraform state directory: %w", err)
+	}
+
+	return &FileStateStore{dir: dir}, nil
+}
+
+// Inline implements StateStore.
+func (store *FileStateStore) Inline() bool {
+	return false
+}
+
+// Get implements StateStore.
+func (store *FileStateStore) Get(_ context.Context, deploymentId string) ([]byte, error) {
+	state, err := os.ReadFile(store.path(deploymentId))
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return nil, nil
+	case err != nil:
+		return nil, fmt.Errorf("error reading terraform state: %w", err)
+	default:
+		return state, nil
+	}
+}
+
+// Put implements StateStore. The state is written to a temporary file which
+// is then renamed so that readers never see a partially written state.
+func (store *FileStateStore) Put(_ context.Context, deploymentId string, state []byte) error {
+	tmp, err := os.CreateTemp(store.dir, ".tfstate-*")
+	if err != nil {
+		return fmt.Errorf("error writing terraform state: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(state); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error writing terraform state: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error writing terraform state: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), store.path(deploymentId)); err != nil {
+		return fmt.Errorf("error writing terraform state: %w", err)
+	}
+
+	return nil
+}
+
+func (store *FileStateStore) path(deploymentId string) string {
+	return filepath.Join(store.dir, url.PathEscape(deploymentId)+".tfstate")
+}
+
+// HttpStateStore keeps the state on a server implementing the Terraform http
+// backend protocol, with the state of each deployment at the address followed
+// by the deployment id. Locking is not used because the broker already locks
+// deployments while it runs operations on them.
+type HttpStateStore struct {
+	address  string
+	username string
+	password string
+	client   *http.Client
+}
+
+var _ StateStore = (*HttpStateStore)(nil)
+
+// NewHttpStateStore creates an HttpStateStore for the given base address. The
+// username and password are used for basic authentication if set.
+func NewHttpStateStore(address, username, password string) (*HttpStateStore, error) {
+	if address == "" {
+		return nil, fmt.Errorf("%s must be set to use the %q terraform state store", stateStoreAddressKey, httpStateStoreType)
+	}
+
+	if _, err := url.ParseRequestURI(address); err != nil {
+		return nil, fmt.Errorf("invalid terraform state store address: %w", err)
+	}
+
+	return &HttpStateStore{
+		address:  strings.TrimSuffix(address, "/"),
+		username: username,
+		password: password,
+		client:   &http.Client{Timeout: httpStateStoreTimeout},
+	}, nil
+}
+
+// Inline implements StateStore.
+func (store *HttpStateStore) Inline() bool {
+	return false
+}
+
+// Get implements StateStore.
+func (store *HttpStateStore) Get(ctx context.Context, deploymentId string) ([]byte, error) {
+	resp, err := store.do(ctx, http.MethodGet, deploymentId, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return io.ReadAll(resp.Body)
+	case http.StatusNoContent, http.StatusNotFound:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("error getting terraform state: unexpected status %q", resp.Status)
+	}
+}
+
+// Put implements StateStore.
+func (store *HttpStateStore) Put(ctx context.Context, deploymentId string, state []byte) error {
+	resp, err := store.do(ctx, http.MethodPost, deploymentId, state)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("error saving terraform state: unexpected status %q", resp.Status)
+	}
+
+	return nil
+}
+
+func (store *HttpStateStore) do(ctx context.Context, method, deploymentId string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, store.address+"/"+url.PathEscape(deploymentId), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if store.username != "" || store.password != "" {
+		req.SetBasicAuth(store.username, store.password)
+	}
+
+	resp, err := store.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error contacting terraform state store: %w", err)
+	}
+
+	return resp, nil
+}
+
+// EncryptedStateStore encrypts the state with the encryptor of the database
+// before it is put in another store, and decrypts it when it is got back.
+// Encrypted state cannot be read by Terraform, so the store can no longer be
+// used as a Terraform backend to inspect the state.
+type EncryptedStateStore struct {
+	store StateStore
+}
+
+var _ StateStore = (*EncryptedStateStore)(nil)
+
+// NewEncryptedStateStore creates an EncryptedStateStore that keeps the
+// encrypted state in the given store.
+func NewEncryptedStateStore(store StateStore) *EncryptedStateStore {
+	return &EncryptedStateStore{store: store}
+}
+
+// Inline implements StateStore.
+func (store *EncryptedStateStore) Inline() bool {
+	return store.store.Inline()
+}
+
+// Get implements StateStore.
+func (store *EncryptedStateStore) Get(ctx context.Context, deploymentId string) ([]byte, error) {
+	data, err := store.store.Get(ctx, deploymentId)
+	if err != nil {
+		return nil, err
+	}
+
+	return decryptState(data)
+}
+
+// Put implements StateStore.
+func (store *EncryptedStateStore) Put(ctx context.Context, deploymentId string, state []byte) error {
+	encrypted, err := encryptState(state)
+	if err != nil {
+		return err
+	}
+
+	return store.store.Put(ctx, deploymentId, encrypted)
+}
+
+// encryptState encrypts the state with the encryptor of the database, before
+// it is put in a state store.
+func encryptState(state []byte) ([]byte, error) {
+	encrypted, err := models.GetEncryptor().Encrypt(state)
+	if err != nil {
+		return nil, fmt.Errorf("error encrypting terraform state: %w", err)
+	}
+	return []byte(encrypted), nil
+}
+
+// decryptState decrypts state got from a state store. State that was put in
+// the store before it was encrypted is JSON, which is never valid ciphertext,
+// and is returned as it is.
+func decryptState(data []byte) ([]byte, error) {
+	if len(data) == 0 {
+		return data, nil
+	}
+
+	state, err := models.GetEncryptor().Decrypt(string(data))
+	switch {
+	case err == nil:
+		return state, nil
+	case bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")):
+		return data, nil
+	default:
+		return nil, fmt.Errorf("error decrypting terraform state: %w", err)
+	}
+}
+
+// ReencryptStates encrypts the state of every deployment in an
+// EncryptedStateStore with the primary encryptor of the database, which must
+// also be able to decrypt with the previous primary password. It must complete
+// before the previous password is removed, as the database is re-encrypted,
+// because state kept outside the database is not re-encrypted with it. Other
+// stores are left as they are.
+//
+// Deployments are locked while their state is re-encrypted. Those locked by a
+// running operation are retried until the operation has finished.
+func ReencryptStates(ctx context.Context, store StateStore, logger lager.Logger) error {
+	if _, ok := store.(*EncryptedStateStore); !ok || store.Inline() {
+		return nil
+	}
+
+	deployments, err := db_service.GetTerraformDeployments(ctx)
+	if err != nil {
+		return fmt.Errorf("error listing deployments: %w", err)
+	}
+
+	pending := make([]string, 0, len(deployments))
+	for _, deployment := range deployments {
+		pending = append(pending, deployment.ID)
+	}
+
+	for {
+		var locked []string
+		for _, id := range pending {
+			switch err := reencryptState(ctx, store, id); {
+			case errors.Is(err, ErrDeploymentLocked):
+				locked = append(locked, id)
+			case err != nil:
+				return fmt.Errorf("error reencrypting terraform state of deployment %q: %w", id, err)
+			}
+		}
+
+		if len(locked) == 0 {
+			logger.Info("reencrypted-terraform-states", lager.Data{"deployments": len(deployments)})
+			return nil
+		}
+		pending = locked
+
+		logger.Info("waiting-for-locked-deployments", lager.Data{"deployments": pending})
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(deploymentLockDuration):
+		}
+	}
+}
+
+func reencryptState(ctx context.Context, store StateStore, deploymentId string) error {
+	lock, err := lockDeployment(ctx, deploymentId)
+	if err != nil {
+		return err
+	}
+	defer lock.release()
+
+	state, err := store.Get(ctx, deploymentId)
+	if err != nil || len(state) == 0 {
+		return err
+	}
+
+	return store.Put(ctx, deploymentId, state)
+}
@@ -0,0 +1,337 @@
+// Copyright 2021 the Service Broker Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tf
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/db_service"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/db_service/models"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/internal/encryption/compoundencryptor"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/internal/encryption/gcmencryptor"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/internal/encryption/noopencryptor"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/pkg/providers/tf/wrapper"
+	. "github.com/onsi/gomega"
+	"github.com/spf13/viper"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestNewStateStoreFromEnv(t *testing.T) {
+	cases := map[string]struct {
+		config          map[string]string
+		expectEncrypted bool
+		expectedError   string
+	}{
+		"default":         {},
+		"file encrypted":  {config: map[string]string{stateStoreKey: "file", stateStorePathKey: t.TempDir(), stateStoreEncryptKey: "true"}, expectEncrypted: true},
+		"db encrypted":    {config: map[string]string{stateStoreKey: "db", stateStoreEncryptKey: "true"}},
+		"db":              {config: map[string]string{stateStoreKey: "db"}},
+		"file":            {config: map[string]string{stateStoreKey: "file", stateStorePathKey: t.TempDir()}},
+		"http":            {config: map[string]string{stateStoreKey: "http", stateStoreAddressKey: "https://example.com/state"}},
+		"file no path":    {config: map[string]string{stateStoreKey: "file"}, expectedError: "terraform.state_store_path must be set to use the \"file\" terraform state store"},
+		"http no address": {config: map[string]string{stateStoreKey: "http"}, expectedError: "terraform.state_store_address must be set to use the \"http\" terraform state store"},
+		"unknown":         {config: map[string]string{stateStoreKey: "s3"}, expectedError: `unknown terraform state store "s3", must be one of "db", "file" or "http"`},
+	}
+
+	for tn, tc := range cases {
+		t.Run(tn, func(t *testing.T) {
+			g := NewGomegaWithT(t)
+			defer viper.Reset()
+			for k, v := range tc.config {
+				viper.Set(k, v)
+			}
+
+			store, err := NewStateStoreFromEnv()
+			if tc.expectedError != "" {
+				g.Expect(err).To(MatchError(tc.expectedError))
+			} else {
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(store).NotTo(BeNil())
+				_, encrypted := store.(*EncryptedStateStore)
+				g.Expect(encrypted).To(Equal(tc.expectEncrypted))
+			}
+		})
+	}
+}
+
+func TestFileStateStore(t *testing.T) {
+	g := NewGomegaWithT(t)
+	ctx := context.Background()
+
+	store, err := NewFileStateStore(t.TempDir())
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(store.Inline()).To(BeFalse())
+
+	state, err := store.Get(ctx, "tf:instance:binding")
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(state).To(BeNil())
+
+	g.Expect(store.Put(ctx, "tf:instance:binding", []byte(`{"version":4}`))).To(Succeed())
+	g.Expect(store.Put(ctx, "tf:instance:binding", []byte(`{"version":4,"serial":2}`))).To(Succeed())
+
+	state, err = store.Get(ctx, "tf:instance:binding")
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(state).To(MatchJSON(`{"version":4,"serial":2}`))
+}
+
+func TestHttpStateStore(t *testing.T) {
+	g := NewGomegaWithT(t)
+	ctx := context.Background()
+
+	var mutex sync.Mutex
+	states := map[string][]byte{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		if username, password, ok := r.BasicAuth(); !ok || username != "user" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			state, ok := states[r.URL.EscapedPath()]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Write(state)
+		case http.MethodPost:
+			states[r.URL.EscapedPath()], _ = io.ReadAll(r.Body)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}))
+	defer server.Close()
+
+	store, err := NewHttpStateStore(server.URL+"/state/", "user", "secret")
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(store.Inline()).To(BeFalse())
+
+	state, err := store.Get(ctx, "tf:instance:")
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(state).To(BeNil())
+
+	g.Expect(store.Put(ctx, "tf:instance:", []byte(`{"version":4}`))).To(Succeed())
+	g.Expect(states).To(HaveKey("/state/tf:instance:"))
+
+	state, err = store.Get(ctx, "tf:instance:")
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(state).To(MatchJSON(`{"version":4}`))
+
+	badCredentials, err := NewHttpStateStore(server.URL+"/state", "user", "wrong")
+	g.Expect(err).NotTo(HaveOccurred())
+	_, err = badCredentials.Get(ctx, "tf:instance:")
+	g.Expect(err).To(MatchError(`error getting terraform state: unexpected status "401 Unauthorized"`))
+}
+
+func TestTfJobRunner_ExternalStateStore(t *testing.T) {
+	g := NewGomegaWithT(t)
+	ctx := context.Background()
+	defer models.SetEncryptor(noopencryptor.New())
+	models.SetEncryptor(gcmencryptor.New([32]byte{1}))
+
+	store, err := NewFileStateStore(t.TempDir())
+	g.Expect(err).NotTo(HaveOccurred())
+	runner := NewTfJobRunnerForProject(map[string]string{})
+	runner.StateStore = store
+
+	workspace, err := wrapper.NewWorkspace(map[string]interface{}{}, `output status { value = "done" }`, nil, nil, nil, nil)
+	g.Expect(err).NotTo(HaveOccurred())
+	workspace.State = []byte(`{"version":4}`)
+
+	deployment := &models.TerraformDeployment{ID: "tf:instance:"}
+	serialized, err := runner.serializeWorkspace(ctx, deployment.ID, workspace)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(serialized).To(ContainSubstring(`"tfstate":null`))
+	g.Expect(workspace.State).To(MatchJSON(`{"version":4}`), "the workspace in memory should keep its state")
+	g.Expect(deployment.SetWorkspace(serialized)).To(Succeed())
+
+	stored, err := os.ReadFile(store.path(deployment.ID))
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(stored).To(MatchJSON(`{"version":4}`), "the state should be readable by terraform unless encryption is enabled")
+
+	loaded, err := runner.loadWorkspace(ctx, deployment)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(loaded.State).To(MatchJSON(`{"version":4}`))
+
+	t.Run("state saved before the store was configured", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		legacy := &models.TerraformDeployment{ID: "tf:legacy:"}
+		inline, err := workspace.Serialize()
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(legacy.SetWorkspace(inline)).To(Succeed())
+
+		loaded, err := runner.loadWorkspace(ctx, legacy)
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(loaded.State).To(MatchJSON(`{"version":4}`))
+	})
+}
+
+func TestTfJobRunner_EncryptedStateStore(t *testing.T) {
+	g := NewGomegaWithT(t)
+	ctx := context.Background()
+	defer models.SetEncryptor(noopencryptor.New())
+	models.SetEncryptor(gcmencryptor.New([32]byte{1}))
+
+	store, err := NewFileStateStore(t.TempDir())
+	g.Expect(err).NotTo(HaveOccurred())
+	runner := NewTfJobRunnerForProject(map[string]string{})
+	runner.StateStore = NewEncryptedStateStore(store)
+
+	workspace, err := wrapper.NewWorkspace(map[string]interface{}{}, `output status { value = "done" }`, nil, nil, nil, nil)
+	g.Expect(err).NotTo(HaveOccurred())
+	workspace.State = []byte(`{"version":4,"secret":"password"}`)
+
+	deployment := &models.TerraformDeployment{ID: "tf:instance:"}
+	serialized, err := runner.serializeWorkspace(ctx, deployment.ID, workspace)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(deployment.SetWorkspace(serialized)).To(Succeed())
+
+	stored, err := os.ReadFile(store.path(deployment.ID))
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(string(stored)).NotTo(ContainSubstring("password"))
+
+	loaded, err := runner.loadWorkspace(ctx, deployment)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(loaded.State).To(MatchJSON(`{"version":4,"secret":"password"}`))
+
+	t.Run("state put before it was encrypted", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		g.Expect(store.Put(ctx, deployment.ID, []byte(`{"version":4,"serial":2}`))).To(Succeed())
+
+		loaded, err := runner.loadWorkspace(ctx, deployment)
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(loaded.State).To(MatchJSON(`{"version":4,"serial":2}`))
+	})
+
+	t.Run("state encrypted with another key", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		encrypted, err := gcmencryptor.New([32]byte{2}).Encrypt([]byte(`{"version":4}`))
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(store.Put(ctx, deployment.ID, []byte(encrypted))).To(Succeed())
+
+		_, err = runner.loadWorkspace(ctx, deployment)
+		g.Expect(err).To(MatchError(ContainSubstring("error decrypting terraform state")))
+	})
+}
+
+type unavailableStateStore struct{}
+
+func (unavailableStateStore) Inline() bool { return false }
+
+func (unavailableStateStore) Get(context.Context, string) ([]byte, error) { return nil, nil }
+
+func (unavailableStateStore) Put(context.Context, string, []byte) error {
+	return errors.New("state store unavailable")
+}
+
+func TestTfJobRunner_StateStoreUnavailable(t *testing.T) {
+	g := NewGomegaWithT(t)
+	ctx := context.Background()
+
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(db.Migrator().CreateTable(models.TerraformDeployment{}, models.TerraformJob{})).To(Succeed())
+	db_service.DbConnection = db
+	models.SetEncryptor(noopencryptor.New())
+
+	runner := NewTfJobRunnerForProject(map[string]string{})
+	runner.StateStore = unavailableStateStore{}
+
+	workspace, err := wrapper.NewWorkspace(map[string]interface{}{}, `output status { value = "done" }`, nil, nil, nil, nil)
+	g.Expect(err).NotTo(HaveOccurred())
+	workspace.State = []byte(`{"version":4}`)
+
+	g.Expect(db_service.CreateTerraformDeployment(ctx, &models.TerraformDeployment{ID: "tf:instance:", LastOperationState: InProgress})).To(Succeed())
+	deployment, err := db_service.GetTerraformDeploymentById(ctx, "tf:instance:")
+	g.Expect(err).NotTo(HaveOccurred())
+
+	g.Expect(runner.operationFinished(nil, "done", workspace, deployment)).To(Succeed())
+
+	saved, err := db_service.GetTerraformDeploymentById(ctx, "tf:instance:")
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(saved.LastOperationState).To(Equal(Succeeded))
+
+	loaded, err := runner.loadWorkspace(ctx, saved)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(loaded.State).To(MatchJSON(`{"version":4}`), "the state should be kept in the workspace")
+}
+
+func TestReencryptStates(t *testing.T) {
+	g := NewGomegaWithT(t)
+	ctx := context.Background()
+	defer models.SetEncryptor(noopencryptor.New())
+
+	defer func(duration time.Duration) { deploymentLockDuration = duration }(deploymentLockDuration)
+	deploymentLockDuration = 50 * time.Millisecond
+
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(db.Migrator().CreateTable(models.TerraformDeployment{}, models.TerraformDeploymentLock{})).To(Succeed())
+	db_service.DbConnection = db
+
+	previous, primary := gcmencryptor.New([32]byte{1}), gcmencryptor.New([32]byte{2})
+	models.SetEncryptor(previous)
+
+	plain, err := NewFileStateStore(t.TempDir())
+	g.Expect(err).NotTo(HaveOccurred())
+	store := NewEncryptedStateStore(plain)
+	for _, id := range []string{"tf:instance:", "tf:locked:", "tf:empty:"} {
+		g.Expect(db_service.CreateTerraformDeployment(ctx, &models.TerraformDeployment{ID: id})).To(Succeed())
+	}
+	for _, id := range []string{"tf:instance:", "tf:locked:"} {
+		g.Expect(store.Put(ctx, id, []byte(`{"version":4}`))).To(Succeed())
+	}
+
+	// an operation that is still running when the rotation starts
+	g.Expect(db_service.AcquireTerraformDeploymentLock(ctx, "tf:locked:", "running-operation", time.Now().Add(100*time.Millisecond))).To(BeTrue())
+
+	models.SetEncryptor(compoundencryptor.New(primary, primary, previous))
+	g.Expect(ReencryptStates(ctx, store, lager.NewLogger("test"))).To(Succeed())
+
+	models.SetEncryptor(primary)
+	for _, id := range []string{"tf:instance:", "tf:locked:"} {
+		state, err := store.Get(ctx, id)
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(state).To(MatchJSON(`{"version":4}`))
+	}
+
+	state, err := store.Get(ctx, "tf:empty:")
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(state).To(BeEmpty())
+
+	t.Run("unencrypted store", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		g.Expect(plain.Put(ctx, "tf:instance:", []byte(`{"version":4}`))).To(Succeed())
+
+		g.Expect(ReencryptStates(ctx, plain, lager.NewLogger("test"))).To(Succeed())
+
+		state, err := plain.Get(ctx, "tf:instance:")
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(state).To(MatchJSON(`{"version":4}`), "state that is not encrypted should be left as it is")
+	})
+}
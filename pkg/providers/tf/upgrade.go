@@ -0,0 +1,231 @@
+// Copyright 2021 the Service Broker Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tf
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/db_service"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/db_service/models"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/pkg/broker"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/pkg/varcontext"
+	"github.com/pivotal-cf/brokerapi/v8/domain"
+)
+
+// The outcomes of upgrading an instance.
+const (
+	// Upgraded means the current templates were applied to the instance.
+	Upgraded = "upgraded"
+	// UpToDate means the instance already uses the current templates.
+	UpToDate = "up-to-date"
+	// UpgradePending means the instance would be upgraded, in a dry run.
+	UpgradePending = "pending"
+	// UpgradeSkipped means the instance could not be upgraded at this time,
+	// for example because an operation is in progress on it.
+	UpgradeSkipped = "skipped"
+	// UpgradeFailed means the upgrade was attempted and failed.
+	UpgradeFailed = "failed"
+)
+
+// UpgradeOptions chooses the instances that UpgradeInstances upgrades and how.
+type UpgradeOptions struct {
+	// ServiceId is the service whose instances are upgraded.
+	ServiceId string
+	// PlanId limits the upgrade to instances of one plan, if it is set.
+	PlanId string
+	// Parallelism is how many instances are upgraded at once. Zero or less
+	// means one at a time.
+	Parallelism int
+	// DryRun reports the instances that would be upgraded without changing them.
+	DryRun bool
+	// Skip holds the ids of instances that must not be upgraded, for example
+	// because an earlier run already upgraded them.
+	Skip map[string]bool
+}
+
+// UpgradeResult is the outcome of upgrading one instance.
+type UpgradeResult struct {
+	InstanceId string `json:"instance_id"`
+	ServiceId  string `json:"service_id"`
+	PlanId     string `json:"plan_id"`
+	Outcome    string `json:"outcome"`
+	Message    string `json:"message,omitempty"`
+}
+
+// UpgradeInstances applies the current provision templates of a service to
+// its instances, with the parameters they already have, as an upgrade requested
+// by the platform would. Instances whose workspace is not outdated, as reported
+// by CheckTemplates, are left as they are, so an interrupted run can be started
+// again.
+// The result of each instance is passed to report as soon as it is known;
+// report may be called from several goroutines at once. Once the context is
+// done no more upgrades are started, and those in progress are waited on.
+func UpgradeInstances(ctx context.Context, registry broker.BrokerRegistry, opts UpgradeOptions, report func(UpgradeResult), logger lager.Logger) error {
+	defn, err := registry.GetServiceById(opts.ServiceId)
+	if err != nil {
+		return err
+	}
+
+	if opts.PlanId != "" {
+		if _, err := defn.GetPlanById(opts.PlanId); err != nil {
+			return err
+		}
+	}
+
+	instances, err := db_service.GetServiceInstanceDetails(ctx)
+	if err != nil {
+		return fmt.Errorf("error listing service instances: %w", err)
+	}
+
+	parallelism := opts.Parallelism
+	if parallelism < 1 {
+		parallelism = 1
+	}
+
+	queue := make(chan models.ServiceInstanceDetails)
+	var wg sync.WaitGroup
+	for i := 0; i < parallelism; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for instance := range queue {
+				report(upgradeInstance(ctx, registry, defn, instance, opts.DryRun, logger))
+			}
+		}()
+	}
+
+	for _, instance := range instances {
+		if instance.ServiceId != opts.ServiceId || (opts.PlanId != "" && instance.PlanId != opts.PlanId) || opts.Skip[instance.ID] {
+			continue
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		queue <- instance
+	}
+	close(queue)
+	wg.Wait()
+
+	return ctx.Err()
+}
+
+func upgradeInstance(ctx context.Context, registry broker.BrokerRegistry, defn *broker.ServiceDefinition, instance models.ServiceInstanceDetails, dryRun bool, logger lager.Logger) UpgradeResult {
+	result := UpgradeResult{InstanceId: instance.ID, ServiceId: instance.ServiceId, PlanId: instance.PlanId}
+	data := lager.Data{"instance_id": instance.ID}
+
+	outcome, err := func() (string, error) {
+		provider, ok := defn.ProviderBuilder(logger).(*terraformProvider)
+		if !ok {
+			return UpgradeFailed, fmt.Errorf("service %q is not backed by Terraform", defn.Name)
+		}
+
+		id := DeploymentId(instance.ID, "")
+		deployment, err := db_service.GetTerraformDeploymentById(ctx, id)
+		if err != nil {
+			return UpgradeFailed, fmt.Errorf("error retrieving deployment: %w", err)
+		}
+		if deployment.LastOperationState == InProgress {
+			return UpgradeSkipped, fmt.Errorf("a %s operation is in progress", deployment.LastOperationType)
+		}
+
+		status, err := CheckTemplates(ctx, registry, deployment, logger)
+		if err != nil {
+			return UpgradeFailed, err
+		}
+		if !status.Outdated() {
+			return UpToDate, nil
+		}
+
+		vars, err := upgradeVariables(ctx, defn, instance)
+		if err != nil {
+			return UpgradeFailed, err
+		}
+
+		// the workspace of an imported instance is generated from the imported
+		// resources, so it never matches the templates, and instances on
+		// subsume plans cannot be updated
+		if provider.serviceDefinition.ProvisionSettings.IsTfImport(vars) {
+			return UpgradeSkipped, errors.New("the instance was imported with a subsume plan, update it to another plan to manage it with the templates of the service")
+		}
+
+		if dryRun {
+			return UpgradePending, nil
+		}
+
+		if err := ctx.Err(); err != nil {
+			return UpgradeSkipped, fmt.Errorf("stopped before the upgrade started: %w", err)
+		}
+
+		logger.Info("upgrade-instance", data)
+		_, err = provider.Upgrade(ctx, vars)
+		switch {
+		case errors.Is(err, ErrDeploymentLocked):
+			return UpgradeSkipped, errors.New("an operation is in progress")
+		case err != nil:
+			return UpgradeFailed, err
+		}
+
+		// Terraform runs in this process, so an upgrade that has started is
+		// waited on even once the context is done, as it would be interrupted
+		// if the process exited
+		if err := provider.jobRunner.Wait(context.Background(), id); err != nil {
+			return UpgradeFailed, err
+		}
+
+		return Upgraded, nil
+	}()
+
+	result.Outcome = outcome
+	if err != nil {
+		result.Message = err.Error()
+		logger.Error("upgrade-instance", err, data)
+	}
+
+	return result
+}
+
+// upgradeVariables gets the variables to upgrade the instance with, from the
+// details of the request that provisioned it, as an update without parameters.
+func upgradeVariables(ctx context.Context, defn *broker.ServiceDefinition, instance models.ServiceInstanceDetails) (*varcontext.VarContext, error) {
+	plan, err := defn.GetPlanById(instance.PlanId)
+	if err != nil {
+		return nil, err
+	}
+
+	pr, err := db_service.GetProvisionRequestDetailsByInstanceId(ctx, instance.ID)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving provision request details: %w", err)
+	}
+
+	provisionDetails, err := pr.GetRequestDetails()
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving provision request details: %w", err)
+	}
+
+	details := domain.UpdateDetails{
+		ServiceID: instance.ServiceId,
+		PlanID:    instance.PlanId,
+		PreviousValues: domain.PreviousValues{
+			ServiceID: instance.ServiceId,
+			PlanID:    instance.PlanId,
+		},
+	}
+
+	return defn.UpdateVariables(instance.ID, details, provisionDetails, *plan, nil)
+}
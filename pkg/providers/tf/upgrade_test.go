@@ -0,0 +1,199 @@
+// Copyright 2021 the Service Broker Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tf
+
+import (
+	"context"
+	"encoding/json"
+	"os/exec"
+	"sync"
+	"testing"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/db_service"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/db_service/models"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/pkg/broker"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/pkg/providers/tf/wrapper"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/pkg/varcontext"
+	. "github.com/onsi/gomega"
+	"github.com/pivotal-cf/brokerapi/v8/domain"
+)
+
+const upgradedTemplate = `variable size { type = string }
+output version { value = "2" }`
+
+func TestUpgradeInstances(t *testing.T) {
+	cases := map[string]struct {
+		opts             UpgradeOptions
+		state            string
+		planId           string
+		expectedOutcomes []string
+		expectApply      bool
+	}{
+		"upgrade": {
+			opts:             UpgradeOptions{ServiceId: "service-id", Parallelism: 2},
+			expectedOutcomes: []string{Upgraded},
+			expectApply:      true,
+		},
+		"dry run": {
+			opts:             UpgradeOptions{ServiceId: "service-id", DryRun: true},
+			expectedOutcomes: []string{UpgradePending},
+		},
+		"in progress": {
+			opts:             UpgradeOptions{ServiceId: "service-id"},
+			state:            InProgress,
+			expectedOutcomes: []string{UpgradeSkipped},
+		},
+		"skipped": {
+			opts: UpgradeOptions{ServiceId: "service-id", Skip: map[string]bool{"instance": true}},
+		},
+		// imported instances are outdated, but can't be upgraded
+		"subsumed": {
+			opts:             UpgradeOptions{ServiceId: "service-id"},
+			planId:           "subsume-plan-id",
+			expectedOutcomes: []string{UpgradeSkipped},
+		},
+		"subsumed dry run": {
+			opts:             UpgradeOptions{ServiceId: "service-id", DryRun: true},
+			planId:           "subsume-plan-id",
+			expectedOutcomes: []string{UpgradeSkipped},
+		},
+		"other plan": {
+			opts: UpgradeOptions{ServiceId: "service-id", PlanId: "other-plan-id"},
+		},
+	}
+
+	for tn, tc := range cases {
+		t.Run(tn, func(t *testing.T) {
+			g := NewGomegaWithT(t)
+			ctx := context.Background()
+			deployment := setupUpdatableDeployment(g)
+			if tc.state != "" {
+				deployment.LastOperationState = tc.state
+				g.Expect(db_service.SaveTerraformDeployment(ctx, deployment)).To(Succeed())
+			}
+
+			var applied bool
+			runner := NewTfJobRunnerForProject(map[string]string{})
+			runner.Executor = func(ctx context.Context, cmd *exec.Cmd) (wrapper.ExecutionOutput, error) {
+				applied = applied || cmd.Args[1] == "apply"
+				return wrapper.ExecutionOutput{}, nil
+			}
+			registry := setupUpgradeRegistry(g, runner)
+			if tc.planId != "" {
+				instance, err := db_service.GetServiceInstanceDetailsById(ctx, "instance")
+				g.Expect(err).NotTo(HaveOccurred())
+				instance.PlanId = tc.planId
+				g.Expect(db_service.SaveServiceInstanceDetails(ctx, instance)).To(Succeed())
+			}
+
+			var mutex sync.Mutex
+			var outcomes []string
+			err := UpgradeInstances(ctx, registry, tc.opts, func(result UpgradeResult) {
+				mutex.Lock()
+				defer mutex.Unlock()
+				outcomes = append(outcomes, result.Outcome)
+			}, lager.NewLogger("test"))
+
+			g.Expect(err).NotTo(HaveOccurred())
+			g.Expect(outcomes).To(Equal(tc.expectedOutcomes))
+			g.Expect(applied).To(Equal(tc.expectApply))
+			if !tc.expectApply {
+				return
+			}
+
+			saved, err := runner.Workspace(ctx, deployment.ID)
+			g.Expect(err).NotTo(HaveOccurred())
+			g.Expect(saved.Modules).To(Equal([]wrapper.ModuleDefinition{wrapper.NewModule(upgradedTemplate, nil)}))
+			g.Expect(saved.Instances[0].Configuration).To(HaveKeyWithValue("size", "small"))
+
+			outcomes = nil
+			g.Expect(UpgradeInstances(ctx, registry, tc.opts, func(result UpgradeResult) {
+				outcomes = append(outcomes, result.Outcome)
+			}, lager.NewLogger("test"))).To(Succeed())
+			g.Expect(outcomes).To(Equal([]string{UpToDate}), "upgraded instances should not be upgraded again")
+		})
+	}
+}
+
+func TestUpgradeInstances_Interrupted(t *testing.T) {
+	g := NewGomegaWithT(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	deployment := setupUpdatableDeployment(g)
+
+	runner := NewTfJobRunnerForProject(map[string]string{})
+	runner.Executor = func(_ context.Context, cmd *exec.Cmd) (wrapper.ExecutionOutput, error) {
+		if cmd.Args[1] == "apply" {
+			// interrupted while the upgrade runs
+			cancel()
+		}
+		return wrapper.ExecutionOutput{}, nil
+	}
+	registry := setupUpgradeRegistry(g, runner)
+
+	var outcomes []string
+	err := UpgradeInstances(ctx, registry, UpgradeOptions{ServiceId: "service-id"}, func(result UpgradeResult) {
+		outcomes = append(outcomes, result.Outcome)
+	}, lager.NewLogger("test"))
+
+	g.Expect(err).To(MatchError(context.Canceled))
+	g.Expect(outcomes).To(Equal([]string{Upgraded}), "the upgrade in progress should be waited on")
+
+	saved, err := db_service.GetTerraformDeploymentById(context.Background(), deployment.ID)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(saved.LastOperationState).To(Equal(Succeeded))
+}
+
+// setupUpgradeRegistry creates a service, backed by the runner, with an upgraded
+// template and an instance that the deployment created by
+// setupUpdatableDeployment belongs to.
+func setupUpgradeRegistry(g *GomegaWithT, runner *TfJobRunner) broker.BrokerRegistry {
+	ctx := context.Background()
+	g.Expect(db_service.DbConnection.Migrator().CreateTable(models.ServiceInstanceDetails{}, models.ProvisionRequestDetails{})).To(Succeed())
+	instance := models.ServiceInstanceDetails{ID: "instance", ServiceId: "service-id", PlanId: "plan-id"}
+	g.Expect(db_service.CreateServiceInstanceDetails(ctx, &instance)).To(Succeed())
+	pr := models.ProvisionRequestDetails{ServiceInstanceId: "instance"}
+	g.Expect(pr.SetRequestDetails(json.RawMessage(`{"size":"small"}`))).To(Succeed())
+	g.Expect(db_service.CreateProvisionRequestDetails(ctx, &pr)).To(Succeed())
+
+	definition := TfServiceDefinitionV1{
+		Name: "service",
+		ProvisionSettings: TfServiceDefinitionV1Action{
+			Template:   upgradedTemplate,
+			UserInputs: []broker.BrokerVariable{{FieldName: "size", Type: broker.JsonTypeString, Details: "size"}},
+			PlanInputs: []broker.BrokerVariable{{FieldName: "subsume", Type: broker.JsonTypeBoolean, Details: "subsume"}},
+		},
+	}
+
+	return broker.BrokerRegistry{
+		"service": &broker.ServiceDefinition{
+			Id:   "service-id",
+			Name: "service",
+			Plans: []broker.ServicePlan{
+				{ServicePlan: domain.ServicePlan{ID: "plan-id", Name: "plan"}},
+				{ServicePlan: domain.ServicePlan{ID: "other-plan-id", Name: "other-plan"}},
+				{ServicePlan: domain.ServicePlan{ID: "subsume-plan-id", Name: "subsume"}, ServiceProperties: map[string]interface{}{"subsume": true}},
+			},
+			ProvisionInputVariables: definition.ProvisionSettings.UserInputs,
+			ProvisionComputedVariables: []varcontext.DefaultVariable{
+				{Name: "tf_id", Default: "tf:${request.instance_id}:", Overwrite: true},
+			},
+			ProviderBuilder: func(logger lager.Logger) broker.ServiceProvider {
+				return NewTerraformProvider(runner, logger, definition)
+			},
+		},
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright 2021 the Service Broker Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tf
+
+import (
+	"context"
+	"sync"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	maxConcurrentJobsKey = "terraform.max_concurrent_jobs"
+	operationTimeoutKey  = "terraform.operation_timeout"
+
+	// Queued is the message reported for an operation that is waiting for the
+	// worker pool to have capacity before it starts.
+	Queued = "queued"
+)
+
+func init() {
+	viper.BindEnv(maxConcurrentJobsKey, "TF_MAX_CONCURRENT_JOBS")
+	viper.SetDefault(maxConcurrentJobsKey, 0)
+	viper.BindEnv(operationTimeoutKey, "TF_OPERATION_TIMEOUT")
+}
+
+// jobLimit restricts the number of jobs sharing the key that can run at once.
+// A max of zero or less means there is no limit.
+type jobLimit struct {
+	key string
+	max int
+}
+
+// workerPool bounds the number of Terraform jobs that run concurrently. Each
+// limit is a counting semaphore, and a job must hold a slot in all of the
+// limits that apply to it before it can run.
+type workerPool struct {
+	mutex      sync.Mutex
+	semaphores map[string]chan struct{}
+}
+
+func newWorkerPool() *workerPool {
+	return &workerPool{semaphores: make(map[string]chan struct{})}
+}
+
+// workers is shared by all TfJobRunners because a runner is created per request.
+var workers = newWorkerPool()
+
+func (pool *workerPool) semaphore(limit jobLimit) chan struct{} {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+
+	sem, ok := pool.semaphores[limit.key]
+	if !ok {
+		sem = make(chan struct{}, limit.max)
+		pool.semaphores[limit.key] = sem
+	}
+
+	return sem
+}
+
+// acquire blocks until there is a slot free in all the limits, or the context
+// is done. If the job has to wait, onQueued is called once before waiting. The
+// returned function must be called to release the slots when the job is done.
+//
+// Slots are always acquired in the order the limits are given, so callers must
+// list them consistently, narrowest first, to avoid deadlocks.
+func (pool *workerPool) acquire(ctx context.Context, limits []jobLimit, onQueued func()) (release func(), err error) {
+	var held []chan struct{}
+	release = func() {
+		for _, sem := range held {
+			<-sem
+		}
+	}
+	queued := false
+
+	for _, limit := range limits {
+		if limit.max <= 0 {
+			continue
+		}
+
+		sem := pool.semaphore(limit)
+		select {
+		case sem <- struct{}{}:
+		default:
+			if !queued {
+				queued = true
+				onQueued()
+			}
+
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				release()
+				return nil, ctx.Err()
+			}
+		}
+		held = append(held, sem)
+	}
+
+	return release, nil
+}
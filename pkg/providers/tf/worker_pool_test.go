@@ -0,0 +1,82 @@
+// Copyright 2021 the Service Broker Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tf
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestWorkerPool_Acquire(t *testing.T) {
+	t.Run("unlimited", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		pool := newWorkerPool()
+		limits := []jobLimit{{key: "global", max: 0}}
+
+		for i := 0; i < 10; i++ {
+			pool.acquire(context.Background(), limits, func() { t.Fatal("expected job not to be queued") })
+		}
+		g.Expect(pool.semaphores).To(BeEmpty())
+	})
+
+	t.Run("queues jobs over the limit until a slot is released", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		pool := newWorkerPool()
+		limits := []jobLimit{{key: "plan:a", max: 1}, {key: "global", max: 2}}
+
+		release, err := pool.acquire(context.Background(), limits, func() { t.Fatal("expected job not to be queued") })
+		g.Expect(err).NotTo(HaveOccurred())
+
+		queued := make(chan struct{})
+		acquired := make(chan struct{})
+		go func() {
+			pool.acquire(context.Background(), limits, func() { close(queued) })
+			close(acquired)
+		}()
+
+		g.Eventually(queued).Should(BeClosed())
+		g.Consistently(acquired, 100*time.Millisecond).ShouldNot(BeClosed())
+
+		release()
+		g.Eventually(acquired).Should(BeClosed())
+	})
+
+	t.Run("limits are independent", func(t *testing.T) {
+		pool := newWorkerPool()
+
+		pool.acquire(context.Background(), []jobLimit{{key: "plan:a", max: 1}, {key: "global", max: 2}}, func() { t.Fatal("expected job not to be queued") })
+		pool.acquire(context.Background(), []jobLimit{{key: "plan:b", max: 1}, {key: "global", max: 2}}, func() { t.Fatal("expected job not to be queued") })
+	})
+
+	t.Run("stops waiting when the context is done", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		pool := newWorkerPool()
+		limits := []jobLimit{{key: "plan:a", max: 2}, {key: "global", max: 1}}
+
+		_, err := pool.acquire(context.Background(), limits, func() { t.Fatal("expected job not to be queued") })
+		g.Expect(err).NotTo(HaveOccurred())
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err = pool.acquire(ctx, limits, func() {})
+		g.Expect(err).To(MatchError(context.Canceled))
+
+		// the slot taken on the plan limit while waiting was given back
+		g.Expect(pool.semaphores["plan:a"]).To(HaveLen(1))
+	})
+}
@@ -15,6 +15,7 @@
 package wrapper
 
 import (
+	"crypto/sha256"
 	"fmt"
 	"sort"
 
@@ -100,6 +101,26 @@ func (module *ModuleDefinition) Outputs() ([]string, error) {
 	return sortedKeys(blocks.OfType("output")), err
 }
 
+// Digest gets a SHA-256 digest of the templates of the module, which changes
+// when any of them change. It does not depend on the name of the module.
+func (module *ModuleDefinition) Digest() string {
+	names := make([]string, 0, len(module.Definitions))
+	for name := range module.Definitions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// each template is prefixed with its length so that moving text between
+	// templates changes the digest
+	h := sha256.New()
+	fmt.Fprintf(h, "%d:%s", len(module.Definition), module.Definition)
+	for _, name := range names {
+		fmt.Fprintf(h, "%d:%s%d:%s", len(name), name, len(module.Definitions[name]), module.Definitions[name])
+	}
+
+	return fmt.Sprintf("sha256:%x", h.Sum(nil))
+}
+
 func sortedKeys(m hcl.Blocks) []string {
 	var keys []string
 	for _, block := range m {
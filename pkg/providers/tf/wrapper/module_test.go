@@ -208,3 +208,43 @@ func TestModuleDefinition_Validate(t *testing.T) {
 		})
 	}
 }
+
+func TestModuleDefinition_Digest(t *testing.T) {
+	module := ModuleDefinition{
+		Name:        "brokertemplate",
+		Definition:  `output "name" { value = "x" }`,
+		Definitions: map[string]string{"variables": `variable "name" {}`, "main": `resource "a" "b" {}`},
+	}
+
+	cases := map[string]struct {
+		Module ModuleDefinition
+		Same   bool
+	}{
+		"renamed": {
+			Module: ModuleDefinition{Name: "other", Definition: module.Definition, Definitions: module.Definitions},
+			Same:   true,
+		},
+		"definition changed": {
+			Module: ModuleDefinition{Name: module.Name, Definition: `output "name" { value = "y" }`, Definitions: module.Definitions},
+		},
+		"definitions changed": {
+			Module: ModuleDefinition{Name: module.Name, Definition: module.Definition, Definitions: map[string]string{"variables": `variable "name" {}`}},
+		},
+		"text moved between templates": {
+			Module: ModuleDefinition{Name: module.Name, Definition: module.Definition, Definitions: map[string]string{"variables": `variable "name" {}resource "a" "b" {}`, "main": ``}},
+		},
+	}
+
+	digest := module.Digest()
+	if !strings.HasPrefix(digest, "sha256:") {
+		t.Fatalf("Expected a sha256 digest, got %q", digest)
+	}
+
+	for tn, tc := range cases {
+		t.Run(tn, func(t *testing.T) {
+			if same := tc.Module.Digest() == digest; same != tc.Same {
+				t.Fatalf("Expected digests to be the same: %v, got %q and %q", tc.Same, digest, tc.Module.Digest())
+			}
+		})
+	}
+}
@@ -0,0 +1,149 @@
+// Copyright 2021 the Service Broker Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// PlanSummary lists the addresses of the resources that a Terraform plan
+// would change, grouped by the action that would be taken on them.
+type PlanSummary struct {
+	Create  []string `json:"create"`
+	Update  []string `json:"update"`
+	Replace []string `json:"replace"`
+	Destroy []string `json:"destroy"`
+}
+
+// planJSON is the part of the output of `terraform show -json` for a plan
+// that is used to summarize it.
+type planJSON struct {
+	ResourceChanges []resourceChangeJSON `json:"resource_changes"`
+	ResourceDrift   []resourceChangeJSON `json:"resource_drift"`
+}
+
+type resourceChangeJSON struct {
+	Address string `json:"address"`
+	Change  struct {
+		Actions []string `json:"actions"`
+	} `json:"change"`
+}
+
+// NewPlanSummary summarizes the changes a plan would make, from the output of
+// `terraform show -json` for the plan.
+func NewPlanSummary(showOutput []byte) (*PlanSummary, error) {
+	plan := planJSON{}
+	if err := json.Unmarshal(showOutput, &plan); err != nil {
+		return nil, fmt.Errorf("error unmarshalling JSON plan: %w", err)
+	}
+
+	return summarize(plan.ResourceChanges)
+}
+
+// NewDriftSummary summarizes the changes made to resources outside of
+// Terraform, from the output of `terraform show -json` for a refresh-only plan.
+// Resources that were deleted are listed as destroyed.
+func NewDriftSummary(showOutput []byte) (*PlanSummary, error) {
+	plan := planJSON{}
+	if err := json.Unmarshal(showOutput, &plan); err != nil {
+		return nil, fmt.Errorf("error unmarshalling JSON plan: %w", err)
+	}
+
+	return summarize(plan.ResourceDrift)
+}
+
+func summarize(changes []resourceChangeJSON) (*PlanSummary, error) {
+	summary := PlanSummary{
+		Create:  []string{},
+		Update:  []string{},
+		Replace: []string{},
+		Destroy: []string{},
+	}
+
+	for _, rc := range changes {
+		actions := rc.Change.Actions
+		switch {
+		case len(actions) == 2: // ["delete", "create"] or ["create", "delete"]
+			summary.Replace = append(summary.Replace, rc.Address)
+		case len(actions) != 1:
+			return nil, fmt.Errorf("unexpected actions %q for resource %q", actions, rc.Address)
+		case actions[0] == "create":
+			summary.Create = append(summary.Create, rc.Address)
+		case actions[0] == "update":
+			summary.Update = append(summary.Update, rc.Address)
+		case actions[0] == "delete":
+			summary.Destroy = append(summary.Destroy, rc.Address)
+		}
+	}
+
+	return &summary, nil
+}
+
+// HasChanges reports whether any resources would change.
+func (summary *PlanSummary) HasChanges() bool {
+	return len(summary.Create)+len(summary.Update)+len(summary.Replace)+len(summary.Destroy) > 0
+}
+
+// String returns the summary as JSON, which is how it is reported to users.
+func (summary *PlanSummary) String() string {
+	s, err := json.Marshal(summary)
+	if err != nil {
+		return fmt.Sprintf("error marshalling plan summary: %s", err)
+	}
+
+	return string(s)
+}
+
+// ReplacedOrDestroyed lists the resources that the plan would replace or
+// destroy which match any of the patterns. A pattern matches either a resource
+// type, such as "google_sql_database_instance", or the address of a resource
+// within its module, such as "google_sql_database_instance.instance".
+func (summary *PlanSummary) ReplacedOrDestroyed(patterns []string) []string {
+	var matches []string
+	for _, address := range append(append([]string{}, summary.Replace...), summary.Destroy...) {
+		resource := localAddress(address)
+		resourceType := strings.SplitN(resource, ".", 2)[0]
+
+		for _, pattern := range patterns {
+			if pattern == resource || pattern == resourceType {
+				matches = append(matches, address)
+				break
+			}
+		}
+	}
+
+	return matches
+}
+
+// localAddress removes the module path and instance key from a resource
+// address, so "module.instance.google_sql_database.database[0]" becomes
+// "google_sql_database.database".
+func localAddress(address string) string {
+	for strings.HasPrefix(address, "module.") {
+		parts := strings.SplitN(address, ".", 3)
+		if len(parts) < 3 {
+			break
+		}
+		address = parts[2]
+	}
+
+	if i := strings.Index(address, "["); i >= 0 {
+		address = address[:i]
+	}
+
+	return address
+}
@@ -0,0 +1,106 @@
+// Copyright 2021 the Service Broker Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import "fmt"
+
+func ExampleNewPlanSummary() {
+	plan := `{
+    "format_version": "0.2",
+    "terraform_version": "1.0.10",
+    "resource_changes": [
+        {
+          "address": "random_password.password",
+          "type": "random_password",
+          "change": {"actions": ["no-op"]}
+        },
+        {
+          "address": "google_sql_database_instance.instance",
+          "type": "google_sql_database_instance",
+          "change": {"actions": ["delete", "create"]}
+        },
+        {
+          "address": "google_sql_user.admin",
+          "type": "google_sql_user",
+          "change": {"actions": ["update"]}
+        },
+        {
+          "address": "google_sql_database.database[1]",
+          "type": "google_sql_database",
+          "change": {"actions": ["create"]}
+        },
+        {
+          "address": "google_sql_database.database[2]",
+          "type": "google_sql_database",
+          "change": {"actions": ["delete"]}
+        }
+    ]
+  }`
+
+	summary, err := NewPlanSummary([]byte(plan))
+	fmt.Printf("%v %v", summary, err)
+
+	// Output: {"create":["google_sql_database.database[1]"],"update":["google_sql_user.admin"],"replace":["google_sql_database_instance.instance"],"destroy":["google_sql_database.database[2]"]} <nil>
+}
+
+func ExampleNewPlanSummary_noChanges() {
+	summary, err := NewPlanSummary([]byte(`{"format_version": "0.2"}`))
+	fmt.Printf("%v %v", summary, err)
+
+	// Output: {"create":[],"update":[],"replace":[],"destroy":[]} <nil>
+}
+
+func ExamplePlanSummary_ReplacedOrDestroyed() {
+	summary := PlanSummary{
+		Create:  []string{"google_sql_user.admin"},
+		Replace: []string{"module.instance.google_sql_database_instance.instance", "random_password.password"},
+		Destroy: []string{"google_sql_database.database[0]", "google_storage_bucket.bucket"},
+	}
+
+	fmt.Println(summary.ReplacedOrDestroyed([]string{"google_sql_database_instance", "google_sql_database.database", "google_sql_user"}))
+
+	// Output: [module.instance.google_sql_database_instance.instance google_sql_database.database[0]]
+}
+
+func ExampleNewDriftSummary() {
+	plan := `{
+    "format_version": "0.2",
+    "terraform_version": "1.0.10",
+    "resource_drift": [
+        {
+          "address": "google_sql_database_instance.instance",
+          "type": "google_sql_database_instance",
+          "change": {"actions": ["update"]}
+        },
+        {
+          "address": "google_sql_user.admin",
+          "type": "google_sql_user",
+          "change": {"actions": ["delete"]}
+        }
+    ],
+    "resource_changes": [
+        {
+          "address": "google_sql_user.admin",
+          "type": "google_sql_user",
+          "change": {"actions": ["create"]}
+        }
+    ]
+  }`
+
+	summary, err := NewDriftSummary([]byte(plan))
+	fmt.Printf("%v %v %v", summary, summary.HasChanges(), err)
+
+	// Output: {"create":[],"update":["google_sql_database_instance.instance"],"replace":[],"destroy":["google_sql_user.admin"]} true <nil>
+}
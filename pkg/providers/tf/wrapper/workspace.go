@@ -23,10 +23,13 @@ import (
 	"os/exec"
 	"path"
 	"regexp"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"code.cloudfoundry.org/lager"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/pkg/tracing"
 	"github.com/cloudfoundry-incubator/cloud-service-broker/utils"
 	"github.com/cloudfoundry-incubator/cloud-service-broker/utils/correlation"
 )
@@ -56,11 +59,7 @@ func NewWorkspace(templateVars map[string]interface{},
 	importParameterMappings []ParameterMapping,
 	parametersToRemove []string,
 	parametersToAdd []ParameterMapping) (*TerraformWorkspace, error) {
-	tfModule := ModuleDefinition{
-		Name:        "brokertemplate",
-		Definition:  terraformTemplate,
-		Definitions: terraformTemplates,
-	}
+	tfModule := NewModule(terraformTemplate, terraformTemplates)
 
 	inputList, err := tfModule.Inputs()
 	if err != nil {
@@ -91,6 +90,26 @@ func NewWorkspace(templateVars map[string]interface{},
 	return &workspace, nil
 }
 
+// NewModule creates the module that workspaces created by NewWorkspace run from
+// the given template.
+func NewModule(terraformTemplate string, terraformTemplates map[string]string) ModuleDefinition {
+	return ModuleDefinition{
+		Name:        "brokertemplate",
+		Definition:  terraformTemplate,
+		Definitions: terraformTemplates,
+	}
+}
+
+// Provenance identifies the brokerpak and service that the modules of a
+// workspace were created from.
+type Provenance struct {
+	BrokerpakName    string `json:"brokerpak_name"`
+	BrokerpakVersion string `json:"brokerpak_version"`
+	ServiceId        string `json:"service_id"`
+	// TemplateDigest is the Digest of the module created from the templates.
+	TemplateDigest string `json:"template_digest"`
+}
+
 // DeserializeWorkspace creates a new TerraformWorkspace from a given JSON
 // serialization of one.
 func DeserializeWorkspace(definition string) (*TerraformWorkspace, error) {
@@ -124,6 +143,10 @@ type TerraformWorkspace struct {
 	Executor    TerraformExecutor `json:"-"`
 	Transformer TfTransformer     `json:"transform"`
 
+	// Provenance records where the modules came from. It is nil for
+	// workspaces created before it was recorded.
+	Provenance *Provenance `json:"provenance,omitempty"`
+
 	dirLock sync.Mutex
 	dir     string
 }
@@ -136,6 +159,11 @@ func (workspace *TerraformWorkspace) String() string {
 	b.WriteString("# Terraform Workspace\n")
 	fmt.Fprintf(&b, "modules: %d\n", len(workspace.Modules))
 	fmt.Fprintf(&b, "instances: %d\n", len(workspace.Instances))
+	if p := workspace.Provenance; p != nil {
+		fmt.Fprintf(&b, "brokerpak: %s %s\n", p.BrokerpakName, p.BrokerpakVersion)
+		fmt.Fprintf(&b, "service: %s\n", p.ServiceId)
+		fmt.Fprintf(&b, "template digest: %s\n", p.TemplateDigest)
+	}
 	fmt.Fprintln(&b)
 
 	for _, instance := range workspace.Instances {
@@ -280,21 +308,21 @@ func (workspace *TerraformWorkspace) initializeFs(ctx context.Context) error {
 }
 
 // TeardownFs removes the directory we executed Terraform in and updates the
-// state from it.
+// state from it. The workspace is always unlocked, so that it can be used
+// again even if Terraform failed before writing any state.
 func (workspace *TerraformWorkspace) teardownFs() error {
+	defer workspace.dirLock.Unlock()
+	defer func() {
+		os.RemoveAll(workspace.dir)
+		workspace.dir = ""
+	}()
+
 	bytes, err := os.ReadFile(workspace.tfStatePath())
 	if err != nil {
 		return err
 	}
 
 	workspace.State = bytes
-
-	if err := os.RemoveAll(workspace.dir); err != nil {
-		return err
-	}
-
-	workspace.dir = ""
-	workspace.dirLock.Unlock()
 	return nil
 }
 
@@ -367,6 +395,115 @@ func (workspace *TerraformWorkspace) Plan(ctx context.Context) error {
 	return nil
 }
 
+// Preview runs `terraform plan` on this workspace and summarizes the changes
+// that applying it would make. The state is not changed.
+// This function blocks if another Terraform command is running on this workspace.
+func (workspace *TerraformWorkspace) Preview(ctx context.Context) (*PlanSummary, error) {
+	output, err := workspace.showPlan(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewPlanSummary(output)
+}
+
+// DetectDrift runs `terraform plan -refresh-only` on this workspace and
+// summarizes the changes that have been made to its resources outside of
+// Terraform. The state is not changed. Drift detection needs Terraform 0.15.4
+// or later, older versions fail with an error saying so.
+// This function blocks if another Terraform command is running on this workspace.
+func (workspace *TerraformWorkspace) DetectDrift(ctx context.Context) (*PlanSummary, error) {
+	err := workspace.initializeFs(ctx)
+	defer workspace.teardownFs()
+	if err != nil {
+		return nil, err
+	}
+
+	version, err := workspace.terraformVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if version.olderThan(minDriftDetectionVersion) {
+		return nil, fmt.Errorf("drift detection requires Terraform %s or later, but the workspace uses Terraform %s", minDriftDetectionVersion, version)
+	}
+
+	output, err := workspace.makePlan(ctx, "-refresh-only")
+	if err != nil {
+		return nil, err
+	}
+
+	return NewDriftSummary(output)
+}
+
+// minDriftDetectionVersion is the first version of Terraform that can make
+// refresh-only plans that report the drift of resources.
+var minDriftDetectionVersion = terraformVersion{0, 15, 4}
+
+var terraformVersionRegex = regexp.MustCompile(`Terraform v(\d+)\.(\d+)\.(\d+)`)
+
+// terraformVersion is the major, minor and patch version of Terraform.
+type terraformVersion [3]int
+
+func (v terraformVersion) String() string {
+	return fmt.Sprintf("%d.%d.%d", v[0], v[1], v[2])
+}
+
+func (v terraformVersion) olderThan(other terraformVersion) bool {
+	for i := range v {
+		if v[i] != other[i] {
+			return v[i] < other[i]
+		}
+	}
+	return false
+}
+
+// terraformVersion runs `terraform version` to find the version of Terraform
+// that runs the commands of this workspace.
+func (workspace *TerraformWorkspace) terraformVersion(ctx context.Context) (terraformVersion, error) {
+	output, err := workspace.runTf(ctx, "version")
+	if err != nil {
+		return terraformVersion{}, err
+	}
+
+	match := terraformVersionRegex.FindStringSubmatch(output.StdOut)
+	if match == nil {
+		return terraformVersion{}, fmt.Errorf("couldn't find the version of Terraform in the output of terraform version: %q", output.StdOut)
+	}
+
+	var version terraformVersion
+	for i := range version {
+		version[i], _ = strconv.Atoi(match[i+1])
+	}
+	return version, nil
+}
+
+// showPlan makes a plan with the given options and returns the output of
+// `terraform show -json` for it.
+func (workspace *TerraformWorkspace) showPlan(ctx context.Context, planOptions ...string) ([]byte, error) {
+	err := workspace.initializeFs(ctx)
+	defer workspace.teardownFs()
+	if err != nil {
+		return nil, err
+	}
+
+	return workspace.makePlan(ctx, planOptions...)
+}
+
+// makePlan is like showPlan, for a workspace that has already been initialized.
+func (workspace *TerraformWorkspace) makePlan(ctx context.Context, planOptions ...string) ([]byte, error) {
+	args := append([]string{"-no-color", "-out=" + workspace.planPath()}, planOptions...)
+	if _, err := workspace.runTf(ctx, "plan", args...); err != nil {
+		return nil, err
+	}
+
+	output, err := workspace.runTf(ctx, "show", "-json", "-no-color", workspace.planPath())
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(output.StdOut), nil
+}
+
 // Destroy runs `terraform destroy` on this workspace.
 // This function blocks if another Terraform command is running on this workspace.
 func (workspace *TerraformWorkspace) Destroy(ctx context.Context) error {
@@ -416,11 +553,47 @@ func (workspace *TerraformWorkspace) Show(ctx context.Context) (string, error) {
 	return output.StdOut, nil
 }
 
+// RemoveState runs `terraform state rm` on this workspace, so that Terraform
+// forgets the resources at the given addresses without destroying them.
+// This function blocks if another Terraform command is running on this workspace.
+func (workspace *TerraformWorkspace) RemoveState(ctx context.Context, addresses ...string) (string, error) {
+	return workspace.runState(ctx, append([]string{"rm"}, addresses...)...)
+}
+
+// MoveState runs `terraform state mv` on this workspace, so that the resource
+// at the source address is tracked at the destination address instead.
+// This function blocks if another Terraform command is running on this workspace.
+func (workspace *TerraformWorkspace) MoveState(ctx context.Context, source, destination string) (string, error) {
+	return workspace.runState(ctx, "mv", source, destination)
+}
+
+func (workspace *TerraformWorkspace) runState(ctx context.Context, args ...string) (string, error) {
+	err := workspace.initializeFs(ctx)
+	defer workspace.teardownFs()
+	if err != nil {
+		return "", err
+	}
+
+	output, err := workspace.runTf(ctx, "state", args...)
+	if err != nil {
+		return "", err
+	}
+
+	return output.StdOut, nil
+}
+
 func (workspace *TerraformWorkspace) tfStatePath() string {
 	return path.Join(workspace.dir, "terraform.tfstate")
 }
 
-func (workspace *TerraformWorkspace) runTf(ctx context.Context, subCommand string, args ...string) (ExecutionOutput, error) {
+func (workspace *TerraformWorkspace) planPath() string {
+	return path.Join(workspace.dir, "preview.tfplan")
+}
+
+func (workspace *TerraformWorkspace) runTf(ctx context.Context, subCommand string, args ...string) (output ExecutionOutput, err error) {
+	ctx, span := tracing.Start(ctx, "terraform "+subCommand)
+	defer func() { tracing.End(span, err) }()
+
 	sub := []string{subCommand}
 	sub = append(sub, args...)
 
@@ -506,10 +679,17 @@ func DefaultExecutor(ctx context.Context, c *exec.Cmd) (ExecutionOutput, error)
 		return ExecutionOutput{}, fmt.Errorf("failed to execute terraform: %v", err)
 	}
 
+	exited := make(chan struct{})
+	defer close(exited)
+	go stopOnCancel(ctx, c, exited, logger)
+
 	output, _ := io.ReadAll(stdout)
 	errors, _ := io.ReadAll(stderr)
 
 	err = c.Wait()
+	if ctx.Err() != nil {
+		return ExecutionOutput{}, fmt.Errorf("terraform was stopped: %w", ctx.Err())
+	}
 
 	if err != nil ||
 		len(errors) > 0 {
@@ -532,3 +712,32 @@ func DefaultExecutor(ctx context.Context, c *exec.Cmd) (ExecutionOutput, error)
 		StdOut: string(output),
 	}, nil
 }
+
+// KillGracePeriod is how long Terraform is given to stop cleanly after it is
+// interrupted before it is killed.
+var KillGracePeriod = 30 * time.Second
+
+// stopOnCancel interrupts the process if the context is cancelled before the
+// process exits, which lets Terraform release its state lock, and kills it if
+// it has not exited after KillGracePeriod.
+func stopOnCancel(ctx context.Context, c *exec.Cmd, exited <-chan struct{}, logger lager.Logger) {
+	select {
+	case <-exited:
+		return
+	case <-ctx.Done():
+	}
+
+	logger.Info("interrupting process", lager.Data{"reason": ctx.Err().Error()})
+	if err := c.Process.Signal(os.Interrupt); err != nil {
+		logger.Error("interrupt-process", err)
+	}
+
+	select {
+	case <-exited:
+	case <-time.After(KillGracePeriod):
+		logger.Info("killing process")
+		if err := c.Process.Kill(); err != nil {
+			logger.Error("kill-process", err)
+		}
+	}
+}
@@ -16,11 +16,13 @@ package wrapper
 
 import (
 	"context"
+	"errors"
 	"os"
 	"os/exec"
 	"path"
 	"reflect"
 	"testing"
+	"time"
 )
 
 func TestTerraformWorkspace_Invariants(t *testing.T) {
@@ -50,6 +52,18 @@ func TestTerraformWorkspace_Invariants(t *testing.T) {
 		"plan": {Exec: func(ws *TerraformWorkspace) {
 			ws.Plan(context.TODO())
 		}},
+		"preview": {Exec: func(ws *TerraformWorkspace) {
+			ws.Preview(context.TODO())
+		}},
+		"detect drift": {Exec: func(ws *TerraformWorkspace) {
+			ws.DetectDrift(context.TODO())
+		}},
+		"state rm": {Exec: func(ws *TerraformWorkspace) {
+			ws.RemoveState(context.TODO(), "random_password.password")
+		}},
+		"state mv": {Exec: func(ws *TerraformWorkspace) {
+			ws.MoveState(context.TODO(), "random_password.password", "random_password.admin")
+		}},
 	}
 
 	for tn, tc := range cases {
@@ -285,3 +299,19 @@ func TestCustomEnvironmentExecutor(t *testing.T) {
 		t.Fatalf("Expected %v actual %v", expected, actual)
 	}
 }
+
+func TestDefaultExecutor_StopsOnCancel(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := DefaultExecutor(ctx, exec.Command("sleep", "30"))
+
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the deadline error, got %v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("Expected the process to be stopped, but it ran for %v", elapsed)
+	}
+}
@@ -0,0 +1,559 @@
+// Copyright 2021 the Service Broker Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/db_service"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/db_service/models"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/pkg/broker"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/pkg/providers/tf"
+	"github.com/gorilla/mux"
+	"github.com/pivotal-cf/brokerapi/v8/auth"
+	"gorm.io/gorm"
+)
+
+// redacted replaces secrets, such as credentials and Terraform state, in the
+// records returned by the admin API.
+const redacted = "[REDACTED]"
+
+// CredentialRotator replaces the credentials of a binding, keeping the previous
+// credentials valid for the grace period.
+type CredentialRotator interface {
+	RotateBindingCredentials(ctx context.Context, instanceID, bindingID string, gracePeriod time.Duration) error
+}
+
+// AddAdminHandler adds the admin API under /admin. It lets operators inspect
+// the records the broker keeps about service instances, bindings and Terraform
+// deployments, and repair instances that are stuck, without access to the
+// database. Requests must use basic authentication with the given credentials.
+// The credentials of bindings can be rotated if a rotator is given.
+func AddAdminHandler(router *mux.Router, registry broker.BrokerRegistry, rotator CredentialRotator, username, password string, logger lager.Logger) {
+	admin := &adminHandler{registry: registry, rotator: rotator, logger: logger.Session("admin")}
+
+	r := router.PathPrefix("/admin").Subrouter()
+	r.Use(auth.NewWrapper(username, password).Wrap)
+
+	r.HandleFunc("/instances", admin.handle(admin.listInstances)).Methods(http.MethodGet)
+	r.HandleFunc("/instances/{instance_id}", admin.handle(admin.getInstance)).Methods(http.MethodGet)
+	r.HandleFunc("/instances/{instance_id}/reset-operation-state", admin.handle(admin.resetInstance)).Methods(http.MethodPost)
+	r.HandleFunc("/instances/{instance_id}/force-delete", admin.handle(admin.forceDeleteInstance)).Methods(http.MethodPost)
+
+	r.HandleFunc("/bindings", admin.handle(admin.listBindings)).Methods(http.MethodGet)
+	r.HandleFunc("/bindings/{binding_id}", admin.handle(admin.getBinding)).Methods(http.MethodGet)
+	r.HandleFunc("/bindings/{binding_id}/reset-operation-state", admin.handle(admin.resetBinding)).Methods(http.MethodPost)
+	r.HandleFunc("/bindings/{binding_id}/force-delete", admin.handle(admin.forceDeleteBinding)).Methods(http.MethodPost)
+	if rotator != nil {
+		r.HandleFunc("/bindings/{binding_id}/rotate-credentials", admin.handle(admin.rotateBindingCredentials)).Methods(http.MethodPost)
+	}
+
+	r.HandleFunc("/deployments", admin.handle(admin.listDeployments)).Methods(http.MethodGet)
+	r.HandleFunc("/deployments/{deployment_id}", admin.handle(admin.getDeployment)).Methods(http.MethodGet)
+	r.HandleFunc("/deployments/{deployment_id}/reset-operation-state", admin.handle(admin.resetDeployment)).Methods(http.MethodPost)
+	r.HandleFunc("/deployments/{deployment_id}/rerun-last-operation", admin.handle(admin.rerunDeployment)).Methods(http.MethodPost)
+	r.HandleFunc("/deployments/{deployment_id}/retry", admin.handle(admin.retryDeployment)).Methods(http.MethodPost)
+	r.HandleFunc("/deployments/{deployment_id}/cancel", admin.handle(admin.cancelDeployment)).Methods(http.MethodPost)
+	r.HandleFunc("/deployments/{deployment_id}/check-drift", admin.handle(admin.checkDeploymentDrift)).Methods(http.MethodPost)
+}
+
+type adminHandler struct {
+	registry broker.BrokerRegistry
+	rotator  CredentialRotator
+	logger   lager.Logger
+}
+
+// adminFunc handles an admin API request, returning the status and the value
+// to send as JSON in the response.
+type adminFunc func(r *http.Request) (int, interface{}, error)
+
+func (admin *adminHandler) handle(f adminFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		status, body, err := f(r)
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			status, body = http.StatusNotFound, adminError{Error: "not found"}
+		case errors.Is(err, tf.ErrDeploymentLocked), errors.Is(err, tf.ErrOperationNotFailed), errors.Is(err, tf.ErrPreviewNotRerunnable), errors.Is(err, tf.ErrImportNotRerunnable):
+			status, body = http.StatusConflict, adminError{Error: err.Error()}
+		case errors.Is(err, tf.ErrRotationNotSupported), errors.Is(err, tf.ErrGracePeriodNotSupported), errors.Is(err, errBadRequest):
+			status, body = http.StatusUnprocessableEntity, adminError{Error: err.Error()}
+		case err != nil:
+			admin.logger.Error("request", err, lager.Data{"method": r.Method, "path": r.URL.Path})
+			status, body = http.StatusInternalServerError, adminError{Error: err.Error()}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(body)
+	}
+}
+
+// errBadRequest is wrapped by errors in the parameters of requests.
+var errBadRequest = errors.New("invalid request")
+
+type adminError struct {
+	Error string `json:"error"`
+}
+
+type instanceView struct {
+	ID               string          `json:"id"`
+	Name             string          `json:"name"`
+	ServiceID        string          `json:"service_id"`
+	PlanID           string          `json:"plan_id"`
+	SpaceGUID        string          `json:"space_guid"`
+	OrganizationGUID string          `json:"organization_guid"`
+	OperationType    string          `json:"operation_type"`
+	OperationID      string          `json:"operation_id"`
+	OtherDetails     string          `json:"other_details,omitempty"`
+	CreatedAt        time.Time       `json:"created_at"`
+	UpdatedAt        time.Time       `json:"updated_at"`
+	Bindings         []bindingView   `json:"bindings,omitempty"`
+	Deployment       *deploymentView `json:"deployment,omitempty"`
+}
+
+func newInstanceView(instance models.ServiceInstanceDetails) instanceView {
+	return instanceView{
+		ID:               instance.ID,
+		Name:             instance.Name,
+		ServiceID:        instance.ServiceId,
+		PlanID:           instance.PlanId,
+		SpaceGUID:        instance.SpaceGuid,
+		OrganizationGUID: instance.OrganizationGuid,
+		OperationType:    instance.OperationType,
+		OperationID:      instance.OperationId,
+		OtherDetails:     redact(instance.OtherDetails),
+		CreatedAt:        instance.CreatedAt,
+		UpdatedAt:        instance.UpdatedAt,
+	}
+}
+
+type bindingView struct {
+	ID                uint      `json:"id"`
+	BindingID         string    `json:"binding_id"`
+	ServiceInstanceID string    `json:"service_instance_id"`
+	ServiceID         string    `json:"service_id"`
+	OperationType     string    `json:"operation_type"`
+	OperationID       string    `json:"operation_id"`
+	OtherDetails      string    `json:"other_details,omitempty"`
+	CreatedAt         time.Time `json:"created_at"`
+	UpdatedAt         time.Time `json:"updated_at"`
+}
+
+func newBindingView(binding models.ServiceBindingCredentials) bindingView {
+	return bindingView{
+		ID:                binding.ID,
+		BindingID:         binding.BindingId,
+		ServiceInstanceID: binding.ServiceInstanceId,
+		ServiceID:         binding.ServiceId,
+		OperationType:     binding.OperationType,
+		OperationID:       binding.OperationId,
+		OtherDetails:      redact(binding.OtherDetails),
+		CreatedAt:         binding.CreatedAt,
+		UpdatedAt:         binding.UpdatedAt,
+	}
+}
+
+type deploymentView struct {
+	ID                           string     `json:"id"`
+	LastOperationType            string     `json:"last_operation_type"`
+	LastOperationState           string     `json:"last_operation_state"`
+	LastOperationMessage         string     `json:"last_operation_message"`
+	Locked                       bool       `json:"locked"`
+	Version                      int        `json:"version"`
+	DriftStatus                  string     `json:"drift_status,omitempty"`
+	DriftMessage                 string     `json:"drift_message,omitempty"`
+	DriftCheckedAt               *time.Time `json:"drift_checked_at,omitempty"`
+	PreviousCredentialsExpireAt  *time.Time `json:"previous_credentials_expire_at,omitempty"`
+	CredentialRetirementFailures int        `json:"credential_retirement_failures,omitempty"`
+	Workspace                    string     `json:"workspace,omitempty"`
+	CreatedAt                    time.Time  `json:"created_at"`
+	UpdatedAt                    time.Time  `json:"updated_at"`
+}
+
+func newDeploymentView(ctx context.Context, deployment models.TerraformDeployment) (deploymentView, error) {
+	locked, err := db_service.IsTerraformDeploymentLocked(ctx, deployment.ID)
+	if err != nil {
+		return deploymentView{}, err
+	}
+
+	return deploymentView{
+		ID:                           deployment.ID,
+		LastOperationType:            deployment.LastOperationType,
+		LastOperationState:           deployment.LastOperationState,
+		LastOperationMessage:         deployment.LastOperationMessage,
+		Locked:                       locked,
+		Version:                      deployment.Version,
+		DriftStatus:                  deployment.DriftStatus,
+		DriftMessage:                 deployment.DriftMessage,
+		DriftCheckedAt:               deployment.DriftCheckedAt,
+		PreviousCredentialsExpireAt:  deployment.PreviousCredentialsExpireAt,
+		CredentialRetirementFailures: deployment.CredentialRetirementFailures,
+		Workspace:                    redact(deployment.Workspace),
+		CreatedAt:                    deployment.CreatedAt,
+		UpdatedAt:                    deployment.UpdatedAt,
+	}, nil
+}
+
+func redact(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return redacted
+}
+
+func (admin *adminHandler) listInstances(r *http.Request) (int, interface{}, error) {
+	instances, err := db_service.GetServiceInstanceDetails(r.Context())
+	if err != nil {
+		return 0, nil, err
+	}
+
+	views := []instanceView{}
+	for _, instance := range instances {
+		views = append(views, newInstanceView(instance))
+	}
+
+	return http.StatusOK, views, nil
+}
+
+func (admin *adminHandler) getInstance(r *http.Request) (int, interface{}, error) {
+	instanceID := mux.Vars(r)["instance_id"]
+	instance, err := db_service.GetServiceInstanceDetailsById(r.Context(), instanceID)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	view := newInstanceView(*instance)
+
+	bindings, err := db_service.GetServiceBindingCredentialsByServiceInstanceId(r.Context(), instanceID)
+	if err != nil {
+		return 0, nil, err
+	}
+	for _, binding := range bindings {
+		view.Bindings = append(view.Bindings, newBindingView(binding))
+	}
+
+	deployment, err := db_service.GetTerraformDeploymentById(r.Context(), tf.DeploymentId(instanceID, ""))
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+	case err != nil:
+		return 0, nil, err
+	default:
+		deploymentView, err := newDeploymentView(r.Context(), *deployment)
+		if err != nil {
+			return 0, nil, err
+		}
+		view.Deployment = &deploymentView
+	}
+
+	return http.StatusOK, view, nil
+}
+
+// resetInstance clears the operation recorded on the instance so that the
+// platform can start another, and fails the operation on its deployment if it
+// is stuck in progress.
+func (admin *adminHandler) resetInstance(r *http.Request) (int, interface{}, error) {
+	instance, err := db_service.GetServiceInstanceDetailsById(r.Context(), mux.Vars(r)["instance_id"])
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if err := admin.resetDeploymentIfExists(r.Context(), tf.DeploymentId(instance.ID, "")); err != nil {
+		return 0, nil, err
+	}
+
+	instance.OperationType = models.ClearOperationType
+	instance.OperationId = ""
+	if err := db_service.SaveServiceInstanceDetails(r.Context(), instance); err != nil {
+		return 0, nil, err
+	}
+
+	admin.logger.Info("reset-instance", lager.Data{"instance": instance.ID})
+	return http.StatusOK, newInstanceView(*instance), nil
+}
+
+// forceDeleteInstance deletes the records of the instance and its bindings
+// without running Terraform, for when they cannot be deprovisioned. The
+// Terraform deployments are kept so that any resources that remain can be
+// found and cleaned up.
+func (admin *adminHandler) forceDeleteInstance(r *http.Request) (int, interface{}, error) {
+	ctx := r.Context()
+	instance, err := db_service.GetServiceInstanceDetailsById(ctx, mux.Vars(r)["instance_id"])
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if err := checkNotRunning(ctx, tf.DeploymentId(instance.ID, "")); err != nil {
+		return 0, nil, err
+	}
+
+	bindings, err := db_service.GetServiceBindingCredentialsByServiceInstanceId(ctx, instance.ID)
+	if err != nil {
+		return 0, nil, err
+	}
+	for i := range bindings {
+		if err := db_service.DeleteServiceBindingCredentials(ctx, &bindings[i]); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	details, err := db_service.GetProvisionRequestDetailsByInstanceId(ctx, instance.ID)
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+	case err != nil:
+		return 0, nil, err
+	default:
+		if err := db_service.DeleteProvisionRequestDetails(ctx, details); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	if err := db_service.DeleteServiceInstanceDetails(ctx, instance); err != nil {
+		return 0, nil, err
+	}
+
+	admin.logger.Info("force-delete-instance", lager.Data{"instance": instance.ID, "bindings": len(bindings)})
+	return http.StatusOK, newInstanceView(*instance), nil
+}
+
+func (admin *adminHandler) listBindings(r *http.Request) (int, interface{}, error) {
+	var bindings []models.ServiceBindingCredentials
+	var err error
+	if instanceID := r.URL.Query().Get("instance_id"); instanceID != "" {
+		bindings, err = db_service.GetServiceBindingCredentialsByServiceInstanceId(r.Context(), instanceID)
+	} else {
+		bindings, err = db_service.GetServiceBindingCredentials(r.Context())
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+
+	views := []bindingView{}
+	for _, binding := range bindings {
+		views = append(views, newBindingView(binding))
+	}
+
+	return http.StatusOK, views, nil
+}
+
+func (admin *adminHandler) getBinding(r *http.Request) (int, interface{}, error) {
+	binding, err := db_service.GetServiceBindingCredentialsByBindingId(r.Context(), mux.Vars(r)["binding_id"])
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return http.StatusOK, newBindingView(*binding), nil
+}
+
+// resetBinding clears the operation recorded on the binding so that the
+// platform can start another, and fails the operation on its deployment if it
+// is stuck in progress.
+func (admin *adminHandler) resetBinding(r *http.Request) (int, interface{}, error) {
+	binding, err := db_service.GetServiceBindingCredentialsByBindingId(r.Context(), mux.Vars(r)["binding_id"])
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if err := admin.resetDeploymentIfExists(r.Context(), tf.DeploymentId(binding.ServiceInstanceId, binding.BindingId)); err != nil {
+		return 0, nil, err
+	}
+
+	binding.OperationType = models.ClearOperationType
+	binding.OperationId = ""
+	if err := db_service.SaveServiceBindingCredentials(r.Context(), binding); err != nil {
+		return 0, nil, err
+	}
+
+	admin.logger.Info("reset-binding", lager.Data{"binding": binding.BindingId})
+	return http.StatusOK, newBindingView(*binding), nil
+}
+
+// forceDeleteBinding deletes the record of the binding without running
+// Terraform, for when it cannot be unbound. Credentials kept in a credential
+// store are not deleted.
+func (admin *adminHandler) forceDeleteBinding(r *http.Request) (int, interface{}, error) {
+	binding, err := db_service.GetServiceBindingCredentialsByBindingId(r.Context(), mux.Vars(r)["binding_id"])
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if err := checkNotRunning(r.Context(), tf.DeploymentId(binding.ServiceInstanceId, binding.BindingId)); err != nil {
+		return 0, nil, err
+	}
+
+	if err := db_service.DeleteServiceBindingCredentials(r.Context(), binding); err != nil {
+		return 0, nil, err
+	}
+
+	admin.logger.Info("force-delete-binding", lager.Data{"binding": binding.BindingId})
+	return http.StatusOK, newBindingView(*binding), nil
+}
+
+// rotateBindingCredentials replaces the credentials of the binding, waiting
+// for its Terraform to be applied. The previous credentials are kept valid for
+// the grace_period given as a query parameter, such as "24h", if it is set.
+func (admin *adminHandler) rotateBindingCredentials(r *http.Request) (int, interface{}, error) {
+	binding, err := db_service.GetServiceBindingCredentialsByBindingId(r.Context(), mux.Vars(r)["binding_id"])
+	if err != nil {
+		return 0, nil, err
+	}
+
+	var gracePeriod time.Duration
+	if value := r.URL.Query().Get("grace_period"); value != "" {
+		gracePeriod, err = time.ParseDuration(value)
+		if err != nil || gracePeriod < 0 {
+			return 0, nil, fmt.Errorf("%w: grace_period must be a duration such as 24h, got %q", errBadRequest, value)
+		}
+	}
+
+	if binding.OperationType != models.ClearOperationType {
+		return 0, nil, tf.ErrDeploymentLocked
+	}
+
+	if err := admin.rotator.RotateBindingCredentials(r.Context(), binding.ServiceInstanceId, binding.BindingId, gracePeriod); err != nil {
+		return 0, nil, err
+	}
+
+	binding, err = db_service.GetServiceBindingCredentialsByBindingId(r.Context(), binding.BindingId)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	admin.logger.Info("rotate-binding-credentials", lager.Data{"binding": binding.BindingId, "grace_period": gracePeriod.String()})
+	return http.StatusOK, newBindingView(*binding), nil
+}
+
+func (admin *adminHandler) listDeployments(r *http.Request) (int, interface{}, error) {
+	deployments, err := db_service.GetTerraformDeployments(r.Context())
+	if err != nil {
+		return 0, nil, err
+	}
+
+	views := []deploymentView{}
+	for _, deployment := range deployments {
+		view, err := newDeploymentView(r.Context(), deployment)
+		if err != nil {
+			return 0, nil, err
+		}
+		views = append(views, view)
+	}
+
+	return http.StatusOK, views, nil
+}
+
+func (admin *adminHandler) getDeployment(r *http.Request) (int, interface{}, error) {
+	return admin.deployment(r.Context(), mux.Vars(r)["deployment_id"], http.StatusOK)
+}
+
+func (admin *adminHandler) resetDeployment(r *http.Request) (int, interface{}, error) {
+	id := mux.Vars(r)["deployment_id"]
+	if err := tf.ResetOperationState(r.Context(), id); err != nil {
+		return 0, nil, err
+	}
+
+	admin.logger.Info("reset-deployment", lager.Data{"deployment": id})
+	return admin.deployment(r.Context(), id, http.StatusOK)
+}
+
+func (admin *adminHandler) rerunDeployment(r *http.Request) (int, interface{}, error) {
+	id := mux.Vars(r)["deployment_id"]
+	if err := tf.RerunLastOperation(r.Context(), admin.registry, id, admin.logger); err != nil {
+		return 0, nil, err
+	}
+
+	admin.logger.Info("rerun-deployment", lager.Data{"deployment": id})
+	return admin.deployment(r.Context(), id, http.StatusAccepted)
+}
+
+func (admin *adminHandler) retryDeployment(r *http.Request) (int, interface{}, error) {
+	id := mux.Vars(r)["deployment_id"]
+	if err := tf.RetryFailedOperation(r.Context(), admin.registry, id, admin.logger); err != nil {
+		return 0, nil, err
+	}
+
+	admin.logger.Info("retry-deployment", lager.Data{"deployment": id})
+	return admin.deployment(r.Context(), id, http.StatusAccepted)
+}
+
+func (admin *adminHandler) cancelDeployment(r *http.Request) (int, interface{}, error) {
+	id := mux.Vars(r)["deployment_id"]
+	runner, err := tf.NewTfJobRunnerForDeployment(r.Context(), admin.registry, id, admin.logger)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if err := runner.Cancel(r.Context(), id); err != nil {
+		return 0, nil, err
+	}
+
+	admin.logger.Info("cancel-deployment", lager.Data{"deployment": id})
+	return admin.deployment(r.Context(), id, http.StatusAccepted)
+}
+
+// checkDeploymentDrift checks the deployment for drift. A check that fails is
+// recorded on the deployment like any other result.
+func (admin *adminHandler) checkDeploymentDrift(r *http.Request) (int, interface{}, error) {
+	id := mux.Vars(r)["deployment_id"]
+	runner, err := tf.NewTfJobRunnerForDeployment(r.Context(), admin.registry, id, admin.logger)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if result, err := runner.CheckDrift(r.Context(), id); result == nil {
+		return 0, nil, err
+	}
+
+	return admin.deployment(r.Context(), id, http.StatusOK)
+}
+
+func (admin *adminHandler) deployment(ctx context.Context, id string, status int) (int, interface{}, error) {
+	deployment, err := db_service.GetTerraformDeploymentById(ctx, id)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	view, err := newDeploymentView(ctx, *deployment)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return status, view, nil
+}
+
+func (admin *adminHandler) resetDeploymentIfExists(ctx context.Context, id string) error {
+	if err := tf.ResetOperationState(ctx, id); err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	return nil
+}
+
+// checkNotRunning fails with tf.ErrDeploymentLocked if an operation is running
+// on the deployment, which should be cancelled first.
+func checkNotRunning(ctx context.Context, id string) error {
+	locked, err := db_service.IsTerraformDeploymentLocked(ctx, id)
+	switch {
+	case err != nil:
+		return err
+	case locked:
+		return tf.ErrDeploymentLocked
+	default:
+		return nil
+	}
+}
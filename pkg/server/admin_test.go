@@ -0,0 +1,365 @@
+// Copyright 2021 the Service Broker Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/db_service"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/db_service/models"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/internal/encryption/noopencryptor"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/pkg/broker"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/pkg/providers/tf"
+	"github.com/gorilla/mux"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestAdminHandler(t *testing.T) {
+	cases := map[string]struct {
+		Method         string
+		Endpoint       string
+		Unauthorized   bool
+		Locked         bool
+		ExpectedStatus int
+		ExpectedBody   []string
+	}{
+		"unauthorized": {
+			Method:         http.MethodGet,
+			Endpoint:       "/admin/instances",
+			Unauthorized:   true,
+			ExpectedStatus: http.StatusUnauthorized,
+		},
+		"list instances": {
+			Method:         http.MethodGet,
+			Endpoint:       "/admin/instances",
+			ExpectedStatus: http.StatusOK,
+			ExpectedBody:   []string{`"id":"instance"`, `"other_details":"[REDACTED]"`},
+		},
+		"get instance": {
+			Method:         http.MethodGet,
+			Endpoint:       "/admin/instances/instance",
+			ExpectedStatus: http.StatusOK,
+			ExpectedBody:   []string{`"binding_id":"binding"`, `"deployment":{"id":"tf:instance:"`, `"workspace":"[REDACTED]"`},
+		},
+		"get missing instance": {
+			Method:         http.MethodGet,
+			Endpoint:       "/admin/instances/missing",
+			ExpectedStatus: http.StatusNotFound,
+			ExpectedBody:   []string{`{"error":"not found"}`},
+		},
+		"reset instance": {
+			Method:         http.MethodPost,
+			Endpoint:       "/admin/instances/instance/reset-operation-state",
+			ExpectedStatus: http.StatusOK,
+			ExpectedBody:   []string{`"operation_type":""`, `"operation_id":""`},
+		},
+		"reset running instance": {
+			Method:         http.MethodPost,
+			Endpoint:       "/admin/instances/instance/reset-operation-state",
+			Locked:         true,
+			ExpectedStatus: http.StatusConflict,
+			ExpectedBody:   []string{tf.ErrDeploymentLocked.Error()},
+		},
+		"force delete instance": {
+			Method:         http.MethodPost,
+			Endpoint:       "/admin/instances/instance/force-delete",
+			ExpectedStatus: http.StatusOK,
+			ExpectedBody:   []string{`"id":"instance"`},
+		},
+		"force delete running instance": {
+			Method:         http.MethodPost,
+			Endpoint:       "/admin/instances/instance/force-delete",
+			Locked:         true,
+			ExpectedStatus: http.StatusConflict,
+		},
+		"list bindings of instance": {
+			Method:         http.MethodGet,
+			Endpoint:       "/admin/bindings?instance_id=instance",
+			ExpectedStatus: http.StatusOK,
+			ExpectedBody:   []string{`"binding_id":"binding"`, `"other_details":"[REDACTED]"`},
+		},
+		"list bindings of other instance": {
+			Method:         http.MethodGet,
+			Endpoint:       "/admin/bindings?instance_id=other",
+			ExpectedStatus: http.StatusOK,
+			ExpectedBody:   []string{`[]`},
+		},
+		"list deployments": {
+			Method:         http.MethodGet,
+			Endpoint:       "/admin/deployments",
+			ExpectedStatus: http.StatusOK,
+			ExpectedBody:   []string{`"id":"tf:instance:"`, `"last_operation_state":"in progress"`, `"drift_status":"drifted"`, `"workspace":"[REDACTED]"`},
+		},
+		"reset deployment": {
+			Method:         http.MethodPost,
+			Endpoint:       "/admin/deployments/tf:instance:/reset-operation-state",
+			ExpectedStatus: http.StatusOK,
+			ExpectedBody:   []string{`"last_operation_state":"failed"`},
+		},
+		"retry deployment in progress": {
+			Method:         http.MethodPost,
+			Endpoint:       "/admin/deployments/tf:instance:/retry",
+			ExpectedStatus: http.StatusConflict,
+			ExpectedBody:   []string{tf.ErrOperationNotFailed.Error()},
+		},
+		"rerun missing deployment": {
+			Method:         http.MethodPost,
+			Endpoint:       "/admin/deployments/tf:missing:/rerun-last-operation",
+			ExpectedStatus: http.StatusNotFound,
+		},
+	}
+
+	for tn, tc := range cases {
+		t.Run(tn, func(t *testing.T) {
+			setupAdminDatabase(t)
+			ctx := context.Background()
+
+			if tc.Locked {
+				acquired, err := db_service.AcquireTerraformDeploymentLock(ctx, "tf:instance:", "other-broker", time.Now().Add(time.Minute))
+				if err != nil || !acquired {
+					t.Fatalf("couldn't lock deployment: %v", err)
+				}
+			}
+
+			router := mux.NewRouter()
+			AddAdminHandler(router, broker.BrokerRegistry{}, nil, "admin", "secret", lager.NewLogger("test"))
+
+			req := httptest.NewRequest(tc.Method, tc.Endpoint, nil)
+			if !tc.Unauthorized {
+				req.SetBasicAuth("admin", "secret")
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != tc.ExpectedStatus {
+				t.Errorf("Expected status %d, got %d: %s", tc.ExpectedStatus, w.Code, w.Body.String())
+			}
+			for _, expected := range tc.ExpectedBody {
+				if !strings.Contains(w.Body.String(), expected) {
+					t.Errorf("Expected body to contain %q, got %s", expected, w.Body.String())
+				}
+			}
+		})
+	}
+}
+
+func TestAdminHandler_Repairs(t *testing.T) {
+	router := mux.NewRouter()
+	AddAdminHandler(router, broker.BrokerRegistry{}, nil, "admin", "secret", lager.NewLogger("test"))
+	post := func(endpoint string) {
+		req := httptest.NewRequest(http.MethodPost, endpoint, nil)
+		req.SetBasicAuth("admin", "secret")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+	}
+
+	t.Run("reset instance", func(t *testing.T) {
+		setupAdminDatabase(t)
+		ctx := context.Background()
+
+		post("/admin/instances/instance/reset-operation-state")
+
+		instance, err := db_service.GetServiceInstanceDetailsById(ctx, "instance")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if instance.OperationType != models.ClearOperationType {
+			t.Errorf("Expected the operation to be cleared, got %q", instance.OperationType)
+		}
+
+		deployment, err := db_service.GetTerraformDeploymentById(ctx, "tf:instance:")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if deployment.LastOperationState != tf.Failed {
+			t.Errorf("Expected the deployment to be failed, got %q", deployment.LastOperationState)
+		}
+	})
+
+	t.Run("force delete instance", func(t *testing.T) {
+		setupAdminDatabase(t)
+		ctx := context.Background()
+
+		post("/admin/instances/instance/force-delete")
+
+		if exists, err := db_service.ExistsServiceInstanceDetailsById(ctx, "instance"); err != nil || exists {
+			t.Errorf("Expected the instance to be deleted, got exists: %v, error: %v", exists, err)
+		}
+		if exists, err := db_service.ExistsServiceBindingCredentialsByBindingId(ctx, "binding"); err != nil || exists {
+			t.Errorf("Expected the binding to be deleted, got exists: %v, error: %v", exists, err)
+		}
+		if exists, err := db_service.ExistsTerraformDeploymentById(ctx, "tf:instance:"); err != nil || !exists {
+			t.Errorf("Expected the deployment to be kept, got exists: %v, error: %v", exists, err)
+		}
+	})
+}
+
+func TestAdminHandler_RotateCredentials(t *testing.T) {
+	cases := map[string]struct {
+		Endpoint            string
+		RotateError         error
+		ExpectedStatus      int
+		ExpectedGracePeriod time.Duration
+		ExpectRotation      bool
+	}{
+		"rotate": {
+			Endpoint:       "/admin/bindings/binding/rotate-credentials",
+			ExpectedStatus: http.StatusOK,
+			ExpectRotation: true,
+		},
+		"rotate with grace period": {
+			Endpoint:            "/admin/bindings/binding/rotate-credentials?grace_period=24h",
+			ExpectedStatus:      http.StatusOK,
+			ExpectedGracePeriod: 24 * time.Hour,
+			ExpectRotation:      true,
+		},
+		"invalid grace period": {
+			Endpoint:       "/admin/bindings/binding/rotate-credentials?grace_period=tomorrow",
+			ExpectedStatus: http.StatusUnprocessableEntity,
+		},
+		"rotation not supported": {
+			Endpoint:       "/admin/bindings/binding/rotate-credentials",
+			RotateError:    fmt.Errorf("error rotating credentials: %w", tf.ErrRotationNotSupported),
+			ExpectedStatus: http.StatusUnprocessableEntity,
+			ExpectRotation: true,
+		},
+		"missing binding": {
+			Endpoint:       "/admin/bindings/missing/rotate-credentials",
+			ExpectedStatus: http.StatusNotFound,
+		},
+	}
+
+	for tn, tc := range cases {
+		t.Run(tn, func(t *testing.T) {
+			setupAdminDatabase(t)
+			rotator := &fakeCredentialRotator{err: tc.RotateError}
+
+			router := mux.NewRouter()
+			AddAdminHandler(router, broker.BrokerRegistry{}, rotator, "admin", "secret", lager.NewLogger("test"))
+
+			req := httptest.NewRequest(http.MethodPost, tc.Endpoint, nil)
+			req.SetBasicAuth("admin", "secret")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != tc.ExpectedStatus {
+				t.Errorf("Expected status %d, got %d: %s", tc.ExpectedStatus, w.Code, w.Body.String())
+			}
+			if rotator.called != tc.ExpectRotation {
+				t.Fatalf("Expected rotation: %v, got %v", tc.ExpectRotation, rotator.called)
+			}
+			if tc.ExpectRotation && (rotator.instanceID != "instance" || rotator.bindingID != "binding" || rotator.gracePeriod != tc.ExpectedGracePeriod) {
+				t.Errorf("Expected instance, binding and grace period %s, got %q, %q and %s", tc.ExpectedGracePeriod, rotator.instanceID, rotator.bindingID, rotator.gracePeriod)
+			}
+		})
+	}
+
+	t.Run("without rotator", func(t *testing.T) {
+		setupAdminDatabase(t)
+		router := mux.NewRouter()
+		AddAdminHandler(router, broker.BrokerRegistry{}, nil, "admin", "secret", lager.NewLogger("test"))
+
+		req := httptest.NewRequest(http.MethodPost, "/admin/bindings/binding/rotate-credentials", nil)
+		req.SetBasicAuth("admin", "secret")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code == http.StatusOK {
+			t.Errorf("Expected the route not to exist, got %d: %s", w.Code, w.Body.String())
+		}
+	})
+}
+
+type fakeCredentialRotator struct {
+	err error
+
+	called      bool
+	instanceID  string
+	bindingID   string
+	gracePeriod time.Duration
+}
+
+func (f *fakeCredentialRotator) RotateBindingCredentials(ctx context.Context, instanceID, bindingID string, gracePeriod time.Duration) error {
+	f.called = true
+	f.instanceID, f.bindingID, f.gracePeriod = instanceID, bindingID, gracePeriod
+	return f.err
+}
+
+// setupAdminDatabase creates an instance with a binding, and a deployment with
+// an operation in progress, in a new in-memory database.
+func setupAdminDatabase(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("couldn't create database: %v", err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("couldn't get database connection: %v", err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	if err := db.Migrator().CreateTable(
+		models.ServiceInstanceDetails{},
+		models.ServiceBindingCredentials{},
+		models.ProvisionRequestDetails{},
+		models.TerraformDeployment{},
+		models.TerraformJob{},
+		models.TerraformDeploymentLock{},
+	); err != nil {
+		t.Fatalf("couldn't create tables: %v", err)
+	}
+	db_service.DbConnection = db
+	models.SetEncryptor(noopencryptor.New())
+
+	ctx := context.Background()
+	instance := models.ServiceInstanceDetails{ID: "instance", OperationType: models.UpdateOperationType, OperationId: "tf:instance:"}
+	if err := instance.SetOtherDetails(map[string]string{"password": "hunter2"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db_service.CreateServiceInstanceDetails(ctx, &instance); err != nil {
+		t.Fatal(err)
+	}
+
+	binding := models.ServiceBindingCredentials{ServiceInstanceId: "instance", BindingId: "binding"}
+	if err := binding.SetOtherDetails(map[string]string{"password": "hunter2"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db_service.CreateServiceBindingCredentials(ctx, &binding); err != nil {
+		t.Fatal(err)
+	}
+
+	deployment := models.TerraformDeployment{
+		ID:                 "tf:instance:",
+		LastOperationType:  models.UpdateOperationType,
+		LastOperationState: tf.InProgress,
+		DriftStatus:        tf.DriftDetected,
+	}
+	if err := deployment.SetWorkspace(`{"tfstate":"secret"}`); err != nil {
+		t.Fatal(err)
+	}
+	if err := db_service.CreateTerraformDeployment(ctx, &deployment); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,186 @@
+// Copyright 2021 the Service Broker Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/db_service/models"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/pkg/audit"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/pkg/broker"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/utils/correlation"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/utils/request"
+	"github.com/pivotal-cf/brokerapi/v8/domain"
+	"github.com/pivotal-cf/brokerapi/v8/middlewares"
+)
+
+// AuditWrapper records every state-changing request to the wrapped
+// ServiceBroker in the audit log.
+type AuditWrapper struct {
+	domain.ServiceBroker
+	registry broker.BrokerRegistry
+	sinks    []audit.Sink
+	logger   lager.Logger
+}
+
+// NewAuditWrapper wraps the given servicebroker so that the provision, update,
+// deprovision, bind and unbind requests it handles are recorded in each of the
+// sinks. Parameters are looked up in the registry so that sensitive fields can
+// be redacted.
+func NewAuditWrapper(wrapped domain.ServiceBroker, registry broker.BrokerRegistry, logger lager.Logger, sinks ...audit.Sink) domain.ServiceBroker {
+	return &AuditWrapper{ServiceBroker: wrapped, registry: registry, sinks: sinks, logger: logger.Session("audit")}
+}
+
+func (w *AuditWrapper) Provision(ctx context.Context, instanceID string, details domain.ProvisionDetails, asyncAllowed bool) (domain.ProvisionedServiceSpec, error) {
+	event := w.newEvent(ctx, models.ProvisionOperationType, instanceID, "", details.ServiceID, details.PlanID)
+	event.Parameters = w.redactParameters(details.ServiceID, details.RawParameters, provisionInputs)
+
+	spec, err := w.ServiceBroker.Provision(ctx, instanceID, details, asyncAllowed)
+	w.record(ctx, event, spec.IsAsync, err)
+	return spec, err
+}
+
+func (w *AuditWrapper) Update(ctx context.Context, instanceID string, details domain.UpdateDetails, asyncAllowed bool) (domain.UpdateServiceSpec, error) {
+	event := w.newEvent(ctx, models.UpdateOperationType, instanceID, "", details.ServiceID, details.PlanID)
+	event.Parameters = w.redactParameters(details.ServiceID, details.RawParameters, provisionInputs)
+
+	spec, err := w.ServiceBroker.Update(ctx, instanceID, details, asyncAllowed)
+	w.record(ctx, event, spec.IsAsync, err)
+	return spec, err
+}
+
+func (w *AuditWrapper) Deprovision(ctx context.Context, instanceID string, details domain.DeprovisionDetails, asyncAllowed bool) (domain.DeprovisionServiceSpec, error) {
+	event := w.newEvent(ctx, models.DeprovisionOperationType, instanceID, "", details.ServiceID, details.PlanID)
+
+	spec, err := w.ServiceBroker.Deprovision(ctx, instanceID, details, asyncAllowed)
+	w.record(ctx, event, spec.IsAsync, err)
+	return spec, err
+}
+
+func (w *AuditWrapper) Bind(ctx context.Context, instanceID, bindingID string, details domain.BindDetails, asyncAllowed bool) (domain.Binding, error) {
+	event := w.newEvent(ctx, models.BindOperationType, instanceID, bindingID, details.ServiceID, details.PlanID)
+	event.Parameters = w.redactParameters(details.ServiceID, details.RawParameters, bindInputs)
+
+	binding, err := w.ServiceBroker.Bind(ctx, instanceID, bindingID, details, asyncAllowed)
+	w.record(ctx, event, binding.IsAsync, err)
+	return binding, err
+}
+
+func (w *AuditWrapper) Unbind(ctx context.Context, instanceID, bindingID string, details domain.UnbindDetails, asyncAllowed bool) (domain.UnbindSpec, error) {
+	event := w.newEvent(ctx, models.UnbindOperationType, instanceID, bindingID, details.ServiceID, details.PlanID)
+
+	spec, err := w.ServiceBroker.Unbind(ctx, instanceID, bindingID, details, asyncAllowed)
+	w.record(ctx, event, spec.IsAsync, err)
+	return spec, err
+}
+
+func (w *AuditWrapper) newEvent(ctx context.Context, operation, instanceID, bindingID, serviceID, planID string) models.AuditEvent {
+	event := models.AuditEvent{
+		Operation:         operation,
+		ServiceInstanceId: instanceID,
+		BindingId:         bindingID,
+		ServiceId:         serviceID,
+		PlanId:            planID,
+		StartedAt:         time.Now(),
+	}
+
+	event.RequestId, _ = ctx.Value(middlewares.RequestIdentityKey).(string)
+	event.CorrelationId, _ = ctx.Value(middlewares.CorrelationIDKey).(string)
+	if identity := request.DecodeOriginatingIdentityHeader(ctx); identity != nil {
+		if out, err := json.Marshal(identity); err == nil {
+			event.OriginatingIdentity = string(out)
+		}
+	}
+
+	return event
+}
+
+// record completes the event with the outcome of the request and writes it to
+// the sinks. Failing to record an event does not fail the request, which has
+// already been carried out.
+func (w *AuditWrapper) record(ctx context.Context, event models.AuditEvent, isAsync bool, err error) {
+	event.FinishedAt = time.Now()
+	switch {
+	case err != nil:
+		event.Outcome = audit.Failed
+		event.Message = err.Error()
+	case isAsync:
+		event.Outcome = audit.Accepted
+	default:
+		event.Outcome = audit.Succeeded
+	}
+
+	// the event is recorded even if the request has been cancelled
+	ctx = correlation.Background(ctx)
+	for _, sink := range w.sinks {
+		if err := sink.Record(ctx, event); err != nil {
+			w.logger.Error("recording-audit-event", err, correlation.ID(ctx), lager.Data{
+				"operation":   event.Operation,
+				"instance_id": event.ServiceInstanceId,
+				"binding_id":  event.BindingId,
+			})
+		}
+	}
+}
+
+func provisionInputs(svc *broker.ServiceDefinition) []broker.BrokerVariable {
+	return svc.ProvisionInputVariables
+}
+
+func bindInputs(svc *broker.ServiceDefinition) []broker.BrokerVariable {
+	return svc.BindInputVariables
+}
+
+// redactParameters returns the parameters of a request as JSON, with only the
+// fields that the service declares as inputs, and those that it marks as
+// sensitive replaced. Other fields are dropped, as it is not known whether
+// they hold secrets. Parameters that cannot be redacted, because the service
+// is not known or they are not a JSON object, are not recorded.
+func (w *AuditWrapper) redactParameters(serviceID string, parameters json.RawMessage, inputs func(*broker.ServiceDefinition) []broker.BrokerVariable) string {
+	if len(parameters) == 0 {
+		return ""
+	}
+
+	svc, err := w.registry.GetServiceById(serviceID)
+	if err != nil {
+		return ""
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal(parameters, &fields); err != nil {
+		return ""
+	}
+
+	declared := make(map[string]interface{})
+	for _, input := range inputs(svc) {
+		value, ok := fields[input.FieldName]
+		switch {
+		case !ok:
+		case input.Sensitive:
+			declared[input.FieldName] = redacted
+		default:
+			declared[input.FieldName] = value
+		}
+	}
+
+	out, err := json.Marshal(declared)
+	if err != nil {
+		return ""
+	}
+	return string(out)
+}
@@ -0,0 +1,192 @@
+// Copyright 2021 the Service Broker Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/pivotal-cf/brokerapi/v8/domain"
+	"github.com/pivotal-cf/brokerapi/v8/middlewares"
+
+	"github.com/cloudfoundry-incubator/cloud-service-broker/db_service/models"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/pkg/broker"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/pkg/server/fakes"
+)
+
+type recordingSink struct {
+	events []models.AuditEvent
+}
+
+func (s *recordingSink) Record(_ context.Context, event models.AuditEvent) error {
+	s.events = append(s.events, event)
+	return nil
+}
+
+func TestAuditWrapper(t *testing.T) {
+	registry := broker.BrokerRegistry{
+		"audited": &broker.ServiceDefinition{
+			Id: "service",
+			ProvisionInputVariables: []broker.BrokerVariable{
+				{FieldName: "name"},
+				{FieldName: "password", Sensitive: true},
+			},
+			BindInputVariables: []broker.BrokerVariable{
+				{FieldName: "role"},
+			},
+		},
+	}
+
+	identity := base64.StdEncoding.EncodeToString([]byte(`{"user_id":"user-guid"}`))
+	ctx := context.WithValue(context.Background(), middlewares.OriginatingIdentityKey, "cloudfoundry "+identity)
+	ctx = context.WithValue(ctx, middlewares.RequestIdentityKey, "request-id")
+	ctx = context.WithValue(ctx, middlewares.CorrelationIDKey, "correlation-id")
+
+	cases := map[string]struct {
+		Call     func(broker domain.ServiceBroker) error
+		Setup    func(fake *fakes.FakeServiceBroker)
+		Expected models.AuditEvent
+	}{
+		"provision": {
+			Call: func(b domain.ServiceBroker) error {
+				_, err := b.Provision(ctx, "instance", domain.ProvisionDetails{ServiceID: "service", PlanID: "plan", RawParameters: json.RawMessage(`{"name":"db","password":"hunter2"}`)}, true)
+				return err
+			},
+			Setup: func(fake *fakes.FakeServiceBroker) {
+				fake.ProvisionReturns(domain.ProvisionedServiceSpec{IsAsync: true}, nil)
+			},
+			Expected: models.AuditEvent{
+				Operation:         "provision",
+				ServiceInstanceId: "instance",
+				ServiceId:         "service",
+				PlanId:            "plan",
+				Parameters:        `{"name":"db","password":"[REDACTED]"}`,
+				Outcome:           "accepted",
+			},
+		},
+		"update failed": {
+			Call: func(b domain.ServiceBroker) error {
+				_, err := b.Update(ctx, "instance", domain.UpdateDetails{ServiceID: "service", PlanID: "plan", RawParameters: json.RawMessage(`{"password":"hunter2"}`)}, true)
+				return err
+			},
+			Setup: func(fake *fakes.FakeServiceBroker) {
+				fake.UpdateReturns(domain.UpdateServiceSpec{}, errors.New("update failed"))
+			},
+			Expected: models.AuditEvent{
+				Operation:         "update",
+				ServiceInstanceId: "instance",
+				ServiceId:         "service",
+				PlanId:            "plan",
+				Parameters:        `{"password":"[REDACTED]"}`,
+				Outcome:           "failed",
+				Message:           "update failed",
+			},
+		},
+		"bind": {
+			Call: func(b domain.ServiceBroker) error {
+				_, err := b.Bind(ctx, "instance", "binding", domain.BindDetails{ServiceID: "service", PlanID: "plan", RawParameters: json.RawMessage(`{"role":"admin","password":"not-a-bind-input"}`)}, false)
+				return err
+			},
+			Expected: models.AuditEvent{
+				Operation:         "bind",
+				ServiceInstanceId: "instance",
+				BindingId:         "binding",
+				ServiceId:         "service",
+				PlanId:            "plan",
+				Parameters:        `{"role":"admin"}`,
+				Outcome:           "succeeded",
+			},
+		},
+		"undeclared parameters": {
+			Call: func(b domain.ServiceBroker) error {
+				_, err := b.Provision(ctx, "instance", domain.ProvisionDetails{ServiceID: "service", PlanID: "plan", RawParameters: json.RawMessage(`{"name":"db","api_key":"secret"}`)}, true)
+				return err
+			},
+			Setup: func(fake *fakes.FakeServiceBroker) {
+				fake.ProvisionReturns(domain.ProvisionedServiceSpec{IsAsync: true}, nil)
+			},
+			Expected: models.AuditEvent{
+				Operation:         "provision",
+				ServiceInstanceId: "instance",
+				ServiceId:         "service",
+				PlanId:            "plan",
+				Parameters:        `{"name":"db"}`,
+				Outcome:           "accepted",
+			},
+		},
+		"unknown service": {
+			Call: func(b domain.ServiceBroker) error {
+				_, err := b.Provision(ctx, "instance", domain.ProvisionDetails{ServiceID: "unknown", PlanID: "plan", RawParameters: json.RawMessage(`{"password":"hunter2"}`)}, true)
+				return err
+			},
+			Expected: models.AuditEvent{
+				Operation:         "provision",
+				ServiceInstanceId: "instance",
+				ServiceId:         "unknown",
+				PlanId:            "plan",
+				Outcome:           "succeeded",
+			},
+		},
+		"unbind": {
+			Call: func(b domain.ServiceBroker) error {
+				_, err := b.Unbind(ctx, "instance", "binding", domain.UnbindDetails{ServiceID: "service", PlanID: "plan"}, true)
+				return err
+			},
+			Expected: models.AuditEvent{
+				Operation:         "unbind",
+				ServiceInstanceId: "instance",
+				BindingId:         "binding",
+				ServiceId:         "service",
+				PlanId:            "plan",
+				Outcome:           "succeeded",
+			},
+		},
+	}
+
+	for tn, tc := range cases {
+		t.Run(tn, func(t *testing.T) {
+			fakeBroker := &fakes.FakeServiceBroker{}
+			if tc.Setup != nil {
+				tc.Setup(fakeBroker)
+			}
+			sink := &recordingSink{}
+			wrapper := NewAuditWrapper(fakeBroker, registry, lager.NewLogger("test"), sink)
+
+			tc.Call(wrapper)
+
+			if len(sink.events) != 1 {
+				t.Fatalf("Expected 1 event to be recorded, got %d", len(sink.events))
+			}
+			actual := sink.events[0]
+			if actual.StartedAt.IsZero() || actual.FinishedAt.Before(actual.StartedAt) {
+				t.Errorf("Expected the event to be timed, got %v to %v", actual.StartedAt, actual.FinishedAt)
+			}
+			actual.StartedAt, actual.FinishedAt = tc.Expected.StartedAt, tc.Expected.FinishedAt
+
+			expected := tc.Expected
+			expected.RequestId = "request-id"
+			expected.CorrelationId = "correlation-id"
+			expected.OriginatingIdentity = `{"platform":"cloudfoundry","value":{"user_id":"user-guid"}}`
+			if !reflect.DeepEqual(actual, expected) {
+				t.Errorf("Expected event %#v, got %#v", expected, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright 2021 the Service Broker Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/cloudfoundry-incubator/cloud-service-broker/pkg/metrics"
+	"github.com/gorilla/mux"
+	"github.com/pivotal-cf/brokerapi/v8/domain"
+)
+
+// AddMetricsHandler serves the Prometheus metrics of the broker on the
+// /metrics endpoint.
+func AddMetricsHandler(router *mux.Router) {
+	router.Handle("/metrics", metrics.Handler())
+}
+
+// MetricsWrapper records metrics for every request to the wrapped ServiceBroker.
+type MetricsWrapper struct {
+	domain.ServiceBroker
+}
+
+// NewMetricsWrapper wraps the given servicebroker so the requests it handles
+// are counted and timed.
+func NewMetricsWrapper(wrapped domain.ServiceBroker) domain.ServiceBroker {
+	return &MetricsWrapper{ServiceBroker: wrapped}
+}
+
+func (w *MetricsWrapper) Services(ctx context.Context) (services []domain.Service, err error) {
+	defer observe("catalog", "", "", time.Now())(&err)
+	return w.ServiceBroker.Services(ctx)
+}
+
+func (w *MetricsWrapper) Provision(ctx context.Context, instanceID string, details domain.ProvisionDetails, asyncAllowed bool) (spec domain.ProvisionedServiceSpec, err error) {
+	defer observe("provision", details.ServiceID, details.PlanID, time.Now())(&err)
+	return w.ServiceBroker.Provision(ctx, instanceID, details, asyncAllowed)
+}
+
+func (w *MetricsWrapper) Deprovision(ctx context.Context, instanceID string, details domain.DeprovisionDetails, asyncAllowed bool) (spec domain.DeprovisionServiceSpec, err error) {
+	defer observe("deprovision", details.ServiceID, details.PlanID, time.Now())(&err)
+	return w.ServiceBroker.Deprovision(ctx, instanceID, details, asyncAllowed)
+}
+
+func (w *MetricsWrapper) GetInstance(ctx context.Context, instanceID string, details domain.FetchInstanceDetails) (spec domain.GetInstanceDetailsSpec, err error) {
+	defer observe("get_instance", details.ServiceID, details.PlanID, time.Now())(&err)
+	return w.ServiceBroker.GetInstance(ctx, instanceID, details)
+}
+
+func (w *MetricsWrapper) Update(ctx context.Context, instanceID string, details domain.UpdateDetails, asyncAllowed bool) (spec domain.UpdateServiceSpec, err error) {
+	defer observe("update", details.ServiceID, details.PlanID, time.Now())(&err)
+	return w.ServiceBroker.Update(ctx, instanceID, details, asyncAllowed)
+}
+
+func (w *MetricsWrapper) LastOperation(ctx context.Context, instanceID string, details domain.PollDetails) (op domain.LastOperation, err error) {
+	defer observe("last_operation", details.ServiceID, details.PlanID, time.Now())(&err)
+	return w.ServiceBroker.LastOperation(ctx, instanceID, details)
+}
+
+func (w *MetricsWrapper) Bind(ctx context.Context, instanceID, bindingID string, details domain.BindDetails, asyncAllowed bool) (binding domain.Binding, err error) {
+	defer observe("bind", details.ServiceID, details.PlanID, time.Now())(&err)
+	return w.ServiceBroker.Bind(ctx, instanceID, bindingID, details, asyncAllowed)
+}
+
+func (w *MetricsWrapper) Unbind(ctx context.Context, instanceID, bindingID string, details domain.UnbindDetails, asyncAllowed bool) (spec domain.UnbindSpec, err error) {
+	defer observe("unbind", details.ServiceID, details.PlanID, time.Now())(&err)
+	return w.ServiceBroker.Unbind(ctx, instanceID, bindingID, details, asyncAllowed)
+}
+
+func (w *MetricsWrapper) GetBinding(ctx context.Context, instanceID, bindingID string, details domain.FetchBindingDetails) (spec domain.GetBindingSpec, err error) {
+	defer observe("get_binding", details.ServiceID, details.PlanID, time.Now())(&err)
+	return w.ServiceBroker.GetBinding(ctx, instanceID, bindingID, details)
+}
+
+func (w *MetricsWrapper) LastBindingOperation(ctx context.Context, instanceID, bindingID string, details domain.PollDetails) (op domain.LastOperation, err error) {
+	defer observe("last_binding_operation", details.ServiceID, details.PlanID, time.Now())(&err)
+	return w.ServiceBroker.LastBindingOperation(ctx, instanceID, bindingID, details)
+}
+
+// observe returns a function that records a request which started at the
+// given time, with the outcome of the error it points to.
+func observe(verb, serviceID, planID string, start time.Time) func(err *error) {
+	return func(err *error) {
+		metrics.OSBRequests.WithLabelValues(verb, serviceID, planID, metrics.Outcome(*err)).Inc()
+		metrics.OSBRequestDuration.WithLabelValues(verb, serviceID, planID).Observe(metrics.Since(start))
+	}
+}
@@ -0,0 +1,64 @@
+// Copyright 2021 the Service Broker Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/pivotal-cf/brokerapi/v8/domain"
+
+	"github.com/cloudfoundry-incubator/cloud-service-broker/pkg/server/fakes"
+)
+
+func TestMetricsWrapper(t *testing.T) {
+	fakeBroker := &fakes.FakeServiceBroker{}
+	fakeBroker.BindReturns(domain.Binding{}, errors.New("bind failed"))
+	wrapper := NewMetricsWrapper(fakeBroker)
+	ctx := context.Background()
+
+	if _, err := wrapper.Provision(ctx, "instance", domain.ProvisionDetails{ServiceID: "metrics-service", PlanID: "metrics-plan"}, true); err != nil {
+		t.Fatalf("Expected provision to succeed, got %v", err)
+	}
+	if _, err := wrapper.Bind(ctx, "instance", "binding", domain.BindDetails{ServiceID: "metrics-service", PlanID: "metrics-plan"}, true); err == nil {
+		t.Fatal("Expected the error from the wrapped broker to be returned")
+	}
+	if fakeBroker.ProvisionCallCount() != 1 || fakeBroker.BindCallCount() != 1 {
+		t.Errorf("Expected calls to be passed to the wrapped broker, got %d provision and %d bind", fakeBroker.ProvisionCallCount(), fakeBroker.BindCallCount())
+	}
+
+	router := mux.NewRouter()
+	AddMetricsHandler(router)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	for _, expected := range []string{
+		`csb_osb_requests_total{outcome="succeeded",plan_id="metrics-plan",service_id="metrics-service",verb="provision"} 1`,
+		`csb_osb_requests_total{outcome="failed",plan_id="metrics-plan",service_id="metrics-service",verb="bind"} 1`,
+		`csb_osb_request_duration_seconds_count{plan_id="metrics-plan",service_id="metrics-service",verb="provision"} 1`,
+	} {
+		if !strings.Contains(w.Body.String(), expected) {
+			t.Errorf("Expected metrics to contain %q, got %s", expected, w.Body.String())
+		}
+	}
+}
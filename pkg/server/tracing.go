@@ -0,0 +1,109 @@
+// Copyright 2021 the Service Broker Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+
+	"github.com/cloudfoundry-incubator/cloud-service-broker/pkg/tracing"
+	"github.com/pivotal-cf/brokerapi/v8/domain"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TracingWrapper records a span for every request to the wrapped ServiceBroker.
+type TracingWrapper struct {
+	domain.ServiceBroker
+}
+
+// NewTracingWrapper wraps the given servicebroker so the requests it handles
+// are traced, along with the work done for them.
+func NewTracingWrapper(wrapped domain.ServiceBroker) domain.ServiceBroker {
+	return &TracingWrapper{ServiceBroker: wrapped}
+}
+
+func (w *TracingWrapper) Services(ctx context.Context) (services []domain.Service, err error) {
+	ctx, span := tracing.Start(ctx, "osb.catalog")
+	defer func() { tracing.End(span, err) }()
+	return w.ServiceBroker.Services(ctx)
+}
+
+func (w *TracingWrapper) Provision(ctx context.Context, instanceID string, details domain.ProvisionDetails, asyncAllowed bool) (spec domain.ProvisionedServiceSpec, err error) {
+	ctx, span := startOSBSpan(ctx, "provision", instanceID, "", details.ServiceID, details.PlanID)
+	defer func() { tracing.End(span, err) }()
+	return w.ServiceBroker.Provision(ctx, instanceID, details, asyncAllowed)
+}
+
+func (w *TracingWrapper) Deprovision(ctx context.Context, instanceID string, details domain.DeprovisionDetails, asyncAllowed bool) (spec domain.DeprovisionServiceSpec, err error) {
+	ctx, span := startOSBSpan(ctx, "deprovision", instanceID, "", details.ServiceID, details.PlanID)
+	defer func() { tracing.End(span, err) }()
+	return w.ServiceBroker.Deprovision(ctx, instanceID, details, asyncAllowed)
+}
+
+func (w *TracingWrapper) GetInstance(ctx context.Context, instanceID string, details domain.FetchInstanceDetails) (spec domain.GetInstanceDetailsSpec, err error) {
+	ctx, span := startOSBSpan(ctx, "get_instance", instanceID, "", details.ServiceID, details.PlanID)
+	defer func() { tracing.End(span, err) }()
+	return w.ServiceBroker.GetInstance(ctx, instanceID, details)
+}
+
+func (w *TracingWrapper) Update(ctx context.Context, instanceID string, details domain.UpdateDetails, asyncAllowed bool) (spec domain.UpdateServiceSpec, err error) {
+	ctx, span := startOSBSpan(ctx, "update", instanceID, "", details.ServiceID, details.PlanID)
+	defer func() { tracing.End(span, err) }()
+	return w.ServiceBroker.Update(ctx, instanceID, details, asyncAllowed)
+}
+
+func (w *TracingWrapper) LastOperation(ctx context.Context, instanceID string, details domain.PollDetails) (op domain.LastOperation, err error) {
+	ctx, span := startOSBSpan(ctx, "last_operation", instanceID, "", details.ServiceID, details.PlanID)
+	defer func() { tracing.End(span, err) }()
+	return w.ServiceBroker.LastOperation(ctx, instanceID, details)
+}
+
+func (w *TracingWrapper) Bind(ctx context.Context, instanceID, bindingID string, details domain.BindDetails, asyncAllowed bool) (binding domain.Binding, err error) {
+	ctx, span := startOSBSpan(ctx, "bind", instanceID, bindingID, details.ServiceID, details.PlanID)
+	defer func() { tracing.End(span, err) }()
+	return w.ServiceBroker.Bind(ctx, instanceID, bindingID, details, asyncAllowed)
+}
+
+func (w *TracingWrapper) Unbind(ctx context.Context, instanceID, bindingID string, details domain.UnbindDetails, asyncAllowed bool) (spec domain.UnbindSpec, err error) {
+	ctx, span := startOSBSpan(ctx, "unbind", instanceID, bindingID, details.ServiceID, details.PlanID)
+	defer func() { tracing.End(span, err) }()
+	return w.ServiceBroker.Unbind(ctx, instanceID, bindingID, details, asyncAllowed)
+}
+
+func (w *TracingWrapper) GetBinding(ctx context.Context, instanceID, bindingID string, details domain.FetchBindingDetails) (spec domain.GetBindingSpec, err error) {
+	ctx, span := startOSBSpan(ctx, "get_binding", instanceID, bindingID, details.ServiceID, details.PlanID)
+	defer func() { tracing.End(span, err) }()
+	return w.ServiceBroker.GetBinding(ctx, instanceID, bindingID, details)
+}
+
+func (w *TracingWrapper) LastBindingOperation(ctx context.Context, instanceID, bindingID string, details domain.PollDetails) (op domain.LastOperation, err error) {
+	ctx, span := startOSBSpan(ctx, "last_binding_operation", instanceID, bindingID, details.ServiceID, details.PlanID)
+	defer func() { tracing.End(span, err) }()
+	return w.ServiceBroker.LastBindingOperation(ctx, instanceID, bindingID, details)
+}
+
+// startOSBSpan starts a span for the request, with the IDs it was made with.
+func startOSBSpan(ctx context.Context, verb, instanceID, bindingID, serviceID, planID string) (context.Context, trace.Span) {
+	attributes := []attribute.KeyValue{
+		attribute.String("osb.instance_id", instanceID),
+		attribute.String("osb.service_id", serviceID),
+		attribute.String("osb.plan_id", planID),
+	}
+	if bindingID != "" {
+		attributes = append(attributes, attribute.String("osb.binding_id", bindingID))
+	}
+
+	return tracing.Start(ctx, "osb."+verb, attributes...)
+}
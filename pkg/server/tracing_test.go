@@ -0,0 +1,83 @@
+// Copyright 2021 the Service Broker Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/pivotal-cf/brokerapi/v8/domain"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/cloudfoundry-incubator/cloud-service-broker/pkg/server/fakes"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/pkg/tracing"
+)
+
+func TestTracingWrapper(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(tracing.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	fakeBroker := &fakes.FakeServiceBroker{}
+	fakeBroker.BindReturns(domain.Binding{}, errors.New("bind failed"))
+	wrapper := NewTracingWrapper(fakeBroker)
+	ctx := context.Background()
+
+	if _, err := wrapper.Provision(ctx, "instance", domain.ProvisionDetails{ServiceID: "service", PlanID: "plan"}, true); err != nil {
+		t.Fatalf("Expected provision to succeed, got %v", err)
+	}
+	if _, err := wrapper.Bind(ctx, "instance", "binding", domain.BindDetails{ServiceID: "service", PlanID: "plan"}, true); err == nil {
+		t.Fatal("Expected the error from the wrapped broker to be returned")
+	}
+
+	// the wrapped broker is passed the context of the span
+	provisionCtx, _, _, _ := fakeBroker.ProvisionArgsForCall(0)
+	if !trace.SpanContextFromContext(provisionCtx).IsValid() {
+		t.Error("Expected the wrapped broker to be called with the span in the context")
+	}
+
+	ended := recorder.Ended()
+	if len(ended) != 2 {
+		t.Fatalf("Expected 2 spans, got %d", len(ended))
+	}
+
+	provision, bind := ended[0], ended[1]
+	if provision.Name() != "osb.provision" || provision.Status().Code == codes.Error {
+		t.Errorf("Expected a successful osb.provision span, got %s with status %v", provision.Name(), provision.Status())
+	}
+	if bind.Name() != "osb.bind" || bind.Status().Code != codes.Error {
+		t.Errorf("Expected a failed osb.bind span, got %s with status %v", bind.Name(), bind.Status())
+	}
+
+	attributes := map[string]string{}
+	for _, kv := range bind.Attributes() {
+		attributes[string(kv.Key)] = kv.Value.AsString()
+	}
+	expected := map[string]string{
+		"osb.instance_id": "instance",
+		"osb.binding_id":  "binding",
+		"osb.service_id":  "service",
+		"osb.plan_id":     "plan",
+	}
+	for k, v := range expected {
+		if attributes[k] != v {
+			t.Errorf("Expected attribute %s to be %q, got %q", k, v, attributes[k])
+		}
+	}
+}
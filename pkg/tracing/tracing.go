@@ -0,0 +1,149 @@
+// Copyright 2021 the Service Broker Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package tracing records OpenTelemetry traces of the requests the broker
+// handles and the work it does for them.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"net/http"
+
+	"code.cloudfoundry.org/lager"
+	"github.com/cloudfoundry-incubator/cloud-service-broker/utils"
+	"github.com/pborman/uuid"
+	"github.com/pivotal-cf/brokerapi/v8/middlewares"
+	"github.com/spf13/viper"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	exporterKey = "tracing.exporter"
+
+	// ExporterOTLP sends traces to an OpenTelemetry collector over HTTP, as
+	// configured by the standard OTEL_EXPORTER_OTLP_* environment variables.
+	ExporterOTLP = "otlp"
+	// ExporterStdout writes traces to stdout, for local testing.
+	ExporterStdout = "stdout"
+
+	tracerName = "github.com/cloudfoundry-incubator/cloud-service-broker"
+)
+
+func init() {
+	viper.BindEnv(exporterKey, "TRACING_EXPORTER")
+}
+
+// Setup configures where traces are exported to. Traces are not recorded if
+// no exporter is configured.
+func Setup(ctx context.Context, logger lager.Logger) error {
+	var exporter sdktrace.SpanExporter
+	var err error
+	name := viper.GetString(exporterKey)
+	switch name {
+	case "":
+		logger.Info("tracing disabled, set TRACING_EXPORTER to enable it")
+		return nil
+	case ExporterOTLP:
+		exporter, err = otlptracehttp.New(ctx)
+	case ExporterStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithPrettyPrint())
+	default:
+		return fmt.Errorf("invalid tracing exporter %q, valid exporters are: %s and %s", name, ExporterOTLP, ExporterStdout)
+	}
+	if err != nil {
+		return fmt.Errorf("error creating tracing exporter: %w", err)
+	}
+
+	otel.SetTracerProvider(NewTracerProvider(sdktrace.WithBatcher(exporter)))
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	logger.Info("tracing enabled", lager.Data{"exporter": name})
+	return nil
+}
+
+// NewTracerProvider creates a provider for the traces of the broker, which
+// are identified by the request identity of the platform where possible.
+func NewTracerProvider(opts ...sdktrace.TracerProviderOption) *sdktrace.TracerProvider {
+	opts = append([]sdktrace.TracerProviderOption{
+		sdktrace.WithIDGenerator(requestIdentityIDGenerator{}),
+		sdktrace.WithResource(resource.NewWithAttributes(
+			semconv.SchemaURL,
+			semconv.ServiceNameKey.String("cloud-service-broker"),
+			semconv.ServiceVersionKey.String(utils.Version),
+		)),
+	}, opts...)
+
+	return sdktrace.NewTracerProvider(opts...)
+}
+
+// Start starts a span that is a child of any span in the context.
+func Start(ctx context.Context, name string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attributes...))
+}
+
+// End ends the span, recording the error if there was one.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Propagate continues the traces of callers that send a traceparent header,
+// so that spans started while handling a request are part of them.
+func Propagate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// requestIdentityIDGenerator uses the X-Broker-API-Request-Identity of the
+// request as the ID of traces that start with it, if the platform sent a
+// UUID, so that a trace can be found from the logs of the platform.
+type requestIdentityIDGenerator struct{}
+
+func (requestIdentityIDGenerator) NewIDs(ctx context.Context) (trace.TraceID, trace.SpanID) {
+	var traceID trace.TraceID
+	if rid, ok := ctx.Value(middlewares.RequestIdentityKey).(string); ok {
+		if id := uuid.Parse(rid); id != nil {
+			copy(traceID[:], id)
+			return traceID, newSpanID()
+		}
+	}
+
+	rand.Read(traceID[:])
+	return traceID, newSpanID()
+}
+
+func (requestIdentityIDGenerator) NewSpanID(ctx context.Context, traceID trace.TraceID) trace.SpanID {
+	return newSpanID()
+}
+
+func newSpanID() trace.SpanID {
+	var spanID trace.SpanID
+	rand.Read(spanID[:])
+	return spanID
+}
@@ -0,0 +1,98 @@
+// Copyright 2021 the Service Broker Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pivotal-cf/brokerapi/v8/middlewares"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestStart(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	cases := map[string]struct {
+		RequestIdentity string
+		ExpectedTraceID string
+	}{
+		"request identity is a UUID": {
+			RequestIdentity: "6aa85874-9d04-11eb-a03b-73ee7bd59e49",
+			ExpectedTraceID: "6aa858749d0411eba03b73ee7bd59e49",
+		},
+		"request identity is not a UUID": {
+			RequestIdentity: "request-1",
+		},
+		"no request identity": {},
+	}
+
+	for tn, tc := range cases {
+		t.Run(tn, func(t *testing.T) {
+			ctx := context.Background()
+			if tc.RequestIdentity != "" {
+				ctx = context.WithValue(ctx, middlewares.RequestIdentityKey, tc.RequestIdentity)
+			}
+
+			_, span := Start(ctx, "test")
+			End(span, nil)
+
+			traceID := span.SpanContext().TraceID()
+			if !traceID.IsValid() {
+				t.Fatalf("Expected a valid trace ID, got %s", traceID)
+			}
+			if tc.ExpectedTraceID != "" && traceID.String() != tc.ExpectedTraceID {
+				t.Errorf("Expected trace ID %s, got %s", tc.ExpectedTraceID, traceID)
+			}
+		})
+	}
+
+	t.Run("error", func(t *testing.T) {
+		_, span := Start(context.Background(), "failing")
+		End(span, errors.New("boom"))
+
+		ended := recorder.Ended()
+		last := ended[len(ended)-1]
+		if last.Name() != "failing" || last.Status().Code != codes.Error || last.Status().Description != "boom" {
+			t.Errorf("Expected the span to record the error, got %q with status %v", last.Name(), last.Status())
+		}
+	})
+}
+
+func TestPropagate(t *testing.T) {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	var got trace.SpanContext
+	handler := Propagate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = trace.SpanContextFromContext(r.Context())
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/v2/catalog", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if got.TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" || !got.IsRemote() {
+		t.Errorf("Expected the remote trace to be continued, got %v", got)
+	}
+}
@@ -28,6 +28,8 @@ var (
 	terraformIdentifierRegex = regexp.MustCompile(`^[a-z_]*$`)
 	jsonSchemaTypeRegex      = regexp.MustCompile(`^(|object|boolean|array|number|string|integer)$`)
 	uuidRegex                = regexp.MustCompile(`^[0-9a-fA-F]{8}\-[0-9a-fA-F]{4}\-[0-9a-fA-F]{4}\-[0-9a-fA-F]{4}\-[0-9a-fA-F]{12}$`)
+	// from https://semver.org
+	semverRegex = regexp.MustCompile(`^(0|[1-9]\d*)\.(0|[1-9]\d*)\.(0|[1-9]\d*)(?:-((?:0|[1-9]\d*|\d*[a-zA-Z-][0-9a-zA-Z-]*)(?:\.(?:0|[1-9]\d*|\d*[a-zA-Z-][0-9a-zA-Z-]*))*))?(?:\+([0-9a-zA-Z-]+(?:\.[0-9a-zA-Z-]+)*))?$`)
 )
 
 // ErrIfNotHCL returns an error if the value is not valid HCL.
@@ -102,6 +104,18 @@ func ErrIfNotUUID(value, field string) *FieldError {
 	}
 }
 
+// ErrIfNotSemver returns an error if the value is not a semantic version.
+func ErrIfNotSemver(value, field string) *FieldError {
+	if semverRegex.MatchString(value) {
+		return nil
+	}
+
+	return &FieldError{
+		Message: "field must be a semantic version",
+		Paths:   []string{field},
+	}
+}
+
 // ErrIfNotURL returns an error if the value is not a valid URL.
 func ErrIfNotURL(value, field string) *FieldError {
 	// Validaiton inspired by: github.com/go-playground/validator/baked_in.go
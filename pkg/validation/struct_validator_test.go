@@ -64,6 +64,14 @@ func ExampleErrIfNotJSON() {
 	// Bad: invalid JSON: my-field
 }
 
+func ExampleErrIfNotSemver() {
+	fmt.Println("Good is nil:", ErrIfNotSemver("1.2.3-rc.1+build.5", "my-field") == nil)
+	fmt.Println("Bad:", ErrIfNotSemver("1.2", "my-field"))
+
+	// Output: Good is nil: true
+	// Bad: field must be a semantic version: my-field
+}
+
 func ExampleErrIfOutsideLength() {
 	fmt.Println("Good is within length:", ErrIfOutsideLength("four", "my-field", 2, 10) == nil)
 	fmt.Println("Good is at minimum:", ErrIfOutsideLength("four", "my-field", 4, 10) == nil)
@@ -5,6 +5,7 @@ import (
 
 	"code.cloudfoundry.org/lager"
 	"github.com/pivotal-cf/brokerapi/v8/middlewares"
+	"go.opentelemetry.io/otel/trace"
 )
 
 func ID(ctx context.Context) lager.Data {
@@ -19,3 +20,23 @@ func ID(ctx context.Context) lager.Data {
 
 	return result
 }
+
+// Background returns a new background context that carries the correlation
+// and request IDs, and the trace, of ctx. It is used for work that outlives
+// the request, so that it is not cancelled when the request completes.
+func Background(ctx context.Context) context.Context {
+	result := context.Background()
+	if cid, ok := ctx.Value(middlewares.CorrelationIDKey).(string); ok {
+		result = context.WithValue(result, middlewares.CorrelationIDKey, cid)
+	}
+
+	if rid, ok := ctx.Value(middlewares.RequestIdentityKey).(string); ok {
+		result = context.WithValue(result, middlewares.RequestIdentityKey, rid)
+	}
+
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		result = trace.ContextWithSpanContext(result, sc)
+	}
+
+	return result
+}
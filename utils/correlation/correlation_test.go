@@ -7,6 +7,7 @@ import (
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"github.com/pivotal-cf/brokerapi/v8/middlewares"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var _ = Describe("Correlation", func() {
@@ -55,4 +56,33 @@ var _ = Describe("Correlation", func() {
 			Expect(data).To(BeEmpty())
 		})
 	})
+
+	Describe("Background", func() {
+		It("keeps the IDs but not the cancellation of the context", func() {
+			const cid = "417a8ca4-994b-11eb-a555-b30bdd8a2a34"
+			const rid = "6aa85874-9d04-11eb-a03b-73ee7bd59e49"
+			ctx := context.WithValue(context.TODO(), middlewares.CorrelationIDKey, cid)
+			ctx = context.WithValue(ctx, middlewares.RequestIdentityKey, rid)
+			ctx, cancel := context.WithCancel(ctx)
+			cancel()
+
+			background := correlation.Background(ctx)
+
+			Expect(background.Err()).NotTo(HaveOccurred())
+			Expect(correlation.ID(background)).To(Equal(correlation.ID(ctx)))
+		})
+
+		It("keeps the trace of the context", func() {
+			sc := trace.NewSpanContext(trace.SpanContextConfig{
+				TraceID:    trace.TraceID{0x4b, 0xf9, 0x2f, 0x35},
+				SpanID:     trace.SpanID{0x00, 0xf0, 0x67, 0xaa},
+				TraceFlags: trace.FlagsSampled,
+			})
+			ctx := trace.ContextWithSpanContext(context.TODO(), sc)
+
+			background := correlation.Background(ctx)
+
+			Expect(trace.SpanContextFromContext(background)).To(Equal(sc))
+		})
+	})
 })